@@ -0,0 +1,43 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+
+	"github.com/dora-exku/netflood/pkg/config"
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+// 构建信息，编译时通过 -ldflags "-X main.Version=..." 设置（见 Makefile）
+var (
+	Version   = "dev"
+	BuildTime = ""
+)
+
+// nodeIdentity 汇总命令行和配置文件中的节点身份，命令行优先
+// 标签先取配置文件，再用命令行中同名的标签覆盖；idFile 为空时不使用节点 ID
+func nodeIdentity(name, idFile string, labels []string, cfg *config.Config) (stats.Identity, error) {
+	id := stats.Identity{Name: name, RunID: stats.NewUUID(), Version: Version, Labels: map[string]string{}}
+	if cfg != nil {
+		id.Name = cmp.Or(id.Name, cfg.NodeName)
+		for k, v := range cfg.Labels {
+			id.Labels[k] = v
+		}
+	}
+	for _, l := range labels {
+		k, v, err := stats.ParseLabel(l)
+		if err != nil {
+			return id, err
+		}
+		id.Labels[k] = v
+	}
+
+	if idFile != "" {
+		nodeID, err := stats.LoadNodeID(idFile)
+		if err != nil {
+			return id, fmt.Errorf("%w（可通过 -node-id-file 指定其他路径，为空则不使用节点 ID）", err)
+		}
+		id.NodeID = nodeID
+	}
+	return id, nil
+}
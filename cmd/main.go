@@ -1,18 +1,49 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
+	"github.com/dora-exku/netflood/pkg/config"
 	"github.com/dora-exku/netflood/pkg/downloader"
+	"github.com/dora-exku/netflood/pkg/history"
+	"github.com/dora-exku/netflood/pkg/logging"
+	"github.com/dora-exku/netflood/pkg/ratelimit"
+	"github.com/dora-exku/netflood/pkg/stats"
+	"github.com/dora-exku/netflood/pkg/summary"
 	"github.com/dora-exku/netflood/pkg/timerange"
+	"github.com/dora-exku/netflood/pkg/tui"
+	"github.com/dora-exku/netflood/pkg/units"
+)
+
+// logger 程序日志记录器（解析参数后按 -log-* 参数重新创建）
+var logger = slog.Default()
+
+// tuiLogLines 仪表盘模式下保留的日志行数，退出仪表盘后输出
+const tuiLogLines = 30
+
+// 退出码
+const (
+	exitOK          = 0 // 正常结束
+	exitError       = 1 // 参数错误或运行出错
+	exitNoTasks     = 2 // 没有可用的下载任务（加载失败或列表为空）
+	exitAllFailed   = 3 // 所有下载都失败，没有任何一次成功
+	exitBelowTarget = 4 // 平均速度未达到 -min-speed 阈值
 )
 
 func main() {
+	// 子命令：netflood serve 启动合成数据测试服务器
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		os.Exit(runServe(os.Args[2:]))
+	}
+
 	// 定义命令行参数（支持简写）
 	api := flag.String("api", "", "API 接口地址")
 	apiShort := flag.String("a", "", "API 接口地址（简写）")
@@ -23,26 +54,123 @@ func main() {
 	useDemo := flag.Bool("demo", false, "使用demo.txt文件而不是API")
 	useDemoShort := flag.Bool("d", false, "使用demo.txt文件而不是API（简写）")
 
+	var taskSpecs stringList
+	flag.Var(&taskSpecs, "tasks", "任务来源，可重复设置：文件路径、目录、http(s):// 接口，或 - 表示标准输入")
+	tasksRefresh := flag.Duration("tasks-refresh", 0, "任务列表刷新间隔（例如 5m），0 表示不刷新")
+	tasksIdentity := flag.Bool("tasks-identity", false, "请求 HTTP 任务来源时附加节点身份请求头（用于 netflood-collector 的任务分发，不要对第三方接口启用）")
+	configPath := flag.String("config", "", "配置文件路径（YAML）")
+
 	timeRangeStr := flag.String("time", "", "下载时间段，格式: HH:MM-HH:MM,HH:MM-HH:MM (例如: 12:00-13:00,14:00-15:00)")
 	timeRangeShort := flag.String("t", "", "下载时间段（简写）")
 
 	statsAPI := flag.String("stats-api", "", "统计数据上报API地址（不设置则不上报）")
 	statsAPIShort := flag.String("s", "", "统计数据上报API地址（简写）")
+	statsInterval := flag.Duration("stats-interval", stats.DefaultInterval, "统计上报间隔（-stats-api 和配置文件中未设置 interval 的输出目标）")
+	statsJitter := flag.Duration("stats-jitter", 0, "统计上报的随机抖动，每次等待间隔加上 [0, jitter) 内的随机时长，避免大量节点同时上报")
+	statsFlushTimeout := flag.Duration("stats-flush-timeout", stats.DefaultFlushTimeout, "退出时最终统计上报的超时，0 表示不做最终上报")
+	nodeName := flag.String("node-name", "", "统计上报中的节点名称，默认为主机名")
+	nodeIDFile := flag.String("node-id-file", stats.DefaultNodeIDPath(), "节点 ID 文件（不存在时生成 UUID 并保存），为空则不使用节点 ID")
+	var labelSpecs stringList
+	flag.Var(&labelSpecs, "label", "统计上报中的节点标签，格式 key=value，可重复设置（例如 -label region=cn-east -label isp=ct）")
+
+	maxRate := flag.String("max-rate", "", "总下载速度上限（如 100MB 或 1Gbps），不设置则不限速")
+	bufferSizeKB := flag.Int("buffer-size", 64, "每个协程读取响应使用的缓冲区大小（KB）")
+
+	stallSpeed := flag.String("stall-speed", "", "停滞检测的最低速度（每秒字节数，例如 100KB），不设置则不检测")
+	stallWindow := flag.Duration("stall-window", downloader.DefaultStallWindow, "停滞检测的平均速度统计窗口")
+
+	duration := flag.Duration("duration", 0, "运行时长，到时后结束（例如 10m），不设置则不限")
+	totalBytes := flag.String("bytes", "", "下载总量，达到后结束（例如 50GB），不设置则不限")
+	iterations := flag.Int("iterations", 0, "每个任务下载的轮数，完成后结束，0 表示无限循环")
+	once := flag.Bool("once", false, "每个任务只下载一次后结束（等同于 -iterations 1）")
+
+	speedFile := flag.String("speed-file", downloader.DefaultSpeedPath, "最新速度文件路径（原子替换，只保留最新一行），为空则不写入")
+	historyPath := flag.String("history", "", "速度历史记录文件路径（每秒追加一条），不设置则不记录")
+	historyFormat := flag.String("history-format", history.FormatCSV, "速度历史记录格式: csv 或 jsonl")
+	historyMaxSize := flag.String("history-max-size", "", "历史记录单个文件的最大大小（例如 100MB），超过后轮转")
+	historyDaily := flag.Bool("history-daily", false, "历史记录每天轮转一次")
+	historyKeep := flag.Int("history-keep", 0, "保留的历史记录轮转文件数量，0 表示全部保留")
+	historyGzip := flag.Bool("history-gzip", false, "使用 gzip 压缩轮转后的历史记录文件")
+
+	summaryJSON := flag.String("summary", "", "运行结束后写入 JSON 汇总的文件路径")
+	summaryMD := flag.String("summary-md", "", "运行结束后写入 Markdown 汇总的文件路径")
+	minSpeed := flag.Float64("min-speed", 0, "平均速度阈值（MB/s），未达到时以退出码 4 结束，0 表示不检查")
+
+	logLevel := flag.String("log-level", "info", "日志级别: debug、info、summary、warn、error（debug 会输出每次下载完成的日志）")
+	logFormat := flag.String("log-format", logging.FormatText, "日志格式: text 或 json")
+	logFile := flag.String("log-file", "", "日志文件路径（追加写入），不设置则输出到标准输出")
+	quiet := flag.Bool("quiet", false, "安静模式：只输出周期汇总、警告和错误")
+	quietShort := flag.Bool("q", false, "安静模式（简写）")
+	useTUI := flag.Bool("tui", false, "终端仪表盘模式：原地刷新速度、任务和状态（标准输出不是终端时自动使用滚动日志）")
 
 	flag.Parse()
 
+	// 仪表盘只在终端中启用，未设置日志文件时日志写入缓冲并显示在面板底部
+	var logBuffer *tui.LogBuffer
+	dashboard := *useTUI && tui.IsTerminal(os.Stdout)
+	if dashboard && *logFile == "" {
+		logBuffer = tui.NewLogBuffer(tuiLogLines)
+	}
+
+	// 初始化日志
+	logOpts := logging.Options{
+		Level:  *logLevel,
+		Format: *logFormat,
+		Quiet:  *quiet || *quietShort,
+		File:   *logFile,
+	}
+	if logBuffer != nil {
+		logOpts.Output = logBuffer
+	}
+	l, closer, err := logging.New(logOpts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "初始化日志失败: %v\n", err)
+		os.Exit(exitError)
+	}
+	defer closer.Close()
+	logger = l
+	slog.SetDefault(logger)
+	if *useTUI && !dashboard {
+		logger.Warn("标准输出不是终端，使用滚动日志输出")
+	}
+
 	// 使用简写参数值（如果设置了简写，优先使用简写）
 	finalAPI := *api
 	if *apiShort != "" {
 		finalAPI = *apiShort
 	}
 
+	// 加载配置文件（命令行参数优先）
+	var cfg *config.Config
+	if *configPath != "" {
+		cfg, err = config.Load(*configPath)
+		if err != nil {
+			fatal("加载配置文件失败", err)
+		}
+	}
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	if finalAPI == "" && cfg != nil {
+		finalAPI = cfg.API
+	}
+
 	finalGoroutines := *goroutines
 	if *goroutinesShort != 12 {
 		finalGoroutines = *goroutinesShort
 	}
+	if !setFlags["goroutines"] && !setFlags["g"] && cfg != nil && cfg.Goroutines > 0 {
+		finalGoroutines = cfg.Goroutines
+	}
 
-	finalUseDemo := *useDemo || *useDemoShort
+	refresh := *tasksRefresh
+	if !setFlags["tasks-refresh"] && cfg != nil {
+		refresh = cfg.TaskRefresh
+	}
+	taskIdentity := *tasksIdentity
+	if !setFlags["tasks-identity"] && cfg != nil {
+		taskIdentity = cfg.TaskIdentity
+	}
 
 	finalTimeRange := *timeRangeStr
 	if *timeRangeShort != "" {
@@ -54,107 +182,283 @@ func main() {
 		finalStatsAPI = *statsAPIShort
 	}
 
-	fmt.Printf("配置参数: API=%s, 协程数=%d\n", finalAPI, finalGoroutines)
+	logger.Info("配置参数", "api", finalAPI, "goroutines", finalGoroutines)
 
 	// 解析时间段
 	trm, err := timerange.NewTimeRangeManager(finalTimeRange)
 	if err != nil {
-		fmt.Printf("解析时间段失败: %v\n", err)
-		fmt.Println("时间段格式示例: -time 12:00-13:00,14:00-15:00")
-		os.Exit(1)
+		fatal("解析时间段失败（格式示例: -time 12:00-13:00,14:00-15:00）", err)
 	}
 	if trm.IsEnabled() {
-		fmt.Printf("下载时间段: %s (每天重复)\n", trm.String())
+		logger.Info("下载时间段（每天重复）", "time_range", trm.String())
 	} else {
-		fmt.Println("下载时间段: 全天候运行")
+		logger.Info("下载时间段: 全天候运行")
 	}
 
 	// 创建下载器
-	dl := downloader.New(finalGoroutines)
+	dlOpts := []downloader.Option{
+		downloader.WithWorkers(finalGoroutines),
+		downloader.WithLogger(logger),
+	}
+	if *maxRate != "" {
+		rate, err := units.ParseRate(*maxRate)
+		if err != nil {
+			fatal("解析限速失败", err)
+		}
+		dlOpts = append(dlOpts, downloader.WithLimiter(ratelimit.New(rate)))
+		logger.Info("下载限速", "max_rate", units.FormatBytes(rate)+"/s")
+	}
+	useDemoFile := *useDemo || *useDemoShort
+	if useDemoFile && finalAPI != "" {
+		logger.Warn("使用 demo.txt 代替 API，忽略 API 地址", "api", finalAPI)
+	}
+	src, err := taskSource(taskSpecs, useDemoFile, finalAPI, cfg)
+	if err != nil {
+		logger.Error("解析任务来源失败", "error", err)
+		os.Exit(exitNoTasks)
+	}
+	if src != nil {
+		dlOpts = append(dlOpts, downloader.WithTaskSource(src, refresh), downloader.WithTaskIdentity(taskIdentity))
+	}
+	dl := downloader.New(dlOpts...)
+
+	// 设置读缓冲区大小
+	dl.SetBufferSize(*bufferSizeKB * 1024)
+
+	// 设置停滞检测
+	if *stallSpeed != "" {
+		minSpeed, err := units.ParseBytes(*stallSpeed)
+		if err != nil {
+			fatal("解析停滞检测速度失败", err)
+		}
+		dl.SetStallPolicy(minSpeed, *stallWindow)
+		logger.Info("停滞检测: 窗口内平均速度低于阈值时中止连接",
+			"window", stallWindow.String(), "min_speed", units.FormatBytes(minSpeed)+"/s")
+	}
+
+	// 设置运行边界
+	limits := downloader.RunLimits{Duration: *duration, Iterations: *iterations}
+	if *once {
+		limits.Iterations = 1
+	}
+	if *totalBytes != "" {
+		limits.Bytes, err = units.ParseBytes(*totalBytes)
+		if err != nil {
+			fatal("解析下载总量失败", err)
+		}
+	}
+	dl.SetRunLimits(limits)
+
+	// 设置速度文件和历史记录
+	dl.SetSpeedFile(*speedFile)
+	if *historyPath != "" {
+		opts := history.Options{
+			Path:     *historyPath,
+			Format:   *historyFormat,
+			Daily:    *historyDaily,
+			MaxFiles: *historyKeep,
+			Compress: *historyGzip,
+			Logger:   logger,
+		}
+		if *historyMaxSize != "" {
+			opts.MaxSize, err = units.ParseBytes(*historyMaxSize)
+			if err != nil {
+				fatal("解析历史记录文件大小失败", err)
+			}
+		}
+		hw, err := history.NewWriter(opts)
+		if err != nil {
+			fatal("创建速度历史记录失败", err)
+		}
+		defer hw.Close()
+		dl.SetHistory(hw)
+		logger.Info("速度历史记录", "path", opts.Path, "format", opts.Format)
+	}
 
 	// 设置时间段管理器
 	dl.SetTimeRangeManager(trm)
 
-	// 设置统计上报API
+	// 设置统计上报：-stats-api 和配置文件中的输出目标可同时使用
 	if finalStatsAPI != "" {
-		if err := dl.SetStatsAPI(finalStatsAPI); err != nil {
-			fmt.Printf("设置统计上报失败: %v\n", err)
-			os.Exit(1)
+		opts := stats.SinkOptions{Interval: *statsInterval, Jitter: *statsJitter}
+		if err := dl.AddStatsSink(stats.SinkHTTP, stats.NewHTTPSink(finalStatsAPI), opts); err != nil {
+			fatal("设置统计上报失败", err)
 		}
-		fmt.Printf("统计上报API: %s (每10秒上报一次)\n", finalStatsAPI)
-	} else {
-		fmt.Println("统计上报: 未启用")
+		logger.Info("统计上报", "stats_api", finalStatsAPI, "interval", statsInterval.String(), "jitter", statsJitter.String())
 	}
-
-	// 加载下载任务
-	if finalUseDemo {
-		// 从demo.txt文件加载
-		fmt.Println("从 demo.txt 文件加载下载任务...")
-		if err := dl.LoadTasksFromFile("demo.txt"); err != nil {
-			fmt.Printf("加载任务失败: %v\n", err)
-			os.Exit(1)
+	var sinkConfigs []stats.SinkConfig
+	if cfg != nil {
+		sinkConfigs = cfg.Stats
+	}
+	for _, sc := range sinkConfigs {
+		sc.Interval = cmp.Or(sc.Interval, *statsInterval)
+		sc.Jitter = cmp.Or(sc.Jitter, *statsJitter)
+		sink, err := stats.NewSink(sc)
+		if err != nil {
+			fatal("创建统计输出失败", err)
 		}
-	} else {
-		// 从API加载
-		fmt.Printf("从 API 加载下载任务: %s\n", finalAPI)
-		if err := dl.LoadTasksFromAPI(finalAPI); err != nil {
-			fmt.Printf("加载任务失败: %v\n", err)
-			os.Exit(1)
+		if err := dl.AddStatsSink(sc.DisplayName(), sink, sc.Options()); err != nil {
+			fatal("创建统计输出失败", err)
+		}
+		logger.Info("统计输出", "name", sc.DisplayName(), "type", sc.Type, "interval", sc.Interval.String(), "jitter", sc.Jitter.String())
+	}
+	statsEnabled := finalStatsAPI != "" || len(sinkConfigs) > 0
+	if !statsEnabled {
+		logger.Info("统计上报: 未启用")
+	}
+	// 节点身份附加在统计上报和设置了 -tasks-identity 时的 HTTP 任务接口请求中（收集器据此分配任务）
+	if statsEnabled || taskIdentity && hasHTTPSource(src) {
+		idFile := *nodeIDFile
+		if !setFlags["node-id-file"] && cfg != nil && cfg.NodeIDFile != "" {
+			idFile = cfg.NodeIDFile
+		}
+		id, err := nodeIdentity(*nodeName, idFile, labelSpecs, cfg)
+		if err != nil {
+			fatal("设置节点身份失败", err)
 		}
+		dl.SetIdentity(id)
+		dl.SetStatsFlushTimeout(cmp.Or(*statsFlushTimeout, -1)) // 命令行中 0 表示不做最终上报
+		logger.Info("节点身份", "name", cmp.Or(id.Name, "（主机名）"), "node_id", id.NodeID, "run_id", id.RunID, "version", id.Version, "labels", id.Labels)
+	}
+
+	// 加载下载任务
+	if src == nil {
+		logger.Error("没有指定任务来源（使用 -tasks、-api、-demo 或配置文件）")
+		exit(dl, exitNoTasks, *summaryJSON, *summaryMD)
+	}
+	logger.Info("加载下载任务", "source", src.String())
+	if err := dl.LoadTasks(context.Background()); err != nil {
+		logger.Error("加载任务失败", "error", err)
+		exit(dl, exitNoTasks, *summaryJSON, *summaryMD)
+	}
+	if refresh > 0 {
+		logger.Info("定期刷新任务列表", "interval", refresh.String())
 	}
 
 	tasks := dl.GetTasks()
-	fmt.Printf("成功加载 %d 个下载任务\n", len(tasks))
+	logger.Info("成功加载下载任务", "count", len(tasks))
 
 	// 显示任务列表
 	for i, task := range tasks {
-		fmt.Printf("  任务 %d: IP=%s, URL=%s\n", i+1, task.IP, task.URL[:min(60, len(task.URL))]+"...")
+		logger.Info("任务", "index", i+1, "ip", task.IP, "url", task.URL)
 	}
 
 	// 创建上下文，用于优雅退出
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// 启动终端仪表盘
+	stopDashboard := func() {}
+	if dashboard {
+		stopDashboard = tui.New(os.Stdout, dl, tui.Options{Limits: limits, TimeRange: trm, Logs: logBuffer}).Start()
+	}
+
 	// 监听退出信号
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
 	go func() {
 		<-sigChan
-		fmt.Println("\n收到退出信号，等待当前下载任务完成...")
-		fmt.Println("⚠️  如需强制退出，请再次按 Ctrl+C")
+		logging.Summary(logger, "收到退出信号，等待当前下载任务完成（如需强制退出，请再次按 Ctrl+C）")
 		cancel()
 
 		// 等待第二次信号，强制退出
 		<-sigChan
-		fmt.Println("\n收到强制退出信号，立即退出...")
-		os.Exit(1)
+		stopDashboard()
+		logging.Summary(logger, "收到强制退出信号，立即退出")
+		os.Exit(exitError)
 	}()
 
 	// 开始下载
-	fmt.Printf("\n开始下载，使用 %d 个协程...\n", finalGoroutines)
-	fmt.Println("速度统计将保存到 ./speed 文件")
-	fmt.Println("⚡ 循环下载模式：协程将不停下载任务")
+	logging.Summary(logger, "开始下载", "goroutines", finalGoroutines)
+	if *speedFile != "" {
+		logger.Info("速度统计将保存到文件", "path", *speedFile)
+	}
+	if limits.IsBounded() {
+		logger.Info("🏁 有限运行模式", "limits", describeLimits(limits))
+	} else {
+		logger.Info("⚡ 循环下载模式：协程将不停下载任务")
+	}
 	if trm.IsEnabled() {
-		fmt.Printf("⏰ 时间段控制已启用：%s (每天重复)\n", trm.String())
+		logger.Info("⏰ 时间段控制已启用（每天重复）", "time_range", trm.String())
 	}
-	fmt.Println("⚠️  按 Ctrl+C 优雅退出")
-	fmt.Println()
+	logger.Info("⚠️  按 Ctrl+C 优雅退出")
 
-	if err := dl.Start(ctx); err != nil {
+	err = dl.Start(ctx)
+	stopDashboard()
+	if logBuffer != nil {
+		// 恢复终端后输出最近的日志（包括最终统计）
+		logBuffer.Detach(os.Stdout)
+	}
+	if err != nil {
 		if err != context.Canceled {
-			fmt.Printf("\n❌ 下载过程出错: %v\n", err)
-			os.Exit(1)
+			logger.Error("❌ 下载过程出错", "error", err)
+			exit(dl, exitError, *summaryJSON, *summaryMD)
+		}
+	}
+
+	code := exitOK
+	snap := dl.Stats()
+	switch {
+	case snap.Completed == 0 && (snap.Failed > 0 || limits.IsBounded()):
+		// 有失败记录，或有限运行模式下没有任何一次下载成功
+		logger.Error("❌ 运行结束，但没有任何一次下载成功")
+		code = exitAllFailed
+	case *minSpeed > 0 && snap.AvgSpeed < *minSpeed:
+		logger.Error("❌ 平均速度未达到阈值", "avg_speed_mbs", snap.AvgSpeed, "min_speed_mbs", *minSpeed)
+		code = exitBelowTarget
+	default:
+		logging.Summary(logger, "✅ 下载已停止，程序退出")
+	}
+	exit(dl, code, *summaryJSON, *summaryMD)
+}
+
+// exitResults 退出码的含义
+var exitResults = map[int]string{
+	exitOK:          "成功",
+	exitError:       "运行出错",
+	exitNoTasks:     "没有下载任务",
+	exitAllFailed:   "所有下载都失败",
+	exitBelowTarget: "平均速度未达到阈值",
+}
+
+// exit 写入汇总文件（如果配置了）并以指定退出码退出
+func exit(dl *downloader.Downloader, code int, jsonPath, mdPath string) {
+	s := summary.Summary{Snapshot: dl.Stats(), ExitCode: code, Result: exitResults[code]}
+	if jsonPath != "" {
+		if err := summary.WriteJSON(jsonPath, s); err != nil {
+			logger.Error("写入 JSON 汇总失败", "error", err)
+		} else {
+			logger.Info("运行汇总已写入", "path", jsonPath)
 		}
 	}
+	if mdPath != "" {
+		if err := summary.WriteMarkdown(mdPath, s); err != nil {
+			logger.Error("写入 Markdown 汇总失败", "error", err)
+		} else {
+			logger.Info("运行汇总已写入", "path", mdPath)
+		}
+	}
+	os.Exit(code)
+}
 
-	fmt.Println("\n✅ 下载已停止，程序退出")
+// fatal 记录错误并以 exitError 退出
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(exitError)
 }
 
-// min 返回两个整数中的最小值
-func min(a, b int) int {
-	if a < b {
-		return a
+// describeLimits 返回运行边界的描述
+func describeLimits(l downloader.RunLimits) string {
+	var parts []string
+	if l.Duration > 0 {
+		parts = append(parts, fmt.Sprintf("运行 %v", l.Duration))
+	}
+	if l.Bytes > 0 {
+		parts = append(parts, fmt.Sprintf("下载 %s", units.FormatBytes(l.Bytes)))
+	}
+	if l.Iterations > 0 {
+		parts = append(parts, fmt.Sprintf("每个任务 %d 轮", l.Iterations))
 	}
-	return b
+	return strings.Join(parts, "，") + "（先到者为准）"
 }
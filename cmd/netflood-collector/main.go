@@ -0,0 +1,281 @@
+// netflood-collector 汇总多个 netflood 节点的统计上报，保存在本地磁盘并提供查询接口
+//
+//	netflood-collector -addr :8080 -data ./collector-data
+//	netflood -demo -stats-api http://collector:8080/stats
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/collector"
+	"github.com/dora-exku/netflood/pkg/logging"
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+// 程序版本，编译时通过 -ldflags 设置
+var (
+	Version   = "dev"
+	BuildTime = ""
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "监听地址")
+	dataDir := flag.String("data", "collector-data", "数据目录")
+	retention := flag.Duration("retention", collector.DefaultRetention, "数据保留时长")
+	offlineAfter := flag.Duration("offline-after", collector.DefaultOfflineAfter, "超过该时长未上报的节点视为离线（建议设置为上报间隔的数倍）")
+	token := flag.String("token", os.Getenv("NETFLOOD_STATS_TOKEN"), "上报接口要求的 Bearer token（默认读取环境变量 NETFLOOD_STATS_TOKEN）")
+	readToken := flag.String("read-token", os.Getenv("NETFLOOD_COLLECTOR_READ_TOKEN"), "查询接口要求的 Bearer token（默认读取环境变量 NETFLOOD_COLLECTOR_READ_TOKEN）")
+	adminToken := flag.String("admin-token", os.Getenv("NETFLOOD_COLLECTOR_ADMIN_TOKEN"), "下发指令接口（POST /api/directives）要求的 Bearer token，需要同时设置 -directive-key（默认读取环境变量 NETFLOOD_COLLECTOR_ADMIN_TOKEN）")
+	directiveKey := flag.String("directive-key", "", "指令签名私钥文件（Ed25519 PEM），不存在时自动生成；启动时输出节点 directive_key 使用的公钥")
+	certNodes := flag.Bool("cert-nodes", false, "只向客户端证书中的节点（CN 或 DNS 名称为 node_id 或名称）下发指令、接受其确认，需要 -client-ca")
+	secretFile := flag.String("hmac-secret-file", "", "上报签名密钥文件（未设置时读取环境变量 NETFLOOD_HMAC_SECRET）")
+	tlsCert := flag.String("tls-cert", "", "服务器证书，设置后使用 HTTPS")
+	tlsKey := flag.String("tls-key", "", "服务器私钥")
+	clientCA := flag.String("client-ca", "", "校验客户端证书的 CA（mTLS），设置后要求客户端证书")
+	alertsPath := flag.String("alerts", "", "告警规则文件（YAML），不设置则不告警")
+	tasksPath := flag.String("tasks", "", "任务池配置文件（YAML），设置后节点可通过 GET /tasks 获取分配的任务列表")
+	tasksTTL := flag.Duration("tasks-ttl", collector.DefaultTaskTTL, "节点超过该时长未获取任务后不再参与分片（应大于节点的 -tasks-refresh）")
+	budgetPath := flag.String("budget", "", "带宽目标配置文件（YAML），设置后按目标向节点下发速度上限，需要同时设置 -directive-key")
+	alertsTest := flag.Bool("alerts-test", false, "为每条告警规则向 webhook 发送一条测试告警和恢复通知后退出")
+	logLevel := flag.String("log-level", "info", "日志级别: debug、info、summary、warn、error（debug 会输出每次收到的上报）")
+	logFormat := flag.String("log-format", logging.FormatText, "日志格式: text 或 json")
+	logFile := flag.String("log-file", "", "日志文件路径（追加写入），不设置则输出到标准输出")
+	showVersion := flag.Bool("version", false, "显示版本信息")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Printf("netflood-collector %s %s\n", Version, BuildTime)
+		return
+	}
+
+	logger, closer, err := logging.New(logging.Options{Level: *logLevel, Format: *logFormat, File: *logFile})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "初始化日志失败: %v\n", err)
+		os.Exit(1)
+	}
+	defer closer.Close()
+
+	if err := run(logger, options{
+		addr:         *addr,
+		dataDir:      *dataDir,
+		retention:    *retention,
+		offlineAfter: *offlineAfter,
+		token:        *token,
+		readToken:    *readToken,
+		adminToken:   *adminToken,
+		directiveKey: *directiveKey,
+		certNodes:    *certNodes,
+		secretFile:   *secretFile,
+		tlsCert:      *tlsCert,
+		tlsKey:       *tlsKey,
+		clientCA:     *clientCA,
+		alertsPath:   *alertsPath,
+		alertsTest:   *alertsTest,
+		tasksPath:    *tasksPath,
+		tasksTTL:     *tasksTTL,
+		budgetPath:   *budgetPath,
+	}); err != nil {
+		logger.Error("运行失败", "error", err)
+		closer.Close()
+		os.Exit(1)
+	}
+}
+
+// options 命令行参数
+type options struct {
+	addr, dataDir           string
+	retention, offlineAfter time.Duration
+	token, readToken        string
+	adminToken              string
+	directiveKey            string
+	certNodes               bool
+	secretFile              string
+	tlsCert, tlsKey         string
+	clientCA                string
+	alertsPath              string
+	alertsTest              bool
+	tasksPath               string
+	tasksTTL                time.Duration
+	budgetPath              string
+}
+
+// run 启动收集器并在收到中断信号后退出
+func run(logger *slog.Logger, opts options) error {
+	c, err := collector.New(collector.Options{
+		Dir:          opts.dataDir,
+		Retention:    opts.retention,
+		OfflineAfter: opts.offlineAfter,
+		Logger:       logger,
+	})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	apiOpts := collector.APIOptions{Token: opts.token, ReadToken: opts.readToken, Logger: logger}
+	if opts.alertsPath != "" {
+		cfg, err := collector.LoadAlertConfig(opts.alertsPath)
+		if err != nil {
+			return err
+		}
+		if apiOpts.Alerter, err = collector.NewAlerter(c, cfg, logger); err != nil {
+			return err
+		}
+		if opts.alertsTest {
+			if err := apiOpts.Alerter.SendTest(context.Background()); err != nil {
+				return fmt.Errorf("发送测试告警失败: %w", err)
+			}
+			logger.Info("已发送测试告警", "rules", len(cfg.Rules), "webhook", cfg.Webhook.URL)
+			return nil
+		}
+	} else if opts.alertsTest {
+		return errors.New("-alerts-test 需要同时设置 -alerts")
+	}
+	if opts.tasksPath != "" {
+		if apiOpts.Tasks, err = collector.NewDispatcher(c, opts.tasksPath, opts.tasksTTL, logger); err != nil {
+			return err
+		}
+	}
+	secret, err := loadSecret(opts.secretFile)
+	if err != nil {
+		return err
+	}
+	if len(secret) > 0 {
+		apiOpts.Verifier = stats.NewVerifier(secret, stats.DefaultMaxSkew)
+	}
+	if opts.directiveKey != "" {
+		// 指令使用收集器独有的私钥签名，节点只持有公钥
+		signer, created, err := stats.LoadDirectiveSigner(opts.directiveKey)
+		if err != nil {
+			return err
+		}
+		apiOpts.Signer = signer
+		logger.Info("指令签名公钥（节点 http 输出目标的 directive_key）", "public_key", signer.PublicKey(), "path", opts.directiveKey, "created", created)
+	}
+	if opts.certNodes {
+		if opts.clientCA == "" {
+			return errors.New("-cert-nodes 需要同时设置 -client-ca")
+		}
+		apiOpts.CertNodes = true
+	}
+	if opts.adminToken != "" {
+		// 节点只执行签名有效的指令
+		if apiOpts.Signer == nil {
+			return errors.New("-admin-token 需要同时设置 -directive-key")
+		}
+		if apiOpts.Control, err = collector.NewController(c, logger); err != nil {
+			return err
+		}
+		apiOpts.AdminToken = opts.adminToken
+	}
+	if opts.budgetPath != "" {
+		// 速度上限通过指令下发，同样需要指令签名
+		if apiOpts.Signer == nil {
+			return errors.New("-budget 需要同时设置 -directive-key")
+		}
+		cfg, err := collector.LoadBudgetConfig(opts.budgetPath)
+		if err != nil {
+			return err
+		}
+		if apiOpts.Control == nil {
+			if apiOpts.Control, err = collector.NewController(c, logger); err != nil {
+				return err
+			}
+		}
+		if apiOpts.Budget, err = collector.NewBalancer(c, apiOpts.Control, cfg, logger); err != nil {
+			return err
+		}
+	}
+
+	server := &http.Server{Addr: opts.addr, Handler: collector.NewAPI(c, apiOpts), ReadHeaderTimeout: 10 * time.Second}
+	if opts.clientCA != "" {
+		if opts.tlsCert == "" {
+			return errors.New("-client-ca 需要同时设置 -tls-cert 和 -tls-key")
+		}
+		pem, err := os.ReadFile(opts.clientCA)
+		if err != nil {
+			return fmt.Errorf("读取客户端 CA 失败: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("客户端 CA 中没有有效的证书: %s", opts.clientCA)
+		}
+		server.TLSConfig = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go c.Run(ctx)
+	if apiOpts.Alerter != nil {
+		go apiOpts.Alerter.Run(ctx)
+	}
+	if apiOpts.Tasks != nil {
+		go apiOpts.Tasks.Run(ctx)
+	}
+	if apiOpts.Control != nil {
+		go apiOpts.Control.Run(ctx)
+	}
+	if apiOpts.Budget != nil {
+		go apiOpts.Budget.Run(ctx)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		if opts.tlsCert != "" {
+			errCh <- server.ListenAndServeTLS(opts.tlsCert, opts.tlsKey)
+		} else {
+			errCh <- server.ListenAndServe()
+		}
+	}()
+	logger.Info("收集器已启动",
+		"addr", opts.addr,
+		"data", opts.dataDir,
+		"nodes", len(c.Nodes()),
+		"tls", opts.tlsCert != "",
+		"token", opts.token != "",
+		"signature", len(secret) > 0,
+		"client_cert", server.TLSConfig != nil,
+		"alerts", opts.alertsPath,
+		"tasks", opts.tasksPath,
+		"directives", apiOpts.Control != nil,
+		"budget", opts.budgetPath,
+	)
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	logger.Info("正在停止收集器")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
+
+// loadSecret 读取签名密钥：密钥文件，未设置时为环境变量 NETFLOOD_HMAC_SECRET
+func loadSecret(path string) ([]byte, error) {
+	if path == "" {
+		return []byte(os.Getenv("NETFLOOD_HMAC_SECRET")), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取签名密钥失败: %w", err)
+	}
+	secret := bytes.TrimSpace(data)
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("签名密钥文件为空: %s", path)
+	}
+	return secret, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/logging"
+	"github.com/dora-exku/netflood/pkg/server"
+	"github.com/dora-exku/netflood/pkg/units"
+)
+
+// serveOptions netflood serve 的参数
+type serveOptions struct {
+	addr      string
+	tlsAddr   string
+	tcpAddr   string
+	tlsCert   string
+	tlsKey    string
+	tlsHosts  string
+	tlsExport string
+	faults    string
+	server    server.Options
+}
+
+// runServe 运行 netflood serve 子命令：启动合成数据测试服务器，返回退出码
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("netflood serve", flag.ContinueOnError)
+	addr := fs.String("addr", ":8080", "HTTP 监听地址，为空则不监听 HTTP")
+	tlsAddr := fs.String("tls-addr", "", "HTTPS 监听地址（例如 :8443），为空则不监听 HTTPS")
+	tcpAddr := fs.String("tcp", "", "原始 TCP 测试监听地址（例如 :5201），供 tcp:// 任务使用，为空则不监听")
+	tlsCert := fs.String("tls-cert", "", "HTTPS 证书，不设置则使用自签名证书")
+	tlsKey := fs.String("tls-key", "", "HTTPS 私钥")
+	tlsHosts := fs.String("tls-host", "localhost,127.0.0.1", "自签名证书包含的域名和 IP，逗号分隔")
+	tlsExport := fs.String("tls-export", "", "把自签名证书写入该文件，供客户端信任（例如 SSL_CERT_FILE）")
+	rate := fs.String("rate", "", "每个连接的速度上限（如 10MB 或 1Gbps），不设置则不限速")
+	chunked := fs.Bool("chunked", false, "默认使用分块传输（不设置 Content-Length），可用 chunked=0 参数按请求关闭")
+	maxSize := fs.String("max-size", "", "单个对象的最大字节数（例如 100GB），不设置则不限")
+	faults := fs.String("faults", "", "故障注入配置文件（YAML），不设置则只按请求参数注入")
+	logInterval := fs.Duration("log-interval", server.DefaultLogInterval, "吞吐量日志间隔，0 表示不输出")
+	logLevel := fs.String("log-level", "info", "日志级别: debug、info、summary、warn、error")
+	logFormat := fs.String("log-format", logging.FormatText, "日志格式: text 或 json")
+	logFile := fs.String("log-file", "", "日志文件路径（追加写入），不设置则输出到标准输出")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitError
+	}
+
+	l, closer, err := logging.New(logging.Options{Level: *logLevel, Format: *logFormat, File: *logFile})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "初始化日志失败: %v\n", err)
+		return exitError
+	}
+	defer closer.Close()
+
+	opts := serveOptions{
+		addr:      *addr,
+		tlsAddr:   *tlsAddr,
+		tcpAddr:   *tcpAddr,
+		tlsCert:   *tlsCert,
+		tlsKey:    *tlsKey,
+		tlsHosts:  *tlsHosts,
+		tlsExport: *tlsExport,
+		faults:    *faults,
+		server:    server.Options{Chunked: *chunked, LogInterval: *logInterval, Logger: l},
+	}
+	if *logInterval == 0 {
+		opts.server.LogInterval = -1
+	}
+	if *rate != "" {
+		if opts.server.Rate, err = units.ParseRate(*rate); err != nil {
+			l.Error("参数错误", "flag", "rate", "error", err)
+			return exitError
+		}
+	}
+	if *maxSize != "" {
+		if opts.server.MaxSize, err = units.ParseBytes(*maxSize); err != nil {
+			l.Error("参数错误", "flag", "max-size", "error", err)
+			return exitError
+		}
+	}
+	if err := serve(l, opts); err != nil {
+		l.Error("测试服务器出错", "error", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// serve 按参数监听 HTTP、HTTPS 和原始 TCP，直到收到退出信号
+func serve(logger *slog.Logger, opts serveOptions) error {
+	if opts.addr == "" && opts.tlsAddr == "" && opts.tcpAddr == "" {
+		return errors.New("-addr、-tls-addr 和 -tcp 至少需要设置一个")
+	}
+	s := server.New(opts.server)
+	if opts.faults != "" {
+		faults, err := server.LoadFaults(opts.faults)
+		if err != nil {
+			return err
+		}
+		if err := s.SetFaults(faults); err != nil {
+			return err
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// 先监听 TCP，失败时不会留下已启动的 HTTP 服务器
+	var tcpLn net.Listener
+	if opts.tcpAddr != "" {
+		ln, err := net.Listen("tcp", opts.tcpAddr)
+		if err != nil {
+			return fmt.Errorf("监听 TCP 失败: %w", err)
+		}
+		tcpLn = ln
+	}
+	var servers []*http.Server
+	errCh := make(chan error, 3)
+	if opts.addr != "" {
+		hs := s.HTTPServer(opts.addr)
+		servers = append(servers, hs)
+		go func() { errCh <- hs.ListenAndServe() }()
+	}
+	if opts.tlsAddr != "" {
+		cert, err := loadServeCertificate(opts)
+		if err != nil {
+			return err
+		}
+		hs := s.HTTPServer(opts.tlsAddr)
+		hs.TLSConfig = s.TLSConfig(cert)
+		servers = append(servers, hs)
+		go func() { errCh <- hs.ListenAndServeTLS("", "") }()
+	}
+	if tcpLn != nil {
+		go func() { errCh <- s.ServeTCP(ctx, tcpLn) }()
+	}
+	go s.Run(ctx)
+	logger.Info("测试服务器已启动",
+		"addr", opts.addr,
+		"tls_addr", opts.tlsAddr,
+		"tcp_addr", opts.tcpAddr,
+		"rate", units.FormatBytes(opts.server.Rate)+"/s",
+		"chunked", opts.server.Chunked,
+		"faults", len(s.Faults()),
+		"example", "/bytes/10GB",
+	)
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		logger.Info("正在停止测试服务器")
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, hs := range servers {
+		hs.Shutdown(shutdownCtx)
+	}
+	return err
+}
+
+// loadServeCertificate 读取 HTTPS 证书，未设置时生成自签名证书（按 -tls-export 导出）
+func loadServeCertificate(opts serveOptions) (tls.Certificate, error) {
+	if opts.tlsCert != "" || opts.tlsKey != "" {
+		if opts.tlsCert == "" || opts.tlsKey == "" {
+			return tls.Certificate{}, errors.New("-tls-cert 和 -tls-key 需要同时设置")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.tlsCert, opts.tlsKey)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("读取证书失败: %w", err)
+		}
+		return cert, nil
+	}
+	certPEM, keyPEM, err := server.SelfSigned(strings.Split(opts.tlsHosts, ",")...)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if opts.tlsExport != "" {
+		if err := os.WriteFile(opts.tlsExport, certPEM, 0o644); err != nil {
+			return tls.Certificate{}, fmt.Errorf("导出证书失败: %w", err)
+		}
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/dora-exku/netflood/pkg/config"
+	"github.com/dora-exku/netflood/pkg/downloader"
+)
+
+// stringList 可重复设置的命令行参数
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// taskSource 汇总命令行和配置文件中的任务来源，多个来源时合并去重
+// useDemo 时使用 demo.txt 代替 API（与之前的版本相同，-demo 和 -api 不合并）；没有任何来源时返回 nil
+func taskSource(specs []string, useDemo bool, api string, cfg *config.Config) (downloader.TaskSource, error) {
+	var sources downloader.MultiSource
+	add := func(spec string) error {
+		src, err := downloader.ParseSource(spec)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, src)
+		return nil
+	}
+
+	for _, spec := range specs {
+		if err := add(spec); err != nil {
+			return nil, err
+		}
+	}
+	if useDemo {
+		sources = append(sources, downloader.FileSource("demo.txt"))
+	} else if api != "" {
+		sources = append(sources, downloader.HTTPSource(api))
+	}
+	if cfg != nil {
+		if len(cfg.Tasks) > 0 {
+			tasks, err := downloader.ParseTasks(strings.NewReader(strings.Join(cfg.Tasks, "\n")))
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, downloader.StaticSource(tasks))
+		}
+		for _, spec := range cfg.TaskSources {
+			if err := add(spec); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	switch len(sources) {
+	case 0:
+		return nil, nil
+	case 1:
+		return sources[0], nil
+	default:
+		return sources, nil
+	}
+}
+
+// hasHTTPSource 任务来源中是否有 HTTP 接口
+func hasHTTPSource(src downloader.TaskSource) bool {
+	switch src := src.(type) {
+	case downloader.HTTPSource:
+		return true
+	case downloader.MultiSource:
+		return slices.ContainsFunc(src, hasHTTPSource)
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Alert 告警（与 netflood-collector 发送的字段相同）
+type Alert struct {
+	ID        string            `json:"id"`
+	Rule      string            `json:"rule"`
+	Type      string            `json:"type"`
+	Severity  string            `json:"severity"`
+	Status    string            `json:"status"`
+	Node      string            `json:"node"`
+	NodeName  string            `json:"node_name"`
+	Labels    map[string]string `json:"labels"`
+	Value     float64           `json:"value"`
+	Threshold float64           `json:"threshold"`
+	Summary   string            `json:"summary"`
+	StartsAt  time.Time         `json:"starts_at"`
+	EndsAt    *time.Time        `json:"ends_at"`
+}
+
+// Payload webhook 请求体
+type Payload struct {
+	Version string    `json:"version"`
+	Status  string    `json:"status"`
+	Alerts  []Alert   `json:"alerts"`
+	SentAt  time.Time `json:"sent_at"`
+	Test    bool      `json:"test"`
+}
+
+func main() {
+	addr := flag.String("addr", ":9095", "监听地址")
+	token := flag.String("token", os.Getenv("NETFLOOD_ALERT_TOKEN"), "要求的 Bearer token（默认读取环境变量 NETFLOOD_ALERT_TOKEN）")
+	fail := flag.Bool("fail", false, "总是返回 500，用于测试收集器的重试")
+	flag.Parse()
+
+	http.HandleFunc("POST /alerts", func(w http.ResponseWriter, r *http.Request) {
+		if *token != "" && r.Header.Get("Authorization") != "Bearer "+*token {
+			log.Printf("❌ 拒绝请求（%s）: token 无效", r.RemoteAddr)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		var payload Payload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			log.Printf("❌ 解析 JSON 失败: %v", err)
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		printPayload(payload)
+		if *fail {
+			http.Error(w, "Simulated failure", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	fmt.Printf("🔔 告警接收示例已启动: http://localhost%s/alerts\n", *addr)
+	fmt.Println("在告警规则文件中设置 webhook.url 为上述地址，然后运行：")
+	fmt.Println("  ./netflood-collector -alerts alerts.yml -alerts-test")
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+// printPayload 打印收到的告警
+func printPayload(p Payload) {
+	test := ""
+	if p.Test {
+		test = "（测试）"
+	}
+	fmt.Printf("[%s] 🔔 收到 %d 条告警%s，状态 %s\n", time.Now().Format("2006-01-02 15:04:05"), len(p.Alerts), test, p.Status)
+	for _, a := range p.Alerts {
+		icon := "🔥"
+		if a.Status == "resolved" {
+			icon = "✅"
+		}
+		fmt.Printf("  %s [%s] %s: %s\n", icon, a.Severity, a.ID, a.Summary)
+		if a.EndsAt != nil {
+			fmt.Printf("     持续 %s\n", a.EndsAt.Sub(a.StartsAt).Round(time.Second))
+		}
+	}
+	fmt.Println("-------------------------------------------")
+}
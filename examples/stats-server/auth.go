@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+// authenticator 校验 Bearer token 和 HMAC-SHA256 签名
+// 签名由 stats.Verifier 校验，拒绝过期（超过 stats.DefaultMaxSkew）和重放的请求
+type authenticator struct {
+	token    string
+	verifier *stats.Verifier // 未设置签名密钥时为 nil
+}
+
+// newAuthenticator 创建认证器，token 和 secret 为空时跳过对应的校验
+func newAuthenticator(token string, secret []byte) *authenticator {
+	a := &authenticator{token: token}
+	if len(secret) > 0 {
+		a.verifier = stats.NewVerifier(secret, stats.DefaultMaxSkew)
+	}
+	return a
+}
+
+// check 校验请求
+func (a *authenticator) check(r *http.Request, body []byte) error {
+	if a.token != "" {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, []byte("Bearer "+a.token)) != 1 {
+			return errors.New("token 无效")
+		}
+	}
+	if a.verifier == nil {
+		return nil
+	}
+	return a.verifier.Verify(r.Header, body)
+}
@@ -1,31 +1,93 @@
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"sync"
 	"time"
 )
 
 // StatsData 统计数据结构
 type StatsData struct {
-	Name  string  `json:"name"`
-	Speed float64 `json:"speed"`
-	Total float64 `json:"total"`
-	Time  string  `json:"time"`
+	Name       string            `json:"name"`
+	NodeID     string            `json:"node_id"`
+	RunID      string            `json:"run_id"`
+	Version    string            `json:"version"`
+	Labels     map[string]string `json:"labels"`
+	Seq        uint64            `json:"seq"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Start      time.Time         `json:"start"`
+	QueueDepth int               `json:"queue_depth"`
+	Speed      float64           `json:"speed"`
+	Total      float64           `json:"total"`
+	Interval   struct {
+		Seconds float64 `json:"seconds"`
+		Bytes   int64   `json:"bytes"`
+		Speed   float64 `json:"speed"`
+	} `json:"interval"`
+	Session *struct {
+		Start time.Time `json:"start"`
+		Speed float64   `json:"speed"`
+	} `json:"session"`
+	Window *struct {
+		Start time.Time `json:"start"`
+		End   time.Time `json:"end"`
+	} `json:"window"`
 }
 
 func main() {
+	addr := flag.String("addr", ":8080", "监听地址")
+	token := flag.String("token", os.Getenv("NETFLOOD_STATS_TOKEN"), "要求的 Bearer token（默认读取环境变量 NETFLOOD_STATS_TOKEN）")
+	secretFile := flag.String("hmac-secret-file", "", "HMAC 签名密钥文件（未设置时读取环境变量 NETFLOOD_HMAC_SECRET）")
+	tlsCert := flag.String("tls-cert", "", "服务器证书，设置后使用 HTTPS")
+	tlsKey := flag.String("tls-key", "", "服务器私钥")
+	clientCA := flag.String("client-ca", "", "校验客户端证书的 CA（mTLS），设置后要求客户端证书")
+	flag.Parse()
+
+	secret := []byte(os.Getenv("NETFLOOD_HMAC_SECRET"))
+	if *secretFile != "" {
+		data, err := os.ReadFile(*secretFile)
+		if err != nil {
+			log.Fatalf("读取签名密钥失败: %v", err)
+		}
+		secret = bytes.TrimSpace(data)
+	}
+	auth = newAuthenticator(*token, secret)
+
+	server := &http.Server{Addr: *addr}
+	if *clientCA != "" {
+		pem, err := os.ReadFile(*clientCA)
+		if err != nil {
+			log.Fatalf("读取客户端 CA 失败: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Fatalf("客户端 CA 中没有有效的证书: %s", *clientCA)
+		}
+		server.TLSConfig = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	}
+
 	http.HandleFunc("/stats", handleStats)
 	http.HandleFunc("/", handleHome)
 
+	scheme := "http"
+	if *tlsCert != "" {
+		scheme = "https"
+	}
 	fmt.Println("===========================================")
 	fmt.Println("📊 NetFlood 统计接收服务器")
 	fmt.Println("===========================================")
-	fmt.Println("监听地址: http://localhost:8080")
-	fmt.Println("统计接口: http://localhost:8080/stats")
+	fmt.Printf("监听地址: %s://localhost%s\n", scheme, *addr)
+	fmt.Printf("统计接口: %s://localhost%s/stats\n", scheme, *addr)
+	fmt.Printf("认证: token %s，签名 %s，客户端证书 %s\n", enabled(auth.token != ""), enabled(auth.verifier != nil), enabled(server.TLSConfig != nil))
 	fmt.Println("")
 	fmt.Println("使用方法:")
 	fmt.Println("  ./netflood -demo -stats-api http://localhost:8080/stats")
@@ -34,11 +96,31 @@ func main() {
 	fmt.Println("===========================================")
 	fmt.Println("")
 
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	var err error
+	if *tlsCert != "" {
+		err = server.ListenAndServeTLS(*tlsCert, *tlsKey)
+	} else {
+		if server.TLSConfig != nil {
+			log.Fatal("-client-ca 需要同时设置 -tls-cert 和 -tls-key")
+		}
+		err = server.ListenAndServe()
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }
 
+// auth 请求认证，在 main 中按参数创建
+var auth *authenticator
+
+// enabled 返回开关的中文描述
+func enabled(on bool) string {
+	if on {
+		return "已启用"
+	}
+	return "未启用"
+}
+
 func handleHome(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	html := `
@@ -105,9 +187,11 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             <h3>📝 请求示例</h3>
             <pre>{
   "name": "my-server",
-  "speed": 15.5,
-  "total": 1024.0,
-  "time": "12:00-13:00"
+  "seq": 42,
+  "speed": 3.25,
+  "total": 40960.0,
+  "interval": {"seconds": 10, "bytes": 209715200, "speed": 20.0},
+  "window": {"start": "2025-10-26T12:00:00+08:00", "end": "2025-10-26T13:00:00+08:00"}
 }</pre>
         </div>
 
@@ -150,24 +234,37 @@ func handleStats(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	// 解析 JSON
-	var stats StatsData
-	if err := json.Unmarshal(body, &stats); err != nil {
+	// 校验 token 和签名（签名覆盖原始请求体，必须在解析前校验）
+	if err := auth.check(r, body); err != nil {
+		log.Printf("❌ 拒绝请求（%s）: %v", r.RemoteAddr, err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// 解析 JSON：单条为对象，积压数据重试时为数组
+	var batch []StatsData
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(body, &batch)
+	} else {
+		var stats StatsData
+		err = json.Unmarshal(body, &stats)
+		batch = []StatsData{stats}
+	}
+	if err != nil {
 		log.Printf("❌ 解析 JSON 失败: %v", err)
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	// 打印统计信息
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	fmt.Printf("[%s] 📊 收到统计数据:\n", timestamp)
-	fmt.Printf("  主机名: %s\n", stats.Name)
-	fmt.Printf("  平均速度: %.2f MB/s\n", stats.Speed)
-	fmt.Printf("  总下载量: %.2f MB (%.2f GB)\n", stats.Total, stats.Total/1024)
-	fmt.Printf("  时间段: %s\n", stats.Time)
-	fmt.Println("-------------------------------------------")
+	for _, stats := range batch {
+		if !dedupe.accept(stats) {
+			fmt.Printf("⏭️  忽略重复数据: %s 序号 %d\n", stats.Name, stats.Seq)
+			continue
+		}
+		printStats(stats)
+	}
 
-	// 返回成功响应
+	// 返回成功响应（重复数据也返回成功，避免客户端反复重试）
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	response := map[string]string{
@@ -176,3 +273,58 @@ func handleStats(w http.ResponseWriter, r *http.Request) {
 	}
 	json.NewEncoder(w).Encode(response)
 }
+
+// printStats 打印一条统计数据
+func printStats(stats StatsData) {
+	timestamp := time.Now().Format("2006-01-02 15:04:05")
+	fmt.Printf("[%s] 📊 收到统计数据:\n", timestamp)
+	fmt.Printf("  节点: %s（序号 %d，采集于 %s）\n", stats.Name, stats.Seq, stats.Timestamp.Format("15:04:05"))
+	if stats.NodeID != "" {
+		fmt.Printf("  节点 ID: %s（版本 %s）\n", stats.NodeID, stats.Version)
+	}
+	if len(stats.Labels) > 0 {
+		fmt.Printf("  标签: %v\n", stats.Labels)
+	}
+	fmt.Printf("  区间速度: %.2f MB/s（%.0f 秒内 %.2f MB）\n", stats.Interval.Speed, stats.Interval.Seconds, float64(stats.Interval.Bytes)/1024/1024)
+	if stats.Session != nil {
+		fmt.Printf("  会话速度: %.2f MB/s（%s 开始）\n", stats.Session.Speed, stats.Session.Start.Format("15:04:05"))
+	} else {
+		fmt.Println("  会话速度: 不在下载时间段内")
+	}
+	fmt.Printf("  平均速度: %.2f MB/s\n", stats.Speed)
+	fmt.Printf("  总下载量: %.2f MB (%.2f GB)\n", stats.Total, stats.Total/1024)
+	if stats.Window != nil {
+		fmt.Printf("  时间段: %s-%s\n", stats.Window.Start.Format("15:04"), stats.Window.End.Format("15:04"))
+	} else {
+		fmt.Println("  时间段: 全天候或时间段外")
+	}
+	if stats.QueueDepth > 0 {
+		fmt.Printf("  积压: %d 条待重试\n", stats.QueueDepth)
+	}
+	fmt.Println("-------------------------------------------")
+}
+
+// seqTracker 按节点记录已接收的最大序号，用于去重
+// 同一节点的数据按顺序发送，序号不大于已接收最大值的数据是重试造成的重复
+type seqTracker struct {
+	mu   sync.Mutex
+	last map[string]uint64 // 键为运行 ID（旧版本没有运行 ID 时为主机名 + 运行开始时间）
+}
+
+var dedupe = &seqTracker{last: make(map[string]uint64)}
+
+// accept 返回数据是否为新数据，并记录其序号
+func (t *seqTracker) accept(stats StatsData) bool {
+	key := stats.RunID
+	if key == "" {
+		key = stats.Name + "|" + stats.Start.String()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if stats.Seq != 0 && stats.Seq <= t.last[key] {
+		return false
+	}
+	t.last[key] = stats.Seq
+	return true
+}
@@ -0,0 +1,542 @@
+package collector
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/history"
+	"github.com/dora-exku/netflood/pkg/stats"
+	"gopkg.in/yaml.v3"
+)
+
+// 告警规则类型
+const (
+	RuleSilent     = "silent"      // 节点超过若干个上报间隔未上报
+	RuleLowRate    = "low_rate"    // 节点在时间段内的速度低于阈值
+	RuleFleetRate  = "fleet_rate"  // 全体总速度低于目标
+	RuleErrorRatio = "error_ratio" // 节点的下载失败比例高于阈值
+)
+
+// 告警状态
+const (
+	StatusPending  = "pending"  // 条件成立，但持续时间未达到 for
+	StatusFiring   = "firing"   // 告警中
+	StatusResolved = "resolved" // 已恢复
+)
+
+// 告警的默认值
+const (
+	DefaultAlertInterval   = 30 * time.Second
+	DefaultRuleWindow      = 5 * time.Minute
+	DefaultSilentIntervals = 3
+	DefaultMinRequests     = 10
+	DefaultWebhookTimeout  = 10 * time.Second
+	DefaultSeverity        = "warning"
+)
+
+// alertStateFile 告警状态文件（数据目录下），重启后不会重复发送已通知的告警
+const alertStateFile = "alerts.json"
+
+// Rule 告警规则
+type Rule struct {
+	Name     string            `yaml:"name"`     // 规则名称，必须唯一
+	Type     string            `yaml:"type"`     // silent、low_rate、fleet_rate、error_ratio
+	Severity string            `yaml:"severity"` // 级别，默认 warning
+	Labels   map[string]string `yaml:"labels"`   // 只检查带有这些标签的节点
+	For      time.Duration     `yaml:"for"`      // 条件持续多久后才告警，默认立即告警
+	Window   time.Duration     `yaml:"window"`   // 计算速度和失败比例的时间窗口，默认 5m
+
+	Intervals      int           `yaml:"intervals"`       // silent：未上报的间隔数，默认 3
+	ReportInterval time.Duration `yaml:"report_interval"` // silent：节点的上报间隔，默认按最近的上报估计
+	MinSpeed       float64       `yaml:"min_speed"`       // low_rate、fleet_rate：速度阈值（MB/s）
+	MaxRatio       float64       `yaml:"max_ratio"`       // error_ratio：失败比例阈值（0~1）
+	MinRequests    int64         `yaml:"min_requests"`    // error_ratio：时间窗口内的下载次数少于该值时不检查，默认 10
+}
+
+// WebhookConfig 告警通知的 webhook
+type WebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Timeout time.Duration     `yaml:"timeout"` // 单次发送超时，默认 10s
+	Repeat  time.Duration     `yaml:"repeat"`  // 告警持续时重复通知的间隔，默认不重复
+	Auth    stats.AuthOptions `yaml:"auth"`    // 认证、签名和 TLS，与 HTTP 统计输出相同
+}
+
+// AlertConfig 告警配置
+type AlertConfig struct {
+	Interval time.Duration `yaml:"interval"` // 检查间隔，默认 30s
+	Webhook  WebhookConfig `yaml:"webhook"`
+	Rules    []Rule        `yaml:"rules"`
+}
+
+// LoadAlertConfig 从 YAML 文件加载告警配置并检查
+func LoadAlertConfig(path string) (AlertConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return AlertConfig{}, fmt.Errorf("读取告警配置失败: %w", err)
+	}
+	var cfg AlertConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return AlertConfig{}, fmt.Errorf("解析告警配置失败: %w", err)
+	}
+	if err := cfg.normalize(); err != nil {
+		return AlertConfig{}, err
+	}
+	return cfg, nil
+}
+
+// normalize 检查配置并填写默认值
+func (cfg *AlertConfig) normalize() error {
+	if cfg.Webhook.URL == "" {
+		return errors.New("告警配置缺少 webhook.url")
+	}
+	cfg.Interval = cmp.Or(cfg.Interval, DefaultAlertInterval)
+	cfg.Webhook.Timeout = cmp.Or(cfg.Webhook.Timeout, DefaultWebhookTimeout)
+
+	names := make(map[string]bool)
+	for i := range cfg.Rules {
+		r := &cfg.Rules[i]
+		if r.Name == "" {
+			return fmt.Errorf("第 %d 条告警规则缺少 name", i+1)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("告警规则名称重复: %s", r.Name)
+		}
+		names[r.Name] = true
+		r.Severity = cmp.Or(r.Severity, DefaultSeverity)
+		r.Window = cmp.Or(r.Window, DefaultRuleWindow)
+
+		switch r.Type {
+		case RuleSilent:
+			r.Intervals = cmp.Or(r.Intervals, DefaultSilentIntervals)
+			if r.Intervals < 1 {
+				return fmt.Errorf("告警规则 %s: intervals 必须大于 0", r.Name)
+			}
+		case RuleLowRate, RuleFleetRate:
+			if r.MinSpeed <= 0 {
+				return fmt.Errorf("告警规则 %s: %s 类型需要设置 min_speed", r.Name, r.Type)
+			}
+		case RuleErrorRatio:
+			if r.MaxRatio <= 0 || r.MaxRatio >= 1 {
+				return fmt.Errorf("告警规则 %s: max_ratio 应在 0 到 1 之间", r.Name)
+			}
+			r.MinRequests = cmp.Or(r.MinRequests, DefaultMinRequests)
+		default:
+			return fmt.Errorf("告警规则 %s: 未知类型 %q（应为 silent、low_rate、fleet_rate 或 error_ratio）", r.Name, r.Type)
+		}
+	}
+	return nil
+}
+
+// Alert 一条告警
+type Alert struct {
+	ID        string            `json:"id"` // 规则名称/节点标识，全体告警为规则名称
+	Rule      string            `json:"rule"`
+	Type      string            `json:"type"`
+	Severity  string            `json:"severity"`
+	Status    string            `json:"status"`
+	Node      string            `json:"node,omitempty"` // 节点标识，全体告警为空
+	NodeName  string            `json:"node_name,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"` // 节点标签
+	Value     float64           `json:"value"`            // 当前值：未上报秒数、速度（MB/s）或失败比例
+	Threshold float64           `json:"threshold"`
+	Summary   string            `json:"summary"`
+	StartsAt  time.Time         `json:"starts_at"`         // 条件开始成立的时间
+	EndsAt    *time.Time        `json:"ends_at,omitempty"` // 恢复时间
+}
+
+// alertState 告警及其通知状态
+type alertState struct {
+	Alert
+	Notified string    `json:"notified"` // 已成功通知的状态
+	SentAt   time.Time `json:"sent_at"`  // 最近一次通知的时间
+}
+
+// WebhookPayload 发送到 webhook 的请求体
+type WebhookPayload struct {
+	Version string    `json:"version"`
+	Status  string    `json:"status"` // 有任一告警中时为 firing，否则为 resolved
+	Alerts  []Alert   `json:"alerts"`
+	SentAt  time.Time `json:"sent_at"`
+	Test    bool      `json:"test,omitempty"` // 由 SendTest 发送的测试通知
+}
+
+// Alerter 定期按规则检查收集器中的数据，告警开始和恢复时通知 webhook
+// 同一告警只在状态变化时通知一次（设置 repeat 时告警持续期间定期重复），发送失败时下一次检查重试
+type Alerter struct {
+	c         *Collector
+	cfg       AlertConfig
+	auth      *stats.Auth
+	client    *http.Client
+	logger    *slog.Logger
+	statePath string
+
+	mu     sync.Mutex
+	alerts map[string]*alertState
+}
+
+// NewAlerter 创建告警器，cfg 通常由 LoadAlertConfig 加载；加载数据目录中保存的告警状态
+func NewAlerter(c *Collector, cfg AlertConfig, logger *slog.Logger) (*Alerter, error) {
+	if err := cfg.normalize(); err != nil {
+		return nil, err
+	}
+	a := &Alerter{
+		c:         c,
+		cfg:       cfg,
+		logger:    cmp.Or(logger, slog.New(slog.DiscardHandler)),
+		statePath: filepath.Join(c.store.dir, alertStateFile),
+		alerts:    make(map[string]*alertState),
+	}
+	if !cfg.Webhook.Auth.IsZero() {
+		auth, err := stats.NewAuth(cfg.Webhook.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("webhook 认证配置错误: %w", err)
+		}
+		a.auth = auth
+	}
+	a.client = a.auth.Client()
+	a.client.Timeout = cfg.Webhook.Timeout
+
+	data, err := os.ReadFile(a.statePath)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &a.alerts); err != nil {
+			a.logger.Warn("告警状态文件损坏，忽略", "path", a.statePath, "error", err)
+			a.alerts = make(map[string]*alertState)
+		}
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("读取告警状态失败: %w", err)
+	}
+	return a, nil
+}
+
+// Run 按检查间隔检查规则，直到 ctx 取消
+func (a *Alerter) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := a.Evaluate(ctx); err != nil {
+			a.logger.Warn("发送告警通知失败，下次检查时重试", "error", err)
+		}
+	}
+}
+
+// Evaluate 检查所有规则，更新告警状态并发送需要通知的告警
+func (a *Alerter) Evaluate(ctx context.Context) error {
+	now := a.c.now()
+	active := make(map[string]Alert)
+	for _, rule := range a.cfg.Rules {
+		for _, alert := range a.c.check(rule, now) {
+			active[alert.ID] = alert
+		}
+	}
+
+	a.mu.Lock()
+	for id, alert := range active {
+		st, ok := a.alerts[id]
+		if !ok || st.Status == StatusResolved {
+			st = &alertState{Alert: alert}
+			st.Status, st.StartsAt = StatusPending, now
+			a.alerts[id] = st
+		}
+		startsAt, status := st.StartsAt, st.Status
+		st.Alert = alert
+		st.StartsAt, st.Status = startsAt, status
+		if st.Status == StatusPending && now.Sub(st.StartsAt) >= a.rule(st.Rule).For {
+			st.Status = StatusFiring
+		}
+	}
+	for id, st := range a.alerts {
+		if _, ok := active[id]; ok || st.Status == StatusResolved {
+			continue
+		}
+		if st.Status == StatusPending || st.Notified == "" {
+			delete(a.alerts, id) // 未通知过的告警恢复时不需要通知
+			continue
+		}
+		end := now
+		st.Status, st.EndsAt = StatusResolved, &end
+	}
+
+	var pending []Alert
+	for _, st := range a.alerts {
+		switch {
+		case st.Status == StatusPending:
+		case st.Status != st.Notified:
+			pending = append(pending, st.Alert)
+		case st.Status == StatusFiring && a.cfg.Webhook.Repeat > 0 && now.Sub(st.SentAt) >= a.cfg.Webhook.Repeat:
+			pending = append(pending, st.Alert)
+		}
+	}
+	a.mu.Unlock()
+
+	var err error
+	if len(pending) > 0 {
+		sortAlerts(pending)
+		if err = a.send(ctx, WebhookPayload{Alerts: pending, SentAt: now}); err == nil {
+			a.markSent(pending, now)
+			for _, alert := range pending {
+				a.logger.Info("已发送告警通知", "id", alert.ID, "status", alert.Status, "summary", alert.Summary)
+			}
+		}
+	}
+	if saveErr := a.save(); saveErr != nil {
+		a.logger.Warn("保存告警状态失败", "error", saveErr)
+	}
+	return err
+}
+
+// rule 按名称查找规则
+func (a *Alerter) rule(name string) Rule {
+	for _, r := range a.cfg.Rules {
+		if r.Name == name {
+			return r
+		}
+	}
+	return Rule{}
+}
+
+// markSent 记录已通知的告警，已恢复的告警通知后删除
+func (a *Alerter) markSent(sent []Alert, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, alert := range sent {
+		st, ok := a.alerts[alert.ID]
+		if !ok || st.Status != alert.Status {
+			continue // 发送期间状态已变化，下次检查时再通知
+		}
+		if st.Status == StatusResolved {
+			delete(a.alerts, alert.ID)
+			continue
+		}
+		st.Notified, st.SentAt = st.Status, now
+	}
+}
+
+// save 保存告警状态
+func (a *Alerter) save() error {
+	a.mu.Lock()
+	data, err := json.Marshal(a.alerts)
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return history.WriteFileAtomic(a.statePath, data)
+}
+
+// Alerts 返回未恢复的告警（告警中和等待中），告警中的排在前面
+func (a *Alerter) Alerts() []Alert {
+	a.mu.Lock()
+	alerts := make([]Alert, 0, len(a.alerts))
+	for _, st := range a.alerts {
+		if st.Status != StatusResolved {
+			alerts = append(alerts, st.Alert)
+		}
+	}
+	a.mu.Unlock()
+	sortAlerts(alerts)
+	return alerts
+}
+
+// sortAlerts 告警中的排在前面，其次按 ID 排序
+func sortAlerts(alerts []Alert) {
+	sort.Slice(alerts, func(i, j int) bool {
+		if (alerts[i].Status == StatusFiring) != (alerts[j].Status == StatusFiring) {
+			return alerts[i].Status == StatusFiring
+		}
+		return alerts[i].ID < alerts[j].ID
+	})
+}
+
+// SendTest 为每条规则发送一条测试告警及其恢复通知，用于检查 webhook 配置
+func (a *Alerter) SendTest(ctx context.Context) error {
+	now := a.c.now()
+	var alerts []Alert
+	for _, r := range a.cfg.Rules {
+		alerts = append(alerts, Alert{
+			ID:       r.Name + "/test",
+			Rule:     r.Name,
+			Type:     r.Type,
+			Severity: r.Severity,
+			Status:   StatusFiring,
+			Summary:  "测试告警: " + r.Name,
+			StartsAt: now,
+		})
+	}
+	if err := a.send(ctx, WebhookPayload{Alerts: alerts, SentAt: now, Test: true}); err != nil {
+		return err
+	}
+	for i := range alerts {
+		alerts[i].Status, alerts[i].EndsAt = StatusResolved, &now
+	}
+	return a.send(ctx, WebhookPayload{Alerts: alerts, SentAt: now, Test: true})
+}
+
+// send 把告警 POST 到 webhook
+func (a *Alerter) send(ctx context.Context, payload WebhookPayload) error {
+	payload.Version = "1"
+	payload.Status = StatusResolved
+	if slices.ContainsFunc(payload.Alerts, func(alert Alert) bool { return alert.Status == StatusFiring }) {
+		payload.Status = StatusFiring
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("序列化告警失败: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.cfg.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("创建 webhook 请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := a.auth.Apply(req, body); err != nil {
+		return err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("发送 webhook 失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook 返回状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// check 返回规则当前成立的告警（状态和开始时间由 Alerter 维护）
+func (c *Collector) check(rule Rule, now time.Time) []Alert {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var nodes []*nodeState
+	for _, n := range c.nodes {
+		if matchLabels(n.Labels, rule.Labels) {
+			nodes = append(nodes, n)
+		}
+	}
+	from := now.Add(-rule.Window)
+	alert := func(n *nodeState, value, threshold float64, summary string) Alert {
+		a := Alert{ID: rule.Name, Rule: rule.Name, Type: rule.Type, Severity: rule.Severity, Value: value, Threshold: threshold, Summary: summary}
+		if n != nil {
+			a.ID += "/" + n.Key
+			a.Node, a.NodeName, a.Labels = n.Key, n.Name, n.Labels
+		}
+		return a
+	}
+
+	var alerts []Alert
+	switch rule.Type {
+	case RuleSilent:
+		for _, n := range nodes {
+			interval := cmp.Or(rule.ReportInterval, reportInterval(n.points))
+			limit := interval * time.Duration(rule.Intervals)
+			if silence := now.Sub(n.LastSeen); silence > limit {
+				alerts = append(alerts, alert(n, silence.Seconds(), limit.Seconds(),
+					fmt.Sprintf("节点 %s 已 %s 未上报（超过 %d 个上报间隔）", n.Name, silence.Round(time.Second), rule.Intervals)))
+			}
+		}
+
+	case RuleLowRate:
+		for _, n := range nodes {
+			// 离线的节点由 silent 规则告警
+			if !n.InWindow || now.Sub(n.LastSeen) > c.offlineAfter {
+				continue
+			}
+			var bytes int64
+			var seconds float64
+			for _, p := range pointsIn(n.points, from, now.Add(time.Nanosecond)) {
+				if p.InWindow {
+					bytes += p.Bytes
+					seconds += p.Seconds
+				}
+			}
+			if seconds <= 0 {
+				continue
+			}
+			if speed := bytesToMB(bytes) / seconds; speed < rule.MinSpeed {
+				alerts = append(alerts, alert(n, speed, rule.MinSpeed,
+					fmt.Sprintf("节点 %s 在时间段内最近 %s 的速度 %.2f MB/s 低于 %.2f MB/s", n.Name, rule.Window, speed, rule.MinSpeed)))
+			}
+		}
+
+	case RuleFleetRate:
+		// 没有节点在时间段内时总速度为 0 是正常的
+		var bytes int64
+		active := false
+		for _, n := range nodes {
+			active = active || n.InWindow && now.Sub(n.LastSeen) <= c.offlineAfter
+			for _, p := range pointsIn(n.points, from, now.Add(time.Nanosecond)) {
+				bytes += p.Bytes
+			}
+		}
+		if !active {
+			break
+		}
+		if speed := bytesToMB(bytes) / rule.Window.Seconds(); speed < rule.MinSpeed {
+			alerts = append(alerts, alert(nil, speed, rule.MinSpeed,
+				fmt.Sprintf("全体最近 %s 的总速度 %.2f MB/s 低于目标 %.2f MB/s", rule.Window, speed, rule.MinSpeed)))
+		}
+
+	case RuleErrorRatio:
+		for _, n := range nodes {
+			var completed, failed int64
+			for _, p := range pointsIn(n.points, from, now.Add(time.Nanosecond)) {
+				completed += p.Completed
+				failed += p.Failed
+			}
+			total := completed + failed
+			if total < rule.MinRequests {
+				continue
+			}
+			if ratio := float64(failed) / float64(total); ratio > rule.MaxRatio {
+				alerts = append(alerts, alert(n, ratio, rule.MaxRatio,
+					fmt.Sprintf("节点 %s 最近 %s 的下载失败比例 %.1f%%（%d/%d）高于 %.1f%%", n.Name, rule.Window, ratio*100, failed, total, rule.MaxRatio*100)))
+			}
+		}
+	}
+	return alerts
+}
+
+// reportInterval 按最近几次上报的区间时长估计节点的上报间隔（取中位数，忽略会话开始、结束时的立即上报）
+// 上报少于 3 次时（启动时的第一次上报区间很短）使用 stats.DefaultInterval
+func reportInterval(points []Point) time.Duration {
+	var seconds []float64
+	for i := len(points) - 1; i >= 0 && len(seconds) < 5; i-- {
+		if points[i].Seconds > 0 {
+			seconds = append(seconds, points[i].Seconds)
+		}
+	}
+	if len(seconds) < 3 {
+		return stats.DefaultInterval
+	}
+	slices.Sort(seconds)
+	return time.Duration(seconds[len(seconds)/2] * float64(time.Second))
+}
+
+// matchLabels 返回节点标签是否包含选择器中的所有标签
+func matchLabels(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
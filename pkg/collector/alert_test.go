@@ -0,0 +1,252 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+// receiver 记录收到的 webhook 请求的测试接收端
+type receiver struct {
+	mu       sync.Mutex
+	payloads []WebhookPayload
+	fail     int // 前 fail 次请求返回 500
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var p WebhookPayload
+	json.NewDecoder(req.Body).Decode(&p)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail > 0 {
+		r.fail--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	r.payloads = append(r.payloads, p)
+}
+
+// take 返回并清空收到的告警，格式为 "状态 ID"
+func (r *receiver) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var result []string
+	for _, p := range r.payloads {
+		for _, a := range p.Alerts {
+			result = append(result, a.Status+" "+a.ID)
+		}
+	}
+	r.payloads = nil
+	return result
+}
+
+func newTestAlerter(t *testing.T, c *Collector, url string, rules ...Rule) *Alerter {
+	t.Helper()
+	a, err := NewAlerter(c, AlertConfig{Webhook: WebhookConfig{URL: url}, Rules: rules}, nil)
+	if err != nil {
+		t.Fatalf("NewAlerter() error = %v", err)
+	}
+	return a
+}
+
+func TestLoadAlertConfig(t *testing.T) {
+	cfg, err := LoadAlertConfig("../../examples/alert-receiver/alerts.yml")
+	if err != nil {
+		t.Fatalf("LoadAlertConfig(example) error = %v", err)
+	}
+	if len(cfg.Rules) != 4 || cfg.Rules[0].Intervals != 3 || cfg.Rules[3].MinRequests != 10 || cfg.Rules[1].Window != 5*time.Minute {
+		t.Errorf("LoadAlertConfig(example) = %+v", cfg)
+	}
+
+	tests := []struct {
+		name, yaml, want string
+	}{
+		{"no webhook", "rules: []", "webhook.url"},
+		{"unknown field", "webhook: {url: x}\nrules: [{name: a, type: silent, foo: 1}]", "foo"},
+		{"unknown type", "webhook: {url: x}\nrules: [{name: a, type: slow}]", "未知类型"},
+		{"missing name", "webhook: {url: x}\nrules: [{type: silent}]", "name"},
+		{"duplicate", "webhook: {url: x}\nrules: [{name: a, type: silent}, {name: a, type: silent}]", "重复"},
+		{"min_speed", "webhook: {url: x}\nrules: [{name: a, type: low_rate}]", "min_speed"},
+		{"max_ratio", "webhook: {url: x}\nrules: [{name: a, type: error_ratio, max_ratio: 1.5}]", "max_ratio"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "alerts.yml")
+			os.WriteFile(path, []byte(tt.yaml), 0o644)
+			if _, err := LoadAlertConfig(path); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadAlertConfig() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlerter_SilentLifecycle(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestCollector(t, dir)
+	now := testNow
+	c.now = func() time.Time { return now }
+	rcv := &receiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	rule := Rule{Name: "silent", Type: RuleSilent, Intervals: 3, For: 10 * time.Second}
+	a := newTestAlerter(t, c, srv.URL, rule)
+	ctx := context.Background()
+
+	c.Ingest([]stats.StatsData{report("a", "r", 1, 0, 1)})
+	steps := []struct {
+		advance time.Duration
+		ingest  bool
+		want    string
+	}{
+		{20 * time.Second, false, ""},                    // 未超过 3 个间隔
+		{15 * time.Second, false, ""},                    // 条件成立，等待 for
+		{10 * time.Second, false, "firing silent/id-a"},  // 持续 10 秒后告警
+		{10 * time.Second, false, ""},                    // 不重复通知
+		{10 * time.Second, true, "resolved silent/id-a"}, // 恢复上报
+		{10 * time.Second, false, ""},                    // 恢复后不再通知
+	}
+	for i, step := range steps {
+		now = now.Add(step.advance)
+		if step.ingest {
+			c.Ingest([]stats.StatsData{report("a", "r", uint64(i+2), now.Sub(testNow), 1)})
+		}
+		if err := a.Evaluate(ctx); err != nil {
+			t.Fatalf("step %d: Evaluate() error = %v", i, err)
+		}
+		if got := strings.Join(rcv.take(), ","); got != step.want {
+			t.Errorf("step %d: notifications = %q, want %q", i, got, step.want)
+		}
+	}
+	if alerts := a.Alerts(); len(alerts) != 0 {
+		t.Errorf("Alerts() = %+v, want none", alerts)
+	}
+}
+
+func TestAlerter_RetryAndPersist(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestCollector(t, dir)
+	now := testNow
+	c.now = func() time.Time { return now }
+	rcv := &receiver{fail: 1}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	rule := Rule{Name: "silent", Type: RuleSilent, ReportInterval: 10 * time.Second}
+	ctx := context.Background()
+
+	c.Ingest([]stats.StatsData{report("a", "r", 1, 0, 1)})
+	now = now.Add(time.Minute)
+	a := newTestAlerter(t, c, srv.URL, rule)
+	if err := a.Evaluate(ctx); err == nil {
+		t.Fatal("Evaluate() error = nil, want webhook failure")
+	}
+	if alerts := a.Alerts(); len(alerts) != 1 || alerts[0].Status != StatusFiring || alerts[0].Value != 60 {
+		t.Fatalf("Alerts() = %+v, want one firing alert with value 60", alerts)
+	}
+	// 发送失败后下一次检查重试
+	a.Evaluate(ctx)
+	if got := rcv.take(); len(got) != 1 {
+		t.Fatalf("notifications after retry = %v, want 1", got)
+	}
+
+	// 重启后不重复通知
+	a = newTestAlerter(t, c, srv.URL, rule)
+	a.Evaluate(ctx)
+	if got := rcv.take(); len(got) != 0 {
+		t.Errorf("notifications after restart = %v, want none", got)
+	}
+
+	// repeat 到期后重复通知
+	a, _ = NewAlerter(c, AlertConfig{Webhook: WebhookConfig{URL: srv.URL, Repeat: time.Hour}, Rules: []Rule{rule}}, nil)
+	now = now.Add(time.Hour)
+	a.Evaluate(ctx)
+	if got := rcv.take(); len(got) != 1 || got[0] != "firing silent/id-a" {
+		t.Errorf("notifications after repeat = %v, want 1 firing", got)
+	}
+}
+
+func TestCollector_CheckRules(t *testing.T) {
+	c := newTestCollector(t, t.TempDir())
+	session := &stats.SessionStats{Start: testNow.Add(-time.Hour)}
+	point := func(node string, seq uint64, at time.Duration, mb int64, done, failed int64, labels map[string]string) stats.StatsData {
+		data := report(node, "r", seq, at, mb)
+		data.Interval.Start = data.Timestamp.Add(-10 * time.Second)
+		data.Interval.Completed, data.Interval.Failed = done, failed
+		data.Session = session
+		data.Labels = labels
+		return data
+	}
+	east := map[string]string{"region": "east"}
+	c.Ingest([]stats.StatsData{
+		point("fast", 1, -20*time.Second, 1000, 10, 0, east),
+		point("fast", 2, -10*time.Second, 1000, 10, 1, east),
+		point("slow", 1, -20*time.Second, 10, 5, 5, nil),
+		point("slow", 2, -10*time.Second, 10, 5, 5, nil),
+	})
+
+	tests := []struct {
+		rule Rule
+		want []string
+	}{
+		{Rule{Name: "r", Type: RuleLowRate, MinSpeed: 50, Window: time.Minute}, []string{"r/id-slow"}},
+		{Rule{Name: "r", Type: RuleLowRate, MinSpeed: 50, Window: time.Minute, Labels: east}, nil},
+		{Rule{Name: "r", Type: RuleFleetRate, MinSpeed: 30, Window: time.Minute}, nil},                                              // 2020MB / 60s
+		{Rule{Name: "r", Type: RuleFleetRate, MinSpeed: 40, Window: time.Minute, Labels: map[string]string{"region": "west"}}, nil}, // 没有节点在时间段内
+		{Rule{Name: "r", Type: RuleFleetRate, MinSpeed: 40, Window: time.Minute, Labels: east}, []string{"r"}},
+		{Rule{Name: "r", Type: RuleErrorRatio, MaxRatio: 0.2, MinRequests: 10, Window: time.Minute}, []string{"r/id-slow"}},
+		{Rule{Name: "r", Type: RuleErrorRatio, MaxRatio: 0.2, MinRequests: 30, Window: time.Minute}, nil},
+		{Rule{Name: "r", Type: RuleSilent, Intervals: 3}, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, a := range c.check(tt.rule, testNow) {
+			got = append(got, a.ID)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("check(%s %+v) = %v, want %v", tt.rule.Type, tt.rule.Labels, got, tt.want)
+		}
+	}
+}
+
+func TestReportInterval(t *testing.T) {
+	tests := []struct {
+		seconds []float64
+		want    time.Duration
+	}{
+		{nil, stats.DefaultInterval},
+		{[]float64{0.001, 1}, stats.DefaultInterval},
+		{[]float64{10, 10, 0.5, 10, 10}, 10 * time.Second},
+		{[]float64{60, 60, 2, 60}, time.Minute},
+	}
+	for _, tt := range tests {
+		var points []Point
+		for _, s := range tt.seconds {
+			points = append(points, Point{Seconds: s})
+		}
+		if got := reportInterval(points); got != tt.want {
+			t.Errorf("reportInterval(%v) = %v, want %v", tt.seconds, got, tt.want)
+		}
+	}
+}
+
+func TestAlerter_SendTest(t *testing.T) {
+	rcv := &receiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	a := newTestAlerter(t, newTestCollector(t, t.TempDir()), srv.URL,
+		Rule{Name: "a", Type: RuleSilent}, Rule{Name: "b", Type: RuleErrorRatio, MaxRatio: 0.1})
+	if err := a.SendTest(context.Background()); err != nil {
+		t.Fatalf("SendTest() error = %v", err)
+	}
+	if got := strings.Join(rcv.take(), ","); got != "firing a/test,firing b/test,resolved a/test,resolved b/test" {
+		t.Errorf("SendTest() notifications = %s", got)
+	}
+}
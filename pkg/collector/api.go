@@ -0,0 +1,598 @@
+package collector
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+// 查询参数的默认值和限制
+const (
+	DefaultQueryRange = time.Hour
+	DefaultDays       = 7
+	maxBuckets        = 2000
+	maxDays           = 366
+	maxBodySize       = 16 << 20
+)
+
+// 事件流的发送间隔：数据变化后最多每 eventThrottle 通知一次，空闲时每 eventKeepAlive 发送一次注释保持连接
+const (
+	eventThrottle  = time.Second
+	eventKeepAlive = 15 * time.Second
+)
+
+// steps 自动选择的时间桶长度，选择使桶数不超过 targetBuckets 的最小值
+var steps = []time.Duration{
+	10 * time.Second, 30 * time.Second, time.Minute, 5 * time.Minute, 15 * time.Minute,
+	time.Hour, 6 * time.Hour, 24 * time.Hour,
+}
+
+const targetBuckets = 300
+
+// APIOptions HTTP 接口配置
+type APIOptions struct {
+	Token      string                 // 上报接口要求的 Bearer token，为空时不校验
+	ReadToken  string                 // 查询接口要求的 Bearer token，为空时不校验
+	Verifier   *stats.Verifier        // 上报接口的签名校验，为空时不校验
+	Alerter    *Alerter               // 告警器，为空时告警接口返回空列表
+	Tasks      *Dispatcher            // 任务分发器，为空时任务接口返回 404
+	Control    *Controller            // 指令控制器，需要同时设置 Signer 和 AdminToken
+	Signer     *stats.DirectiveSigner // 为带指令的响应签名，节点使用对应的公钥校验
+	CertNodes  bool                   // 只向客户端证书中的节点（CN 或 DNS 名称为节点标识）下发指令、接受其确认
+	AdminToken string                 // 下发指令接口要求的 Bearer token，为空时不能下发指令
+	Budget     *Balancer              // 带宽分配器，为空时带宽目标接口返回 404
+	Logger     *slog.Logger           // 默认丢弃
+}
+
+// API 收集器的 HTTP 接口
+//
+//	POST /stats                       接收上报（单条对象或数组）
+//	GET  /api/nodes                   所有节点的状态
+//	GET  /api/nodes/{key}             单个节点的状态
+//	GET  /api/nodes/{key}/series      单个节点的时间序列
+//	GET  /api/fleet                   全体节点的汇总和时间序列
+//	GET  /api/top                     按平均速度排序的节点
+//	GET  /api/export.csv              导出原始数据点
+//	GET  /api/daily                   每天的下载总量
+//	GET  /api/events                  收到新数据时推送的事件流（SSE）
+//	GET  /api/alerts                  未恢复的告警
+//	GET  /api/tasks                   任务池版本和各节点的分配状态
+//	GET  /tasks                       节点获取分配的任务列表（"IP,URL" 格式）
+//	GET  /api/directives              下发的指令及节点的确认状态
+//	POST /api/directives              向节点下发指令（需要 AdminToken）
+//	GET  /api/budget                  带宽目标、实际速度和各节点的分配
+//	GET  /                            仪表盘页面
+//
+// 任务列表接口接受上报或查询的 token，节点身份来自请求头（见 stats.Identity.SetHeader），
+// 也可以用 node 参数预览某个节点的任务（不计入分片）。
+// 指令随节点下一次上报的响应下发（响应由 Signer 签名），节点在之后的上报中确认；
+// 设置 CertNodes 时只下发和确认客户端证书中的节点的指令，其他节点无法取走或确认。
+// 查询接口的 token 可以放在 Authorization 请求头或 token 参数中（EventSource 无法设置请求头）。
+// 查询接口的时间范围参数：from、to（RFC3339 或 Unix 秒），或 last（例如 1h，默认 1 小时）；
+// step 为时间桶长度（例如 1m），默认按时间范围自动选择
+type API struct {
+	c      *Collector
+	opts   APIOptions
+	logger *slog.Logger
+	mux    *http.ServeMux
+}
+
+// NewAPI 创建收集器的 HTTP 接口
+func NewAPI(c *Collector, opts APIOptions) *API {
+	a := &API{c: c, opts: opts, logger: opts.Logger, mux: http.NewServeMux()}
+	if a.logger == nil {
+		a.logger = slog.New(slog.DiscardHandler)
+	}
+	a.mux.HandleFunc("POST /stats", a.handleIngest)
+	a.mux.HandleFunc("GET /api/nodes", a.read(a.handleNodes))
+	a.mux.HandleFunc("GET /api/nodes/{key}", a.read(a.handleNode))
+	a.mux.HandleFunc("GET /api/nodes/{key}/series", a.read(a.handleSeries))
+	a.mux.HandleFunc("GET /api/fleet", a.read(a.handleFleet))
+	a.mux.HandleFunc("GET /api/top", a.read(a.handleTop))
+	a.mux.HandleFunc("GET /api/export.csv", a.read(a.handleExport))
+	a.mux.HandleFunc("GET /api/daily", a.read(a.handleDaily))
+	a.mux.HandleFunc("GET /api/events", a.read(a.handleEvents))
+	a.mux.HandleFunc("GET /api/alerts", a.read(a.handleAlerts))
+	a.mux.HandleFunc("GET /api/tasks", a.read(a.handleTaskStatus))
+	a.mux.HandleFunc("GET /tasks", a.handleTasks)
+	a.mux.HandleFunc("GET /api/directives", a.read(a.handleDirectives))
+	a.mux.HandleFunc("POST /api/directives", a.handleSubmitDirective)
+	a.mux.HandleFunc("GET /api/budget", a.read(a.handleBudget))
+	a.mux.Handle("GET /{$}", dashboardHandler())
+	return a
+}
+
+// Handle 在同一个路由上注册其他处理器（例如页面）
+func (a *API) Handle(pattern string, handler http.Handler) {
+	a.mux.Handle(pattern, handler)
+}
+
+// ServeHTTP 实现 http.Handler
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+// checkToken 校验 Bearer token，token 为空时总是通过
+func checkToken(r *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) == 1
+}
+
+// read 为查询接口加上 token 校验，token 也可以放在 token 参数中
+func (a *API) read(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("token"); token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		if !checkToken(r, a.opts.ReadToken) {
+			writeError(w, http.StatusUnauthorized, errors.New("token 无效"))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// checkTaskToken 任务列表接口接受上报或查询的 token，两者都未设置时不校验
+func (a *API) checkTaskToken(r *http.Request) bool {
+	if token := r.URL.Query().Get("token"); token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	if a.opts.Token == "" && a.opts.ReadToken == "" {
+		return true
+	}
+	return a.opts.Token != "" && checkToken(r, a.opts.Token) || a.opts.ReadToken != "" && checkToken(r, a.opts.ReadToken)
+}
+
+func (a *API) handleIngest(w http.ResponseWriter, r *http.Request) {
+	// token 只看请求头，校验通过后才读取请求体
+	if !checkToken(r, a.opts.Token) {
+		a.logger.Warn("拒绝上报", "remote", r.RemoteAddr, "error", "token 无效")
+		writeError(w, http.StatusUnauthorized, errors.New("token 无效"))
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("读取请求体失败: %w", err))
+		return
+	}
+
+	// 签名覆盖原始请求体，必须在解析前校验
+	if a.opts.Verifier != nil {
+		if err := a.opts.Verifier.Verify(r.Header, body); err != nil {
+			a.logger.Warn("拒绝上报", "remote", r.RemoteAddr, "error", err)
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+	}
+
+	batch, err := decodeBatch(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	accepted, err := a.c.Ingest(batch)
+	if err != nil {
+		a.logger.Error("保存上报失败", "error", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	a.logger.Debug("收到上报", "remote", r.RemoteAddr, "count", len(batch), "accepted", accepted)
+
+	// 重复数据也返回成功，避免客户端反复重试
+	resp := stats.ReportResponse{Status: "success", Accepted: accepted}
+	if a.opts.Control == nil || a.opts.Signer == nil {
+		writeJSON(w, resp)
+		return
+	}
+	// 重试的数据中也可能带有确认，按节点处理整批数据
+	var names []string
+	if a.opts.CertNodes {
+		names = certNames(r)
+	}
+	var keys []string
+	for _, data := range batch {
+		key := NodeKey(data)
+		if a.opts.CertNodes && !slices.Contains(names, key) {
+			// 其他节点不能取走或确认本节点的指令
+			a.logger.Warn("忽略与客户端证书不符的节点的指令", "remote", r.RemoteAddr, "node", key, "cert", names)
+			continue
+		}
+		a.opts.Control.Ack(key, data.Acks)
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		resp.Directives = append(resp.Directives, a.opts.Control.Deliver(key)...)
+	}
+	a.writeSigned(w, resp)
+}
+
+// certNames 返回客户端证书中的名称（CN 和 DNS 名称），没有客户端证书时返回 nil
+func certNames(r *http.Request) []string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := r.TLS.PeerCertificates[0]
+	return append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+}
+
+// writeSigned 输出 JSON 并用 Signer 为响应签名，节点据此校验下发的指令
+func (a *API) writeSigned(w http.ResponseWriter, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := a.opts.Signer.SignHeader(w.Header(), body); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// decodeBatch 解析上报的请求体：单条为对象，积压数据重试时为数组
+func decodeBatch(body []byte) ([]stats.StatsData, error) {
+	var batch []stats.StatsData
+	var err error
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &batch)
+	} else {
+		var data stats.StatsData
+		err = json.Unmarshal(body, &data)
+		batch = []stats.StatsData{data}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析 JSON 失败: %w", err)
+	}
+	return batch, nil
+}
+
+func (a *API) handleNodes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.c.Nodes())
+}
+
+func (a *API) handleNode(w http.ResponseWriter, r *http.Request) {
+	node, ok := a.c.Node(r.PathValue("key"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("节点不存在"))
+		return
+	}
+	writeJSON(w, node)
+}
+
+func (a *API) handleSeries(w http.ResponseWriter, r *http.Request) {
+	q, err := a.parseRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	buckets, ok := a.c.Series(r.PathValue("key"), q.from, q.to, q.step)
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("节点不存在"))
+		return
+	}
+	writeJSON(w, map[string]any{
+		"key":     r.PathValue("key"),
+		"from":    q.from,
+		"to":      q.to,
+		"step":    q.step.Seconds(),
+		"buckets": buckets,
+	})
+}
+
+func (a *API) handleFleet(w http.ResponseWriter, r *http.Request) {
+	q, err := a.parseRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, a.c.Fleet(q.from, q.to, q.step))
+}
+
+func (a *API) handleTop(w http.ResponseWriter, r *http.Request) {
+	q, err := a.parseRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	n := 10
+	if s := r.URL.Query().Get("n"); s != "" {
+		if n, err = strconv.Atoi(s); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("n 格式错误: %w", err))
+			return
+		}
+	}
+	var asc bool
+	switch order := r.URL.Query().Get("order"); order {
+	case "", "desc":
+	case "asc":
+		asc = true
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("order 只能为 asc 或 desc: %q", order))
+		return
+	}
+	writeJSON(w, a.c.Rank(q.from, q.to, n, asc))
+}
+
+// handleExport 以 CSV 导出原始数据点，node 参数为空时导出全部节点
+func (a *API) handleExport(w http.ResponseWriter, r *http.Request) {
+	q, err := a.parseRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	nodes := a.c.Nodes()
+	if key := r.URL.Query().Get("node"); key != "" {
+		node, ok := a.c.Node(key)
+		if !ok {
+			writeError(w, http.StatusNotFound, errors.New("节点不存在"))
+			return
+		}
+		nodes = []Node{node}
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="netflood-`+q.from.UTC().Format("20060102T150405Z")+`.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"node", "name", "time", "interval_seconds", "interval_bytes", "total_bytes", "in_window", "completed", "failed"})
+	for _, node := range nodes {
+		for _, p := range a.c.Points(node.Key, q.from, q.to) {
+			cw.Write([]string{
+				node.Key,
+				node.Name,
+				p.Time.UTC().Format(time.RFC3339),
+				strconv.FormatFloat(p.Seconds, 'f', -1, 64),
+				strconv.FormatInt(p.Bytes, 10),
+				strconv.FormatInt(p.Total, 10),
+				strconv.FormatBool(p.InWindow),
+				strconv.FormatInt(p.Completed, 10),
+				strconv.FormatInt(p.Failed, 10),
+			})
+		}
+	}
+	cw.Flush()
+}
+
+func (a *API) handleDaily(w http.ResponseWriter, r *http.Request) {
+	days := DefaultDays
+	if s := r.URL.Query().Get("days"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxDays {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("days 应为 1 到 %d: %q", maxDays, s))
+			return
+		}
+		days = n
+	}
+	writeJSON(w, a.c.Daily(days))
+}
+
+func (a *API) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	alerts := []Alert{}
+	if a.opts.Alerter != nil {
+		alerts = a.opts.Alerter.Alerts()
+	}
+	writeJSON(w, alerts)
+}
+
+func (a *API) handleDirectives(w http.ResponseWriter, r *http.Request) {
+	directives := []DirectiveRecord{}
+	if a.opts.Control != nil {
+		directives = a.opts.Control.Directives()
+	}
+	writeJSON(w, directives)
+}
+
+// handleSubmitDirective 创建指令，返回为每个节点创建的指令
+func (a *API) handleSubmitDirective(w http.ResponseWriter, r *http.Request) {
+	if a.opts.Control == nil || a.opts.Signer == nil || a.opts.AdminToken == "" {
+		writeError(w, http.StatusNotFound, errors.New("未启用指令下发（需要签名密钥和管理 token）"))
+		return
+	}
+	if !checkToken(r, a.opts.AdminToken) {
+		a.logger.Warn("拒绝下发指令", "remote", r.RemoteAddr, "error", "token 无效")
+		writeError(w, http.StatusUnauthorized, errors.New("token 无效"))
+		return
+	}
+	var req DirectiveRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("解析 JSON 失败: %w", err))
+		return
+	}
+	created, err := a.opts.Control.Submit(req)
+	switch {
+	case err != nil && created == nil:
+		writeError(w, http.StatusBadRequest, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("保存指令失败: %w", err))
+	default:
+		a.logger.Info("下发指令", "remote", r.RemoteAddr, "action", req.Action, "nodes", len(created))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+	}
+}
+
+func (a *API) handleTaskStatus(w http.ResponseWriter, r *http.Request) {
+	if a.opts.Tasks == nil {
+		writeError(w, http.StatusNotFound, errors.New("未启用任务分发"))
+		return
+	}
+	writeJSON(w, a.opts.Tasks.Status())
+}
+
+func (a *API) handleBudget(w http.ResponseWriter, r *http.Request) {
+	if a.opts.Budget == nil {
+		writeError(w, http.StatusNotFound, errors.New("未启用带宽目标"))
+		return
+	}
+	writeJSON(w, a.opts.Budget.Status())
+}
+
+// handleTasks 返回分配给节点的任务列表，格式与 downloader.ParseTasks 相同；
+// 请求头 If-None-Match 与任务列表的 ETag 相同时返回 304
+func (a *API) handleTasks(w http.ResponseWriter, r *http.Request) {
+	if !a.checkTaskToken(r) {
+		writeError(w, http.StatusUnauthorized, errors.New("token 无效"))
+		return
+	}
+	if a.opts.Tasks == nil {
+		writeError(w, http.StatusNotFound, errors.New("未启用任务分发"))
+		return
+	}
+
+	var assignment Assignment
+	if key := r.URL.Query().Get("node"); key != "" {
+		assignment = a.opts.Tasks.Preview(key)
+	} else {
+		var err error
+		if assignment, err = a.opts.Tasks.Assign(stats.IdentityFromHeader(r.Header)); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		a.logger.Debug("分配任务", "node", assignment.Node, "remote", r.RemoteAddr, "version", assignment.Version, "tasks", len(assignment.Tasks))
+	}
+
+	etag := assignment.ETag()
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Netflood-Tasks-Version", assignment.Version)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, t := range assignment.Tasks {
+		fmt.Fprintf(w, "%s,%s\n", t.IP, t.URL)
+	}
+}
+
+// handleEvents 以 SSE 推送数据变化：收到新数据后发送 update 事件，客户端收到后重新查询
+func (a *API) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("不支持事件流"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		changed := a.c.Changed()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-changed:
+			fmt.Fprintf(w, "event: update\ndata: {\"time\":%q}\n\n", a.c.now().Format(time.RFC3339))
+		}
+		flusher.Flush()
+
+		// 大量节点同时上报时合并通知
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(eventThrottle):
+		}
+	}
+}
+
+// timeRange 查询的时间范围和时间桶长度
+type timeRange struct {
+	from, to time.Time
+	step     time.Duration
+}
+
+// parseRange 解析查询参数中的时间范围；from 按 step 向前对齐，使相邻查询的时间桶一致
+func (a *API) parseRange(r *http.Request) (timeRange, error) {
+	query := r.URL.Query()
+	q := timeRange{to: a.c.now()}
+
+	var err error
+	if s := query.Get("to"); s != "" {
+		if q.to, err = parseTime(s); err != nil {
+			return q, fmt.Errorf("to 格式错误: %w", err)
+		}
+	}
+	switch {
+	case query.Get("from") != "":
+		if q.from, err = parseTime(query.Get("from")); err != nil {
+			return q, fmt.Errorf("from 格式错误: %w", err)
+		}
+	case query.Get("last") != "":
+		last, err := time.ParseDuration(query.Get("last"))
+		if err != nil || last <= 0 {
+			return q, fmt.Errorf("last 格式错误: %q", query.Get("last"))
+		}
+		q.from = q.to.Add(-last)
+	default:
+		q.from = q.to.Add(-DefaultQueryRange)
+	}
+	if !q.to.After(q.from) {
+		return q, errors.New("to 必须晚于 from")
+	}
+
+	if s := query.Get("step"); s != "" {
+		if q.step, err = time.ParseDuration(s); err != nil || q.step < time.Second {
+			return q, fmt.Errorf("step 格式错误或小于 1 秒: %q", s)
+		}
+	} else {
+		q.step = autoStep(q.to.Sub(q.from))
+	}
+	q.from = q.from.Truncate(q.step)
+	if q.to.Sub(q.from)/q.step > maxBuckets {
+		return q, fmt.Errorf("时间桶过多（最多 %d 个），请增大 step", maxBuckets)
+	}
+	return q, nil
+}
+
+// autoStep 按时间范围选择时间桶长度
+func autoStep(d time.Duration) time.Duration {
+	for _, step := range steps {
+		if d/step <= targetBuckets {
+			return step
+		}
+	}
+	return steps[len(steps)-1]
+}
+
+// parseTime 解析 RFC3339 时间或 Unix 秒
+func parseTime(s string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
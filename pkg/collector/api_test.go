@@ -0,0 +1,247 @@
+package collector
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+// do 发送请求并返回响应
+func do(t *testing.T, h http.Handler, method, target, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPI_IngestAuth(t *testing.T) {
+	c := newTestCollector(t, t.TempDir())
+	secret := []byte("secret")
+	api := NewAPI(c, APIOptions{Token: "up", Verifier: stats.NewVerifier(secret, 0)})
+	body, _ := json.Marshal([]stats.StatsData{report("a", "r", 1, 0, 1), report("a", "r", 2, 0, 1)})
+
+	// token 错误时不读取请求体
+	unread := strings.NewReader(string(body))
+	req := httptest.NewRequest("POST", "/stats", unread)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || unread.Len() != len(body) {
+		t.Errorf("wrong token: status = %d, %d body bytes read, want 401 without reading", rec.Code, len(body)-unread.Len())
+	}
+	if rec := do(t, api, "POST", "/stats", "up", string(body)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("missing signature: status = %d, want 401", rec.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/stats", strings.NewReader(string(body)))
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Authorization", "Bearer up")
+	req.Header.Set(stats.HeaderTimestamp, ts)
+	req.Header.Set(stats.HeaderNonce, "n1")
+	req.Header.Set(stats.HeaderSignature, stats.Sign(secret, ts, "n1", body))
+	rec = httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"accepted":2`) {
+		t.Errorf("signed batch: status = %d, body = %s, want 200 with 2 accepted", rec.Code, rec.Body)
+	}
+
+	api = NewAPI(c, APIOptions{})
+	if rec := do(t, api, "POST", "/stats", "", "not json"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid JSON: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestAPI_Queries(t *testing.T) {
+	c := newTestCollector(t, t.TempDir())
+	c.Ingest([]stats.StatsData{
+		report("a", "r", 1, -50*time.Second, 100),
+		report("b", "r", 1, -40*time.Second, 10),
+	})
+	api := NewAPI(c, APIOptions{ReadToken: "read"})
+
+	if rec := do(t, api, "GET", "/api/nodes", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without read token: status = %d, want 401", rec.Code)
+	}
+
+	var nodes []Node
+	rec := do(t, api, "GET", "/api/nodes", "read", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &nodes); err != nil || len(nodes) != 2 {
+		t.Fatalf("/api/nodes = %s, want 2 nodes", rec.Body)
+	}
+
+	tests := []struct {
+		target string
+		status int
+		want   string
+	}{
+		{"/api/nodes/id-a", 200, `"name":"a"`},
+		{"/api/nodes/missing", 404, "节点不存在"},
+		{"/api/nodes/id-a/series?last=1m&step=30s", 200, `"step":30`},
+		{"/api/fleet?from=" + strconv.FormatInt(testNow.Add(-time.Minute).Unix(), 10) + "&to=" + testNow.Format(time.RFC3339), 200, `"nodes":2`},
+		{"/api/fleet?last=-1h", 400, "last"},
+		{"/api/fleet?last=24h&step=1s", 400, "时间桶过多"},
+		{"/api/top?n=1&order=asc&last=1m", 200, `"name":"b"`},
+		{"/api/top?order=up", 400, "order"},
+		{"/api/daily?days=2", 200, `"date":"2025-10-25"`},
+		{"/api/daily?days=0", 400, "days"},
+		{"/api/nodes?token=read", 200, `"name":"a"`},
+	}
+	for _, tt := range tests {
+		rec := do(t, api, "GET", tt.target, "read", "")
+		if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.want) {
+			t.Errorf("GET %s = %d %s, want %d containing %q", tt.target, rec.Code, rec.Body, tt.status, tt.want)
+		}
+	}
+}
+
+func TestAPI_ExportCSV(t *testing.T) {
+	c := newTestCollector(t, t.TempDir())
+	c.Ingest([]stats.StatsData{
+		report("a", "r", 1, -50*time.Second, 1),
+		report("a", "r", 2, -40*time.Second, 1),
+		report("b", "r", 1, -40*time.Second, 1),
+	})
+	api := NewAPI(c, APIOptions{})
+
+	tests := []struct {
+		target string
+		rows   int
+	}{
+		{"/api/export.csv?last=1h", 4},
+		{"/api/export.csv?last=1h&node=id-a", 3},
+		{"/api/export.csv?last=30s", 1},
+	}
+	for _, tt := range tests {
+		rec := do(t, api, "GET", tt.target, "", "")
+		rows, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil || len(rows) != tt.rows {
+			t.Errorf("GET %s = %d rows, %v, want %d", tt.target, len(rows), err, tt.rows)
+			continue
+		}
+		if rows[0][0] != "node" || len(rows) > 1 && rows[1][4] != "1048576" {
+			t.Errorf("GET %s rows = %v", tt.target, rows)
+		}
+	}
+}
+
+func TestAPI_Events(t *testing.T) {
+	c := newTestCollector(t, t.TempDir())
+	srv := httptest.NewServer(NewAPI(c, APIOptions{ReadToken: "read"}))
+	defer srv.Close()
+
+	if resp, err := http.Get(srv.URL + "/api/events"); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("GET /api/events without token = %v, %v, want 401", resp.Status, err)
+	}
+
+	resp, err := http.Get(srv.URL + "/api/events?token=read")
+	if err != nil {
+		t.Fatalf("GET /api/events error = %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	// 连接建立后（收到 retry 行）再写入数据
+	if line := <-lines; !strings.HasPrefix(line, "retry:") {
+		t.Fatalf("first line = %q, want retry", line)
+	}
+	c.Ingest([]stats.StatsData{report("a", "r", 1, 0, 1)})
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line := <-lines:
+			if line == "event: update" {
+				return
+			}
+		case <-timeout:
+			t.Fatal("no update event after Ingest")
+		}
+	}
+}
+
+func TestAPI_Dashboard(t *testing.T) {
+	api := NewAPI(newTestCollector(t, t.TempDir()), APIOptions{ReadToken: "read"})
+
+	rec := do(t, api, "GET", "/", "", "")
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") || !strings.Contains(body, "/api/events") {
+		t.Fatalf("GET / = %d %s, want dashboard page", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	// 页面不引用外部资源
+	for _, external := range []string{`src="http`, `href="http`, `src="//`, `href="//`, "url(http", "@import"} {
+		if strings.Contains(body, external) {
+			t.Errorf("dashboard references external resource %q", external)
+		}
+	}
+	if rec := do(t, api, "GET", "/missing", "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET /missing = %d, want 404", rec.Code)
+	}
+}
+
+func TestAPI_Tasks(t *testing.T) {
+	c := newTestCollector(t, t.TempDir())
+	path := writePool(t, t.TempDir(), `pools: [{name: a, shard: true, tasks: ["1.1.1.1,http://a/1", "2.2.2.2,http://b/1"]}]`)
+	api := NewAPI(c, APIOptions{Token: "up", ReadToken: "read", Tasks: newTestDispatcher(t, c, path)})
+
+	fetch := func(token, node, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/tasks", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("If-None-Match", etag)
+		stats.Identity{NodeID: node}.SetHeader(req.Header)
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := fetch("wrong", "n1", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status = %d, want 401", rec.Code)
+	}
+	rec := fetch("up", "n1", "")
+	if rec.Code != http.StatusOK || rec.Body.String() != "1.1.1.1,http://a/1\n2.2.2.2,http://b/1\n" || rec.Header().Get("X-Netflood-Tasks-Version") == "" {
+		t.Fatalf("GET /tasks = %d %q", rec.Code, rec.Body)
+	}
+	if rec := fetch("read", "n1", rec.Header().Get("ETag")); rec.Code != http.StatusNotModified {
+		t.Errorf("unchanged tasks: status = %d, want 304", rec.Code)
+	}
+
+	// 第二个节点加入后分片，n1 的任务变化
+	if rec := fetch("up", "n2", ""); rec.Body.String() != "2.2.2.2,http://b/1\n" {
+		t.Errorf("GET /tasks for n2 = %q", rec.Body)
+	}
+	if rec := do(t, api, "GET", "/tasks?node=n1&token=read", "", ""); rec.Body.String() != "1.1.1.1,http://a/1\n" {
+		t.Errorf("preview n1 = %q", rec.Body)
+	}
+
+	var status TaskStatus
+	rec = do(t, api, "GET", "/api/tasks", "read", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil || len(status.Members) != 2 || len(status.Pools[0].Members) != 2 {
+		t.Errorf("GET /api/tasks = %s, %v", rec.Body, err)
+	}
+
+	api = NewAPI(c, APIOptions{})
+	if rec := do(t, api, "GET", "/tasks?node=n1", "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET /tasks without dispatcher = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,488 @@
+package collector
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/history"
+	"github.com/dora-exku/netflood/pkg/stats"
+	"github.com/dora-exku/netflood/pkg/timerange"
+	"github.com/dora-exku/netflood/pkg/units"
+	"gopkg.in/yaml.v3"
+)
+
+// 带宽目标的默认值
+const (
+	DefaultBudgetInterval  = 30 * time.Second // 重新分配的间隔
+	DefaultCapacityWindow  = time.Hour        // 按该时间窗口内的峰值速度估计节点容量
+	DefaultBudgetTolerance = 0.05             // 分配变化超过该比例时才下发新的速度上限
+	DefaultMaxErrorRatio   = 0.5              // 最近失败比例高于该值的节点不参与分配
+)
+
+// 分配时的估计参数
+const (
+	recentWindow   = time.Minute     // 计算节点当前速度的时间窗口
+	healthWindow   = 5 * time.Minute // 计算失败比例的时间窗口
+	saturatedRatio = 0.9             // 当前速度达到速度上限的该比例时认为节点还有余量
+	probeFactor    = 1.25            // 达到速度上限的节点的容量按上限的该倍数估计，逐步试探真实容量
+	minNodeRate    = 64 * units.KB   // 分配给单个节点的最小速度上限
+)
+
+// budgetStateFile 已下发的速度上限（数据目录下），重启后仍能在时间段结束时解除
+const budgetStateFile = "budget.json"
+
+// 节点不参与分配的原因
+const (
+	ExcludeOffline   = "offline"    // 离线
+	ExcludePaused    = "paused"     // 已暂停
+	ExcludeOutWindow = "out_window" // 不在节点自己的下载时间段内
+	ExcludeUnhealthy = "unhealthy"  // 最近的下载失败比例过高
+)
+
+// BudgetTarget 一个带宽目标：生效时间段内，把总速度按容量分配给匹配的节点
+type BudgetTarget struct {
+	Name   string            `yaml:"name"`   // 名称，必须唯一
+	Rate   string            `yaml:"rate"`   // 总速度，例如 20Gbps、2.5GB/s
+	Time   string            `yaml:"time"`   // 生效时间段（格式与 -time 相同），为空时全天生效
+	Nodes  []string          `yaml:"nodes"`  // 只分配给这些节点（节点 ID 或名称），为空时不限
+	Labels map[string]string `yaml:"labels"` // 只分配给带有这些标签的节点，为空时不限
+
+	rate  int64 // 每秒字节数
+	times *timerange.TimeRangeManager
+}
+
+// BudgetConfig 全体带宽目标配置
+type BudgetConfig struct {
+	Interval       time.Duration  `yaml:"interval"`        // 重新分配的间隔，默认 30s
+	CapacityWindow time.Duration  `yaml:"capacity_window"` // 估计节点容量的时间窗口，默认 1h
+	Tolerance      float64        `yaml:"tolerance"`       // 分配变化超过该比例时才下发，默认 0.05
+	MaxErrorRatio  float64        `yaml:"max_error_ratio"` // 最近 5 分钟失败比例高于该值的节点不参与分配，默认 0.5
+	Targets        []BudgetTarget `yaml:"targets"`
+}
+
+// LoadBudgetConfig 从 YAML 文件加载带宽目标配置并检查
+func LoadBudgetConfig(path string) (BudgetConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return BudgetConfig{}, fmt.Errorf("读取带宽目标配置失败: %w", err)
+	}
+	var cfg BudgetConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return BudgetConfig{}, fmt.Errorf("解析带宽目标配置失败: %w", err)
+	}
+	if err := cfg.normalize(); err != nil {
+		return BudgetConfig{}, err
+	}
+	return cfg, nil
+}
+
+// normalize 检查配置、解析速度和时间段并填写默认值
+func (cfg *BudgetConfig) normalize() error {
+	if len(cfg.Targets) == 0 {
+		return errors.New("带宽目标配置中没有 targets")
+	}
+	cfg.Interval = cmp.Or(cfg.Interval, DefaultBudgetInterval)
+	cfg.CapacityWindow = cmp.Or(cfg.CapacityWindow, DefaultCapacityWindow)
+	cfg.Tolerance = cmp.Or(cfg.Tolerance, DefaultBudgetTolerance)
+	cfg.MaxErrorRatio = cmp.Or(cfg.MaxErrorRatio, DefaultMaxErrorRatio)
+	if cfg.Interval < 0 || cfg.CapacityWindow < 0 {
+		return errors.New("interval 和 capacity_window 不能为负数")
+	}
+	if cfg.Tolerance < 0 || cfg.Tolerance >= 1 {
+		return errors.New("tolerance 应在 0 到 1 之间")
+	}
+	if cfg.MaxErrorRatio <= 0 || cfg.MaxErrorRatio > 1 {
+		return errors.New("max_error_ratio 应在 0 到 1 之间")
+	}
+
+	names := make(map[string]bool)
+	for i := range cfg.Targets {
+		t := &cfg.Targets[i]
+		if t.Name == "" {
+			return fmt.Errorf("第 %d 个带宽目标缺少 name", i+1)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("带宽目标名称重复: %s", t.Name)
+		}
+		names[t.Name] = true
+
+		rate, err := units.ParseRate(t.Rate)
+		if err != nil || rate <= 0 {
+			return fmt.Errorf("带宽目标 %s: rate 无效: %q", t.Name, t.Rate)
+		}
+		t.rate = rate
+		if t.times, err = timerange.NewTimeRangeManager(t.Time); err != nil {
+			return fmt.Errorf("带宽目标 %s: %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// activeAt 目标在 t 时是否生效
+func (t BudgetTarget) activeAt(at time.Time) bool {
+	if !t.times.IsEnabled() {
+		return true
+	}
+	_, ok := t.times.RangeAt(at)
+	return ok
+}
+
+// matches 节点是否属于该目标
+func (t BudgetTarget) matches(n Node) bool {
+	if len(t.Nodes) > 0 && !slices.Contains(t.Nodes, n.Key) && !slices.Contains(t.Nodes, n.Name) {
+		return false
+	}
+	return matchLabels(n.Labels, t.Labels)
+}
+
+// Allocation 分配给一个节点的速度上限，速度单位均为字节/秒
+type Allocation struct {
+	Key          string `json:"key"`
+	Name         string `json:"name"`
+	Capacity     int64  `json:"capacity"`                 // 估计的容量：容量窗口内的峰值速度，达到速度上限时按上限放大试探
+	Rate         int64  `json:"rate"`                     // 分配的速度上限，不参与分配时为 0
+	Actual       int64  `json:"actual"`                   // 最近一分钟的速度
+	MaxRate      int64  `json:"max_rate"`                 // 节点上报的当前速度上限，0 表示不限速
+	LocalMaxRate int64  `json:"local_max_rate,omitempty"` // 节点本地配置的速度上限，分配和容量都不超过它
+	Excluded     string `json:"excluded,omitempty"`       // 不参与分配的原因：offline、paused、out_window、unhealthy
+}
+
+// TargetStatus 带宽目标的当前状态，速度单位均为字节/秒
+type TargetStatus struct {
+	Name      string       `json:"name"`
+	Time      string       `json:"time,omitempty"`
+	Active    bool         `json:"active"`    // 当前是否在生效时间段内
+	Target    int64        `json:"target"`    // 目标总速度
+	Actual    int64        `json:"actual"`    // 匹配节点最近一分钟的总速度
+	Gap       int64        `json:"gap"`       // 目标与实际的差距（目标 - 实际），未生效时为 0
+	Allocated int64        `json:"allocated"` // 分配给节点的速度上限之和
+	Capacity  int64        `json:"capacity"`  // 参与分配的节点的容量之和，小于目标时目标无法达到
+	Nodes     []Allocation `json:"nodes"`     // 匹配的节点，按名称排序
+}
+
+// BudgetStatus 所有带宽目标的状态
+type BudgetStatus struct {
+	Time    time.Time      `json:"time"`
+	Targets []TargetStatus `json:"targets"`
+}
+
+// sentRate 下发给节点的速度上限
+type sentRate struct {
+	Target string    `json:"target"`
+	Rate   int64     `json:"rate"`
+	At     time.Time `json:"at"`
+}
+
+// Balancer 把全体带宽目标分配给节点，通过 set_rate 指令下发速度上限
+//
+// 每个间隔按节点的容量（最近的峰值速度）比例分配生效中的目标，离线、暂停、不在下载时间段内
+// 或失败比例过高的节点不参与分配，其份额由其他节点分担；分配变化超过 tolerance 时才下发。
+// 节点不再属于任何生效中的目标（例如时间段结束）时下发 set_rate 0 解除速度上限。
+// 一个节点同时匹配多个生效中的目标时只属于配置中的第一个
+type Balancer struct {
+	c         *Collector
+	control   *Controller
+	cfg       BudgetConfig
+	logger    *slog.Logger
+	statePath string
+
+	mu   sync.Mutex
+	sent map[string]sentRate // 按节点标识
+}
+
+// NewBalancer 创建带宽分配器，cfg 通常由 LoadBudgetConfig 加载；加载数据目录中保存的已下发速度上限
+func NewBalancer(c *Collector, control *Controller, cfg BudgetConfig, logger *slog.Logger) (*Balancer, error) {
+	if err := cfg.normalize(); err != nil {
+		return nil, err
+	}
+	b := &Balancer{
+		c:         c,
+		control:   control,
+		cfg:       cfg,
+		logger:    cmp.Or(logger, slog.New(slog.DiscardHandler)),
+		statePath: filepath.Join(c.store.dir, budgetStateFile),
+		sent:      make(map[string]sentRate),
+	}
+	data, err := os.ReadFile(b.statePath)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &b.sent); err != nil {
+			b.logger.Warn("带宽分配状态文件损坏，忽略", "path", b.statePath, "error", err)
+			b.sent = make(map[string]sentRate)
+		}
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("读取带宽分配状态失败: %w", err)
+	}
+	return b, nil
+}
+
+// Run 按间隔重新分配，直到 ctx 取消
+func (b *Balancer) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := b.Rebalance(); err != nil {
+			b.logger.Warn("下发速度上限失败，下次分配时重试", "error", err)
+		}
+	}
+}
+
+// Status 返回当前的分配结果（不下发）
+func (b *Balancer) Status() BudgetStatus {
+	return b.plan(b.c.now())
+}
+
+// plan 计算 now 时各目标的分配
+func (b *Balancer) plan(now time.Time) BudgetStatus {
+	usage := b.c.usage(now, b.cfg.CapacityWindow)
+	nodes := b.c.Nodes()
+	status := BudgetStatus{Time: now, Targets: make([]TargetStatus, 0, len(b.cfg.Targets))}
+	claimed := make(map[string]bool)
+	for _, target := range b.cfg.Targets {
+		ts := TargetStatus{Name: target.Name, Time: target.Time, Active: target.activeAt(now), Target: target.rate, Nodes: []Allocation{}}
+		var members []*Allocation
+		for _, node := range nodes {
+			if !target.matches(node) || ts.Active && claimed[node.Key] {
+				continue
+			}
+			u := usage[node.Key]
+			alloc := Allocation{Key: node.Key, Name: node.Name, Capacity: u.peak, Actual: u.recent, MaxRate: node.Last.MaxRate, LocalMaxRate: node.Last.LocalMaxRate}
+			if alloc.MaxRate > 0 && float64(alloc.Actual) >= saturatedRatio*float64(alloc.MaxRate) {
+				alloc.Capacity = max(alloc.Capacity, int64(probeFactor*float64(alloc.MaxRate)))
+			}
+			if alloc.LocalMaxRate > 0 {
+				alloc.Capacity = min(alloc.Capacity, alloc.LocalMaxRate)
+			}
+			switch {
+			case !node.Online:
+				alloc.Excluded = ExcludeOffline
+			case node.Last.Paused:
+				alloc.Excluded = ExcludePaused
+			case !node.InWindow:
+				alloc.Excluded = ExcludeOutWindow
+			case u.requests >= DefaultMinRequests && float64(u.failed)/float64(u.requests) > b.cfg.MaxErrorRatio:
+				alloc.Excluded = ExcludeUnhealthy
+			}
+			if node.Online {
+				ts.Actual += alloc.Actual
+			}
+			if ts.Active {
+				claimed[node.Key] = true
+			}
+			ts.Nodes = append(ts.Nodes, alloc)
+		}
+		for i := range ts.Nodes {
+			if ts.Nodes[i].Excluded == "" {
+				members = append(members, &ts.Nodes[i])
+			}
+		}
+		if ts.Active {
+			allocate(target.rate, members)
+			ts.Gap = ts.Target - ts.Actual
+		}
+		for _, m := range members {
+			ts.Allocated += m.Rate
+			ts.Capacity += m.Capacity
+		}
+		status.Targets = append(status.Targets, ts)
+	}
+	return status
+}
+
+// allocate 按容量比例把 total 分配给节点；没有容量数据的节点（刚加入）按其他节点容量的中位数计算，
+// 都没有数据时平均分配。容量之和小于目标时分配的上限会超过容量，节点能跑多快就跑多快；
+// 分配不超过节点本地配置的上限，超出的部分按比例分给其他节点
+func allocate(total int64, members []*Allocation) {
+	if len(members) == 0 {
+		return
+	}
+	var known []int64
+	for _, m := range members {
+		if m.Capacity > 0 {
+			known = append(known, m.Capacity)
+		}
+	}
+	fallback := int64(1)
+	if len(known) > 0 {
+		slices.Sort(known)
+		fallback = known[len(known)/2]
+	}
+	weights := make([]float64, len(members))
+	for i, m := range members {
+		weights[i] = float64(cmp.Or(m.Capacity, fallback))
+	}
+	// 每轮把按比例分配超过本地上限的节点固定为上限，剩余的目标在其他节点之间重新分配
+	open := make([]bool, len(members))
+	for i := range open {
+		open[i] = true
+	}
+	remaining := float64(total)
+	for capped := true; capped; {
+		capped = false
+		var sum float64
+		for i := range members {
+			if open[i] {
+				sum += weights[i]
+			}
+		}
+		for i, m := range members {
+			if open[i] && m.LocalMaxRate > 0 && remaining*weights[i]/sum > float64(m.LocalMaxRate) {
+				m.Rate, open[i], capped = m.LocalMaxRate, false, true
+				remaining -= float64(m.LocalMaxRate)
+			}
+		}
+		if capped {
+			continue
+		}
+		for i, m := range members {
+			if open[i] {
+				rate := int64(math.Round(max(remaining, 0)*weights[i]/sum/float64(units.KB))) * units.KB
+				m.Rate = max(rate, minNodeRate)
+			}
+		}
+	}
+}
+
+// Rebalance 计算分配并向变化超过 tolerance 的节点下发速度上限，
+// 向不再属于生效中目标的节点下发 set_rate 0 解除上限
+func (b *Balancer) Rebalance() error {
+	now := b.c.now()
+	status := b.plan(now)
+
+	type push struct {
+		key, name, target string
+		rate              int64
+	}
+	var pushes []push
+	covered := make(map[string]bool)
+	b.mu.Lock()
+	for _, ts := range status.Targets {
+		if !ts.Active {
+			continue
+		}
+		for _, alloc := range ts.Nodes {
+			covered[alloc.Key] = true
+			if alloc.Excluded != "" {
+				continue
+			}
+			last, ok := b.sent[alloc.Key]
+			// 节点重启等原因导致上报的上限与下发的不一致时，等待确认一段时间后重新下发
+			stale := differs(alloc.MaxRate, last.Rate, b.cfg.Tolerance) && now.Sub(last.At) > 2*b.cfg.Interval
+			if !ok || differs(alloc.Rate, last.Rate, b.cfg.Tolerance) || stale {
+				pushes = append(pushes, push{alloc.Key, alloc.Name, ts.Name, alloc.Rate})
+			}
+		}
+	}
+	for key, last := range b.sent {
+		if covered[key] {
+			continue
+		}
+		if _, ok := b.c.Node(key); !ok {
+			// 节点的数据已超过保留期
+			delete(b.sent, key)
+			continue
+		}
+		pushes = append(pushes, push{key: key, target: last.Target})
+	}
+	b.mu.Unlock()
+	if len(pushes) == 0 {
+		return nil
+	}
+
+	// 指令在下一个分配间隔之后过期，节点离线时不会积压过时的上限
+	ttl := max(2*b.cfg.Interval, time.Minute)
+	var errs []error
+	for _, p := range pushes {
+		_, err := b.control.Submit(DirectiveRequest{Action: stats.ActionSetRate, Value: p.rate, TTL: ttl.String(), Nodes: []string{p.key}})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("节点 %s: %w", p.key, err))
+			continue
+		}
+		b.mu.Lock()
+		if p.rate == 0 {
+			delete(b.sent, p.key)
+		} else {
+			b.sent[p.key] = sentRate{Target: p.target, Rate: p.rate, At: now}
+		}
+		b.mu.Unlock()
+		b.logger.Info("下发速度上限", "target", p.target, "node", cmp.Or(p.name, p.key), "rate", p.rate)
+	}
+	if err := b.save(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// differs a 和 b 的差距是否超过较大者的 tolerance 比例
+func differs(a, b int64, tolerance float64) bool {
+	return math.Abs(float64(a-b)) > tolerance*float64(max(a, b))
+}
+
+// save 保存已下发的速度上限
+func (b *Balancer) save() error {
+	b.mu.Lock()
+	data, err := json.Marshal(b.sent)
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return history.WriteFileAtomic(b.statePath, data)
+}
+
+// nodeUsage 节点最近的速度和下载结果
+type nodeUsage struct {
+	recent   int64 // 最近一分钟的速度（字节/秒）
+	peak     int64 // 容量窗口内时间段中的峰值速度（字节/秒）
+	requests int64 // 最近 5 分钟的下载次数
+	failed   int64 // 最近 5 分钟失败的下载次数
+}
+
+// usage 返回每个节点在 now 时的速度和下载结果
+func (c *Collector) usage(now time.Time, capacityWindow time.Duration) map[string]nodeUsage {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	end := now.Add(time.Nanosecond)
+	result := make(map[string]nodeUsage, len(c.nodes))
+	for key, n := range c.nodes {
+		var u nodeUsage
+		for _, p := range pointsIn(n.points, now.Add(-capacityWindow), end) {
+			if p.InWindow && p.Seconds > 0 {
+				u.peak = max(u.peak, int64(float64(p.Bytes)/p.Seconds))
+			}
+		}
+		var bytes int64
+		var seconds float64
+		for _, p := range pointsIn(n.points, now.Add(-recentWindow), end) {
+			bytes += p.Bytes
+			seconds += p.Seconds
+		}
+		if seconds > 0 {
+			u.recent = int64(float64(bytes) / seconds)
+		}
+		for _, p := range pointsIn(n.points, now.Add(-healthWindow), end) {
+			u.requests += p.Completed + p.Failed
+			u.failed += p.Failed
+		}
+		result[key] = u
+	}
+	return result
+}
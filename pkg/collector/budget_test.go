@@ -0,0 +1,291 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/downloader"
+	"github.com/dora-exku/netflood/pkg/server"
+	"github.com/dora-exku/netflood/pkg/stats"
+	"github.com/dora-exku/netflood/pkg/units"
+)
+
+// sessionReport 构造一条在下载会话中的上报：区间 10 秒内下载 mb MB，maxRate 为节点上报的速度上限
+func sessionReport(node string, seq uint64, at time.Duration, mb, maxRate int64) stats.StatsData {
+	data := report(node, "r", seq, at, mb)
+	data.Session = &stats.SessionStats{Start: testNow.Add(-time.Hour)}
+	data.Interval.Start = data.Timestamp.Add(-10 * time.Second)
+	data.MaxRate = maxRate
+	return data
+}
+
+func newTestBalancer(t *testing.T, c *Collector, targets ...BudgetTarget) *Balancer {
+	t.Helper()
+	b, err := NewBalancer(c, newTestController(t, c), BudgetConfig{Targets: targets}, nil)
+	if err != nil {
+		t.Fatalf("NewBalancer() error = %v", err)
+	}
+	return b
+}
+
+// allocations 返回目标的 "节点=分配(MB/s)" 或 "节点=不参与原因" 列表
+func allocations(ts TargetStatus) string {
+	var result []string
+	for _, n := range ts.Nodes {
+		if n.Excluded != "" {
+			result = append(result, n.Name+"="+n.Excluded)
+		} else {
+			result = append(result, fmt.Sprintf("%s=%d", n.Name, n.Rate/units.MB))
+		}
+	}
+	return strings.Join(result, " ")
+}
+
+func TestLoadBudgetConfig(t *testing.T) {
+	cfg, err := LoadBudgetConfig("../../examples/collector-budget/budget.yml")
+	if err != nil {
+		t.Fatalf("LoadBudgetConfig(example) error = %v", err)
+	}
+	if len(cfg.Targets) != 2 || cfg.Targets[0].rate != 2_500_000_000 || cfg.Targets[1].rate != 3*units.GB/2 {
+		t.Errorf("LoadBudgetConfig(example) = %+v", cfg)
+	}
+
+	tests := []struct {
+		name, yaml, want string
+	}{
+		{"no targets", "targets: []", "targets"},
+		{"unknown field", "targets: [{name: a, rate: 1Gbps, foo: 1}]", "foo"},
+		{"missing name", "targets: [{rate: 1Gbps}]", "name"},
+		{"duplicate", "targets: [{name: a, rate: 1Gbps}, {name: a, rate: 1Gbps}]", "重复"},
+		{"bad rate", "targets: [{name: a, rate: fast}]", "rate"},
+		{"zero rate", "targets: [{name: a, rate: 0Gbps}]", "rate"},
+		{"bad time", "targets: [{name: a, rate: 1Gbps, time: '9-18'}]", "时间"},
+		{"bad tolerance", "tolerance: 2\ntargets: [{name: a, rate: 1Gbps}]", "tolerance"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "budget.yml")
+			os.WriteFile(path, []byte(tt.yaml), 0o644)
+			_, err := LoadBudgetConfig(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadBudgetConfig() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBalancer_Plan(t *testing.T) {
+	c := newTestCollector(t, t.TempDir())
+	paused := sessionReport("d", 1, 0, 50, 0)
+	paused.Paused = true
+	idle := report("e", "r", 1, 0, 0) // 不在下载会话中
+	failing := sessionReport("f", 1, 0, 10, 0)
+	failing.Interval.Completed, failing.Interval.Failed = 2, 18
+	other := sessionReport("g", 1, 0, 10, 0)
+	other.Labels = map[string]string{"region": "eu"}
+	c.Ingest([]stats.StatsData{
+		sessionReport("a", 1, -20*time.Minute, 300, 0), // 峰值 30 MB/s
+		sessionReport("a", 2, 0, 100, 0),
+		sessionReport("b", 1, 0, 100, 0),      // 10 MB/s
+		sessionReport("c", 1, 0, 100, 20<<20), // 只达到速度上限的一半，不试探
+		paused, idle, failing, other,
+	})
+	c.now = func() time.Time { return testNow.Add(-5 * time.Minute) }
+	c.Ingest([]stats.StatsData{sessionReport("h", 1, -5*time.Minute, 100, 0)}) // 离线
+
+	b := newTestBalancer(t, c,
+		BudgetTarget{Name: "eu", Rate: "100MB/s", Labels: map[string]string{"region": "eu"}},
+		BudgetTarget{Name: "all", Rate: "50MB/s"},
+		BudgetTarget{Name: "night", Rate: "1Gbps", Time: "00:00-06:00"},
+	)
+	c.now = func() time.Time { return testNow.Add(10 * time.Second) }
+	status := b.Status()
+	if len(status.Targets) != 3 {
+		t.Fatalf("Status() = %+v", status)
+	}
+
+	// 按容量 30:10:10 分配，其他节点不参与；g 已属于前一个生效的目标
+	// 实际速度包括不参与分配的在线节点：a、b、c 各 10 MB/s，d 5 MB/s，f 1 MB/s
+	all := status.Targets[1]
+	if got, want := allocations(all), "a=30 b=10 c=10 d=paused e=out_window f=unhealthy h=offline"; got != want {
+		t.Errorf("all = %s, want %s", got, want)
+	}
+	if all.Actual != 36<<20 || all.Gap != 14<<20 || all.Allocated != 50<<20 || all.Capacity != 50<<20 {
+		t.Errorf("all actual = %d gap = %d allocated = %d capacity = %d", all.Actual, all.Gap, all.Allocated, all.Capacity)
+	}
+	if eu := status.Targets[0]; allocations(eu) != "g=100" || eu.Capacity != 1<<20 {
+		t.Errorf("eu = %s, capacity %d", allocations(eu), eu.Capacity)
+	}
+	// 未生效的目标只显示匹配的节点，不分配
+	if night := status.Targets[2]; night.Active || night.Allocated != 0 || night.Gap != 0 || len(night.Nodes) != 8 {
+		t.Errorf("night = %+v", night)
+	}
+
+	// 达到速度上限的节点按上限放大估计容量；没有数据的节点按中位数计算
+	members := []*Allocation{{Capacity: 10 << 20}, {Capacity: 30 << 20}, {}, {Capacity: 20 << 20}}
+	allocate(80<<20, members)
+	if members[0].Rate != 10<<20 || members[1].Rate != 30<<20 || members[2].Rate != 20<<20 || members[3].Rate != 20<<20 {
+		t.Errorf("allocate() = %d %d %d %d", members[0].Rate, members[1].Rate, members[2].Rate, members[3].Rate)
+	}
+	// 本地上限低于按比例分配的节点固定为上限，多出的部分分给其他节点
+	members = []*Allocation{{Capacity: 10 << 20, LocalMaxRate: 5 << 20}, {Capacity: 10 << 20}, {Capacity: 20 << 20}}
+	allocate(50<<20, members)
+	if members[0].Rate != 5<<20 || members[1].Rate != 15<<20 || members[2].Rate != 30<<20 {
+		t.Errorf("allocate() with local cap = %d %d %d", members[0].Rate, members[1].Rate, members[2].Rate)
+	}
+	c.Ingest([]stats.StatsData{sessionReport("c", 2, 10*time.Second, 100, 10<<20)})
+	for _, n := range b.Status().Targets[1].Nodes {
+		if n.Name == "c" && n.Capacity != 25<<20/2 {
+			t.Errorf("saturated capacity = %d, want %d", n.Capacity, 25<<20/2)
+		}
+	}
+}
+
+func TestBalancer_Rebalance(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestCollector(t, dir)
+	c.Ingest([]stats.StatsData{sessionReport("a", 1, 0, 300, 0), sessionReport("b", 1, 0, 100, 0)})
+	target := BudgetTarget{Name: "day", Rate: "40MB/s", Time: "09:00-18:00"}
+	b := newTestBalancer(t, c, target)
+
+	pushed := func() string {
+		var result []string
+		for _, rec := range b.control.Directives() {
+			if rec.Status == DirectivePending {
+				result = append(result, fmt.Sprintf("%s=%d", rec.NodeName, rec.Value/units.MB))
+				b.control.Ack(rec.Node, []stats.Ack{{ID: rec.ID, Status: stats.AckApplied}})
+			}
+		}
+		return strings.Join(result, " ")
+	}
+
+	if err := b.Rebalance(); err != nil {
+		t.Fatalf("Rebalance() error = %v", err)
+	}
+	if got := pushed(); got != "b=10 a=30" {
+		t.Errorf("first Rebalance() pushed %q", got)
+	}
+	// 分配没有变化时不重复下发
+	b.Rebalance()
+	if got := pushed(); got != "" {
+		t.Errorf("unchanged Rebalance() pushed %q", got)
+	}
+
+	// b 离线后由 a 承担全部目标
+	c.now = func() time.Time { return testNow.Add(2 * time.Minute) }
+	c.Ingest([]stats.StatsData{sessionReport("a", 2, 2*time.Minute, 300, 30<<20)})
+	b.Rebalance()
+	if got := pushed(); got != "a=40" {
+		t.Errorf("Rebalance() after b offline pushed %q", got)
+	}
+
+	// 重启后保留已下发的状态，时间段结束后解除速度上限
+	b = newTestBalancer(t, c, target)
+	c.now = func() time.Time { return testNow.Add(7 * time.Hour) }
+	b.Rebalance()
+	if got := pushed(); got != "b=0 a=0" && got != "a=0 b=0" {
+		t.Errorf("Rebalance() after window pushed %q", got)
+	}
+	b.Rebalance()
+	if got := pushed(); got != "" {
+		t.Errorf("second Rebalance() after window pushed %q", got)
+	}
+}
+
+func TestAPI_Budget(t *testing.T) {
+	c := newTestCollector(t, t.TempDir())
+	c.Ingest([]stats.StatsData{sessionReport("a", 1, 0, 100, 0)})
+	if rec := do(t, NewAPI(c, APIOptions{}), "GET", "/api/budget", "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET /api/budget without balancer: status = %d, want 404", rec.Code)
+	}
+
+	api := NewAPI(c, APIOptions{Budget: newTestBalancer(t, c, BudgetTarget{Name: "all", Rate: "1Gbps"})})
+	rec := do(t, api, "GET", "/api/budget", "", "")
+	var status BudgetStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil || len(status.Targets) != 1 || status.Targets[0].Gap != 125_000_000-10<<20 {
+		t.Errorf("GET /api/budget = %s", rec.Body)
+	}
+}
+
+// 节点按分配的速度上限下载时，每个对象的耗时超过请求超时也能完成，节点保持健康
+func TestBalancer_CappedNodeStaysHealthy(t *testing.T) {
+	c := newTestCollector(t, t.TempDir())
+	c.now = time.Now
+	ct := newTestController(t, c)
+	signer := newTestSigner(t)
+	collector := httptest.NewServer(NewAPI(c, APIOptions{Signer: signer, Control: ct}))
+	defer collector.Close()
+
+	s := server.New(server.Options{})
+	origin := httptest.NewUnstartedServer(s)
+	origin.Config = s.HTTPServer("")
+	origin.Start()
+	defer origin.Close()
+
+	sink, err := stats.NewSink(stats.SinkConfig{Type: stats.SinkHTTP, URL: collector.URL + "/stats", Directives: true, DirectiveKey: signer.PublicKey()})
+	if err != nil {
+		t.Fatalf("NewSink() error = %v", err)
+	}
+	// 640KB/s 下载 96KB 约需 150ms（首个 64KB 为突发量），超过 50ms 的请求超时
+	const requestTimeout = 50 * time.Millisecond
+	t.Chdir(t.TempDir())
+	d := downloader.New(
+		downloader.WithWorkers(1),
+		downloader.WithRequestTimeout(requestTimeout),
+		downloader.WithTasks(downloader.DownloadTask{IP: "127.0.0.1", URL: origin.URL + "/bytes/96KB"}),
+		downloader.WithIdentity(stats.Identity{NodeID: "n1", Name: "edge-1"}),
+		downloader.WithStatsSink("collector", sink, stats.SinkOptions{Interval: 50 * time.Millisecond}),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- d.Start(ctx) }()
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		for !cond() {
+			if ctx.Err() != nil {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor("the node to report", func() bool { node, ok := c.Node("n1"); return ok && node.InWindow })
+
+	// 均衡器和 API 共用控制器，下发的指令随节点的上报取走
+	b, err := NewBalancer(c, ct, BudgetConfig{Targets: []BudgetTarget{{Name: "all", Rate: "640KB/s"}}}, nil)
+	if err != nil {
+		t.Fatalf("NewBalancer() error = %v", err)
+	}
+	if err := b.Rebalance(); err != nil {
+		t.Fatalf("Rebalance() error = %v", err)
+	}
+	waitFor("the rate cap", func() bool { return d.MaxRate() == 640<<10 })
+
+	start, finished := time.Now(), d.Completed()+d.Failed()
+	waitFor("capped downloads", func() bool { return d.Completed()+d.Failed()-finished >= DefaultMinRequests })
+	if elapsed := time.Since(start); elapsed < DefaultMinRequests*2*requestTimeout {
+		t.Errorf("%d capped downloads took %v, want each to outlast the request timeout", DefaultMinRequests, elapsed)
+	}
+	if d.Failed() != 0 {
+		t.Errorf("Failed() = %d, want 0", d.Failed())
+	}
+	waitFor("reports of the capped downloads", func() bool {
+		return c.usage(time.Now(), time.Hour)["n1"].requests >= d.Completed()+d.Failed()
+	})
+	for _, alloc := range b.Status().Targets[0].Nodes {
+		if alloc.Excluded != "" {
+			t.Errorf("%s excluded after capped downloads: %s", alloc.Name, alloc.Excluded)
+		}
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,502 @@
+package collector
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+// 默认值
+const (
+	DefaultRetention    = 7 * 24 * time.Hour
+	DefaultOfflineAfter = time.Minute
+)
+
+// Options 收集器配置
+type Options struct {
+	Dir          string        // 数据目录
+	Retention    time.Duration // 数据保留时长，默认 7 天
+	OfflineAfter time.Duration // 超过该时长未上报的节点视为离线，默认 1 分钟
+	Logger       *slog.Logger  // 默认丢弃
+}
+
+// Point 节点的一次上报（查询使用的精简数据）
+type Point struct {
+	Time     time.Time // 节点的采集时间
+	Seconds  float64   // 上报区间时长
+	Bytes    int64     // 上报区间内下载的字节数
+	Total    int64     // 运行以来的总字节数
+	InWindow bool      // 整个上报区间是否都在下载会话中（进入时间段时的第一次上报包含之前的等待时间，不算在内）
+
+	Completed int64 // 上报区间内成功完成的下载次数
+	Failed    int64 // 上报区间内失败的下载次数
+}
+
+// Node 节点状态
+type Node struct {
+	Key       string            `json:"key"` // 节点标识：node_id，未设置时为名称
+	Name      string            `json:"name"`
+	NodeID    string            `json:"node_id,omitempty"`
+	Version   string            `json:"version,omitempty"`
+	RunID     string            `json:"run_id,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	FirstSeen time.Time         `json:"first_seen"`
+	LastSeen  time.Time         `json:"last_seen"` // 最近一次收到上报的时间
+	Reports   int64             `json:"reports"`
+	Online    bool              `json:"online"`
+	InWindow  bool              `json:"in_window"` // 最近一次上报时是否在下载会话中（时间段内或全天候运行）
+	Last      stats.StatsData   `json:"last"`      // 最近一次上报的数据
+}
+
+// nodeState 节点的状态和数据点
+type nodeState struct {
+	Node
+	points  []Point           // 按时间排列
+	lastSeq map[string]runSeq // 每次运行已收到的最大序号，用于去重
+}
+
+// runSeq 一次运行已收到的最大序号和最近收到上报的时间（超过保留期后删除）
+type runSeq struct {
+	seq  uint64
+	seen time.Time
+}
+
+// Collector 汇总多个节点的统计上报
+type Collector struct {
+	store        *Store
+	retention    time.Duration
+	offlineAfter time.Duration
+	logger       *slog.Logger
+	now          func() time.Time
+
+	mu      sync.RWMutex
+	nodes   map[string]*nodeState
+	changed chan struct{} // 收到新数据时关闭并替换
+}
+
+// New 打开数据目录并加载保留期内的数据
+func New(opts Options) (*Collector, error) {
+	return open(opts, time.Now)
+}
+
+// open 使用指定的时钟创建收集器
+func open(opts Options, now func() time.Time) (*Collector, error) {
+	store, err := OpenStore(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	c := &Collector{
+		store:        store,
+		retention:    cmp.Or(opts.Retention, DefaultRetention),
+		offlineAfter: cmp.Or(opts.OfflineAfter, DefaultOfflineAfter),
+		logger:       cmp.Or(opts.Logger, slog.New(slog.DiscardHandler)),
+		now:          now,
+		nodes:        make(map[string]*nodeState),
+		changed:      make(chan struct{}),
+	}
+
+	cutoff := c.now().Add(-c.retention)
+	if err := store.Load(cutoff, func(rec Record) {
+		if rec.Received.After(cutoff) {
+			c.apply(rec)
+		}
+	}); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("加载历史数据失败: %w", err)
+	}
+	return c, nil
+}
+
+// Close 关闭存储
+func (c *Collector) Close() error {
+	return c.store.Close()
+}
+
+// NodeKey 返回统计数据所属节点的标识：node_id，未设置时为名称
+func NodeKey(data stats.StatsData) string {
+	return cmp.Or(data.NodeID, data.Name)
+}
+
+// runKey 区分节点的每次运行：run_id，旧版本没有 run_id 时为运行开始时间
+func runKey(data stats.StatsData) string {
+	if data.RunID != "" {
+		return data.RunID
+	}
+	return data.Start.UTC().Format(time.RFC3339Nano)
+}
+
+// Ingest 保存一批上报数据，返回新接收的条数
+// 同一次运行中序号不大于已接收最大值的数据是重试造成的重复，会被忽略
+func (c *Collector) Ingest(batch []stats.StatsData) (int, error) {
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var records []Record
+	seen := make(map[string]uint64) // 本批次内的去重
+	for _, data := range batch {
+		key := NodeKey(data)
+		if key == "" {
+			continue
+		}
+		run := key + "|" + runKey(data)
+		last := seen[run]
+		if n := c.nodes[key]; n != nil {
+			last = max(last, n.lastSeq[runKey(data)].seq)
+		}
+		if data.Seq != 0 && data.Seq <= last {
+			continue
+		}
+		seen[run] = data.Seq
+		records = append(records, Record{Received: now, Data: data})
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	if err := c.store.Append(records); err != nil {
+		return 0, err
+	}
+	for _, rec := range records {
+		c.apply(rec)
+	}
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return len(records), nil
+}
+
+// Changed 返回在下一次收到新数据时关闭的通道
+func (c *Collector) Changed() <-chan struct{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.changed
+}
+
+// apply 把一条记录加入节点状态（调用方持有写锁，或在加载时调用）
+func (c *Collector) apply(rec Record) {
+	data := rec.Data
+	key := NodeKey(data)
+	n := c.nodes[key]
+	if n == nil {
+		n = &nodeState{Node: Node{Key: key, FirstSeen: rec.Received}, lastSeq: make(map[string]runSeq)}
+		c.nodes[key] = n
+	}
+	n.Name, n.NodeID, n.Version, n.RunID, n.Labels = data.Name, data.NodeID, data.Version, data.RunID, data.Labels
+	n.LastSeen = rec.Received
+	n.Last = data
+	n.InWindow = data.Session != nil
+	n.Reports++
+	run := runKey(data)
+	n.lastSeq[run] = runSeq{seq: max(n.lastSeq[run].seq, data.Seq), seen: rec.Received}
+
+	t := data.Timestamp
+	if t.IsZero() {
+		t = rec.Received
+	}
+	// 数据点通常按时间到达，时钟偏差或补发造成乱序时插入到相同时间的数据点之后，保持按时间排列
+	i := len(n.points)
+	if i > 0 && t.Before(n.points[i-1].Time) {
+		i = sort.Search(len(n.points), func(k int) bool { return n.points[k].Time.After(t) })
+	}
+	n.points = slices.Insert(n.points, i, Point{
+		Time:     t,
+		Seconds:  data.Interval.Seconds,
+		Bytes:    data.Interval.Bytes,
+		Total:    data.TotalBytes,
+		InWindow: data.Session != nil && !data.Interval.Start.Before(data.Session.Start),
+
+		Completed: data.Interval.Completed,
+		Failed:    data.Interval.Failed,
+	})
+}
+
+// Prune 删除保留期之前的数据（内存中的数据点和磁盘上的分段）
+func (c *Collector) Prune() error {
+	cutoff := c.now().Add(-c.retention)
+
+	c.mu.Lock()
+	for key, n := range c.nodes {
+		n.points = slices.Delete(n.points, 0, searchPoints(n.points, cutoff.Add(time.Nanosecond)))
+		maps.DeleteFunc(n.lastSeq, func(_ string, r runSeq) bool { return r.seen.Before(cutoff) })
+		if len(n.points) == 0 && n.LastSeen.Before(cutoff) {
+			delete(c.nodes, key)
+		}
+	}
+	c.mu.Unlock()
+
+	removed, err := c.store.Prune(cutoff)
+	if removed > 0 {
+		c.logger.Info("删除过期数据", "segments", removed, "before", cutoff.Format(dayLayout))
+	}
+	return err
+}
+
+// PruneInterval 定期删除过期数据的间隔
+const PruneInterval = time.Hour
+
+// Run 定期删除过期数据，直到 ctx 取消
+func (c *Collector) Run(ctx context.Context) {
+	ticker := time.NewTicker(PruneInterval)
+	defer ticker.Stop()
+	for {
+		if err := c.Prune(); err != nil {
+			c.logger.Warn("删除过期数据失败", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Nodes 返回所有节点的状态（按名称排序）
+func (c *Collector) Nodes() []Node {
+	now := c.now()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	nodes := make([]Node, 0, len(c.nodes))
+	for _, n := range c.nodes {
+		node := n.Node
+		node.Online = now.Sub(n.LastSeen) <= c.offlineAfter
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Name != nodes[j].Name {
+			return nodes[i].Name < nodes[j].Name
+		}
+		return nodes[i].Key < nodes[j].Key
+	})
+	return nodes
+}
+
+// Node 返回单个节点的状态
+func (c *Collector) Node(key string) (Node, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	n, ok := c.nodes[key]
+	if !ok {
+		return Node{}, false
+	}
+	node := n.Node
+	node.Online = c.now().Sub(n.LastSeen) <= c.offlineAfter
+	return node, true
+}
+
+// online 返回各节点当前是否在线（按节点标识），只加一次锁，供需要遍历所有节点的调用方使用
+func (c *Collector) online() map[string]bool {
+	now := c.now()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	online := make(map[string]bool, len(c.nodes))
+	for key, n := range c.nodes {
+		online[key] = now.Sub(n.LastSeen) <= c.offlineAfter
+	}
+	return online
+}
+
+// Points 返回节点在 [from, to) 内的数据点
+func (c *Collector) Points(key string, from, to time.Time) []Point {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	n, ok := c.nodes[key]
+	if !ok {
+		return nil
+	}
+	return pointsIn(n.points, from, to)
+}
+
+// pointsIn 返回按时间排列的 points 中时间在 [from, to) 内的数据点（二分查找，返回副本）
+func pointsIn(points []Point, from, to time.Time) []Point {
+	i, j := searchPoints(points, from), searchPoints(points, to)
+	if i >= j {
+		return nil
+	}
+	return slices.Clone(points[i:j])
+}
+
+// searchPoints 返回按时间排列的 points 中第一个时间不早于 t 的数据点的下标
+func searchPoints(points []Point, t time.Time) int {
+	return sort.Search(len(points), func(k int) bool { return !points[k].Time.Before(t) })
+}
+
+// Bucket 时间序列中的一个时间桶
+type Bucket struct {
+	Time  time.Time `json:"time"`  // 桶的开始时间
+	Bytes int64     `json:"bytes"` // 桶内下载的字节数（上报区间的字节数按上报时间计入）
+	Speed float64   `json:"speed"` // 桶内平均速度（MB/s）
+	Nodes int       `json:"nodes"` // 桶内有上报的节点数
+}
+
+// bucketize 按 step 把数据点汇总为时间桶
+func bucketize(from, to time.Time, step time.Duration, series ...[]Point) []Bucket {
+	if step <= 0 || !to.After(from) {
+		return nil
+	}
+	count := int((to.Sub(from) + step - 1) / step)
+	buckets := make([]Bucket, count)
+	for i := range buckets {
+		buckets[i].Time = from.Add(time.Duration(i) * step)
+	}
+	for _, points := range series {
+		last := -1
+		for _, p := range points {
+			i := int(p.Time.Sub(from) / step)
+			if i < 0 || i >= count {
+				continue
+			}
+			buckets[i].Bytes += p.Bytes
+			if i != last {
+				buckets[i].Nodes++
+				last = i
+			}
+		}
+	}
+	for i := range buckets {
+		buckets[i].Speed = bytesToMB(buckets[i].Bytes) / step.Seconds()
+	}
+	return buckets
+}
+
+// Series 返回节点在 [from, to) 内按 step 汇总的时间序列
+func (c *Collector) Series(key string, from, to time.Time, step time.Duration) ([]Bucket, bool) {
+	c.mu.RLock()
+	n, ok := c.nodes[key]
+	var points []Point
+	if ok {
+		points = pointsIn(n.points, from, to)
+	}
+	c.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return bucketize(from, to, step, points), true
+}
+
+// Fleet 全体节点在一段时间内的汇总
+type Fleet struct {
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Step    float64   `json:"step"`  // 时间桶长度（秒）
+	Bytes   int64     `json:"bytes"` // 总下载字节数
+	Speed   float64   `json:"speed"` // 平均总速度（MB/s）
+	Nodes   int       `json:"nodes"` // 有上报的节点数
+	Buckets []Bucket  `json:"buckets"`
+}
+
+// Fleet 返回全体节点在 [from, to) 内的汇总和按 step 汇总的总速度
+func (c *Collector) Fleet(from, to time.Time, step time.Duration) Fleet {
+	c.mu.RLock()
+	var series [][]Point
+	for _, n := range c.nodes {
+		if points := pointsIn(n.points, from, to); len(points) > 0 {
+			series = append(series, points)
+		}
+	}
+	c.mu.RUnlock()
+
+	fleet := Fleet{From: from, To: to, Step: step.Seconds(), Nodes: len(series), Buckets: bucketize(from, to, step, series...)}
+	for _, b := range fleet.Buckets {
+		fleet.Bytes += b.Bytes
+	}
+	if seconds := to.Sub(from).Seconds(); seconds > 0 {
+		fleet.Speed = bytesToMB(fleet.Bytes) / seconds
+	}
+	return fleet
+}
+
+// NodeRate 节点在一段时间内的平均速度
+type NodeRate struct {
+	Key     string  `json:"key"`
+	Name    string  `json:"name"`
+	Bytes   int64   `json:"bytes"`
+	Seconds float64 `json:"seconds"` // 上报区间的总时长
+	Speed   float64 `json:"speed"`   // 平均速度（MB/s）= 字节数 / 上报区间总时长
+}
+
+// Rank 按 [from, to) 内的平均速度排序节点，asc 为 true 时从慢到快；n 小于等于 0 时返回全部
+// 没有上报的节点不参与排序
+func (c *Collector) Rank(from, to time.Time, n int, asc bool) []NodeRate {
+	c.mu.RLock()
+	var rates []NodeRate
+	for _, node := range c.nodes {
+		points := pointsIn(node.points, from, to)
+		if len(points) == 0 {
+			continue
+		}
+		rate := NodeRate{Key: node.Key, Name: node.Name}
+		for _, p := range points {
+			rate.Bytes += p.Bytes
+			rate.Seconds += p.Seconds
+		}
+		if rate.Seconds > 0 {
+			rate.Speed = bytesToMB(rate.Bytes) / rate.Seconds
+		}
+		rates = append(rates, rate)
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(rates, func(i, j int) bool {
+		if rates[i].Speed != rates[j].Speed {
+			return (rates[i].Speed < rates[j].Speed) == asc
+		}
+		return rates[i].Key < rates[j].Key
+	})
+	if n > 0 && len(rates) > n {
+		rates = rates[:n]
+	}
+	return rates
+}
+
+// DayTotal 一天（收集器所在时区）的下载总量
+type DayTotal struct {
+	Date  string `json:"date"` // YYYY-MM-DD
+	Bytes int64  `json:"bytes"`
+	Nodes int    `json:"nodes"` // 当天有下载的节点数
+}
+
+// Daily 返回最近 days 天（包括今天）每天的下载总量，按日期升序
+func (c *Collector) Daily(days int) []DayTotal {
+	now := c.now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	totals := make([]DayTotal, days)
+	index := make(map[string]int, days)
+	for i := range totals {
+		date := today.AddDate(0, 0, i-days+1).Format(dayLayout)
+		totals[i].Date = date
+		index[date] = i
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, n := range c.nodes {
+		counted := make(map[int]bool)
+		for _, p := range n.points {
+			i, ok := index[p.Time.In(now.Location()).Format(dayLayout)]
+			if !ok {
+				continue
+			}
+			totals[i].Bytes += p.Bytes
+			if p.Bytes > 0 && !counted[i] {
+				totals[i].Nodes++
+				counted[i] = true
+			}
+		}
+	}
+	return totals
+}
+
+// bytesToMB 字节转换为 MB
+func bytesToMB(n int64) float64 {
+	return float64(n) / 1024 / 1024
+}
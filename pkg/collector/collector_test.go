@@ -0,0 +1,214 @@
+package collector
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+// testNow 测试使用的固定时间
+var testNow = time.Date(2025, 10, 26, 12, 0, 0, 0, time.UTC)
+
+// newTestCollector 创建使用固定时间的收集器
+func newTestCollector(t *testing.T, dir string) *Collector {
+	t.Helper()
+	c, err := open(Options{Dir: dir}, func() time.Time { return testNow })
+	if err != nil {
+		t.Fatalf("open() error = %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// report 构造一条上报：at 为相对 testNow 的采集时间，区间 10 秒内下载 mb MB
+func report(node, run string, seq uint64, at time.Duration, mb int64) stats.StatsData {
+	return stats.StatsData{
+		Name:      node,
+		NodeID:    "id-" + node,
+		RunID:     run,
+		Seq:       seq,
+		Timestamp: testNow.Add(at),
+		Interval:  stats.Interval{Seconds: 10, Bytes: mb << 20},
+	}
+}
+
+func TestCollector_IngestDedupesAndReloads(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestCollector(t, dir)
+
+	n, err := c.Ingest([]stats.StatsData{
+		report("a", "r1", 1, -30*time.Second, 10),
+		report("a", "r1", 2, -20*time.Second, 10),
+		report("a", "r1", 2, -20*time.Second, 10), // 批次内重复
+		{Name: ""}, // 没有节点标识
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("Ingest() = %d, %v, want 2", n, err)
+	}
+	// 重试的旧数据被忽略，新的运行从序号 1 开始
+	n, _ = c.Ingest([]stats.StatsData{
+		report("a", "r1", 1, -30*time.Second, 10),
+		report("a", "r2", 1, -10*time.Second, 10),
+	})
+	if n != 1 {
+		t.Errorf("Ingest() = %d, want 1", n)
+	}
+	c.Close()
+
+	// 重新打开后从磁盘恢复状态和去重信息
+	c = newTestCollector(t, dir)
+	node, ok := c.Node("id-a")
+	if !ok || node.Reports != 3 || node.RunID != "r2" || node.Name != "a" {
+		t.Fatalf("Node() = %+v, %v, want 3 reports from run r2", node, ok)
+	}
+	if n, _ := c.Ingest([]stats.StatsData{report("a", "r2", 1, 0, 10)}); n != 0 {
+		t.Errorf("Ingest() after reload = %d, want 0 (duplicate)", n)
+	}
+}
+
+func TestCollector_NodesOnline(t *testing.T) {
+	c := newTestCollector(t, t.TempDir())
+	c.Ingest([]stats.StatsData{report("b", "r", 1, 0, 1)})
+	c.now = func() time.Time { return testNow.Add(2 * time.Minute) }
+	c.Ingest([]stats.StatsData{report("a", "r", 1, 0, 1)})
+
+	nodes := c.Nodes()
+	if len(nodes) != 2 || nodes[0].Name != "a" || !nodes[0].Online || nodes[1].Online {
+		t.Errorf("Nodes() = %+v, want a online and b offline", nodes)
+	}
+}
+
+func TestCollector_SeriesFleetRank(t *testing.T) {
+	c := newTestCollector(t, t.TempDir())
+	c.Ingest([]stats.StatsData{
+		report("a", "r", 1, -50*time.Second, 100),
+		report("a", "r", 2, -40*time.Second, 100),
+		report("a", "r", 3, -10*time.Second, 100),
+		report("b", "r", 1, -40*time.Second, 10),
+		report("b", "r", 2, -2*time.Hour, 10), // 时间范围外
+	})
+	from, to := testNow.Add(-time.Minute), testNow
+
+	series, ok := c.Series("id-a", from, to, 30*time.Second)
+	if !ok || len(series) != 2 || series[0].Bytes != 200<<20 || series[1].Bytes != 100<<20 {
+		t.Fatalf("Series() = %+v, want [200MB 100MB]", series)
+	}
+	if series[0].Speed != 200.0/30 {
+		t.Errorf("Series()[0].Speed = %v, want %v", series[0].Speed, 200.0/30)
+	}
+	if _, ok := c.Series("missing", from, to, time.Minute); ok {
+		t.Error("Series(missing) ok = true")
+	}
+
+	fleet := c.Fleet(from, to, 30*time.Second)
+	if fleet.Bytes != 310<<20 || fleet.Nodes != 2 || fleet.Buckets[0].Nodes != 2 || fleet.Buckets[1].Nodes != 1 {
+		t.Errorf("Fleet() = %+v, want 310MB from 2 nodes", fleet)
+	}
+
+	tests := []struct {
+		n    int
+		asc  bool
+		want []string
+	}{
+		{0, false, []string{"a", "b"}},
+		{1, true, []string{"b"}},
+	}
+	for _, tt := range tests {
+		rates := c.Rank(from, to, tt.n, tt.asc)
+		var got []string
+		for _, r := range rates {
+			got = append(got, r.Name)
+		}
+		if len(got) != len(tt.want) || got[0] != tt.want[0] {
+			t.Errorf("Rank(%d, %v) = %v, want %v", tt.n, tt.asc, got, tt.want)
+		}
+	}
+	if rates := c.Rank(from, to, 1, false); rates[0].Speed != 10 || rates[0].Seconds != 30 {
+		t.Errorf("Rank()[0] = %+v, want 10 MB/s over 30s", rates[0])
+	}
+}
+
+func TestCollector_Prune(t *testing.T) {
+	c := newTestCollector(t, t.TempDir())
+	c.Ingest([]stats.StatsData{report("a", "r", 1, -8*24*time.Hour, 1), report("a", "r", 2, 0, 1), report("b", "old", 1, 0, 1)})
+	c.now = func() time.Time { return testNow.Add(8 * 24 * time.Hour) }
+	c.Ingest([]stats.StatsData{report("b", "r", 1, 8*24*time.Hour, 1)})
+
+	if err := c.Prune(); err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if _, ok := c.Node("id-a"); ok {
+		t.Error("node a not removed after retention")
+	}
+	if points := c.Points("id-b", testNow, testNow.Add(9*24*time.Hour)); len(points) != 1 {
+		t.Errorf("Points(b) = %d, want 1", len(points))
+	}
+	if seqs := c.nodes["id-b"].lastSeq; len(seqs) != 1 || seqs["r"].seq != 1 {
+		t.Errorf("lastSeq(b) = %v, want only the run seen within retention", seqs)
+	}
+}
+
+func TestCollector_PointsOutOfOrder(t *testing.T) {
+	c := newTestCollector(t, t.TempDir())
+	// 第二次运行的时钟落后，数据点晚到但时间更早
+	c.Ingest([]stats.StatsData{report("a", "r1", 1, -20*time.Second, 1), report("a", "r1", 2, -10*time.Second, 2)})
+	c.Ingest([]stats.StatsData{report("a", "r2", 1, -30*time.Second, 3), report("a", "r2", 2, -15*time.Second, 4)})
+
+	points := c.Points("id-a", testNow.Add(-time.Hour), testNow)
+	var mb []int64
+	for _, p := range points {
+		mb = append(mb, p.Bytes>>20)
+	}
+	if !slices.Equal(mb, []int64{3, 1, 4, 2}) {
+		t.Errorf("Points() MB = %v, want sorted by time [3 1 4 2]", mb)
+	}
+	if points := c.Points("id-a", testNow.Add(-20*time.Second), testNow.Add(-10*time.Second)); len(points) != 2 {
+		t.Errorf("Points([-20s, -10s)) = %d, want 2", len(points))
+	}
+	if rates := c.Rank(testNow.Add(-16*time.Second), testNow, 0, false); len(rates) != 1 || rates[0].Bytes != 6<<20 {
+		t.Errorf("Rank() = %+v, want 6 MB from the last two points", rates)
+	}
+}
+
+func TestCollector_DailyAndChanged(t *testing.T) {
+	c := newTestCollector(t, t.TempDir())
+	changed := c.Changed()
+	c.Ingest([]stats.StatsData{
+		report("a", "r", 1, -36*time.Hour, 5),
+		report("a", "r", 2, -time.Hour, 10),
+		report("b", "r", 1, -time.Minute, 20),
+		report("b", "r", 2, 0, 0),
+	})
+	select {
+	case <-changed:
+	default:
+		t.Error("Changed() not closed after Ingest")
+	}
+	if n, _ := c.Ingest([]stats.StatsData{report("b", "r", 2, 0, 0)}); n != 0 || isClosed(c.Changed()) {
+		t.Error("Changed() closed without new data")
+	}
+
+	days := c.Daily(3)
+	want := []DayTotal{
+		{Date: "2025-10-24", Bytes: 0, Nodes: 0},
+		{Date: "2025-10-25", Bytes: 5 << 20, Nodes: 1},
+		{Date: "2025-10-26", Bytes: 30 << 20, Nodes: 2},
+	}
+	for i := range want {
+		if days[i] != want[i] {
+			t.Errorf("Daily(3)[%d] = %+v, want %+v", i, days[i], want[i])
+		}
+	}
+}
+
+// isClosed 返回通道是否已关闭
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,265 @@
+package collector
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/history"
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+// DefaultDirectiveTTL 指令默认的有效期，节点在此之前没有上报则不再下发
+const DefaultDirectiveTTL = 10 * time.Minute
+
+// directiveRetention 已结束的指令在过期后保留多久
+const directiveRetention = 24 * time.Hour
+
+// directiveStateFile 指令状态文件（数据目录下），重启后继续下发未确认的指令
+const directiveStateFile = "directives.json"
+
+// 指令在收集器中的状态；节点确认后为确认中的状态（applied、failed、rejected）
+const (
+	DirectivePending   = "pending"   // 等待节点上报
+	DirectiveDelivered = "delivered" // 已在上报响应中下发，等待确认（确认前每次上报都会重新下发）
+	DirectiveExpired   = "expired"   // 有效期内没有收到确认
+)
+
+// DirectiveRequest 下发指令的请求（POST /api/directives）
+// Nodes 和 Labels 都为空时下发给所有已知节点
+type DirectiveRequest struct {
+	Action string            `json:"action"`
+	Value  int64             `json:"value,omitempty"`
+	TTL    string            `json:"ttl,omitempty"`    // 有效期，例如 10m，默认 DefaultDirectiveTTL
+	Nodes  []string          `json:"nodes,omitempty"`  // 节点标识或名称
+	Labels map[string]string `json:"labels,omitempty"` // 标签全部匹配的节点
+}
+
+// DirectiveRecord 下发给一个节点的指令及其状态
+type DirectiveRecord struct {
+	stats.Directive
+	NodeName    string     `json:"node_name,omitempty"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"` // 节点确认时返回的错误
+	Created     time.Time  `json:"created"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"` // 第一次下发的时间
+	AckedAt     *time.Time `json:"acked_at,omitempty"`     // 收到确认的时间
+}
+
+// done 返回指令是否已结束（已确认或已过期）
+func (r *DirectiveRecord) done() bool {
+	return r.Status != DirectivePending && r.Status != DirectiveDelivered
+}
+
+// Controller 保存下发给节点的指令：节点上报时随响应下发，之后的上报中确认
+// 响应需要签名（节点只执行签名有效的指令），因此只在配置了签名密钥时使用
+type Controller struct {
+	c         *Collector
+	logger    *slog.Logger
+	statePath string
+
+	mu         sync.Mutex
+	directives []*DirectiveRecord // 按创建时间排列
+}
+
+// NewController 创建指令控制器，加载数据目录中保存的指令状态
+func NewController(c *Collector, logger *slog.Logger) (*Controller, error) {
+	ct := &Controller{
+		c:         c,
+		logger:    cmp.Or(logger, slog.New(slog.DiscardHandler)),
+		statePath: filepath.Join(c.store.dir, directiveStateFile),
+	}
+	data, err := os.ReadFile(ct.statePath)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &ct.directives); err != nil {
+			ct.logger.Warn("指令状态文件损坏，忽略", "path", ct.statePath, "error", err)
+			ct.directives = nil
+		}
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("读取指令状态失败: %w", err)
+	}
+	return ct, nil
+}
+
+// Submit 为匹配的节点各创建一条指令，返回创建的指令
+func (ct *Controller) Submit(req DirectiveRequest) ([]DirectiveRecord, error) {
+	if err := (stats.Directive{Action: req.Action, Value: req.Value}).Validate(); err != nil {
+		return nil, err
+	}
+	ttl := DefaultDirectiveTTL
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("ttl 无效: %q", req.TTL)
+		}
+	}
+	nodes, err := ct.selectNodes(req.Nodes, req.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	now := ct.c.now()
+	var created []DirectiveRecord
+	ct.mu.Lock()
+	for _, node := range nodes {
+		rec := &DirectiveRecord{
+			Directive: stats.Directive{ID: stats.NewUUID(), Node: node.Key, Action: req.Action, Value: req.Value, Expires: now.Add(ttl)},
+			NodeName:  node.Name,
+			Status:    DirectivePending,
+			Created:   now,
+		}
+		ct.directives = append(ct.directives, rec)
+		created = append(created, *rec)
+	}
+	ct.mu.Unlock()
+
+	ct.logger.Info("创建指令", "action", req.Action, "value", req.Value, "nodes", len(created), "expires", now.Add(ttl).Format(time.RFC3339))
+	return created, ct.save()
+}
+
+// selectNodes 按节点标识、名称和标签选择节点，都为空时选择所有已知节点
+func (ct *Controller) selectNodes(names []string, labels map[string]string) ([]Node, error) {
+	all := ct.c.Nodes()
+	var result []Node
+	for _, node := range all {
+		if len(names) > 0 && !slices.Contains(names, node.Key) && !slices.Contains(names, node.Name) {
+			continue
+		}
+		if matchLabels(node.Labels, labels) {
+			result = append(result, node)
+		}
+	}
+	for _, name := range names {
+		if !slices.ContainsFunc(all, func(n Node) bool { return n.Key == name || n.Name == name }) {
+			return nil, fmt.Errorf("未知节点: %s", name)
+		}
+	}
+	if len(result) == 0 {
+		return nil, errors.New("没有匹配的节点")
+	}
+	return result, nil
+}
+
+// Deliver 返回需要随上报响应下发给节点的指令（等待下发和已下发未确认的），并标记为已下发
+func (ct *Controller) Deliver(key string) []stats.Directive {
+	now := ct.c.now()
+	var result []stats.Directive
+	changed := false
+
+	ct.mu.Lock()
+	for _, rec := range ct.directives {
+		if rec.Node != key || rec.done() || now.After(rec.Expires) {
+			continue
+		}
+		if rec.Status == DirectivePending {
+			rec.Status, rec.DeliveredAt = DirectiveDelivered, &now
+			changed = true
+		}
+		result = append(result, rec.Directive)
+	}
+	ct.mu.Unlock()
+
+	if changed {
+		if err := ct.save(); err != nil {
+			ct.logger.Warn("保存指令状态失败", "error", err)
+		}
+	}
+	return result
+}
+
+// Ack 记录节点对指令的确认，已结束的指令和其他节点的指令忽略
+func (ct *Controller) Ack(key string, acks []stats.Ack) {
+	if len(acks) == 0 {
+		return
+	}
+	now := ct.c.now()
+	changed := false
+
+	ct.mu.Lock()
+	for _, ack := range acks {
+		i := slices.IndexFunc(ct.directives, func(r *DirectiveRecord) bool { return r.ID == ack.ID && r.Node == key })
+		if i < 0 || ct.directives[i].done() {
+			continue
+		}
+		rec := ct.directives[i]
+		rec.Status, rec.Error, rec.AckedAt = ack.Status, ack.Error, &now
+		changed = true
+		ct.logger.Info("节点确认指令", "node", key, "id", rec.ID, "action", rec.Action, "status", ack.Status, "error", ack.Error)
+	}
+	ct.mu.Unlock()
+
+	if changed {
+		if err := ct.save(); err != nil {
+			ct.logger.Warn("保存指令状态失败", "error", err)
+		}
+	}
+}
+
+// Directives 返回所有指令，最新的在前
+func (ct *Controller) Directives() []DirectiveRecord {
+	ct.expire()
+
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	result := make([]DirectiveRecord, 0, len(ct.directives))
+	for _, rec := range slices.Backward(ct.directives) {
+		result = append(result, *rec)
+	}
+	return result
+}
+
+// expire 把过期未确认的指令标记为 expired，删除已结束且过期超过 directiveRetention 的指令，返回是否有变化
+func (ct *Controller) expire() bool {
+	now := ct.c.now()
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	before := len(ct.directives)
+	changed := false
+	ct.directives = slices.DeleteFunc(ct.directives, func(rec *DirectiveRecord) bool {
+		if !rec.done() && now.After(rec.Expires) {
+			rec.Status = DirectiveExpired
+			changed = true
+		}
+		return rec.done() && now.Sub(rec.Expires) > directiveRetention
+	})
+	return changed || len(ct.directives) != before
+}
+
+// Run 每分钟清理过期的指令，直到 ctx 取消
+func (ct *Controller) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if ct.expire() {
+			if err := ct.save(); err != nil {
+				ct.logger.Warn("保存指令状态失败", "error", err)
+			}
+		}
+	}
+}
+
+// save 保存指令状态
+func (ct *Controller) save() error {
+	ct.mu.Lock()
+	data, err := json.Marshal(ct.directives)
+	ct.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return history.WriteFileAtomic(ct.statePath, data)
+}
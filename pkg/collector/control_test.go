@@ -0,0 +1,206 @@
+package collector
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+func newTestSigner(t *testing.T) *stats.DirectiveSigner {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stats.NewDirectiveSigner(key)
+}
+
+func newTestController(t *testing.T, c *Collector) *Controller {
+	t.Helper()
+	ct, err := NewController(c, nil)
+	if err != nil {
+		t.Fatalf("NewController() error = %v", err)
+	}
+	return ct
+}
+
+// statuses 返回指令的 "节点=状态" 列表（按创建顺序）
+func statuses(records []DirectiveRecord) string {
+	var result []string
+	for i := len(records) - 1; i >= 0; i-- {
+		result = append(result, records[i].NodeName+"="+records[i].Status)
+	}
+	return strings.Join(result, " ")
+}
+
+func TestController_Submit(t *testing.T) {
+	c := newTestCollector(t, t.TempDir())
+	a, b := report("a", "r", 1, 0, 1), report("b", "r", 1, 0, 1)
+	b.Labels = map[string]string{"region": "eu"}
+	c.Ingest([]stats.StatsData{a, b})
+	ct := newTestController(t, c)
+
+	tests := []struct {
+		req   DirectiveRequest
+		nodes string // 创建指令的节点，为空时期望错误
+	}{
+		{DirectiveRequest{Action: stats.ActionPause}, "id-a id-b"},
+		{DirectiveRequest{Action: stats.ActionSetRate, Value: 1 << 20, Nodes: []string{"a"}}, "id-a"},
+		{DirectiveRequest{Action: stats.ActionResume, Labels: map[string]string{"region": "eu"}}, "id-b"},
+		{DirectiveRequest{Action: "reboot"}, ""},
+		{DirectiveRequest{Action: stats.ActionSetWorkers}, ""},
+		{DirectiveRequest{Action: stats.ActionPause, TTL: "soon"}, ""},
+		{DirectiveRequest{Action: stats.ActionPause, Nodes: []string{"missing"}}, ""},
+		{DirectiveRequest{Action: stats.ActionPause, Labels: map[string]string{"region": "us"}}, ""},
+	}
+	for _, tt := range tests {
+		created, err := ct.Submit(tt.req)
+		var nodes []string
+		for _, rec := range created {
+			nodes = append(nodes, rec.Node)
+		}
+		if got := strings.Join(nodes, " "); got != tt.nodes || (err != nil) != (tt.nodes == "") {
+			t.Errorf("Submit(%+v) = %q, %v, want nodes %q", tt.req, got, err, tt.nodes)
+		}
+	}
+}
+
+func TestController_DeliverAckExpire(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestCollector(t, dir)
+	c.Ingest([]stats.StatsData{report("a", "r", 1, 0, 1), report("b", "r", 1, 0, 1)})
+	ct := newTestController(t, c)
+	created, _ := ct.Submit(DirectiveRequest{Action: stats.ActionPause, TTL: "5m"})
+
+	// 确认之前每次上报都重新下发
+	for range 2 {
+		if got := ct.Deliver("id-a"); len(got) != 1 || got[0].ID != created[0].ID || got[0].Expires != testNow.Add(5*time.Minute) {
+			t.Errorf("Deliver(id-a) = %+v", got)
+		}
+	}
+	// 其他节点的确认被忽略
+	ct.Ack("id-b", []stats.Ack{{ID: created[0].ID, Status: stats.AckApplied}})
+	ct.Ack("id-a", []stats.Ack{{ID: created[0].ID, Status: stats.AckApplied}})
+	if got := ct.Deliver("id-a"); len(got) != 0 {
+		t.Errorf("Deliver(id-a) after ack = %+v, want none", got)
+	}
+	if got := statuses(ct.Directives()); got != "a=applied b=pending" {
+		t.Errorf("Directives() = %s", got)
+	}
+
+	// 重启后保留状态，过期未确认的标记为 expired，之后不再下发
+	ct = newTestController(t, c)
+	c.now = func() time.Time { return testNow.Add(6 * time.Minute) }
+	if got := ct.Deliver("id-b"); len(got) != 0 {
+		t.Errorf("Deliver(id-b) after expiry = %+v, want none", got)
+	}
+	if got := statuses(ct.Directives()); got != "a=applied b=expired" {
+		t.Errorf("Directives() after expiry = %s", got)
+	}
+	c.now = func() time.Time { return testNow.Add(directiveRetention + time.Hour) }
+	if !ct.expire() || len(ct.Directives()) != 0 {
+		t.Errorf("Directives() after retention = %+v, want none", ct.Directives())
+	}
+}
+
+func TestAPI_Directives(t *testing.T) {
+	c := newTestCollector(t, t.TempDir())
+	c.now = time.Now
+	ct := newTestController(t, c)
+	secret := "s3cret"
+	signer := newTestSigner(t)
+	api := NewAPI(c, APIOptions{Verifier: stats.NewVerifier([]byte(secret), 0), Signer: signer, Control: ct, AdminToken: "admin", ReadToken: "read"})
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	// 节点使用带签名和指令的 HTTP 输出目标上报，指令按收集器的公钥校验
+	sink, err := stats.NewSink(stats.SinkConfig{Type: stats.SinkHTTP, URL: server.URL + "/stats", Auth: stats.AuthOptions{HMACSecret: secret}, Directives: true, DirectiveKey: signer.PublicKey()})
+	if err != nil {
+		t.Fatalf("NewSink() error = %v", err)
+	}
+	var applied []string
+	reporter := stats.NewReporter(func() stats.StatsData { return stats.StatsData{Timestamp: time.Now()} })
+	reporter.SetLogger(slog.New(slog.DiscardHandler))
+	reporter.SetIdentity(stats.Identity{NodeID: "n1", Name: "edge-1"})
+	reporter.SetDirectiveHandler(func(ctx context.Context, d stats.Directive) error {
+		applied = append(applied, d.Action)
+		return nil
+	})
+	reporter.AddSink("collector", sink, stats.SinkOptions{})
+	if err := reporter.Report(context.Background()); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+
+	body := `{"action": "set_rate", "value": 1048576, "nodes": ["edge-1"]}`
+	if rec := do(t, api, "POST", "/api/directives", "read", body); rec.Code != http.StatusUnauthorized {
+		t.Errorf("POST /api/directives with read token: status = %d, want 401", rec.Code)
+	}
+	if rec := do(t, api, "POST", "/api/directives", "admin", `{"action": "set_rate", "foo": 1}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST /api/directives with unknown field: status = %d, want 400", rec.Code)
+	}
+	if rec := do(t, api, "POST", "/api/directives", "admin", body); rec.Code != http.StatusCreated {
+		t.Fatalf("POST /api/directives: status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	// 下一次上报收到并执行指令，再下一次上报确认
+	for range 2 {
+		if err := reporter.Report(context.Background()); err != nil {
+			t.Fatalf("Report() error = %v", err)
+		}
+	}
+	if strings.Join(applied, ",") != stats.ActionSetRate {
+		t.Errorf("applied = %v, want set_rate once", applied)
+	}
+	var records []DirectiveRecord
+	rec := do(t, api, "GET", "/api/directives", "read", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &records); err != nil || len(records) != 1 || records[0].Status != stats.AckApplied || records[0].AckedAt == nil {
+		t.Errorf("GET /api/directives = %s", rec.Body)
+	}
+
+	// 没有管理 token 时不能下发指令
+	api = NewAPI(c, APIOptions{Verifier: stats.NewVerifier([]byte(secret), 0), Signer: signer, Control: ct})
+	if rec := do(t, api, "POST", "/api/directives", "", body); rec.Code != http.StatusNotFound {
+		t.Errorf("POST /api/directives without admin token: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestAPI_DirectivesCertNodes(t *testing.T) {
+	c := newTestCollector(t, t.TempDir())
+	c.now = time.Now
+	ct := newTestController(t, c)
+	api := NewAPI(c, APIOptions{Signer: newTestSigner(t), Control: ct, CertNodes: true})
+	c.Ingest([]stats.StatsData{{NodeID: "n1", Name: "edge-1", Timestamp: time.Now()}})
+	if _, err := ct.Submit(DirectiveRequest{Action: stats.ActionPause, Nodes: []string{"n1"}}); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	// ingest 以证书 cn 的身份上报 node 的数据，返回收到的指令数
+	ingest := func(cn, node string) int {
+		body, _ := json.Marshal(stats.StatsData{NodeID: node, Timestamp: time.Now()})
+		req := httptest.NewRequest("POST", "/stats", strings.NewReader(string(body)))
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: cn}}}}
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		var resp stats.ReportResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return len(resp.Directives)
+	}
+	// 其他节点冒充 n1 不能取走指令，证书与节点一致时才下发
+	if n := ingest("n2", "n1"); n != 0 {
+		t.Errorf("ingest as n2 for n1 got %d directives, want 0", n)
+	}
+	if n := ingest("n1", "n1"); n != 1 {
+		t.Errorf("ingest as n1 got %d directives, want 1", n)
+	}
+}
@@ -0,0 +1,26 @@
+package collector
+
+import (
+	"bytes"
+	"embed"
+	"net/http"
+	"time"
+)
+
+// web 仪表盘页面：单个 HTML 文件，样式和脚本内联，不依赖外部资源
+//
+//go:embed web/index.html
+var web embed.FS
+
+// dashboardHandler 返回仪表盘页面；页面本身不含数据，查询接口的 token 由页面从地址栏的 token 参数读取
+func dashboardHandler() http.Handler {
+	page, err := web.ReadFile("web/index.html")
+	if err != nil {
+		panic(err)
+	}
+	modTime := time.Now()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+		http.ServeContent(w, r, "index.html", modTime, bytes.NewReader(page))
+	})
+}
@@ -0,0 +1,200 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+// Record 存储中的一条上报记录
+type Record struct {
+	Received time.Time       `json:"received"` // 收集器收到的时间
+	Data     stats.StatsData `json:"data"`
+}
+
+// 存储文件按天（UTC）分段：reports-2025-10-26.jsonl
+const (
+	segmentPrefix = "reports-"
+	segmentSuffix = ".jsonl"
+	dayLayout     = "2006-01-02"
+)
+
+// Store 本地磁盘上的只追加存储，每条记录一行 JSON，按收到的日期分段
+// 写入只追加到当天的分段，过期的分段整体删除
+type Store struct {
+	dir string
+
+	mu   sync.Mutex
+	day  string
+	file *os.File
+}
+
+// OpenStore 打开（或创建）存储目录
+func OpenStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("创建存储目录失败: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Append 追加一批记录，按收到的日期写入对应的分段（每个分段一次写入）
+func (s *Store) Append(records []Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf bytes.Buffer
+	flush := func() error {
+		if buf.Len() == 0 {
+			return nil
+		}
+		_, err := s.file.Write(buf.Bytes())
+		buf.Reset()
+		if err != nil {
+			return fmt.Errorf("写入存储失败: %w", err)
+		}
+		return nil
+	}
+
+	for _, rec := range records {
+		if day := rec.Received.UTC().Format(dayLayout); day != s.day {
+			if err := flush(); err != nil {
+				return err
+			}
+			if err := s.rotate(day); err != nil {
+				return err
+			}
+		}
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return fmt.Errorf("序列化记录失败: %w", err)
+		}
+		buf.Write(append(line, '\n'))
+	}
+	return flush()
+}
+
+// rotate 切换到指定日期的分段
+func (s *Store) rotate(day string) error {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	file, err := os.OpenFile(s.segmentPath(day), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("打开存储分段失败: %w", err)
+	}
+	s.file, s.day = file, day
+	return nil
+}
+
+func (s *Store) segmentPath(day string) string {
+	return filepath.Join(s.dir, segmentPrefix+day+segmentSuffix)
+}
+
+// segments 返回所有分段的日期（升序）
+func (s *Store) segments() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("读取存储目录失败: %w", err)
+	}
+	var days []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		day := strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix)
+		if _, err := time.Parse(dayLayout, day); err == nil {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+// Load 按写入顺序读取 since 当天及之后的所有记录，跳过损坏的行（例如写入时断电）
+func (s *Store) Load(since time.Time, fn func(Record)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	days, err := s.segments()
+	if err != nil {
+		return err
+	}
+	first := since.UTC().Format(dayLayout)
+	for _, day := range days {
+		if day < first {
+			continue
+		}
+		if err := s.loadSegment(day, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) loadSegment(day string, fn func(Record)) error {
+	file, err := os.Open(s.segmentPath(day))
+	if err != nil {
+		return fmt.Errorf("读取存储分段失败: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		fn(rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("读取存储分段 %s 失败: %w", day, err)
+	}
+	return nil
+}
+
+// Prune 删除 before 当天之前的分段，返回删除的分段数
+func (s *Store) Prune(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	days, err := s.segments()
+	if err != nil {
+		return 0, err
+	}
+	cutoff := before.UTC().Format(dayLayout)
+	removed := 0
+	for _, day := range days {
+		if day >= cutoff || day == s.day {
+			continue
+		}
+		if err := os.Remove(s.segmentPath(day)); err != nil {
+			return removed, fmt.Errorf("删除过期分段失败: %w", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Close 关闭当前分段
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file, s.day = nil, ""
+	return err
+}
@@ -0,0 +1,86 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+func TestStore_AppendRotatesByDay(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenStore(dir)
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	day1 := time.Date(2025, 10, 26, 23, 59, 0, 0, time.UTC)
+	day2 := day1.Add(2 * time.Minute)
+	err = s.Append([]Record{
+		{Received: day1, Data: stats.StatsData{Name: "a", Seq: 1}},
+		{Received: day2, Data: stats.StatsData{Name: "a", Seq: 2}},
+		{Received: day2, Data: stats.StatsData{Name: "b", Seq: 1}},
+	})
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	s.Close()
+
+	for _, name := range []string{"reports-2025-10-26.jsonl", "reports-2025-10-27.jsonl"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("segment %s: %v", name, err)
+		}
+	}
+
+	// 损坏的行被跳过
+	f, _ := os.OpenFile(filepath.Join(dir, "reports-2025-10-27.jsonl"), os.O_APPEND|os.O_WRONLY, 0o644)
+	f.WriteString("{\"received\":\n")
+	f.Close()
+
+	tests := []struct {
+		since time.Time
+		want  int
+	}{
+		{day1.Add(-48 * time.Hour), 3},
+		{day2, 2},
+		{day2.Add(24 * time.Hour), 0},
+	}
+	for _, tt := range tests {
+		var got int
+		if err := s.Load(tt.since, func(Record) { got++ }); err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("Load(%s) = %d records, want %d", tt.since.Format(dayLayout), got, tt.want)
+		}
+	}
+}
+
+func TestStore_Prune(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := OpenStore(dir)
+	defer s.Close()
+	base := time.Date(2025, 10, 20, 12, 0, 0, 0, time.UTC)
+	for i := range 3 {
+		s.Append([]Record{{Received: base.Add(time.Duration(i) * 24 * time.Hour), Data: stats.StatsData{Name: "a"}}})
+	}
+	os.WriteFile(filepath.Join(dir, "other.txt"), nil, 0o644)
+
+	removed, err := s.Prune(base.Add(24 * time.Hour))
+	if err != nil || removed != 1 {
+		t.Fatalf("Prune() = %d, %v, want 1", removed, err)
+	}
+	// 当前写入的分段不会被删除
+	removed, _ = s.Prune(base.Add(10 * 24 * time.Hour))
+	if removed != 1 {
+		t.Errorf("Prune() removed %d, want 1 (current segment kept)", removed)
+	}
+	days, _ := s.segments()
+	if len(days) != 1 || days[0] != "2025-10-22" {
+		t.Errorf("segments() = %v, want [2025-10-22]", days)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "other.txt")); err != nil {
+		t.Errorf("unrelated file removed: %v", err)
+	}
+}
@@ -0,0 +1,423 @@
+package collector
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/downloader"
+	"github.com/dora-exku/netflood/pkg/stats"
+	"gopkg.in/yaml.v3"
+)
+
+// 任务分发的默认值
+const (
+	DefaultTaskTTL    = 10 * time.Minute // 节点超过该时长未获取任务后不再参与分片
+	DefaultTaskReload = 10 * time.Second // 检查任务池文件变化的间隔
+)
+
+// taskMemberRetention 超过该时长未获取任务的节点从状态中删除
+const taskMemberRetention = 24 * time.Hour
+
+// TaskPool 任务池：一组任务及其分配规则
+type TaskPool struct {
+	Name   string            `yaml:"name"`   // 名称，必须唯一
+	Tasks  []string          `yaml:"tasks"`  // "IP,URL" 格式的任务
+	Files  []string          `yaml:"files"`  // 任务文件（每行 "IP,URL"），相对路径相对于配置文件所在目录
+	Nodes  []string          `yaml:"nodes"`  // 只分配给这些节点（节点 ID 或名称），为空时不限
+	Labels map[string]string `yaml:"labels"` // 只分配给带有这些标签的节点，为空时不限
+	Shard  bool              `yaml:"shard"`  // 在匹配的节点之间分片，否则每个节点都获得全部任务
+
+	tasks []downloader.DownloadTask // 合并 tasks 和 files 后的任务
+}
+
+// TaskConfig 任务分发配置
+type TaskConfig struct {
+	Pools []TaskPool `yaml:"pools"`
+
+	version string // 任务池内容的摘要，内容变化时改变
+}
+
+// Version 返回任务池版本：配置和任务文件内容的摘要，内容不变时重启后也不变
+func (cfg TaskConfig) Version() string {
+	return cfg.version
+}
+
+// LoadTaskConfig 从 YAML 文件加载任务分发配置，读取任务文件并计算版本
+func LoadTaskConfig(path string) (TaskConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return TaskConfig{}, fmt.Errorf("读取任务池配置失败: %w", err)
+	}
+	var cfg TaskConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return TaskConfig{}, fmt.Errorf("解析任务池配置失败: %w", err)
+	}
+	if err := cfg.resolve(filepath.Dir(path)); err != nil {
+		return TaskConfig{}, err
+	}
+	return cfg, nil
+}
+
+// resolve 检查配置，读取任务文件并计算版本
+func (cfg *TaskConfig) resolve(dir string) error {
+	if len(cfg.Pools) == 0 {
+		return errors.New("任务池配置中没有 pools")
+	}
+	names := make(map[string]bool)
+	for i := range cfg.Pools {
+		p := &cfg.Pools[i]
+		if p.Name == "" {
+			return fmt.Errorf("第 %d 个任务池缺少 name", i+1)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("任务池名称重复: %s", p.Name)
+		}
+		names[p.Name] = true
+
+		tasks, err := downloader.ParseTasks(strings.NewReader(strings.Join(p.Tasks, "\n")))
+		if err != nil {
+			return fmt.Errorf("任务池 %s: %w", p.Name, err)
+		}
+		for _, file := range p.Files {
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(dir, file)
+			}
+			fileTasks, err := downloader.FileSource(file).Tasks(context.Background())
+			if err != nil {
+				return fmt.Errorf("任务池 %s: %w", p.Name, err)
+			}
+			tasks = append(tasks, fileTasks...)
+		}
+		if len(tasks) == 0 {
+			return fmt.Errorf("任务池 %s 没有任务", p.Name)
+		}
+		p.tasks = tasks
+	}
+
+	// 版本覆盖分配规则和合并后的任务，任务文件变化时也会改变
+	type versioned struct {
+		Name   string
+		Nodes  []string
+		Labels map[string]string
+		Shard  bool
+		Tasks  []downloader.DownloadTask
+	}
+	pools := make([]versioned, len(cfg.Pools))
+	for i, p := range cfg.Pools {
+		pools[i] = versioned{p.Name, p.Nodes, p.Labels, p.Shard, p.tasks}
+	}
+	data, err := json.Marshal(pools)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	cfg.version = hex.EncodeToString(sum[:6])
+	return nil
+}
+
+// matches 节点是否符合任务池的分配规则
+func (p TaskPool) matches(m TaskMember) bool {
+	if len(p.Nodes) > 0 && !slices.Contains(p.Nodes, m.Key) && !slices.Contains(p.Nodes, m.Name) {
+		return false
+	}
+	return matchLabels(m.Labels, p.Labels)
+}
+
+// TaskMember 获取过任务的节点
+type TaskMember struct {
+	Key       string            `json:"key"` // 节点标识：node_id，未设置时为名称
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	LastFetch time.Time         `json:"last_fetch"` // 最近一次获取任务的时间
+	Version   string            `json:"version"`    // 最近一次获取的任务池版本，与当前版本不同说明节点还未刷新
+	Tasks     int               `json:"tasks"`      // 最近一次获取的任务数
+	Active    bool              `json:"active"`     // 是否参与分片
+}
+
+// Assignment 分配给节点的任务
+type Assignment struct {
+	Node    string                    `json:"node"`
+	Version string                    `json:"version"` // 任务池版本
+	Pools   []string                  `json:"pools"`   // 匹配的任务池
+	Tasks   []downloader.DownloadTask `json:"tasks"`
+}
+
+// ETag 返回任务列表的摘要，任务池版本变化但节点的任务不变时保持不变
+func (a Assignment) ETag() string {
+	h := sha256.New()
+	for _, t := range a.Tasks {
+		fmt.Fprintf(h, "%s,%s\n", t.IP, t.URL)
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:8]) + `"`
+}
+
+// TaskStatus 任务分发状态
+type TaskStatus struct {
+	Version  string           `json:"version"`
+	LoadedAt time.Time        `json:"loaded_at"` // 当前版本的加载时间
+	Pools    []TaskPoolStatus `json:"pools"`
+	Members  []TaskMember     `json:"members"` // 按标识排序
+}
+
+// TaskPoolStatus 任务池状态
+type TaskPoolStatus struct {
+	Name    string            `json:"name"`
+	Tasks   int               `json:"tasks"`
+	Shard   bool              `json:"shard"`
+	Nodes   []string          `json:"nodes,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Members []string          `json:"members"` // 当前匹配并参与分配的节点
+}
+
+// Dispatcher 按任务池配置为每个节点分配任务列表
+//
+// 节点通过 HTTP 任务来源获取任务时附加节点身份请求头（见 stats.Identity.SetHeader），
+// 分片的任务池在最近 TTL 内获取过任务、且没有因停止上报而离线的匹配节点之间按 IP 均匀分配；
+// 节点加入或离开后，其他节点在下一次刷新任务列表时获得新的分片
+type Dispatcher struct {
+	c      *Collector
+	path   string
+	ttl    time.Duration
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	cfg      TaskConfig
+	loadedAt time.Time
+	members  map[string]*TaskMember
+}
+
+// NewDispatcher 加载任务池配置并创建任务分发器，ttl 为 0 时使用 DefaultTaskTTL
+func NewDispatcher(c *Collector, path string, ttl time.Duration, logger *slog.Logger) (*Dispatcher, error) {
+	cfg, err := LoadTaskConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Dispatcher{
+		c:        c,
+		path:     path,
+		ttl:      cmp.Or(ttl, DefaultTaskTTL),
+		logger:   cmp.Or(logger, slog.New(slog.DiscardHandler)),
+		cfg:      cfg,
+		loadedAt: c.now(),
+		members:  make(map[string]*TaskMember),
+	}, nil
+}
+
+// Version 返回当前的任务池版本
+func (d *Dispatcher) Version() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cfg.version
+}
+
+// Reload 重新加载任务池配置，返回版本是否变化；加载失败时保留原配置
+func (d *Dispatcher) Reload() (bool, error) {
+	cfg, err := LoadTaskConfig(d.path)
+	if err != nil {
+		return false, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if cfg.version == d.cfg.version {
+		return false, nil
+	}
+	d.cfg, d.loadedAt = cfg, d.c.now()
+	return true, nil
+}
+
+// Run 定期检查任务池配置的变化，直到 ctx 取消
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(DefaultTaskReload)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := d.Reload()
+		switch {
+		case err != nil:
+			d.logger.Warn("重新加载任务池失败，继续使用原配置", "path", d.path, "error", err)
+		case changed:
+			d.logger.Info("任务池已更新", "version", d.Version(), "path", d.path)
+		}
+		d.prune()
+	}
+}
+
+// prune 删除长时间未获取任务的节点
+func (d *Dispatcher) prune() {
+	now := d.c.now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, m := range d.members {
+		if now.Sub(m.LastFetch) > taskMemberRetention {
+			delete(d.members, key)
+		}
+	}
+}
+
+// Assign 为请求任务的节点分配任务，并记录节点参与之后的分片
+// 身份中没有标签时使用节点最近一次上报的标签
+func (d *Dispatcher) Assign(id stats.Identity) (Assignment, error) {
+	key := cmp.Or(id.NodeID, id.Name)
+	if key == "" {
+		return Assignment{}, errors.New("缺少节点身份")
+	}
+	m := TaskMember{Key: key, Name: id.Name, Labels: id.Labels}
+	if node, ok := d.c.Node(key); ok {
+		m.Name = cmp.Or(m.Name, node.Name)
+		if m.Labels == nil {
+			m.Labels = node.Labels
+		}
+	}
+
+	now := d.c.now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	a := d.assign(m, now)
+	m.LastFetch, m.Version, m.Tasks = now, a.Version, len(a.Tasks)
+	d.members[key] = &m
+	return a, nil
+}
+
+// Preview 返回节点当前会获得的任务，不记录节点；key 为节点标识，也可以是获取过任务的节点名称
+func (d *Dispatcher) Preview(key string) Assignment {
+	m := TaskMember{Key: key}
+	if node, ok := d.c.Node(key); ok {
+		m.Name, m.Labels = node.Name, node.Labels
+	}
+
+	now := d.c.now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if known := d.member(key); known != nil {
+		m = *known
+	}
+	return d.assign(m, now)
+}
+
+// member 按标识或名称查找获取过任务的节点，调用时持有 d.mu
+func (d *Dispatcher) member(key string) *TaskMember {
+	if m, ok := d.members[key]; ok {
+		return m
+	}
+	for _, m := range d.members {
+		if m.Name == key {
+			return m
+		}
+	}
+	return nil
+}
+
+// assign 合并节点匹配的所有任务池的任务（按 IP + URL 去重），调用时持有 d.mu
+func (d *Dispatcher) assign(m TaskMember, now time.Time) Assignment {
+	a := Assignment{Node: m.Key, Version: d.cfg.version, Pools: []string{}, Tasks: []downloader.DownloadTask{}}
+	seen := make(map[downloader.DownloadTask]bool)
+	var online map[string]bool // 节点在线状态的快照，第一个分片任务池使用时读取
+	for _, p := range d.cfg.Pools {
+		if !p.matches(m) {
+			continue
+		}
+		tasks := p.tasks
+		if p.Shard {
+			if online == nil {
+				online = d.c.online()
+			}
+			tasks = shard(tasks, d.poolMembers(p, m.Key, now, online), m.Key)
+		}
+		a.Pools = append(a.Pools, p.Name)
+		for _, t := range tasks {
+			if !seen[t] {
+				seen[t] = true
+				a.Tasks = append(a.Tasks, t)
+			}
+		}
+	}
+	return a
+}
+
+// poolMembers 返回参与任务池分片的节点标识（包括 self），按标识排序，调用时持有 d.mu
+// online 为节点在线状态的快照（见 Collector.online）
+func (d *Dispatcher) poolMembers(p TaskPool, self string, now time.Time, online map[string]bool) []string {
+	keys := []string{self}
+	for key, m := range d.members {
+		if key != self && d.active(m, now, online) && p.matches(*m) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// active 节点是否参与分片：TTL 内获取过任务，并且没有因停止上报而离线（从未上报的节点只看 TTL）
+func (d *Dispatcher) active(m *TaskMember, now time.Time, online map[string]bool) bool {
+	if now.Sub(m.LastFetch) > d.ttl {
+		return false
+	}
+	up, ok := online[m.Key]
+	return !ok || up
+}
+
+// shard 返回 self 在 members 之间分得的任务
+// 任务按 IP 分组后轮流分配，每个 IP 的任务均匀分布在各节点上；任务少于节点时每个节点分到一个任务
+func shard(tasks []downloader.DownloadTask, members []string, self string) []downloader.DownloadTask {
+	n := len(members)
+	i := slices.Index(members, self)
+	if n <= 1 || i < 0 {
+		return tasks
+	}
+	sorted := slices.Clone(tasks)
+	slices.SortStableFunc(sorted, func(a, b downloader.DownloadTask) int { return strings.Compare(a.IP, b.IP) })
+	if len(sorted) < n {
+		return sorted[i%len(sorted) : i%len(sorted)+1]
+	}
+	var result []downloader.DownloadTask
+	for j := i; j < len(sorted); j += n {
+		result = append(result, sorted[j])
+	}
+	return result
+}
+
+// Status 返回任务池和节点的分配状态
+func (d *Dispatcher) Status() TaskStatus {
+	now := d.c.now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	status := TaskStatus{Version: d.cfg.version, LoadedAt: d.loadedAt, Pools: []TaskPoolStatus{}, Members: []TaskMember{}}
+	online := d.c.online()
+	for _, m := range d.members {
+		member := *m
+		member.Active = d.active(m, now, online)
+		status.Members = append(status.Members, member)
+	}
+	slices.SortFunc(status.Members, func(a, b TaskMember) int { return strings.Compare(a.Key, b.Key) })
+
+	for _, p := range d.cfg.Pools {
+		ps := TaskPoolStatus{Name: p.Name, Tasks: len(p.tasks), Shard: p.Shard, Nodes: p.Nodes, Labels: p.Labels, Members: []string{}}
+		for _, m := range status.Members {
+			if m.Active && p.matches(m) {
+				ps.Members = append(ps.Members, m.Key)
+			}
+		}
+		status.Pools = append(status.Pools, ps)
+	}
+	return status
+}
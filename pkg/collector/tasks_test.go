@@ -0,0 +1,185 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/downloader"
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+// writePool 写入任务池配置并返回路径
+func writePool(t *testing.T, dir, yaml string) string {
+	t.Helper()
+	path := filepath.Join(dir, "pool.yml")
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func newTestDispatcher(t *testing.T, c *Collector, path string) *Dispatcher {
+	t.Helper()
+	d, err := NewDispatcher(c, path, time.Minute, nil)
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+	return d
+}
+
+// ips 返回任务的 IP 列表
+func ips(tasks []downloader.DownloadTask) string {
+	var result []string
+	for _, task := range tasks {
+		result = append(result, task.IP)
+	}
+	return strings.Join(result, " ")
+}
+
+func TestLoadTaskConfig(t *testing.T) {
+	cfg, err := LoadTaskConfig("../../examples/collector-tasks/pool.yml")
+	if err != nil {
+		t.Fatalf("LoadTaskConfig(example) error = %v", err)
+	}
+	if len(cfg.Pools) != 3 || len(cfg.Pools[0].tasks) != 6 || len(cfg.Pools[1].tasks) != 2 || len(cfg.Version()) != 12 {
+		t.Errorf("LoadTaskConfig(example) = %+v", cfg)
+	}
+
+	tests := []struct {
+		name, yaml, want string
+	}{
+		{"no pools", "pools: []", "pools"},
+		{"unknown field", "pools: [{name: a, tasks: ['1.1.1.1,http://a/x'], foo: 1}]", "foo"},
+		{"missing name", "pools: [{tasks: ['1.1.1.1,http://a/x']}]", "name"},
+		{"duplicate", "pools: [{name: a, tasks: ['1.1.1.1,http://a/x']}, {name: a, tasks: ['1.1.1.1,http://a/x']}]", "重复"},
+		{"empty", "pools: [{name: a, tasks: ['no comma']}]", "没有任务"},
+		{"missing file", "pools: [{name: a, files: [missing.txt]}]", "missing.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadTaskConfig(writePool(t, t.TempDir(), tt.yaml))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadTaskConfig() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestShard(t *testing.T) {
+	var tasks []downloader.DownloadTask
+	for _, ip := range []string{"b", "a", "c", "a", "b", "c"} {
+		tasks = append(tasks, downloader.DownloadTask{IP: ip, URL: "http://x/" + ip})
+	}
+	tests := []struct {
+		members []string
+		self    string
+		want    string
+	}{
+		{[]string{"n1"}, "n1", "b a c a b c"},
+		{[]string{"n1", "n2"}, "n1", "a b c"},
+		{[]string{"n1", "n2"}, "n2", "a b c"},
+		{[]string{"n1", "n2", "n3"}, "n2", "a c"},
+		{[]string{"n1", "n2", "n3", "n4", "n5", "n6", "n7"}, "n7", "a"}, // 任务少于节点时每个节点分到一个
+	}
+	for _, tt := range tests {
+		if got := ips(shard(tasks, tt.members, tt.self)); got != tt.want {
+			t.Errorf("shard(%v, %s) = %q, want %q", tt.members, tt.self, got, tt.want)
+		}
+	}
+}
+
+func TestDispatcher_AssignAndShard(t *testing.T) {
+	c := newTestCollector(t, t.TempDir())
+	path := writePool(t, t.TempDir(), `
+pools:
+  - name: shared
+    shard: true
+    tasks: ["1.1.1.1,http://a/1", "1.1.1.1,http://a/2", "2.2.2.2,http://b/1", "2.2.2.2,http://b/2"]
+  - name: eu
+    labels: {region: eu}
+    tasks: ["3.3.3.3,http://c/1", "1.1.1.1,http://a/1"]
+  - name: canary
+    nodes: [edge-2]
+    tasks: ["4.4.4.4,http://d/1"]
+`)
+	d := newTestDispatcher(t, c, path)
+
+	if _, err := d.Assign(stats.Identity{}); err == nil {
+		t.Error("Assign() without identity: expected error, got nil")
+	}
+
+	// 第一个节点获得全部共享任务，加上按标签匹配的任务（与共享任务重复的被去掉）
+	a, _ := d.Assign(stats.Identity{NodeID: "n1", Name: "edge-1", Labels: map[string]string{"region": "eu"}})
+	if ips(a.Tasks) != "1.1.1.1 1.1.1.1 2.2.2.2 2.2.2.2 3.3.3.3" || strings.Join(a.Pools, ",") != "shared,eu" || a.Version != d.Version() {
+		t.Errorf("Assign(n1) = %+v", a)
+	}
+
+	// 第二个节点加入后两个节点各分到每个 IP 的一半
+	b, _ := d.Assign(stats.Identity{NodeID: "n2", Name: "edge-2"})
+	if ips(b.Tasks) != "1.1.1.1 2.2.2.2 4.4.4.4" || b.Tasks[0].URL != "http://a/2" {
+		t.Errorf("Assign(n2) = %+v", b)
+	}
+	a, _ = d.Assign(stats.Identity{NodeID: "n1", Name: "edge-1", Labels: map[string]string{"region": "eu"}})
+	if ips(a.Tasks) != "1.1.1.1 2.2.2.2 3.3.3.3" || a.Tasks[0].URL != "http://a/1" {
+		t.Errorf("Assign(n1) after n2 joined = %+v", a)
+	}
+	for _, key := range []string{"n2", "edge-2"} {
+		if p := d.Preview(key); p.ETag() != b.ETag() || p.Node != "n2" {
+			t.Errorf("Preview(%s) = %+v, want the same tasks as the last assignment", key, p)
+		}
+	}
+
+	// n2 超过 TTL 未获取任务后不再参与分片
+	c.now = func() time.Time { return testNow.Add(2 * time.Minute) }
+	if a, _ = d.Assign(stats.Identity{NodeID: "n1"}); len(a.Tasks) != 4 {
+		t.Errorf("Assign(n1) after n2 expired = %+v, want all shared tasks", a)
+	}
+	status := d.Status()
+	if len(status.Members) != 2 || !status.Members[0].Active || status.Members[1].Active || strings.Join(status.Pools[0].Members, ",") != "n1" {
+		t.Errorf("Status() = %+v", status)
+	}
+
+	// 已上报过的节点离线后不等 TTL 到期就不再参与分片
+	d.ttl = time.Hour
+	d.Assign(stats.Identity{NodeID: "n2"})
+	c.Ingest([]stats.StatsData{{NodeID: "n2", RunID: "r", Seq: 1, Timestamp: testNow}})
+	c.now = func() time.Time { return testNow.Add(2*time.Minute + DefaultOfflineAfter + time.Second) }
+	if a, _ = d.Assign(stats.Identity{NodeID: "n1"}); len(a.Tasks) != 4 {
+		t.Errorf("Assign(n1) after n2 went offline = %+v, want all shared tasks", a)
+	}
+}
+
+func TestDispatcher_Reload(t *testing.T) {
+	c := newTestCollector(t, t.TempDir())
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "list.txt"), []byte("1.1.1.1,http://a/1\n"), 0o644)
+	path := writePool(t, dir, "pools: [{name: a, files: [list.txt]}]")
+	d := newTestDispatcher(t, c, path)
+	version := d.Version()
+
+	if changed, err := d.Reload(); changed || err != nil {
+		t.Errorf("Reload() without changes = %v, %v", changed, err)
+	}
+	// 任务文件变化时版本改变
+	os.WriteFile(filepath.Join(dir, "list.txt"), []byte("1.1.1.1,http://a/1\n2.2.2.2,http://b/1\n"), 0o644)
+	if changed, err := d.Reload(); !changed || err != nil || d.Version() == version {
+		t.Errorf("Reload() after file change = %v, %v, version %s", changed, err, d.Version())
+	}
+	a, _ := d.Assign(stats.Identity{Name: "edge"})
+	if len(a.Tasks) != 2 {
+		t.Errorf("Assign() after reload = %+v, want 2 tasks", a)
+	}
+
+	// 配置错误时保留原配置
+	version = d.Version()
+	os.WriteFile(path, []byte("pools: ["), 0o644)
+	if changed, err := d.Reload(); changed || err == nil || d.Version() != version {
+		t.Errorf("Reload() with invalid config = %v, %v", changed, err)
+	}
+	if d.Status().Members[0].Version != version {
+		t.Errorf("Status() = %+v, want member at version %s", d.Status(), version)
+	}
+}
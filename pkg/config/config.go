@@ -2,7 +2,9 @@ package config
 
 import (
 	"os"
+	"time"
 
+	"github.com/dora-exku/netflood/pkg/stats"
 	"gopkg.in/yaml.v3"
 )
 
@@ -12,6 +14,22 @@ type Config struct {
 	API string `yaml:"api"`
 	// 同时下载的协程数量
 	Goroutines int `yaml:"goroutines"`
+	// 直接列出的下载任务，格式为 "IP,URL"
+	Tasks []string `yaml:"tasks"`
+	// 任务来源：文件、目录、HTTP 接口，或 "-" 表示标准输入
+	TaskSources []string `yaml:"task_sources"`
+	// 任务列表刷新间隔（例如 5m），不设置则不刷新
+	TaskRefresh time.Duration `yaml:"task_refresh"`
+	// 请求 HTTP 任务来源时附加节点身份请求头（用于 netflood-collector 的任务分发）
+	TaskIdentity bool `yaml:"task_identity"`
+	// 统计输出目标，可同时配置多个，每个目标独立运行
+	Stats []stats.SinkConfig `yaml:"stats"`
+	// 统计上报中的节点名称，默认为主机名
+	NodeName string `yaml:"node_name"`
+	// 节点 ID 文件，默认为用户配置目录下的 netflood/node-id
+	NodeIDFile string `yaml:"node_id_file"`
+	// 统计上报中的节点标签，例如 region、isp、rack
+	Labels map[string]string `yaml:"labels"`
 }
 
 // Load 从指定路径加载配置文件
@@ -0,0 +1,247 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dora-exku/netflood/pkg/ratelimit"
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+// StopRemote 收到收集器的 shutdown 指令
+const StopRemote = "remote"
+
+// ErrPaused 传输因暂停被中断
+var ErrPaused = errors.New("下载已暂停")
+
+// limiterBox 包装 Limiter，运行中可以原子地替换
+type limiterBox struct {
+	Limiter
+}
+
+// rateSetter 运行中可调整速率的限速器（如 ratelimit.Limiter）
+type rateSetter interface {
+	SetRate(bytesPerSec int64)
+	Rate() int64
+}
+
+// workerPool 当前下载会话的工作协程，字段由 d.mu 保护
+type workerPool struct {
+	ctx     context.Context
+	tasks   <-chan DownloadTask
+	quits   []chan struct{} // 每个工作协程的退出信号，关闭后该协程完成当前任务后退出
+	running int             // 仍在运行的工作协程数（包括已收到退出信号、正在完成当前任务的）
+	done    chan struct{}   // 所有工作协程退出后关闭
+	closed  bool            // done 已关闭
+}
+
+// currentLimiter 返回当前的限速器，未设置时返回 nil
+func (d *Downloader) currentLimiter() Limiter {
+	if box := d.limiter.Load(); box != nil {
+		return box.Limiter
+	}
+	return nil
+}
+
+// MaxRate 返回当前生效的总下载速度上限（字节/秒），未限速或限速器不支持查询时返回 0
+func (d *Downloader) MaxRate() int64 {
+	if s, ok := d.currentLimiter().(rateSetter); ok {
+		return s.Rate()
+	}
+	return 0
+}
+
+// LocalMaxRate 返回本地配置的总下载速度上限（字节/秒），0 表示不限速
+func (d *Downloader) LocalMaxRate() int64 {
+	d.rateMu.Lock()
+	defer d.rateMu.Unlock()
+	return d.localRate
+}
+
+// SetMaxRate 运行中调整本地配置的总下载速度上限（字节/秒），0 表示不限速，正在进行的传输立即生效；
+// 收集器设置了速度上限时取两者中较小的一个。
+// 未设置限速器时创建 ratelimit.Limiter；自定义限速器不支持调整速率时返回错误
+func (d *Downloader) SetMaxRate(bytesPerSec int64) error {
+	d.rateMu.Lock()
+	defer d.rateMu.Unlock()
+	if err := d.applyRate(max(bytesPerSec, 0), d.remoteRate); err != nil {
+		return err
+	}
+	d.localRate = max(bytesPerSec, 0)
+	return nil
+}
+
+// SetRemoteMaxRate 设置收集器指令下发的速度上限（字节/秒），与本地配置的上限取较小者；
+// 0 表示解除，恢复本地配置的上限
+func (d *Downloader) SetRemoteMaxRate(bytesPerSec int64) error {
+	d.rateMu.Lock()
+	defer d.rateMu.Unlock()
+	if err := d.applyRate(d.localRate, max(bytesPerSec, 0)); err != nil {
+		return err
+	}
+	d.remoteRate = max(bytesPerSec, 0)
+	return nil
+}
+
+// applyRate 把限速器调整为本地和远程上限中较小的一个（0 表示不限），调用时需持有 d.rateMu
+func (d *Downloader) applyRate(local, remote int64) error {
+	rate := max(local, remote)
+	if local > 0 && remote > 0 {
+		rate = min(local, remote)
+	}
+	limiter := d.currentLimiter()
+	if limiter == nil {
+		if rate > 0 {
+			d.limiter.Store(&limiterBox{ratelimit.New(rate)})
+		}
+		return nil
+	}
+	s, ok := limiter.(rateSetter)
+	if !ok {
+		return fmt.Errorf("限速器 %T 不支持调整速率", limiter)
+	}
+	s.SetRate(rate)
+	return nil
+}
+
+// Workers 返回工作协程数
+func (d *Downloader) Workers() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.goroutines
+}
+
+// SetWorkers 运行中调整工作协程数，立即作用于当前下载会话
+// 减少时多出的工作协程完成当前任务后退出
+func (d *Downloader) SetWorkers(n int) error {
+	if n < 1 {
+		return fmt.Errorf("工作协程数必须大于 0: %d", n)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.goroutines = n
+	if d.pool != nil {
+		d.resize(d.pool, n)
+	}
+	return nil
+}
+
+// resize 把工作协程调整为 n 个，调用时需持有 d.mu；所有协程已退出的会话不再调整
+func (d *Downloader) resize(p *workerPool, n int) {
+	if p.closed {
+		return
+	}
+	for len(p.quits) > n {
+		last := len(p.quits) - 1
+		close(p.quits[last])
+		p.quits = p.quits[:last]
+	}
+	for len(p.quits) < n {
+		quit := make(chan struct{})
+		workerID := len(p.quits)
+		p.quits = append(p.quits, quit)
+		p.running++
+		go func() {
+			d.worker(p.ctx, workerID, p.tasks, quit)
+
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			if p.running--; p.running == 0 {
+				p.close()
+			}
+		}()
+	}
+}
+
+// close 标记所有工作协程已退出，调用时需持有 d.mu
+func (p *workerPool) close() {
+	p.closed = true
+	close(p.done)
+}
+
+// Paused 返回是否处于暂停状态
+func (d *Downloader) Paused() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.resumed != nil
+}
+
+// Pause 暂停下载：中断正在进行的传输（不计为失败），工作协程等待 Resume 后再开始新任务
+// 已暂停时不做任何操作
+func (d *Downloader) Pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.resumed != nil {
+		return
+	}
+	d.resumed = make(chan struct{})
+	d.interrupt(ErrPaused)
+	d.logger.Info("⏸️ 下载已暂停")
+}
+
+// Resume 恢复下载，未暂停时不做任何操作
+func (d *Downloader) Resume() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.resumed == nil {
+		return
+	}
+	close(d.resumed)
+	d.resumed = nil
+	d.pauseCtx, d.interrupt = context.WithCancelCause(context.Background())
+	d.logger.Info("▶️ 下载已恢复")
+}
+
+// waitResume 暂停期间阻塞，恢复后返回 true；ctx 结束时返回 false
+func (d *Downloader) waitResume(ctx context.Context) bool {
+	d.mu.Lock()
+	resumed := d.resumed
+	d.mu.Unlock()
+
+	if resumed == nil {
+		return true
+	}
+	select {
+	case <-resumed:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// pauseContext 返回暂停时取消的上下文，传输开始时获取
+func (d *Downloader) pauseContext() context.Context {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.pauseCtx
+}
+
+// applyDirective 执行收集器下发的指令（stats.DirectiveHandler）
+func (d *Downloader) applyDirective(ctx context.Context, dir stats.Directive) error {
+	switch dir.Action {
+	case stats.ActionPause:
+		d.Pause()
+	case stats.ActionResume:
+		d.Resume()
+	case stats.ActionSetRate:
+		return d.SetRemoteMaxRate(dir.Value)
+	case stats.ActionSetWorkers:
+		return d.SetWorkers(int(dir.Value))
+	case stats.ActionReloadTasks:
+		before := len(d.GetTasks())
+		if err := d.LoadTasks(ctx); err != nil {
+			return err
+		}
+		d.logger.Info("任务列表已重新加载", "before", before, "after", len(d.GetTasks()))
+	case stats.ActionShutdown:
+		// 只结束主循环，不能在上报协程中等待运行结束（结束时要等待最终上报）
+		d.finish(StopRemote, false)
+	default:
+		return fmt.Errorf("未知的指令动作: %q", dir.Action)
+	}
+	return nil
+}
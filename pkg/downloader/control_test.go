@@ -0,0 +1,189 @@
+package downloader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/ratelimit"
+	"github.com/dora-exku/netflood/pkg/server"
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+// hangingPath 发送 64KB 突发量后以 1 B/s 传输的对象，测试期间不会结束
+const hangingPath = "/bytes/1GB?rate=1"
+
+// newHangingServer 返回一直不结束响应体的地址，服务器统计请求次数
+func newHangingServer(t *testing.T) (string, *server.Server) {
+	t.Helper()
+	base, s := newSyntheticServer(t, server.Options{})
+	return base + hangingPath, s
+}
+
+// startBackground 在后台运行下载器，等待下载会话开始，返回结束运行并等待返回的函数
+func startBackground(t *testing.T, d *Downloader) (stop func()) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- d.Start(ctx) }()
+	waitFor(t, "session start", func() bool { return len(d.Stats().Sessions) > 0 })
+	return func() {
+		d.finish(StopSignal, true)
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Start() error = %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Start() did not return after stop")
+		}
+	}
+}
+
+// waitFor 等待条件成立，超时后测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	url, srv := newHangingServer(t)
+	d := New(WithWorkers(1), WithTasks(DownloadTask{IP: "127.0.0.1", URL: url}))
+	stop := startBackground(t, d)
+	defer stop()
+
+	waitFor(t, "first transfer", func() bool { return d.Stats().ActiveWorkers == 1 })
+
+	// 暂停中断正在进行的传输，不计为失败，暂停期间不开始新任务
+	d.Pause()
+	waitFor(t, "transfer interrupted", func() bool { return d.Stats().ActiveWorkers == 0 })
+	time.Sleep(50 * time.Millisecond)
+	if !d.Paused() || !d.Stats().Paused || d.Failed() != 0 || srv.Stats().Requests != 1 {
+		t.Errorf("after Pause(): paused=%v failed=%d requests=%d, want paused, 0 failures, 1 request", d.Paused(), d.Failed(), srv.Stats().Requests)
+	}
+
+	d.Resume()
+	waitFor(t, "transfer after resume", func() bool { return srv.Stats().Requests == 2 })
+	if d.Paused() {
+		t.Error("Paused() = true after Resume()")
+	}
+}
+
+func TestSetWorkers(t *testing.T) {
+	url, _ := newHangingServer(t)
+	d := New(WithWorkers(1), WithTasks(DownloadTask{IP: "127.0.0.1", URL: url}))
+	if err := d.SetWorkers(0); err == nil {
+		t.Error("SetWorkers(0): expected error, got nil")
+	}
+	stop := startBackground(t, d)
+	defer stop()
+
+	waitFor(t, "first worker", func() bool { return d.Stats().ActiveWorkers == 1 })
+	if err := d.SetWorkers(3); err != nil {
+		t.Fatalf("SetWorkers(3) error = %v", err)
+	}
+	waitFor(t, "three workers", func() bool { return d.Stats().ActiveWorkers == 3 })
+
+	// 减少后多出的工作协程完成当前任务后退出
+	d.SetWorkers(2)
+	if got := d.statsData().Workers; got != 2 {
+		t.Errorf("statsData().Workers = %d, want 2", got)
+	}
+	d.Pause()
+	d.Resume()
+	waitFor(t, "two workers", func() bool { return d.Stats().ActiveWorkers == 2 })
+	time.Sleep(50 * time.Millisecond)
+	if got := d.Stats().ActiveWorkers; got != 2 {
+		t.Errorf("ActiveWorkers = %d after shrinking, want 2", got)
+	}
+}
+
+// fixedLimiter 不支持调整速率的限速器
+type fixedLimiter struct{}
+
+func (fixedLimiter) WaitN(context.Context, int) error { return nil }
+
+func TestSetMaxRate(t *testing.T) {
+	d := New()
+	if err := d.SetMaxRate(0); err != nil || d.MaxRate() != 0 {
+		t.Errorf("SetMaxRate(0) without limiter = %v, MaxRate() = %d", err, d.MaxRate())
+	}
+	if err := d.SetMaxRate(1 << 20); err != nil || d.MaxRate() != 1<<20 {
+		t.Errorf("SetMaxRate(1MB) = %v, MaxRate() = %d", err, d.MaxRate())
+	}
+	if err := d.SetMaxRate(0); err != nil || d.MaxRate() != 0 || d.statsData().MaxRate != 0 {
+		t.Errorf("SetMaxRate(0) = %v, MaxRate() = %d", err, d.MaxRate())
+	}
+
+	if err := New(WithLimiter(fixedLimiter{})).SetMaxRate(1); err == nil {
+		t.Error("SetMaxRate() with custom limiter: expected error, got nil")
+	}
+}
+
+func TestSetRemoteMaxRate_KeepsLocalCap(t *testing.T) {
+	d := New(WithLimiter(ratelimit.New(5 << 20)))
+	steps := []struct {
+		remote, want int64
+	}{
+		{10 << 20, 5 << 20}, // 收集器的上限高于本地上限时不生效
+		{2 << 20, 2 << 20},
+		{0, 5 << 20}, // 解除后恢复本地上限
+	}
+	for _, s := range steps {
+		if err := d.applyDirective(context.Background(), stats.Directive{Action: stats.ActionSetRate, Value: s.remote}); err != nil {
+			t.Fatalf("set_rate %d error = %v", s.remote, err)
+		}
+		if d.MaxRate() != s.want || d.LocalMaxRate() != 5<<20 {
+			t.Errorf("after set_rate %d: MaxRate() = %d, LocalMaxRate() = %d, want %d and %d", s.remote, d.MaxRate(), d.LocalMaxRate(), s.want, 5<<20)
+		}
+	}
+
+	// 本地上限调整后仍取两者中较小的一个
+	d.SetRemoteMaxRate(3 << 20)
+	d.SetMaxRate(0)
+	if data := d.statsData(); data.MaxRate != 3<<20 || data.LocalMaxRate != 0 {
+		t.Errorf("statsData() = max_rate %d local_max_rate %d", data.MaxRate, data.LocalMaxRate)
+	}
+	d.SetRemoteMaxRate(0)
+	if d.MaxRate() != 0 {
+		t.Errorf("MaxRate() = %d after releasing both caps, want 0", d.MaxRate())
+	}
+}
+
+func TestApplyDirective(t *testing.T) {
+	url := newPayloadServer(t, 1024)
+	d := New(WithWorkers(1), WithTasks(DownloadTask{IP: "127.0.0.1", URL: url}))
+	stop := startBackground(t, d)
+	defer stop()
+
+	tests := []struct {
+		dir     stats.Directive
+		wantErr bool
+	}{
+		{stats.Directive{Action: stats.ActionSetRate, Value: 2 << 20}, false},
+		{stats.Directive{Action: stats.ActionSetWorkers, Value: 2}, false},
+		{stats.Directive{Action: stats.ActionPause}, false},
+		{stats.Directive{Action: stats.ActionReloadTasks}, true}, // 没有任务来源
+		{stats.Directive{Action: "reboot"}, true},
+	}
+	for _, tt := range tests {
+		if err := d.applyDirective(context.Background(), tt.dir); (err != nil) != tt.wantErr {
+			t.Errorf("applyDirective(%s) error = %v, wantErr %v", tt.dir.Action, err, tt.wantErr)
+		}
+	}
+	if data := d.statsData(); data.MaxRate != 2<<20 || data.Workers != 2 || !data.Paused {
+		t.Errorf("statsData() = max_rate %d workers %d paused %v", data.MaxRate, data.Workers, data.Paused)
+	}
+
+	// shutdown 与退出信号相同：结束主循环
+	d.applyDirective(context.Background(), stats.Directive{Action: stats.ActionShutdown})
+	waitFor(t, "run to end", func() bool { return !d.Stats().Time.IsZero() && d.StopReason() == StopRemote })
+}
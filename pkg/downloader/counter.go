@@ -0,0 +1,106 @@
+package downloader
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBufferSize 默认读缓冲区大小
+const DefaultBufferSize = 64 * 1024
+
+// counterShard 计数器分片
+// 填充到 64 字节，保证每个分片独占一个缓存行，避免伪共享
+type counterShard struct {
+	n atomic.Int64
+	_ [56]byte
+}
+
+// shardedCounter 分片计数器
+// 每个工作协程只写自己的分片，读取时再汇总，热路径上没有跨协程争用
+type shardedCounter struct {
+	mu     sync.Mutex
+	shards []*counterShard
+}
+
+// shard 获取指定工作协程的分片（不存在时自动扩容）
+func (c *shardedCounter) shard(i int) *counterShard {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.shards) <= i {
+		c.shards = append(c.shards, &counterShard{})
+	}
+	return c.shards[i]
+}
+
+// Load 汇总所有分片的计数
+func (c *shardedCounter) Load() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var total int64
+	for _, s := range c.shards {
+		total += s.n.Load()
+	}
+	return total
+}
+
+// countingWriter 计数写入器：只统计字节数，丢弃数据
+// 故意不实现 io.ReaderFrom，让 io.CopyBuffer 使用我们提供的池化缓冲区
+type countingWriter struct {
+	shard    *counterShard
+	transfer *atomic.Int64     // 可选，本次传输的字节数（供停滞检测读取）
+	onWrite  func(n int) error // 可选，计数后调用（通知观察者、限速），返回错误时中止传输
+}
+
+// Write 实现 io.Writer
+func (w countingWriter) Write(p []byte) (int, error) {
+	n := int64(len(p))
+	w.shard.n.Add(n)
+	if w.transfer != nil {
+		w.transfer.Add(n)
+	}
+	if w.onWrite != nil {
+		if err := w.onWrite(len(p)); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// bufferPool 读缓冲区池
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// newBufferPool 创建指定大小的缓冲区池
+func newBufferPool(size int) *bufferPool {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	p := &bufferPool{size: size}
+	p.pool.New = func() any {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return p
+}
+
+// Get 从池中取出缓冲区
+func (p *bufferPool) Get() *[]byte {
+	return p.pool.Get().(*[]byte)
+}
+
+// Put 归还缓冲区
+func (p *bufferPool) Put(buf *[]byte) {
+	p.pool.Put(buf)
+}
+
+// drain 使用池化缓冲区把 src 读完并写入 dst
+func (p *bufferPool) drain(dst io.Writer, src io.Reader) (int64, error) {
+	buf := p.Get()
+	defer p.Put(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
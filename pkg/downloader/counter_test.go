@@ -0,0 +1,120 @@
+package downloader
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dora-exku/netflood/pkg/server"
+)
+
+// benchBodySize 基准测试中每个响应体的大小
+const benchBodySize = 32 << 20
+
+// newSyntheticServer 启动合成数据测试服务器（netflood serve 使用的处理器），返回根地址
+func newSyntheticServer(tb testing.TB, opts server.Options) (string, *server.Server) {
+	tb.Helper()
+	s := server.New(opts)
+	ts := httptest.NewUnstartedServer(s)
+	ts.Config = s.HTTPServer("")
+	ts.Start()
+	tb.Cleanup(ts.Close)
+	return ts.URL, s
+}
+
+// newPayloadServer 创建测试服务器，返回 size 字节响应体的地址
+func newPayloadServer(tb testing.TB, size int) string {
+	tb.Helper()
+	base, _ := newSyntheticServer(tb, server.Options{})
+	return base + "/bytes/" + strconv.Itoa(size)
+}
+
+func TestShardedCounter(t *testing.T) {
+	var c shardedCounter
+	c.shard(0).n.Add(10)
+	c.shard(3).n.Add(5)
+	c.shard(0).n.Add(1)
+
+	if got := c.Load(); got != 16 {
+		t.Errorf("Load() = %d, want 16", got)
+	}
+	if len(c.shards) != 4 {
+		t.Errorf("len(shards) = %d, want 4", len(c.shards))
+	}
+}
+
+// legacyDrain 旧实现：每次分配 64KB 缓冲区，所有协程共享一个原子计数器
+func legacyDrain(counter *atomic.Int64, src io.Reader) error {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			counter.Add(int64(n))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// reportPerGB 输出每 GB 耗费的时间，便于对比不同实现
+func reportPerGB(b *testing.B, total int64) {
+	if total == 0 {
+		return
+	}
+	gb := float64(total) / (1 << 30)
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/gb, "ns/GB")
+}
+
+func BenchmarkDrain_Legacy(b *testing.B) {
+	url := newPayloadServer(b, benchBodySize)
+	var counter atomic.Int64
+
+	b.SetBytes(benchBodySize)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			resp, err := http.Get(url)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			if err := legacyDrain(&counter, resp.Body); err != nil {
+				b.Error(err)
+			}
+			resp.Body.Close()
+		}
+	})
+	reportPerGB(b, counter.Load())
+}
+
+func BenchmarkDrain_Pooled(b *testing.B) {
+	url := newPayloadServer(b, benchBodySize)
+	var counter shardedCounter
+	pool := newBufferPool(DefaultBufferSize)
+	var nextShard atomic.Int32
+
+	b.SetBytes(benchBodySize)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		w := countingWriter{shard: counter.shard(int(nextShard.Add(1)))}
+		for pb.Next() {
+			resp, err := http.Get(url)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			if _, err := pool.drain(w, resp.Body); err != nil {
+				b.Error(err)
+			}
+			resp.Body.Close()
+		}
+	})
+	reportPerGB(b, counter.Load())
+}
@@ -1,19 +1,21 @@
 package downloader
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
-	"io"
+	"log/slog"
+	"math"
 	"net"
 	"net/http"
-	"net/url"
-	"os"
+	"net/http/httptrace"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/dora-exku/netflood/pkg/history"
+	"github.com/dora-exku/netflood/pkg/logging"
 	"github.com/dora-exku/netflood/pkg/stats"
 	"github.com/dora-exku/netflood/pkg/timerange"
 )
@@ -26,23 +28,106 @@ type DownloadTask struct {
 
 // Downloader 下载器
 type Downloader struct {
-	client           *http.Client
 	tasks            []DownloadTask
 	goroutines       int
-	bytesDownloaded  atomic.Int64 // 已下载的字节数
-	speedFile        *os.File     // 速度文件
+	bytesDownloaded  shardedCounter  // 已下载的字节数（按工作协程分片）
+	buffers          *bufferPool     // 读缓冲区池
+	speedPath        string          // 最新速度文件路径，为空时不写入
+	history          *history.Writer // 速度历史记录（可选）
+	lastSpeedLine    string          // 最近一次写入最新速度文件的内容
 	mu               sync.Mutex
+	logger           *slog.Logger            // 日志记录器
+	clients          map[string]*http.Client // 按 IP 缓存的 HTTP 客户端（复用连接）
+	clientsMu        sync.Mutex
+	requestTimeout   time.Duration         // 建连、TLS 握手和等待响应头的超时
+	timing           stats.TimingRecorder  // 总体请求耗时
+	taskStats        map[string]*taskStats // 按任务统计
+	ipStats          map[string]*taskStats // 按 IP 统计
+	statsMu          sync.Mutex
+	stall            StallPolicy        // 停滞检测配置
+	stalls           atomic.Int64       // 停滞中止总次数
+	completed        atomic.Int64       // 成功完成的下载次数
+	failed           atomic.Int64       // 失败的下载次数
+	active           atomic.Int64       // 正在下载的工作协程数
+	limits           RunLimits          // 运行边界
+	passes           int                // 已完成分发的轮数
+	cursor           int                // 当前轮次中下一个要分发的任务
+	stopReason       string             // 运行结束原因
+	sessions         []sessionRecord    // 下载会话记录
+	currentSpeed     float64            // 最近一秒的速度（MB/s）
+	peakSpeed        float64            // 速度峰值（MB/s）
+	cancelRun        context.CancelFunc // 结束主循环
+	abortCtx         context.Context    // 运行边界触发时取消，用于中断正在进行的传输
+	abortTransfers   context.CancelFunc
+	pauseCtx         context.Context             // 暂停时以 ErrPaused 取消，用于中断正在进行的传输
+	interrupt        context.CancelCauseFunc     // 取消 pauseCtx
+	resumed          chan struct{}               // 暂停期间不为 nil，恢复时关闭
+	pool             *workerPool                 // 当前下载会话的工作协程，会话之外为 nil
 	timeRangeManager *timerange.TimeRangeManager // 时间段管理器
 	statsReporter    *stats.Reporter             // 统计上报器
+	identity         stats.Identity              // 统计上报中的节点身份
+	statsFlush       time.Duration               // 结束时最终上报的超时，0 使用默认值，小于 0 不做最终上报
+	limiter          atomic.Pointer[limiterBox]  // 下载限速器（可选，运行中可替换）
+	rateMu           sync.Mutex                  // 保护 localRate、remoteRate 和限速器速率的调整
+	localRate        int64                       // 本地配置的速度上限（WithLimiter、SetMaxRate），0 表示不限速
+	remoteRate       int64                       // 收集器指令设置的速度上限，0 表示未设置
+	transport        http.RoundTripper           // 自定义 Transport（可选，设置后不按 IP 建连）
+	clock            Clock                       // 时间来源
+	observers        []Observer                  // 观察者
+	source           TaskSource                  // 任务来源（可选，用于刷新任务列表）
+	refresh          time.Duration               // 任务列表刷新间隔，0 表示不刷新
+	taskIdentity     bool                        // 请求 HTTP 任务来源时是否附加节点身份请求头
 	startTime        time.Time                   // 开始时间
+	endTime          time.Time                   // 结束时间（Start 返回时记录）
+	optErr           error                       // 选项中发生的错误，Start 时返回
 }
 
 // New 创建新的下载器
-func New(goroutines int) *Downloader {
-	return &Downloader{
-		client:     &http.Client{Timeout: 30 * time.Second},
-		goroutines: goroutines,
+// 默认使用 DefaultWorkers 个工作协程，不输出日志，不写入速度文件
+func New(opts ...Option) *Downloader {
+	d := &Downloader{
+		goroutines:     DefaultWorkers,
+		buffers:        newBufferPool(DefaultBufferSize),
+		logger:         slog.New(slog.DiscardHandler),
+		clock:          systemClock{},
+		requestTimeout: defaultRequestTimeout,
 	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.abortCtx, d.abortTransfers = context.WithCancel(context.Background())
+	d.pauseCtx, d.interrupt = context.WithCancelCause(context.Background())
+	return d
+}
+
+// DefaultSpeedPath 命令行默认的最新速度文件路径
+const DefaultSpeedPath = "./speed"
+
+// summaryTicks 每隔多少个速度统计周期（秒）输出一次周期汇总
+const summaryTicks = 10
+
+// defaultRequestTimeout 建连、TLS 握手和等待响应头各自的超时
+// 读取响应体不限总时长（限速时正常的长传输会超过任何固定值），响应体的进度由停滞检测负责
+const defaultRequestTimeout = 30 * time.Second
+
+// SetLogger 设置日志记录器
+func (d *Downloader) SetLogger(logger *slog.Logger) {
+	d.logger = logger
+}
+
+// SetSpeedFile 设置最新速度文件路径，为空时不写入
+func (d *Downloader) SetSpeedFile(path string) {
+	d.speedPath = path
+}
+
+// SetHistory 设置速度历史记录，每个统计周期追加一条记录
+func (d *Downloader) SetHistory(w *history.Writer) {
+	d.history = w
+}
+
+// SetBufferSize 设置每次读取使用的缓冲区大小（字节）
+func (d *Downloader) SetBufferSize(size int) {
+	d.buffers = newBufferPool(size)
 }
 
 // SetTimeRangeManager 设置时间段管理器
@@ -50,108 +135,150 @@ func (d *Downloader) SetTimeRangeManager(trm *timerange.TimeRangeManager) {
 	d.timeRangeManager = trm
 }
 
-// SetStatsAPI 设置统计上报API
+// SetStatsAPI 设置统计上报API（HTTP JSON 格式，每 stats.DefaultInterval 上报一次，失败的数据在内存中排队重试）
 func (d *Downloader) SetStatsAPI(apiURL string) error {
-	reporter, err := stats.NewReporter(apiURL)
-	if err != nil {
-		return fmt.Errorf("创建统计上报器失败: %w", err)
-	}
-	d.statsReporter = reporter
-	return nil
+	return d.AddStatsSink(stats.SinkHTTP, stats.NewHTTPSink(apiURL), stats.SinkOptions{})
 }
 
-// LoadTasksFromAPI 从API加载下载任务
-func (d *Downloader) LoadTasksFromAPI(apiURL string) error {
-	// 使用 http 请求API
-	resp, err := d.client.Get(apiURL)
-	if err != nil {
-		return fmt.Errorf("请求API失败: %w", err)
+// AddStatsSink 添加统计输出目标，运行期间按 opts.Interval 上报
+// 每个输出目标独立运行，一个目标失败或阻塞不影响其他目标；发送失败的数据排队，退避后按顺序重试
+func (d *Downloader) AddStatsSink(name string, sink stats.Sink, opts stats.SinkOptions) error {
+	if d.statsReporter == nil {
+		d.statsReporter = stats.NewReporter(d.statsData)
 	}
-	defer resp.Body.Close()
+	return d.statsReporter.AddSink(name, sink, opts)
+}
 
-	// 读取响应内容
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("读取响应失败: %w", err)
-	}
+// SetIdentity 设置统计上报中的节点身份（名称、节点 ID、版本和标签），未设置的名称使用主机名
+func (d *Downloader) SetIdentity(id stats.Identity) {
+	d.identity = id
+}
 
-	// 解析响应内容
-	return d.parseTasksFromContent(string(body))
+// SetStatsFlushTimeout 设置运行结束时最终统计上报的超时，0 使用 stats.DefaultFlushTimeout，小于 0 时不做最终上报
+func (d *Downloader) SetStatsFlushTimeout(timeout time.Duration) {
+	d.statsFlush = timeout
 }
 
-// LoadTasksFromFile 从文件加载下载任务
-func (d *Downloader) LoadTasksFromFile(filepath string) error {
-	// 读取文件
-	data, err := os.ReadFile(filepath)
-	if err != nil {
-		return fmt.Errorf("读取文件失败: %w", err)
+// statsData 采集一次统计上报数据（序号和区间增量由上报器按输出目标填写）
+func (d *Downloader) statsData() stats.StatsData {
+	now := d.clock.Now()
+	total := d.bytesDownloaded.Load()
+
+	data := stats.StatsData{
+		Timestamp:  now,
+		Start:      d.startTime,
+		Total:      float64(total) / 1024 / 1024,
+		TotalBytes: total,
+		Completed:  d.completed.Load(),
+		Failed:     d.failed.Load(),
+	}
+	if elapsed := now.Sub(d.startTime).Seconds(); !d.startTime.IsZero() && elapsed > 0 {
+		data.Speed = data.Total / elapsed
 	}
 
-	return d.parseTasksFromContent(string(data))
-}
-
-// parseTasksFromContent 从内容解析下载任务
-func (d *Downloader) parseTasksFromContent(content string) error {
-	scanner := bufio.NewScanner(strings.NewReader(content))
-	var tasks []DownloadTask
+	data.MaxRate = d.MaxRate()
+	data.LocalMaxRate = d.LocalMaxRate()
 
-	// 逐行解析
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
+	// 当前会话：最后一个尚未结束的会话
+	d.mu.Lock()
+	data.Workers, data.Paused = d.goroutines, d.resumed != nil
+	if n := len(d.sessions); n > 0 && d.sessions[n-1].End.IsZero() {
+		rec := d.sessions[n-1]
+		data.Session = &stats.SessionStats{Start: rec.Start, Bytes: total - rec.startBytes}
+		if elapsed := now.Sub(rec.Start).Seconds(); elapsed > 0 {
+			data.Session.Speed = float64(data.Session.Bytes) / 1024 / 1024 / elapsed
 		}
+	}
+	d.mu.Unlock()
 
-		// 按逗号分割 IP 和 URL
-		parts := strings.SplitN(line, ",", 2)
-		if len(parts) != 2 {
-			continue
+	if d.timeRangeManager != nil {
+		if r, ok := d.timeRangeManager.CurrentRange(); ok {
+			data.Window = &stats.Window{Start: r.StartBefore(now), End: r.EndAfter(now)}
 		}
-
-		tasks = append(tasks, DownloadTask{
-			IP:  strings.TrimSpace(parts[0]),
-			URL: strings.TrimSpace(parts[1]),
-		})
 	}
+	if overall := d.LatencySummary(); overall.Requests > 0 {
+		data.Latency = &overall
+		data.LatencyByIP = d.LatencyByIP()
+	}
+	data.Stalls, data.StallsByIP = d.Stalls(), d.StallsByIP()
+	return data
+}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("解析内容失败: %w", err)
+// ReportStatus 返回统计上报状态（所有输出目标汇总），未启用统计上报时 ok 为 false
+func (d *Downloader) ReportStatus() (status stats.ReportStatus, ok bool) {
+	if d.statsReporter == nil {
+		return stats.ReportStatus{}, false
 	}
+	return d.statsReporter.Status(), true
+}
 
-	d.tasks = tasks
-	return nil
+// LoadTasksFromAPI 从API加载下载任务
+func (d *Downloader) LoadTasksFromAPI(apiURL string) error {
+	return d.loadFrom(context.Background(), HTTPSource(apiURL))
 }
 
-// Start 开始下载（循环模式，支持时间段控制）
+// LoadTasksFromFile 从文件加载下载任务
+func (d *Downloader) LoadTasksFromFile(path string) error {
+	return d.loadFrom(context.Background(), FileSource(path))
+}
+
+// Start 开始下载（循环模式，支持时间段控制和运行边界）
+// 收到退出信号或达到运行边界后返回，结束原因可通过 StopReason 获取
 func (d *Downloader) Start(ctx context.Context) error {
-	if len(d.tasks) == 0 {
+	if d.optErr != nil {
+		return d.optErr
+	}
+	if len(d.GetTasks()) == 0 {
 		return fmt.Errorf("没有下载任务")
 	}
 
 	// 记录开始时间
-	d.startTime = time.Now()
-
-	// 打开速度文件
-	var err error
-	d.speedFile, err = os.Create("./speed")
-	if err != nil {
-		return fmt.Errorf("创建速度文件失败: %w", err)
+	d.startTime = d.clock.Now()
+	d.endTime = time.Time{}
+
+	// 初始化最新速度文件（同时检查是否可写）
+	if d.speedPath != "" {
+		d.lastSpeedLine = fmt.Sprintf("%s | 开始下载\n", d.startTime.Format("2006-01-02 15:04:05"))
+		if err := history.WriteFileAtomic(d.speedPath, []byte(d.lastSpeedLine)); err != nil {
+			return fmt.Errorf("创建速度文件失败: %w", err)
+		}
+	}
+	defer d.printFinalStats()
+	defer d.markEnd()
+
+	// 运行上下文：退出信号或运行边界都会结束主循环；
+	// 传输只在运行边界触发时中断，退出信号仍等待当前任务完成
+	ctx, d.cancelRun = context.WithCancel(ctx)
+	defer d.cancelRun()
+	d.abortCtx, d.abortTransfers = context.WithCancel(context.Background())
+	defer d.abortTransfers()
+
+	// 定期刷新任务列表
+	if d.source != nil && d.refresh > 0 {
+		go d.refreshTasks(ctx)
 	}
-	defer d.speedFile.Close()
+
+	stopLimits := d.watchLimits(ctx)
+	defer stopLimits()
+	defer d.finish(StopSignal, false)
 
 	// 启动统计上报协程（如果启用）
+	// 上报使用独立的上下文，主循环返回（会话已结束）后才停止，最终上报包含完整的数据；返回前等待输出目标关闭
 	if d.statsReporter != nil {
-		go d.statsReporter.StartReporting(
-			ctx,
-			func() int64 { return d.bytesDownloaded.Load() },
-			func() time.Time { return d.startTime },
-			func() string {
-				if d.timeRangeManager != nil && d.timeRangeManager.IsEnabled() {
-					return d.timeRangeManager.String()
-				}
-				return "全天候"
-			},
-		)
+		d.statsReporter.SetLogger(d.logger)
+		d.statsReporter.SetIdentity(d.identity)
+		d.statsReporter.SetFlushTimeout(d.statsFlush)
+		d.statsReporter.SetDirectiveHandler(d.applyDirective)
+		reportCtx, stopReport := context.WithCancel(context.Background())
+		reportDone := make(chan struct{})
+		go func() {
+			defer close(reportDone)
+			d.statsReporter.Run(reportCtx)
+		}()
+		defer func() {
+			stopReport()
+			<-reportDone
+		}()
 	}
 
 	// 主循环：处理时间段控制
@@ -166,8 +293,8 @@ func (d *Downloader) Start(ctx context.Context) error {
 				nextStart := d.timeRangeManager.GetNextRangeStart()
 				waitDuration := d.timeRangeManager.WaitUntilNextRange()
 
-				fmt.Printf("\n⏰ 当前不在下载时间段内，等待到 %s (等待 %v)\n",
-					nextStart.Format("15:04:05"), waitDuration.Round(time.Second))
+				d.logger.Info("⏰ 当前不在下载时间段内，等待下一个时间段",
+					"next_start", nextStart.Format("15:04:05"), "wait", waitDuration.Round(time.Second).String())
 
 				// 使用定时器等待
 				timer := time.NewTimer(waitDuration)
@@ -176,7 +303,7 @@ func (d *Downloader) Start(ctx context.Context) error {
 					timer.Stop()
 					return nil
 				case <-timer.C:
-					fmt.Println("\n✅ 进入下载时间段，开始下载...")
+					d.logger.Info("✅ 进入下载时间段，开始下载")
 				}
 			}
 
@@ -194,11 +321,15 @@ func (d *Downloader) runDownloadSession(ctx context.Context) error {
 	sessionCtx, sessionCancel := context.WithCancel(ctx)
 	defer sessionCancel()
 
+	// 记录会话（会话结束时统计本次会话的下载量）
+	session := d.beginSession()
+	defer d.endSession(session)
+
 	// 启动速度统计协程
 	go d.reportSpeed(sessionCtx)
 
 	// 创建任务通道（带缓冲，用于循环发送任务）
-	taskChan := make(chan DownloadTask, d.goroutines*2)
+	taskChan := make(chan DownloadTask, d.Workers()*2)
 
 	// 启动任务分发协程（循环发送任务）
 	go func() {
@@ -213,35 +344,49 @@ func (d *Downloader) runDownloadSession(ctx context.Context) error {
 			case <-ticker.C:
 				// 检查是否还在时间段内
 				if d.timeRangeManager != nil && !d.timeRangeManager.IsInRange() {
-					fmt.Println("\n⏰ 已超出下载时间段，停止分发新任务，等待当前任务完成...")
+					d.logger.Info("⏰ 已超出下载时间段，停止分发新任务，等待当前任务完成")
 					return
 				}
 			default:
-				// 循环发送所有任务
-				for _, task := range d.tasks {
+				// 循环发送所有任务（从上次会话中断的位置继续）
+				for {
+					task, ok := d.nextTask()
+					if !ok {
+						break
+					}
 					select {
 					case <-sessionCtx.Done():
 						return
 					case taskChan <- task:
 						// 任务已发送，继续
+						d.advance()
 					}
 				}
+
+				// 完成指定轮数后停止分发，等待当前任务完成
+				if d.nextPass() {
+					d.finish(StopIterations, false)
+					return
+				}
 			}
 		}
 	}()
 
-	// 启动工作协程
-	var wg sync.WaitGroup
-	for i := 0; i < d.goroutines; i++ {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
-			d.worker(sessionCtx, workerID, taskChan)
-		}(i)
+	// 启动工作协程（运行中可通过 SetWorkers 调整数量）
+	pool := &workerPool{ctx: sessionCtx, tasks: taskChan, done: make(chan struct{})}
+	d.mu.Lock()
+	d.pool = pool
+	d.resize(pool, d.goroutines)
+	if pool.running == 0 {
+		pool.close()
 	}
+	d.mu.Unlock()
 
 	// 等待所有工作协程完成
-	wg.Wait()
+	<-pool.done
+	d.mu.Lock()
+	d.pool = nil
+	d.mu.Unlock()
 
 	return nil
 }
@@ -254,108 +399,280 @@ func (d *Downloader) printFinalStats() {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
+	timestamp := d.clock.Now().Format("2006-01-02 15:04:05")
 	finalLine := fmt.Sprintf("\n%s | ========== 下载结束 ==========\n", timestamp)
 	finalLine += fmt.Sprintf("%s | 总下载量: %.2f MB (%.2f GB)\n", timestamp, totalMB, totalMB/1024)
+	finalLine += fmt.Sprintf("%s | 运行时长: %v | 结束原因: %s\n", timestamp, d.clock.Now().Sub(d.startTime).Round(time.Second), d.stopReason)
+	finalLine += fmt.Sprintf("%s | 峰值速度: %.2f MB/s | 下载会话: %d 次\n", timestamp, d.peakSpeed, len(d.sessions))
+	finalLine += fmt.Sprintf("%s | 成功: %d 次 | 失败: %d 次\n", timestamp, d.Completed(), d.Failed())
+	finalLine += fmt.Sprintf("%s | 停滞中止次数: %d\n", timestamp, d.Stalls())
+	stallsByIP := d.StallsByIP()
+	for _, ip := range sortedKeys(stallsByIP) {
+		finalLine += fmt.Sprintf("%s | [IP %s] 停滞中止: %d\n", timestamp, ip, stallsByIP[ip])
+	}
+	finalLine += formatLatency(timestamp, "全部", d.LatencySummary())
+	byIP := d.LatencyByIP()
+	for _, ip := range sortedKeys(byIP) {
+		finalLine += formatLatency(timestamp, "IP "+ip, byIP[ip])
+	}
+	byTask := d.LatencyByTask()
+	for _, key := range sortedKeys(byTask) {
+		finalLine += formatLatency(timestamp, "任务 "+key, byTask[key])
+	}
+
+	d.writeSpeedFile(d.lastSpeedLine + finalLine)
+
+	// 输出到日志
+	logging.Summary(d.logger, "========== 下载结束 ==========",
+		"total_mb", round2(totalMB), "duration", d.clock.Now().Sub(d.startTime).Round(time.Second).String(),
+		"stop_reason", d.stopReason, "peak_speed_mbs", round2(d.peakSpeed), "sessions", len(d.sessions),
+		"completed", d.Completed(), "failed", d.Failed(), "stalls", d.Stalls())
+	for _, ip := range sortedKeys(stallsByIP) {
+		logging.Summary(d.logger, "停滞统计", "ip", ip, "stalls", stallsByIP[ip])
+	}
+	logLatency(d.logger, "全部", d.LatencySummary())
+	for _, ip := range sortedKeys(byIP) {
+		logLatency(d.logger, "IP "+ip, byIP[ip])
+	}
+	for _, key := range sortedKeys(byTask) {
+		logLatency(d.logger, "任务 "+key, byTask[key])
+	}
+}
 
-	fmt.Print(finalLine)
-	d.speedFile.WriteString(finalLine)
-	d.speedFile.Sync()
+// logLatency 以汇总级别输出耗时分位数（p50/p90/p99，单位毫秒）
+func logLatency(logger *slog.Logger, scope string, l stats.LatencySummary) {
+	if l.Requests == 0 {
+		return
+	}
+	p := func(v stats.Percentiles) string {
+		return fmt.Sprintf("%.1f/%.1f/%.1f", v.P50, v.P90, v.P99)
+	}
+	logging.Summary(logger, "请求耗时 p50/p90/p99 (ms)", "scope", scope,
+		"requests", l.Requests, "reused", l.Reused,
+		"connect", p(l.Connect), "tls", p(l.TLS), "ttfb", p(l.TTFB), "transfer", p(l.Transfer))
 }
 
-// worker 工作协程
-func (d *Downloader) worker(ctx context.Context, workerID int, taskChan <-chan DownloadTask) {
-	for task := range taskChan {
+// round2 保留两位小数，便于日志阅读
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// formatLatency 格式化耗时分位数（p50/p90/p99，单位毫秒）
+func formatLatency(timestamp, label string, l stats.LatencySummary) string {
+	if l.Requests == 0 {
+		return ""
+	}
+	p := func(v stats.Percentiles) string {
+		return fmt.Sprintf("%.1f/%.1f/%.1f", v.P50, v.P90, v.P99)
+	}
+	return fmt.Sprintf("%s | [%s] 请求: %d (复用 %d) | 耗时 p50/p90/p99 (ms) 建连: %s | TLS: %s | 首字节: %s | 传输: %s\n",
+		timestamp, label, l.Requests, l.Reused, p(l.Connect), p(l.TLS), p(l.TTFB), p(l.Transfer))
+}
+
+// worker 工作协程，任务通道关闭或 quit 关闭后退出
+func (d *Downloader) worker(ctx context.Context, workerID int, taskChan <-chan DownloadTask, quit <-chan struct{}) {
+	shard := d.bytesDownloaded.shard(workerID)
+	for {
+		// 已收到退出信号时不再取新任务（select 在多个通道就绪时随机选择）
+		select {
+		case <-quit:
+			return
+		default:
+		}
+		var task DownloadTask
+		select {
+		case <-quit:
+			return
+		case t, ok := <-taskChan:
+			if !ok {
+				return
+			}
+			task = t
+		}
+		// 暂停期间等待恢复；会话结束时跳过已取出的任务
+		if !d.waitResume(ctx) {
+			continue
+		}
+
 		// 不使用 ctx 来中断当前任务，让任务自然完成
-		err := d.downloadTask(task)
+		d.trackActive(task, 1)
+		for _, o := range d.observers {
+			o.OnTaskStart(workerID, task)
+		}
+		start := time.Now()
+		n, err := d.downloadTask(task, shard)
+		d.trackActive(task, -1)
+
+		result := TaskResult{Bytes: n, Duration: time.Since(start), Err: err, Aborted: err != nil && (d.aborted() || errors.Is(err, ErrPaused))}
+		for _, o := range d.observers {
+			if err != nil && !result.Aborted {
+				o.OnError(task, err)
+			}
+			o.OnTaskDone(workerID, task, result)
+		}
+		if result.Aborted {
+			// 达到运行边界或暂停被中断，不计为失败
+			continue
+		}
+		d.recordResult(task, err)
 		if err != nil {
 			// 检查是否是状态码错误
 			if strings.Contains(err.Error(), "HTTP状态码错误") {
 				// 静默跳过非200状态码，不输出错误
 				continue
 			}
+			// 停滞中止：记录后继续下一个任务
+			if errors.Is(err, ErrStalled) {
+				d.logger.Warn("已中止停滞的传输", "worker", workerID, "ip", task.IP, "url", task.URL, "error", err)
+				continue
+			}
 			// 其他错误正常输出
-			fmt.Printf("[Worker %d] 下载失败 %s: %v\n", workerID, task.URL, err)
+			d.logger.Warn("下载失败", "worker", workerID, "ip", task.IP, "url", task.URL, "error", err)
 		} else {
-			fmt.Printf("[Worker %d] 下载完成 %s\n", workerID, task.URL)
+			d.logger.Debug("下载完成", "worker", workerID, "ip", task.IP, "url", task.URL)
 		}
 	}
 }
 
-// downloadTask 下载单个任务
-func (d *Downloader) downloadTask(task DownloadTask) error {
-	// 解析 URL 获取域名
-	parsedURL, err := url.Parse(task.URL)
-	if err != nil {
-		return fmt.Errorf("解析URL失败: %w", err)
+// clientFor 获取指定 IP 的 HTTP 客户端
+// 同一个 IP 的请求共用一个 Transport，这样才能复用连接并统计连接复用情况
+func (d *Downloader) clientFor(ip string) *http.Client {
+	d.clientsMu.Lock()
+	defer d.clientsMu.Unlock()
+
+	if client, ok := d.clients[ip]; ok {
+		return client
 	}
 
 	// 创建自定义的 HTTP Transport，将域名解析到指定IP
-	transport := &http.Transport{
+	var transport http.RoundTripper = d.transport
+	if transport == nil {
+		transport = d.newTransport(ip)
+	}
+
+	// 创建自定义的 HTTP 客户端（直接使用 http 包，不使用 resty）
+	// 不设置 Client.Timeout：它包括读取响应体的时间，限速的传输超过该时长时会被中止；慢的阶段由 Transport 的超时限制
+	client := &http.Client{Transport: transport}
+
+	if d.clients == nil {
+		d.clients = make(map[string]*http.Client)
+	}
+	d.clients[ip] = client
+	return client
+}
+
+// newTransport 创建连接到指定 IP 的 Transport
+func (d *Downloader) newTransport(ip string) *http.Transport {
+	return &http.Transport{
 		DialContext: func(dialCtx context.Context, network, addr string) (net.Conn, error) {
-			// 获取端口
+			// net/http 传入的地址总是带端口（按协议补全 80 或 443），只替换主机为指定的 IP
 			_, port, err := net.SplitHostPort(addr)
 			if err != nil {
-				// 如果没有端口，使用默认端口
-				if parsedURL.Scheme == "https" {
-					port = "443"
-				} else {
-					port = "80"
-				}
+				return nil, fmt.Errorf("解析连接地址失败: %w", err)
 			}
-
-			// 使用指定的IP和端口
-			addr = net.JoinHostPort(task.IP, port)
+			addr = net.JoinHostPort(ip, port)
 			dialer := &net.Dialer{
-				Timeout:   30 * time.Second,
+				Timeout:   d.requestTimeout,
 				KeepAlive: 30 * time.Second,
 			}
 			return dialer.DialContext(dialCtx, network, addr)
 		},
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 100,
-		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout:   d.requestTimeout,
+		ResponseHeaderTimeout: d.requestTimeout,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   100,
+		IdleConnTimeout:       90 * time.Second,
 	}
+}
 
-	// 创建自定义的 HTTP 客户端（直接使用 http 包，不使用 resty）
-	httpClient := &http.Client{
-		Transport: transport,
-		Timeout:   30 * time.Second, // 设置30秒超时
+// downloadTask 下载单个任务，返回本次读取的字节数
+func (d *Downloader) downloadTask(task DownloadTask, shard *counterShard) (int64, error) {
+	if isTCPTask(task) {
+		return d.transferTCP(task, shard)
 	}
 
-	// 创建 HTTP 请求
-	req, err := http.NewRequest("GET", task.URL, nil)
+	// 创建 HTTP 请求，并挂上 httptrace 记录各阶段耗时
+	start := time.Now()
+	trace := newRequestTrace(start)
+	ctx, cancel := context.WithCancelCause(d.abortCtx)
+	defer cancel(nil)
+	stopPause := context.AfterFunc(d.pauseContext(), func() { cancel(ErrPaused) })
+	defer stopPause()
+	req, err := http.NewRequestWithContext(
+		httptrace.WithClientTrace(ctx, trace.clientTrace()),
+		"GET", task.URL, nil)
 	if err != nil {
-		return fmt.Errorf("创建请求失败: %w", err)
+		return 0, fmt.Errorf("创建请求失败: %w", err)
 	}
 
 	// 发送请求
-	resp, err := httpClient.Do(req)
+	resp, err := d.clientFor(task.IP).Do(req)
 	if err != nil {
-		return fmt.Errorf("请求失败: %w", err)
+		if errors.Is(context.Cause(ctx), ErrPaused) {
+			return 0, ErrPaused
+		}
+		return 0, fmt.Errorf("请求失败: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// 检查状态码
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP状态码错误: %d", resp.StatusCode)
+		return 0, fmt.Errorf("HTTP状态码错误: %d", resp.StatusCode)
 	}
 
-	// 读取响应体，但不保存到硬盘
-	buf := make([]byte, 64*1024) // 64KB 缓冲区
-	for {
-		n, err := resp.Body.Read(buf)
-		if n > 0 {
-			// 累加下载字节数
-			d.bytesDownloaded.Add(int64(n))
+	writer, stop := d.transferWriter(ctx, cancel, task, shard)
+	defer stop()
+
+	// 读取响应体，但不保存到硬盘（字节数累加到当前工作协程的分片）
+	n, err := d.buffers.drain(writer, resp.Body)
+	d.recordBytes(task, n)
+	if err != nil {
+		return n, d.transferError(ctx, task, "读取响应失败", err)
+	}
+
+	d.recordTiming(task, trace.finish(time.Now()))
+	return n, nil
+}
+
+// transferWriter 创建单次传输的计数写入器：每段数据通知观察者并按限速器等待，
+// 启用停滞检测时监控本次传输的速度；返回的函数在传输结束后停止监控
+func (d *Downloader) transferWriter(ctx context.Context, cancel context.CancelCauseFunc, task DownloadTask, shard *counterShard) (countingWriter, func()) {
+	writer := countingWriter{shard: shard}
+	stop := func() {}
+	var throttled *throttleClock
+	if d.stall.MinSpeed > 0 {
+		writer.transfer = &atomic.Int64{}
+		throttled = &throttleClock{}
+		stop = d.watchStall(cancel, writer.transfer, throttled)
+	}
+
+	// 限速器每次读取后重新获取，运行中设置的限速对正在进行的传输立即生效
+	writer.onWrite = func(n int) error {
+		for _, o := range d.observers {
+			o.OnBytes(task, n)
 		}
-		if err == io.EOF {
+		limiter := d.currentLimiter()
+		if limiter == nil {
 			return nil
 		}
-		if err != nil {
-			return fmt.Errorf("读取响应失败: %w", err)
+		if throttled != nil {
+			return throttled.wait(func() error { return limiter.WaitN(ctx, n) })
 		}
+		return limiter.WaitN(ctx, n)
 	}
+	return writer, stop
+}
+
+// transferError 按取消原因转换传输中的错误：暂停返回 ErrPaused，停滞记录后返回 ErrStalled
+func (d *Downloader) transferError(ctx context.Context, task DownloadTask, msg string, err error) error {
+	if errors.Is(context.Cause(ctx), ErrPaused) {
+		return ErrPaused
+	}
+	if errors.Is(context.Cause(ctx), ErrStalled) {
+		d.recordStall(task)
+		return fmt.Errorf("%w: %d 秒内平均速度低于 %d B/s", ErrStalled, int(d.stall.Window.Seconds()), d.stall.MinSpeed)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
 }
 
 // reportSpeed 报告下载速度
@@ -363,8 +680,10 @@ func (d *Downloader) reportSpeed(ctx context.Context) {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
-	var lastBytes int64
-	startTime := time.Now()
+	// 从会话开始时的总量算起，避免把之前会话的下载量计入第一秒
+	lastBytes := d.bytesDownloaded.Load()
+	startTime := d.clock.Now()
+	ticks := 0
 
 	for {
 		select {
@@ -379,32 +698,86 @@ func (d *Downloader) reportSpeed(ctx context.Context) {
 
 			// 转换为 MB/s
 			speedMBps := float64(bytesThisSecond) / 1024 / 1024
+			d.updateSpeed(speedMBps)
 
 			// 计算总体平均速度
-			elapsed := time.Since(startTime).Seconds()
-			avgSpeedMBps := float64(currentBytes) / 1024 / 1024 / elapsed
+			var avgSpeedMBps float64
+			if elapsed := d.clock.Now().Sub(startTime).Seconds(); elapsed > 0 {
+				avgSpeedMBps = float64(currentBytes) / 1024 / 1024 / elapsed
+			}
 
-			// 输出到控制台
-			fmt.Printf("[速度统计] 当前速度: %.2f MB/s | 平均速度: %.2f MB/s | 总下载: %.2f MB\n",
-				speedMBps, avgSpeedMBps, float64(currentBytes)/1024/1024)
+			// 输出日志：每秒速度为 INFO，每隔 summaryTicks 秒输出一次周期汇总（SUMMARY 级别）
+			d.logger.Info("速度统计",
+				"speed_mbs", round2(speedMBps), "avg_speed_mbs", round2(avgSpeedMBps), "total_mb", round2(float64(currentBytes)/1024/1024))
+			ticks++
+			if ticks%summaryTicks == 0 {
+				d.mu.Lock()
+				peak := d.peakSpeed
+				d.mu.Unlock()
+				logging.Summary(d.logger, "周期汇总",
+					"total_mb", round2(float64(currentBytes)/1024/1024), "avg_speed_mbs", round2(avgSpeedMBps),
+					"peak_speed_mbs", round2(peak), "completed", d.completed.Load(), "failed", d.failed.Load(), "stalls", d.stalls.Load())
+			}
+
+			// 追加到历史记录
+			now := d.clock.Now()
+			if d.history != nil {
+				err := d.history.Write(history.Record{
+					Time:       now,
+					Speed:      speedMBps,
+					AvgSpeed:   avgSpeedMBps,
+					TotalBytes: currentBytes,
+					Completed:  d.completed.Load(),
+					Failed:     d.failed.Load(),
+				})
+				if err != nil {
+					d.logger.Error("写入速度历史记录失败", "error", err)
+				}
+			}
 
-			// 写入文件（覆盖模式，只保留最新的统计）
+			// 写入最新速度文件（只保留最新的统计）
 			d.mu.Lock()
-			timestamp := time.Now().Format("2006-01-02 15:04:05")
-			content := fmt.Sprintf("%s | 当前速度: %.2f MB/s | 平均速度: %.2f MB/s | 总下载: %.2f MB\n",
+			timestamp := now.Format("2006-01-02 15:04:05")
+			d.lastSpeedLine = fmt.Sprintf("%s | 当前速度: %.2f MB/s | 平均速度: %.2f MB/s | 总下载: %.2f MB\n",
 				timestamp, speedMBps, avgSpeedMBps, float64(currentBytes)/1024/1024)
-
-			// 清空文件并写入新内容
-			d.speedFile.Seek(0, 0)
-			d.speedFile.Truncate(0)
-			d.speedFile.WriteString(content)
-			d.speedFile.Sync() // 立即刷新到磁盘
+			d.writeSpeedFile(d.lastSpeedLine)
 			d.mu.Unlock()
 		}
 	}
 }
 
+// writeSpeedFile 原子替换最新速度文件的内容（调用方需持有 d.mu）
+func (d *Downloader) writeSpeedFile(content string) {
+	if d.speedPath == "" {
+		return
+	}
+	if err := history.WriteFileAtomic(d.speedPath, []byte(content)); err != nil {
+		d.logger.Error("写入速度文件失败", "path", d.speedPath, "error", err)
+	}
+}
+
 // GetTasks 获取任务列表
 func (d *Downloader) GetTasks() []DownloadTask {
-	return d.tasks
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]DownloadTask(nil), d.tasks...)
+}
+
+// nextTask 返回本轮下一个要分发的任务，本轮已分发完时返回 false 并从头开始下一轮
+func (d *Downloader) nextTask() (DownloadTask, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.cursor >= len(d.tasks) {
+		d.cursor = 0
+		return DownloadTask{}, false
+	}
+	return d.tasks[d.cursor], true
+}
+
+// advance 记录当前任务已分发
+func (d *Downloader) advance() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cursor++
 }
@@ -0,0 +1,361 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/ratelimit"
+	"github.com/dora-exku/netflood/pkg/server"
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+func TestDownloadTask_CountsBytes(t *testing.T) {
+	const size = 3*DefaultBufferSize + 123
+	url := newPayloadServer(t, size)
+
+	d := New(WithWorkers(1))
+	shard := d.bytesDownloaded.shard(0)
+	task := DownloadTask{IP: "127.0.0.1", URL: url}
+	n, err := d.downloadTask(task, shard)
+	if err != nil {
+		t.Fatalf("downloadTask() error = %v", err)
+	}
+	if n != size {
+		t.Errorf("downloadTask() = %d bytes, want %d", n, size)
+	}
+
+	if got := d.bytesDownloaded.Load(); got != size {
+		t.Errorf("bytesDownloaded = %d, want %d", got, size)
+	}
+}
+
+func TestDownloadTask_RecordsTiming(t *testing.T) {
+	url := newPayloadServer(t, 1024)
+
+	d := New(WithWorkers(1))
+	shard := d.bytesDownloaded.shard(0)
+	task := DownloadTask{IP: "127.0.0.1", URL: url}
+	for i := 0; i < 3; i++ {
+		if _, err := d.downloadTask(task, shard); err != nil {
+			t.Fatalf("downloadTask() error = %v", err)
+		}
+	}
+
+	overall := d.LatencySummary()
+	if overall.Requests != 3 {
+		t.Errorf("Requests = %d, want 3", overall.Requests)
+	}
+	// 同一个 IP 共用 Transport，后续请求应复用连接
+	if overall.Reused != 2 {
+		t.Errorf("Reused = %d, want 2", overall.Reused)
+	}
+	if overall.TTFB.P50 <= 0 {
+		t.Errorf("TTFB p50 = %v, want > 0", overall.TTFB.P50)
+	}
+
+	if got := d.LatencyByIP()["127.0.0.1"].Requests; got != 3 {
+		t.Errorf("LatencyByIP requests = %d, want 3", got)
+	}
+	if got := d.LatencyByTask()[taskKey(task)].Requests; got != 3 {
+		t.Errorf("LatencyByTask requests = %d, want 3", got)
+	}
+}
+
+func TestDownloadTask_LimitedTransferOutlastsRequestTimeout(t *testing.T) {
+	const size = 384 << 10
+	url := newPayloadServer(t, size)
+
+	// 256KB/s 下载 384KB 约需 1.25 秒（首个 64KB 为突发量），远超请求超时
+	d := New(WithWorkers(1), WithLimiter(ratelimit.New(256<<10)), WithRequestTimeout(200*time.Millisecond))
+	start := time.Now()
+	n, err := d.downloadTask(DownloadTask{IP: "127.0.0.1", URL: url}, d.bytesDownloaded.shard(0))
+	if err != nil || n != size {
+		t.Fatalf("downloadTask() = %d, %v, want %d bytes", n, err, size)
+	}
+	if elapsed := time.Since(start); elapsed < 4*d.requestTimeout {
+		t.Errorf("transfer took %v, want it to outlast the %v request timeout", elapsed, d.requestTimeout)
+	}
+}
+
+func TestDownloadTask_AbortsStalledTransfer(t *testing.T) {
+	url, _ := newHangingServer(t)
+
+	d := New(WithWorkers(1))
+	d.SetStallPolicy(1024, 100*time.Millisecond)
+	task := DownloadTask{IP: "127.0.0.1", URL: url}
+
+	start := time.Now()
+	_, err := d.downloadTask(task, d.bytesDownloaded.shard(0))
+	if !errors.Is(err, ErrStalled) {
+		t.Fatalf("downloadTask() error = %v, want ErrStalled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("stalled transfer took %v to abort", elapsed)
+	}
+
+	if d.Stalls() != 1 {
+		t.Errorf("Stalls() = %d, want 1", d.Stalls())
+	}
+	if got := d.StallsByIP()["127.0.0.1"]; got != 1 {
+		t.Errorf("StallsByIP() = %d, want 1", got)
+	}
+	if got := d.StallsByTask()[taskKey(task)]; got != 1 {
+		t.Errorf("StallsByTask() = %d, want 1", got)
+	}
+}
+
+func TestDownloadTask_ThrottledTransferNotStalled(t *testing.T) {
+	const size = 192 << 10
+	url := newPayloadServer(t, size)
+
+	// 限速 128KB/s 低于 256KB/s 的停滞阈值，在限速器中等待的时间不计入停滞窗口
+	d := New(WithWorkers(1), WithLimiter(ratelimit.New(128<<10)), WithBufferSize(16<<10), WithStallPolicy(256<<10, 100*time.Millisecond))
+	n, err := d.downloadTask(DownloadTask{IP: "127.0.0.1", URL: url}, d.bytesDownloaded.shard(0))
+	if err != nil || n != size {
+		t.Fatalf("downloadTask() = %d, %v, want %d bytes", n, err, size)
+	}
+	if d.Stalls() != 0 {
+		t.Errorf("Stalls() = %d, want 0", d.Stalls())
+	}
+}
+
+func TestDownloadTask_Faults(t *testing.T) {
+	base, _ := newSyntheticServer(t, server.Options{})
+
+	tests := []struct {
+		name, query string
+		bytes       int64  // 失败前已下载的字节数
+		want        string // 错误信息包含的内容
+	}{
+		{"status", "status=503", 0, "HTTP状态码错误: 503"},
+		{"too many requests", "status=429&retry_after=5s", 0, "HTTP状态码错误: 429"},
+		{"truncate", "truncate=256KB", 256 << 10, "读取响应失败"},
+		{"reset", "reset=256KB&chunked=1", -1, "读取响应失败"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := New(WithWorkers(1))
+			n, err := d.downloadTask(DownloadTask{IP: "127.0.0.1", URL: base + "/bytes/1MB?" + tt.query}, d.bytesDownloaded.shard(0))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("downloadTask() error = %v, want containing %q", err, tt.want)
+			}
+			if tt.bytes >= 0 && n != tt.bytes {
+				t.Errorf("downloadTask() = %d bytes, want %d", n, tt.bytes)
+			}
+			if got := d.bytesDownloaded.Load(); got != n {
+				t.Errorf("bytesDownloaded = %d, want %d", got, n)
+			}
+		})
+	}
+
+	// 慢速发送的传输被停滞检测中止
+	d := New(WithWorkers(1))
+	d.SetStallPolicy(64*1024, 200*time.Millisecond)
+	if _, err := d.downloadTask(DownloadTask{IP: "127.0.0.1", URL: base + "/bytes/10MB?trickle=1KB"}, d.bytesDownloaded.shard(0)); !errors.Is(err, ErrStalled) {
+		t.Errorf("downloadTask() with trickle error = %v, want ErrStalled", err)
+	}
+}
+
+func TestStart_CountsFaultFailures(t *testing.T) {
+	base, srv := newSyntheticServer(t, server.Options{})
+
+	d := New(WithWorkers(2))
+	d.tasks = []DownloadTask{
+		{IP: "127.0.0.1", URL: base + "/bytes/64KB"},
+		{IP: "127.0.0.1", URL: base + "/bytes/64KB?status=503"},
+		{IP: "127.0.0.1", URL: base + "/bytes/64KB?latency=50ms&truncate=50%25"},
+	}
+	runWithLimits(t, d, RunLimits{Iterations: 2})
+
+	// 失败的任务同样按轮数计算，不会重复下载到超出轮数
+	if d.Completed() != 2 || d.Failed() != 4 || srv.Stats().Requests != 6 {
+		t.Errorf("Completed/Failed/requests = %d/%d/%d, want 2/4/6", d.Completed(), d.Failed(), srv.Stats().Requests)
+	}
+	for _, task := range d.Stats().Tasks {
+		want := int64(2)
+		if task.URL == base+"/bytes/64KB" {
+			want = 0
+		}
+		if task.Failed != want {
+			t.Errorf("task %s: Failed = %d, want %d", task.URL, task.Failed, want)
+		}
+	}
+	if faults := srv.Stats().Faults; faults[server.FaultStatus] != 2 || faults[server.FaultTruncate] != 2 {
+		t.Errorf("server faults = %v", faults)
+	}
+}
+
+// runWithLimits 在临时目录中以指定边界运行下载器，直到其自行结束
+func runWithLimits(t *testing.T, d *Downloader, limits RunLimits) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+
+	d.SetRunLimits(limits)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := d.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("Start() did not stop before the test timeout")
+	}
+}
+
+func TestStart_Once(t *testing.T) {
+	base, srv := newSyntheticServer(t, server.Options{})
+	url := base + "/bytes/" + strconv.Itoa(len("payload"))
+
+	d := New(WithWorkers(2))
+	d.tasks = []DownloadTask{
+		{IP: "127.0.0.1", URL: url + "?a"},
+		{IP: "127.0.0.1", URL: url + "?b"},
+		{IP: "127.0.0.1", URL: url + "?c"},
+	}
+	runWithLimits(t, d, RunLimits{Iterations: 1})
+
+	if got := srv.Stats().Requests; got != 3 {
+		t.Errorf("requests = %d, want 3", got)
+	}
+	if d.Completed() != 3 || d.Failed() != 0 {
+		t.Errorf("Completed/Failed = %d/%d, want 3/0", d.Completed(), d.Failed())
+	}
+	if d.StopReason() != StopIterations {
+		t.Errorf("StopReason() = %q, want %q", d.StopReason(), StopIterations)
+	}
+
+	snap := d.Stats()
+	if snap.TotalBytes != 3*int64(len("payload")) {
+		t.Errorf("Stats().TotalBytes = %d, want %d", snap.TotalBytes, 3*len("payload"))
+	}
+	if len(snap.Sessions) != 1 || snap.Sessions[0].End.IsZero() || snap.Sessions[0].Bytes != snap.TotalBytes {
+		t.Errorf("Stats().Sessions = %+v, want one finished session with all bytes", snap.Sessions)
+	}
+	if len(snap.Tasks) != 3 {
+		t.Fatalf("len(Stats().Tasks) = %d, want 3", len(snap.Tasks))
+	}
+	for _, task := range snap.Tasks {
+		if task.Completed != 1 || task.Bytes != int64(len("payload")) {
+			t.Errorf("task %s: Completed/Bytes = %d/%d, want 1/%d", task.URL, task.Completed, task.Bytes, len("payload"))
+		}
+	}
+}
+
+func TestStart_BytesLimit(t *testing.T) {
+	url := newPayloadServer(t, 256*1024)
+
+	d := New(WithWorkers(2))
+	d.tasks = []DownloadTask{{IP: "127.0.0.1", URL: url}}
+	runWithLimits(t, d, RunLimits{Bytes: 1024 * 1024})
+
+	if got := d.BytesDownloaded(); got < 1024*1024 {
+		t.Errorf("BytesDownloaded() = %d, want >= 1MB", got)
+	}
+	if d.StopReason() != StopBytes {
+		t.Errorf("StopReason() = %q, want %q", d.StopReason(), StopBytes)
+	}
+}
+
+func TestStart_DurationLimit(t *testing.T) {
+	url := newPayloadServer(t, 1024)
+
+	d := New(WithWorkers(1))
+	d.tasks = []DownloadTask{{IP: "127.0.0.1", URL: url}}
+
+	start := time.Now()
+	runWithLimits(t, d, RunLimits{Duration: 300 * time.Millisecond})
+
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Start() ran for %v, want about 300ms", elapsed)
+	}
+	if d.StopReason() != StopDuration {
+		t.Errorf("StopReason() = %q, want %q", d.StopReason(), StopDuration)
+	}
+	if d.Completed() == 0 {
+		t.Error("Completed() = 0, want some downloads")
+	}
+}
+
+// memorySink 记录收到的统计数据
+type memorySink struct {
+	mu     sync.Mutex
+	data   []stats.StatsData
+	closed bool
+}
+
+func (s *memorySink) Send(_ context.Context, data stats.StatsData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = append(s.data, data)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestStart_StatsReportsOnSessionAndExit(t *testing.T) {
+	url := newPayloadServer(t, 1024)
+
+	// 间隔很长，只有会话开始、结束和最终上报
+	sink := &memorySink{}
+	d := New(WithWorkers(1), WithStatsSink("memory", sink, stats.SinkOptions{Interval: time.Hour}))
+	d.tasks = []DownloadTask{{IP: "127.0.0.1", URL: url}}
+	runWithLimits(t, d, RunLimits{Duration: 150 * time.Millisecond})
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.data) < 2 {
+		t.Fatalf("sink received %d reports, want session start and final reports", len(sink.data))
+	}
+	if last := sink.data[len(sink.data)-1]; last.TotalBytes != d.Stats().TotalBytes {
+		t.Errorf("final report total = %d, want %d", last.TotalBytes, d.Stats().TotalBytes)
+	}
+}
+
+func TestStart_StatsSinks(t *testing.T) {
+	url := newPayloadServer(t, 1024)
+
+	sink := &memorySink{}
+	d := New(WithWorkers(1), WithStatsSink("memory", sink, stats.SinkOptions{Interval: 20 * time.Millisecond}),
+		WithIdentity(stats.Identity{Name: "edge-1", Labels: map[string]string{"region": "eu"}}))
+	d.tasks = []DownloadTask{{IP: "127.0.0.1", URL: url}}
+	runWithLimits(t, d, RunLimits{Duration: 200 * time.Millisecond})
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.data) == 0 || sink.data[len(sink.data)-1].Total <= 0 {
+		t.Fatalf("sink received %d reports, want some with bytes", len(sink.data))
+	}
+	// 会话结束时和最终上报时已不在会话中
+	if sink.data[0].Session == nil {
+		t.Error("first report (session start) has no session")
+	}
+	var sum int64
+	for i, data := range sink.data {
+		if data.Seq != uint64(i+1) || data.Window != nil {
+			t.Errorf("report %d: seq=%d window=%v, want seq %d and no window", i, data.Seq, data.Window, i+1)
+		}
+		if data.Name != "edge-1" || data.Labels["region"] != "eu" || data.RunID == "" {
+			t.Errorf("report %d: name=%q labels=%v run_id=%q, want identity applied", i, data.Name, data.Labels, data.RunID)
+		}
+		sum += data.Interval.Bytes
+	}
+	if last := sink.data[len(sink.data)-1]; sum != last.TotalBytes {
+		t.Errorf("sum of interval bytes = %d, want total %d", sum, last.TotalBytes)
+	}
+	if !sink.closed {
+		t.Error("sink not closed when Start() returned")
+	}
+	if status, ok := d.ReportStatus(); !ok || status.Successes != int64(len(sink.data)) {
+		t.Errorf("ReportStatus() = %+v, %v, want %d successes", status, ok, len(sink.data))
+	}
+}
@@ -0,0 +1,108 @@
+package downloader
+
+import (
+	"context"
+	"time"
+)
+
+// 运行结束原因
+const (
+	StopSignal     = "signal"     // 收到退出信号（外部 context 取消）
+	StopDuration   = "duration"   // 达到运行时长
+	StopBytes      = "bytes"      // 达到下载总量
+	StopIterations = "iterations" // 完成指定轮数
+)
+
+// RunLimits 运行边界，全部为零值时循环运行直到收到退出信号
+type RunLimits struct {
+	Duration   time.Duration // 最长运行时长，0 表示不限
+	Bytes      int64         // 下载总量（字节），0 表示不限
+	Iterations int           // 每个任务下载的轮数，0 表示无限循环；1 即单轮模式
+}
+
+// IsBounded 返回是否设置了任何运行边界
+func (l RunLimits) IsBounded() bool {
+	return l.Duration > 0 || l.Bytes > 0 || l.Iterations > 0
+}
+
+// SetRunLimits 设置运行边界
+func (d *Downloader) SetRunLimits(limits RunLimits) {
+	d.limits = limits
+}
+
+// StopReason 返回运行结束的原因（Start 返回后有效）
+func (d *Downloader) StopReason() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stopReason
+}
+
+// finish 结束本次运行，只有第一次调用生效
+// abort 为 true 时同时中断正在进行的传输（达到时长或总量时使用），
+// 否则等待当前任务自然完成
+func (d *Downloader) finish(reason string, abort bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stopReason != "" {
+		return
+	}
+	d.stopReason = reason
+	if abort {
+		d.abortTransfers()
+	}
+	d.cancelRun()
+}
+
+// aborted 返回正在进行的传输是否已被运行边界中断
+func (d *Downloader) aborted() bool {
+	return d.abortCtx.Err() != nil
+}
+
+// watchLimits 监控运行时长和下载总量，返回的函数用于停止监控
+func (d *Downloader) watchLimits(ctx context.Context) (stop func()) {
+	var timer *time.Timer
+	if d.limits.Duration > 0 {
+		timer = time.AfterFunc(d.limits.Duration, func() {
+			d.finish(StopDuration, true)
+		})
+	}
+
+	done := make(chan struct{})
+	if d.limits.Bytes > 0 {
+		go func() {
+			ticker := time.NewTicker(100 * time.Millisecond)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-done:
+					return
+				case <-ticker.C:
+					if d.bytesDownloaded.Load() >= d.limits.Bytes {
+						d.finish(StopBytes, true)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	return func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		close(done)
+	}
+}
+
+// nextPass 记录完成一轮任务分发，返回是否已达到轮数限制
+func (d *Downloader) nextPass() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.passes++
+	return d.limits.Iterations > 0 && d.passes >= d.limits.Iterations
+}
@@ -0,0 +1,38 @@
+package downloader
+
+import "time"
+
+// Observer 下载过程观察者，嵌入下载器时用于获取进度（替代解析日志输出）
+// 回调在工作协程中同步调用，可能并发执行，实现需要并发安全且尽快返回
+type Observer interface {
+	// OnSessionStart 下载会话开始（进入下载时间段，或全天候运行时启动）
+	OnSessionStart(session SessionSnapshot)
+	// OnSessionEnd 下载会话结束（离开下载时间段或运行结束）
+	OnSessionEnd(session SessionSnapshot)
+	// OnTaskStart 工作协程开始下载一个任务
+	OnTaskStart(worker int, task DownloadTask)
+	// OnTaskDone 一次下载结束（无论成功或失败）
+	OnTaskDone(worker int, task DownloadTask, result TaskResult)
+	// OnBytes 读取到一段数据，每次读取调用一次
+	OnBytes(task DownloadTask, n int)
+	// OnError 一次下载失败（包括 HTTP 状态码错误和停滞中止，不包括运行边界触发的中断）
+	OnError(task DownloadTask, err error)
+}
+
+// TaskResult 一次下载的结果
+type TaskResult struct {
+	Bytes    int64         // 本次下载的字节数
+	Duration time.Duration // 本次下载的耗时
+	Err      error         // 失败原因，成功时为 nil
+	Aborted  bool          // 是否被运行边界中断（不计入成功或失败）
+}
+
+// NopObserver 空实现，嵌入后只需实现关心的回调
+type NopObserver struct{}
+
+func (NopObserver) OnSessionStart(SessionSnapshot)           {}
+func (NopObserver) OnSessionEnd(SessionSnapshot)             {}
+func (NopObserver) OnTaskStart(int, DownloadTask)            {}
+func (NopObserver) OnTaskDone(int, DownloadTask, TaskResult) {}
+func (NopObserver) OnBytes(DownloadTask, int)                {}
+func (NopObserver) OnError(DownloadTask, error)              {}
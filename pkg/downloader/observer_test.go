@@ -0,0 +1,160 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/ratelimit"
+	"github.com/dora-exku/netflood/pkg/server"
+)
+
+// recordingObserver 记录回调次数
+type recordingObserver struct {
+	NopObserver
+	mu                             sync.Mutex
+	sessionsStarted, sessionsEnded int
+	started, done, errors          int
+	bytes                          int64
+	doneBytes                      int64
+}
+
+func (o *recordingObserver) OnSessionStart(SessionSnapshot) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sessionsStarted++
+}
+
+func (o *recordingObserver) OnSessionEnd(SessionSnapshot) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sessionsEnded++
+}
+
+func (o *recordingObserver) OnTaskStart(int, DownloadTask) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.started++
+}
+
+func (o *recordingObserver) OnTaskDone(_ int, _ DownloadTask, r TaskResult) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.done++
+	o.doneBytes += r.Bytes
+}
+
+func (o *recordingObserver) OnBytes(_ DownloadTask, n int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.bytes += int64(n)
+}
+
+func (o *recordingObserver) OnError(DownloadTask, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.errors++
+}
+
+// countingTransport 统计经过的请求数
+type countingTransport struct {
+	requests atomic.Int64
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// fixedClock 固定时间
+type fixedClock struct{ t time.Time }
+
+func (c fixedClock) Now() time.Time { return c.t }
+
+func TestStart_Observer(t *testing.T) {
+	base, _ := newSyntheticServer(t, server.Options{})
+
+	obs := &recordingObserver{}
+	transport := &countingTransport{}
+	clock := fixedClock{time.Date(2025, 10, 26, 12, 0, 0, 0, time.UTC)}
+	d := New(
+		WithWorkers(2),
+		WithObserver(obs),
+		WithTransport(transport),
+		WithClock(clock),
+		WithRunLimits(RunLimits{Iterations: 2}),
+		WithTasks(
+			DownloadTask{IP: "192.0.2.1", URL: base + "/bytes/100KB"}, // 使用自定义 Transport 时不按 IP 建连
+			DownloadTask{IP: "192.0.2.1", URL: base + "/missing"},
+		),
+	)
+	if err := d.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if obs.sessionsStarted != 1 || obs.sessionsEnded != 1 {
+		t.Errorf("sessions started/ended = %d/%d, want 1/1", obs.sessionsStarted, obs.sessionsEnded)
+	}
+	if obs.started != 4 || obs.done != 4 {
+		t.Errorf("tasks started/done = %d/%d, want 4/4", obs.started, obs.done)
+	}
+	if obs.errors != 2 {
+		t.Errorf("errors = %d, want 2", obs.errors)
+	}
+	if obs.bytes != 200*1024 || obs.doneBytes != 200*1024 {
+		t.Errorf("OnBytes total = %d, OnTaskDone total = %d, want %d", obs.bytes, obs.doneBytes, 200*1024)
+	}
+	if got := transport.requests.Load(); got != 4 {
+		t.Errorf("transport requests = %d, want 4", got)
+	}
+
+	snap := d.Stats()
+	if !snap.StartTime.Equal(clock.t) || snap.Duration != 0 {
+		t.Errorf("Stats() StartTime/Duration = %v/%v, want fixed clock", snap.StartTime, snap.Duration)
+	}
+}
+
+func TestNew_DoesNotPrint(t *testing.T) {
+	t.Chdir(t.TempDir())
+	url := newPayloadServer(t, 1024)
+
+	d := New(WithWorkers(1), WithTasks(DownloadTask{IP: "127.0.0.1", URL: url}), WithRunLimits(RunLimits{Iterations: 1}))
+	if err := d.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if d.logger.Enabled(context.Background(), slog.LevelError) {
+		t.Error("default logger is enabled, want discard")
+	}
+	if _, err := os.Stat(DefaultSpeedPath); err == nil {
+		t.Error("speed file written by default")
+	}
+
+	// 设置日志记录器后才输出
+	var buf bytes.Buffer
+	d = New(WithLogger(slog.New(slog.NewTextHandler(&buf, nil))), WithTasks(DownloadTask{IP: "127.0.0.1", URL: url}), WithRunLimits(RunLimits{Iterations: 1}))
+	d.Start(context.Background())
+	if buf.Len() == 0 {
+		t.Error("WithLogger() logger received no output")
+	}
+}
+
+func TestDownloadTask_Limiter(t *testing.T) {
+	const size = 1024 * 1024
+	url := newPayloadServer(t, size)
+
+	// 512 KB/s，桶容量 64 KB：1 MB 至少需要约 1.8 秒
+	d := New(WithLimiter(ratelimit.New(512 * 1024)))
+	start := time.Now()
+	if _, err := d.downloadTask(DownloadTask{IP: "127.0.0.1", URL: url}, d.bytesDownloaded.shard(0)); err != nil {
+		t.Fatalf("downloadTask() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 1500*time.Millisecond {
+		t.Errorf("1MB at 512KB/s took %v, want >= 1.5s", elapsed)
+	}
+}
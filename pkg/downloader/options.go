@@ -0,0 +1,148 @@
+package downloader
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+// DefaultWorkers 默认的工作协程数
+const DefaultWorkers = 12
+
+// Option 下载器配置项
+type Option func(*Downloader)
+
+// Limiter 下载限速器，所有工作协程共用
+// 每读取一段数据后调用 WaitN，与 golang.org/x/time/rate.Limiter 兼容
+// （使用 rate.Limiter 时突发量需不小于读缓冲区大小），内置实现见 pkg/ratelimit
+type Limiter interface {
+	WaitN(ctx context.Context, n int) error
+}
+
+// Clock 时间来源，用于统计快照、下载会话和速度记录中的时间戳及时长
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock 使用系统时间
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// WithWorkers 设置工作协程数
+func WithWorkers(n int) Option {
+	return func(d *Downloader) {
+		d.goroutines = n
+	}
+}
+
+// WithLimiter 设置下载限速器，其速率（支持查询时）作为本地配置的速度上限
+func WithLimiter(l Limiter) Option {
+	return func(d *Downloader) {
+		if l != nil {
+			d.limiter.Store(&limiterBox{l})
+			if s, ok := l.(rateSetter); ok {
+				d.localRate = s.Rate()
+			}
+		}
+	}
+}
+
+// WithTransport 设置发送请求使用的 Transport
+// 设置后所有请求都通过该 Transport 发送，不再按任务 IP 建立连接
+func WithTransport(rt http.RoundTripper) Option {
+	return func(d *Downloader) {
+		d.transport = rt
+	}
+}
+
+// WithRequestTimeout 设置建连、TLS 握手和等待响应头各自的超时，0 使用默认的 30 秒；读取响应体不限时长
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(d *Downloader) {
+		d.requestTimeout = cmp.Or(timeout, defaultRequestTimeout)
+	}
+}
+
+// WithClock 设置时间来源（测试时可以使用固定时间）
+func WithClock(c Clock) Option {
+	return func(d *Downloader) {
+		d.clock = c
+	}
+}
+
+// WithLogger 设置日志记录器，默认丢弃所有日志
+func WithLogger(logger *slog.Logger) Option {
+	return func(d *Downloader) {
+		d.logger = logger
+	}
+}
+
+// WithObserver 添加观察者，可以多次使用
+func WithObserver(o Observer) Option {
+	return func(d *Downloader) {
+		d.observers = append(d.observers, o)
+	}
+}
+
+// WithTasks 设置下载任务
+func WithTasks(tasks ...DownloadTask) Option {
+	return func(d *Downloader) {
+		d.tasks = tasks
+	}
+}
+
+// WithBufferSize 设置每次读取使用的缓冲区大小（字节）
+func WithBufferSize(size int) Option {
+	return func(d *Downloader) {
+		d.buffers = newBufferPool(size)
+	}
+}
+
+// WithRunLimits 设置运行边界
+func WithRunLimits(limits RunLimits) Option {
+	return func(d *Downloader) {
+		d.limits = limits
+	}
+}
+
+// WithStallPolicy 设置停滞检测
+func WithStallPolicy(minSpeed int64, window time.Duration) Option {
+	return func(d *Downloader) {
+		d.SetStallPolicy(minSpeed, window)
+	}
+}
+
+// WithStatsSink 添加统计输出目标，未设置的参数使用默认值
+// 创建发送队列失败（例如磁盘队列目录不可写）时 Start 返回该错误
+func WithStatsSink(name string, sink stats.Sink, opts stats.SinkOptions) Option {
+	return func(d *Downloader) {
+		if err := d.AddStatsSink(name, sink, opts); err != nil && d.optErr == nil {
+			d.optErr = err
+		}
+	}
+}
+
+// WithStatsFlushTimeout 设置运行结束时最终统计上报的超时，小于 0 时不做最终上报
+func WithStatsFlushTimeout(timeout time.Duration) Option {
+	return func(d *Downloader) {
+		d.SetStatsFlushTimeout(timeout)
+	}
+}
+
+// WithIdentity 设置统计上报中的节点身份
+func WithIdentity(id stats.Identity) Option {
+	return func(d *Downloader) {
+		d.SetIdentity(id)
+	}
+}
+
+// WithSpeedFile 设置最新速度文件路径，默认不写入
+func WithSpeedFile(path string) Option {
+	return func(d *Downloader) {
+		d.speedPath = path
+	}
+}
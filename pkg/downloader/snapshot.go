@@ -0,0 +1,188 @@
+package downloader
+
+import (
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+// Snapshot 下载器统计快照（速度单位均为 MB/s）
+type Snapshot struct {
+	StartTime      time.Time            `json:"start_time"`
+	Time           time.Time            `json:"time"`             // 快照时间
+	Duration       float64              `json:"duration_seconds"` // 运行时长（秒）
+	TotalBytes     int64                `json:"total_bytes"`
+	CurrentSpeed   float64              `json:"current_speed"` // 最近一秒的速度
+	AvgSpeed       float64              `json:"avg_speed"`     // 运行期间的平均速度
+	PeakSpeed      float64              `json:"peak_speed"`    // 每秒速度的峰值
+	Completed      int64                `json:"completed"`
+	Failed         int64                `json:"failed"`
+	Stalls         int64                `json:"stalls"`
+	Workers        int                  `json:"workers"`          // 工作协程数
+	ActiveWorkers  int64                `json:"active_workers"`   // 正在下载的工作协程数
+	Passes         int                  `json:"passes"`           // 已完成分发的轮数
+	Paused         bool                 `json:"paused,omitempty"` // 是否处于暂停状态
+	StopReason     string               `json:"stop_reason,omitempty"`
+	TimeRange      string               `json:"time_range"`      // 配置的下载时间段
+	WindowsEntered int                  `json:"windows_entered"` // 进入下载时间段的次数（未启用时间段时为 0）
+	Sessions       []SessionSnapshot    `json:"sessions"`
+	Latency        stats.LatencySummary `json:"latency"`
+	Tasks          []TaskSnapshot       `json:"tasks"`
+}
+
+// SessionSnapshot 单次下载会话
+type SessionSnapshot struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`              // 会话仍在进行时为零值
+	Window string    `json:"window,omitempty"` // 所在的下载时间段，全天候运行时为空
+	Bytes  int64     `json:"bytes"`
+}
+
+// TaskSnapshot 单个任务的统计
+type TaskSnapshot struct {
+	IP        string               `json:"ip"`
+	URL       string               `json:"url"`
+	Bytes     int64                `json:"bytes"`
+	Completed int64                `json:"completed"`
+	Failed    int64                `json:"failed"`
+	Stalls    int64                `json:"stalls"`
+	Active    int64                `json:"active"` // 正在进行的下载数
+	Latency   stats.LatencySummary `json:"latency"`
+}
+
+// Stats 返回当前统计快照，运行中和运行结束后都可调用
+func (d *Downloader) Stats() Snapshot {
+	now := d.clock.Now()
+	total := d.bytesDownloaded.Load()
+
+	d.mu.Lock()
+	if !d.endTime.IsZero() {
+		// 运行已结束，时长截止到结束时间
+		now = d.endTime
+	}
+	snap := Snapshot{
+		StartTime:    d.startTime,
+		Time:         now,
+		TotalBytes:   total,
+		CurrentSpeed: d.currentSpeed,
+		PeakSpeed:    d.peakSpeed,
+		Workers:      d.goroutines,
+		Passes:       d.passes,
+		Paused:       d.resumed != nil,
+		StopReason:   d.stopReason,
+	}
+	for _, rec := range d.sessions {
+		session := rec.SessionSnapshot
+		if session.End.IsZero() {
+			// 会话仍在进行，按当前总量计算
+			session.Bytes = total - rec.startBytes
+		}
+		snap.Sessions = append(snap.Sessions, session)
+	}
+	tasks := append([]DownloadTask(nil), d.tasks...)
+	d.mu.Unlock()
+
+	if !snap.StartTime.IsZero() {
+		snap.Duration = now.Sub(snap.StartTime).Seconds()
+	}
+	if snap.Duration > 0 {
+		snap.AvgSpeed = float64(total) / 1024 / 1024 / snap.Duration
+	}
+
+	snap.Completed = d.completed.Load()
+	snap.Failed = d.failed.Load()
+	snap.Stalls = d.stalls.Load()
+	snap.ActiveWorkers = d.active.Load()
+	snap.Latency = d.timing.Summary()
+	snap.TimeRange = "全天候"
+	if d.timeRangeManager != nil && d.timeRangeManager.IsEnabled() {
+		snap.TimeRange = d.timeRangeManager.String()
+		snap.WindowsEntered = len(snap.Sessions)
+	}
+
+	// 按加载顺序列出任务，保证输出稳定
+	d.statsMu.Lock()
+	for _, task := range tasks {
+		ts := TaskSnapshot{IP: task.IP, URL: task.URL}
+		if s, ok := d.taskStats[taskKey(task)]; ok {
+			ts.Bytes = s.bytes.Load()
+			ts.Completed = s.completed.Load()
+			ts.Failed = s.failed.Load()
+			ts.Stalls = s.stalls.Load()
+			ts.Active = s.active.Load()
+			ts.Latency = s.timing.Summary()
+		}
+		snap.Tasks = append(snap.Tasks, ts)
+	}
+	d.statsMu.Unlock()
+
+	return snap
+}
+
+// markEnd 记录运行结束时间
+func (d *Downloader) markEnd() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.endTime = d.clock.Now()
+}
+
+// sessionRecord 会话记录（附带会话开始时的总字节数）
+type sessionRecord struct {
+	SessionSnapshot
+	startBytes int64
+}
+
+// beginSession 记录一次下载会话开始，返回会话序号
+func (d *Downloader) beginSession() int {
+	rec := sessionRecord{
+		SessionSnapshot: SessionSnapshot{Start: d.clock.Now()},
+		startBytes:      d.bytesDownloaded.Load(),
+	}
+	if d.timeRangeManager != nil {
+		if r, ok := d.timeRangeManager.CurrentRange(); ok {
+			rec.Window = r.String()
+		}
+	}
+
+	d.mu.Lock()
+	d.sessions = append(d.sessions, rec)
+	idx := len(d.sessions) - 1
+	d.mu.Unlock()
+
+	for _, o := range d.observers {
+		o.OnSessionStart(rec.SessionSnapshot)
+	}
+	if d.statsReporter != nil {
+		d.statsReporter.Trigger() // 会话开始时立即上报
+	}
+	return idx
+}
+
+// endSession 记录下载会话结束
+func (d *Downloader) endSession(idx int) {
+	total := d.bytesDownloaded.Load()
+
+	d.mu.Lock()
+	d.sessions[idx].End = d.clock.Now()
+	d.sessions[idx].Bytes = total - d.sessions[idx].startBytes
+	session := d.sessions[idx].SessionSnapshot
+	d.mu.Unlock()
+
+	for _, o := range d.observers {
+		o.OnSessionEnd(session)
+	}
+	if d.statsReporter != nil {
+		d.statsReporter.Trigger() // 会话结束时立即上报
+	}
+}
+
+// updateSpeed 记录最近一秒的速度并更新峰值（单位 MB/s）
+func (d *Downloader) updateSpeed(speed float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.currentSpeed = speed
+	if speed > d.peakSpeed {
+		d.peakSpeed = speed
+	}
+}
@@ -0,0 +1,316 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+// TaskSource 下载任务来源
+// 每次调用 Tasks 都重新读取，用于定期刷新任务列表
+type TaskSource interface {
+	Tasks(ctx context.Context) ([]DownloadTask, error)
+	String() string // 来源描述，用于日志
+}
+
+// ParseTasks 解析任务列表，每行一个任务，格式为 "IP,URL"，空行和格式不正确的行被忽略
+func ParseTasks(r io.Reader) ([]DownloadTask, error) {
+	scanner := bufio.NewScanner(r)
+	var tasks []DownloadTask
+
+	// 逐行解析
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		// 按逗号分割 IP 和 URL
+		parts := strings.SplitN(line, ",", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		tasks = append(tasks, DownloadTask{
+			IP:  strings.TrimSpace(parts[0]),
+			URL: strings.TrimSpace(parts[1]),
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("解析内容失败: %w", err)
+	}
+	return tasks, nil
+}
+
+// ParseSource 按描述创建任务来源：
+// "-" 为标准输入，http:// 或 https:// 开头为 HTTP 接口，目录为目录下的所有文件，其他为文件路径
+func ParseSource(spec string) (TaskSource, error) {
+	switch {
+	case spec == "":
+		return nil, fmt.Errorf("任务来源不能为空")
+	case spec == "-":
+		return StdinSource(), nil
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return HTTPSource(spec), nil
+	}
+
+	info, err := os.Stat(spec)
+	if err != nil {
+		return nil, fmt.Errorf("读取任务来源失败: %w", err)
+	}
+	if info.IsDir() {
+		return DirSource(spec), nil
+	}
+	return FileSource(spec), nil
+}
+
+// FileSource 从文件读取任务
+type FileSource string
+
+// Tasks 实现 TaskSource
+func (s FileSource) Tasks(ctx context.Context) ([]DownloadTask, error) {
+	file, err := os.Open(string(s))
+	if err != nil {
+		return nil, fmt.Errorf("读取文件失败: %w", err)
+	}
+	defer file.Close()
+	return ParseTasks(file)
+}
+
+func (s FileSource) String() string { return "文件 " + string(s) }
+
+// DirSource 读取目录下的所有文件（不递归，忽略隐藏文件），按文件名顺序合并
+type DirSource string
+
+// Tasks 实现 TaskSource
+func (s DirSource) Tasks(ctx context.Context) ([]DownloadTask, error) {
+	entries, err := os.ReadDir(string(s))
+	if err != nil {
+		return nil, fmt.Errorf("读取目录失败: %w", err)
+	}
+
+	var tasks []DownloadTask
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		fileTasks, err := FileSource(filepath.Join(string(s), entry.Name())).Tasks(ctx)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, fileTasks...)
+	}
+	return tasks, nil
+}
+
+func (s DirSource) String() string { return "目录 " + string(s) }
+
+// HTTPSource 从 HTTP 接口读取任务
+// 上下文中带有节点身份（见 ContextWithIdentity）时附加节点身份请求头，收集器据此为节点分配任务；
+// 下载器只在设置了 WithTaskIdentity 时附加，避免向第三方接口泄露节点 ID 和标签
+type HTTPSource string
+
+// identityKey 上下文中节点身份的键
+type identityKey struct{}
+
+// ContextWithIdentity 返回带节点身份的上下文，HTTPSource 请求时附加节点身份请求头
+func ContextWithIdentity(ctx context.Context, id stats.Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// httpSourceClient 读取任务接口使用的客户端
+var httpSourceClient = &http.Client{Timeout: 30 * time.Second}
+
+// Tasks 实现 TaskSource
+func (s HTTPSource) Tasks(ctx context.Context) ([]DownloadTask, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", string(s), nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %w", err)
+	}
+	if id, ok := ctx.Value(identityKey{}).(stats.Identity); ok {
+		id.SetHeader(req.Header)
+	}
+	resp, err := httpSourceClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求API失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("请求API失败: HTTP状态码 %d", resp.StatusCode)
+	}
+	return ParseTasks(resp.Body)
+}
+
+func (s HTTPSource) String() string { return "API " + string(s) }
+
+// StaticSource 固定的任务列表（例如配置文件中直接列出的任务）
+type StaticSource []DownloadTask
+
+// Tasks 实现 TaskSource
+func (s StaticSource) Tasks(ctx context.Context) ([]DownloadTask, error) {
+	return append([]DownloadTask(nil), s...), nil
+}
+
+func (s StaticSource) String() string { return fmt.Sprintf("配置中的 %d 个任务", len(s)) }
+
+// ReaderSource 从 io.Reader 读取任务，只读取一次，之后返回第一次的结果
+type ReaderSource struct {
+	name  string
+	r     io.Reader
+	once  sync.Once
+	tasks []DownloadTask
+	err   error
+}
+
+// NewReaderSource 创建从 r 读取任务的来源
+func NewReaderSource(name string, r io.Reader) *ReaderSource {
+	return &ReaderSource{name: name, r: r}
+}
+
+// StdinSource 从标准输入读取任务（读到 EOF 为止，刷新时不会重新读取）
+func StdinSource() *ReaderSource {
+	return NewReaderSource("标准输入", os.Stdin)
+}
+
+// Tasks 实现 TaskSource
+func (s *ReaderSource) Tasks(ctx context.Context) ([]DownloadTask, error) {
+	s.once.Do(func() {
+		s.tasks, s.err = ParseTasks(s.r)
+	})
+	return append([]DownloadTask(nil), s.tasks...), s.err
+}
+
+func (s *ReaderSource) String() string { return s.name }
+
+// MultiSource 合并多个来源并按 IP + URL 去重（保留第一次出现的顺序）
+// 部分来源失败时返回其余来源的任务以及失败原因，全部失败时只返回错误
+type MultiSource []TaskSource
+
+// Tasks 实现 TaskSource
+func (s MultiSource) Tasks(ctx context.Context) ([]DownloadTask, error) {
+	var tasks []DownloadTask
+	var errs []error
+	seen := make(map[string]bool)
+	failed := 0
+
+	for _, src := range s {
+		srcTasks, err := src.Tasks(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", src, err))
+			failed++
+			continue
+		}
+		for _, task := range srcTasks {
+			key := taskKey(task)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			tasks = append(tasks, task)
+		}
+	}
+
+	if failed > 0 && failed == len(s) {
+		return nil, errors.Join(errs...)
+	}
+	return tasks, errors.Join(errs...)
+}
+
+func (s MultiSource) String() string {
+	names := make([]string, len(s))
+	for i, src := range s {
+		names[i] = src.String()
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// WithTaskSource 设置任务来源，refresh 大于 0 时运行期间按该间隔重新加载任务列表
+func WithTaskSource(src TaskSource, refresh time.Duration) Option {
+	return func(d *Downloader) {
+		d.source = src
+		d.refresh = refresh
+	}
+}
+
+// WithTaskIdentity 设置请求 HTTP 任务来源时是否附加节点身份请求头（用于 netflood-collector 的任务分发），默认不附加
+func WithTaskIdentity(enabled bool) Option {
+	return func(d *Downloader) {
+		d.taskIdentity = enabled
+	}
+}
+
+// LoadTasks 从设置的任务来源加载任务
+// 部分来源失败但仍有任务时记录警告并使用已加载的任务
+func (d *Downloader) LoadTasks(ctx context.Context) error {
+	if d.source == nil {
+		return fmt.Errorf("没有设置任务来源")
+	}
+	return d.loadFrom(ctx, d.source)
+}
+
+// loadFrom 从来源加载任务并替换当前任务列表
+func (d *Downloader) loadFrom(ctx context.Context, src TaskSource) error {
+	if d.taskIdentity && (d.identity.RunID != "" || d.identity.NodeID != "" || d.identity.Name != "") {
+		ctx = ContextWithIdentity(ctx, d.identity)
+	}
+	tasks, err := src.Tasks(ctx)
+	if len(tasks) == 0 {
+		if err == nil {
+			err = fmt.Errorf("没有下载任务")
+		}
+		return err
+	}
+	if err != nil {
+		d.logger.Warn("部分任务来源加载失败", "error", err)
+	}
+	d.setTasks(tasks)
+	return nil
+}
+
+// setTasks 替换任务列表，分发位置超出新列表时从头开始
+func (d *Downloader) setTasks(tasks []DownloadTask) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.tasks = tasks
+	if d.cursor >= len(tasks) {
+		d.cursor = 0
+	}
+}
+
+// refreshTasks 定期重新加载任务列表，加载失败时保留原列表
+func (d *Downloader) refreshTasks(ctx context.Context) {
+	ticker := time.NewTicker(d.refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			before := d.GetTasks()
+			if err := d.loadFrom(ctx, d.source); err != nil {
+				d.logger.Warn("刷新任务列表失败，继续使用原任务列表", "source", d.source.String(), "error", err)
+				continue
+			}
+			if after := d.GetTasks(); !slices.Equal(before, after) {
+				d.logger.Info("任务列表已更新", "before", len(before), "after", len(after))
+			}
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+func writeTasks(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+}
+
+func TestParseTasks(t *testing.T) {
+	tasks, err := ParseTasks(strings.NewReader("1.1.1.1, http://a/x\n\ninvalid\n2.2.2.2,http://b/y?a=1,2\n"))
+	if err != nil {
+		t.Fatalf("ParseTasks() error = %v", err)
+	}
+	want := []DownloadTask{{IP: "1.1.1.1", URL: "http://a/x"}, {IP: "2.2.2.2", URL: "http://b/y?a=1,2"}}
+	if fmt.Sprint(tasks) != fmt.Sprint(want) {
+		t.Errorf("ParseTasks() = %v, want %v", tasks, want)
+	}
+}
+
+func TestParseSource(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "tasks.txt")
+	writeTasks(t, file, "1.1.1.1,http://a/x")
+
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{"-", "*downloader.ReaderSource", false},
+		{"https://api.example.com/tasks", "downloader.HTTPSource", false},
+		{dir, "downloader.DirSource", false},
+		{file, "downloader.FileSource", false},
+		{filepath.Join(dir, "missing.txt"), "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		src, err := ParseSource(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSource(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if got := fmt.Sprintf("%T", src); err == nil && got != tt.want {
+			t.Errorf("ParseSource(%q) = %s, want %s", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestDirSource(t *testing.T) {
+	dir := t.TempDir()
+	writeTasks(t, filepath.Join(dir, "b.txt"), "2.2.2.2,http://b/y")
+	writeTasks(t, filepath.Join(dir, "a.txt"), "1.1.1.1,http://a/x")
+	writeTasks(t, filepath.Join(dir, ".hidden"), "9.9.9.9,http://z/z")
+	os.Mkdir(filepath.Join(dir, "sub"), 0o755)
+
+	tasks, err := DirSource(dir).Tasks(context.Background())
+	if err != nil {
+		t.Fatalf("Tasks() error = %v", err)
+	}
+	if len(tasks) != 2 || tasks[0].IP != "1.1.1.1" || tasks[1].IP != "2.2.2.2" {
+		t.Errorf("Tasks() = %v, want a.txt then b.txt", tasks)
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tasks" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get(stats.HeaderNodeID) == "node-1" {
+			fmt.Fprintln(w, "2.2.2.2,http://b/y")
+		}
+		fmt.Fprintln(w, "1.1.1.1,http://a/x")
+	}))
+	defer server.Close()
+
+	tasks, err := HTTPSource(server.URL + "/tasks").Tasks(context.Background())
+	if err != nil || len(tasks) != 1 {
+		t.Errorf("Tasks() = %v, %v, want one task", tasks, err)
+	}
+	ctx := ContextWithIdentity(context.Background(), stats.Identity{NodeID: "node-1"})
+	if tasks, err := HTTPSource(server.URL + "/tasks").Tasks(ctx); err != nil || len(tasks) != 2 {
+		t.Errorf("Tasks() with identity = %v, %v, want the node's extra task", tasks, err)
+	}
+	if _, err := HTTPSource(server.URL + "/missing").Tasks(context.Background()); err == nil {
+		t.Error("Tasks() with 404: expected error, got nil")
+	}
+}
+
+func TestReaderSource_ReadsOnce(t *testing.T) {
+	src := NewReaderSource("test", strings.NewReader("1.1.1.1,http://a/x\n"))
+	for i := 0; i < 2; i++ {
+		tasks, err := src.Tasks(context.Background())
+		if err != nil || len(tasks) != 1 {
+			t.Errorf("call %d: Tasks() = %v, %v, want one task", i, tasks, err)
+		}
+	}
+}
+
+func TestMultiSource(t *testing.T) {
+	a := StaticSource{{IP: "1.1.1.1", URL: "http://a/x"}, {IP: "2.2.2.2", URL: "http://b/y"}}
+	b := StaticSource{{IP: "2.2.2.2", URL: "http://b/y"}, {IP: "3.3.3.3", URL: "http://c/z"}}
+	missing := FileSource(filepath.Join(t.TempDir(), "missing.txt"))
+
+	tasks, err := MultiSource{a, b}.Tasks(context.Background())
+	if err != nil {
+		t.Fatalf("Tasks() error = %v", err)
+	}
+	if len(tasks) != 3 || tasks[2].IP != "3.3.3.3" {
+		t.Errorf("Tasks() = %v, want 3 de-duplicated tasks in order", tasks)
+	}
+
+	// 部分失败：返回其余来源的任务和错误
+	tasks, err = MultiSource{missing, a}.Tasks(context.Background())
+	if err == nil || len(tasks) != 2 {
+		t.Errorf("partial failure: Tasks() = %v, %v, want 2 tasks and an error", tasks, err)
+	}
+
+	// 全部失败：只返回错误
+	tasks, err = MultiSource{missing}.Tasks(context.Background())
+	if err == nil || tasks != nil {
+		t.Errorf("all failed: Tasks() = %v, %v, want nil and an error", tasks, err)
+	}
+}
+
+func TestLoadTasks_KeepsPreviousOnFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.txt")
+	writeTasks(t, path, "1.1.1.1,http://a/x")
+
+	d := New(WithTaskSource(FileSource(path), 0))
+	if err := d.LoadTasks(context.Background()); err != nil {
+		t.Fatalf("LoadTasks() error = %v", err)
+	}
+
+	os.Remove(path)
+	if err := d.LoadTasks(context.Background()); err == nil {
+		t.Error("LoadTasks() with missing file: expected error, got nil")
+	}
+	if got := d.GetTasks(); len(got) != 1 {
+		t.Errorf("GetTasks() = %v, want previous task list", got)
+	}
+
+	writeTasks(t, path, "")
+	if err := d.LoadTasks(context.Background()); err == nil || errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadTasks() with empty file error = %v, want 没有下载任务", err)
+	}
+}
+
+func TestLoadTasks_TaskIdentity(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get(stats.HeaderNodeID))
+		fmt.Fprintln(w, "1.1.1.1,http://a/x")
+	}))
+	defer server.Close()
+
+	id := stats.Identity{NodeID: "node-1"}
+	for _, enabled := range []bool{false, true} {
+		d := New(WithIdentity(id), WithTaskSource(HTTPSource(server.URL), 0), WithTaskIdentity(enabled))
+		if err := d.LoadTasks(context.Background()); err != nil {
+			t.Fatalf("LoadTasks() error = %v", err)
+		}
+	}
+	if len(got) != 2 || got[0] != "" || got[1] != "node-1" {
+		t.Errorf("node ID headers = %q, want only sent with WithTaskIdentity(true)", got)
+	}
+}
+
+func TestStart_RefreshesTasks(t *testing.T) {
+	url := newPayloadServer(t, 1024)
+	path := filepath.Join(t.TempDir(), "tasks.txt")
+	writeTasks(t, path, "127.0.0.1,"+url+"?a")
+
+	d := New(WithWorkers(1), WithTaskSource(FileSource(path), 50*time.Millisecond))
+	if err := d.LoadTasks(context.Background()); err != nil {
+		t.Fatalf("LoadTasks() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- d.Start(ctx) }()
+
+	writeTasks(t, path, "127.0.0.1,"+url+"?a", "127.0.0.1,"+url+"?b")
+	deadline := time.Now().Add(3 * time.Second)
+	for len(d.GetTasks()) != 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if got := d.GetTasks(); len(got) != 2 {
+		t.Errorf("GetTasks() = %v, want refreshed list with 2 tasks", got)
+	}
+}
@@ -0,0 +1,143 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStalled 传输速度持续低于阈值，连接被主动中止
+var ErrStalled = errors.New("传输停滞")
+
+// DefaultStallWindow 默认停滞检测窗口
+const DefaultStallWindow = 10 * time.Second
+
+// StallPolicy 停滞检测配置
+type StallPolicy struct {
+	MinSpeed int64         // 最低平均速度（字节/秒），0 表示不检测
+	Window   time.Duration // 计算平均速度的时间窗口
+}
+
+// SetStallPolicy 设置停滞检测：传输在一个窗口内的平均速度低于 minSpeed 时中止
+func (d *Downloader) SetStallPolicy(minSpeed int64, window time.Duration) {
+	if window <= 0 {
+		window = DefaultStallWindow
+	}
+	d.stall = StallPolicy{MinSpeed: minSpeed, Window: window}
+}
+
+// throttleClock 累计单次传输在限速器中等待的时间
+// 正在进行的等待也计入，等待跨越检测窗口时不会被误判为停滞
+type throttleClock struct {
+	mu    sync.Mutex
+	total time.Duration // 已结束的等待的总时长
+	since time.Time     // 正在进行的等待的开始时间，零值表示没有在等待
+}
+
+// wait 调用 fn 并把耗时计入等待时间
+func (c *throttleClock) wait(fn func() error) error {
+	c.mu.Lock()
+	c.since = time.Now()
+	c.mu.Unlock()
+
+	err := fn()
+
+	c.mu.Lock()
+	c.total += time.Since(c.since)
+	c.since = time.Time{}
+	c.mu.Unlock()
+	return err
+}
+
+// load 返回截至目前的累计等待时间
+func (c *throttleClock) load() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.since.IsZero() {
+		return c.total
+	}
+	return c.total + time.Since(c.since)
+}
+
+// watchStall 按窗口检查单次传输的字节数，低于阈值时以 ErrStalled 取消请求
+// 在限速器中等待的时间不计入窗口，累计满一个窗口的非等待时间才检查一次：限速低于 MinSpeed 时传输不会被判为停滞
+// 返回的函数用于在传输结束后停止监控
+func (d *Downloader) watchStall(cancel context.CancelCauseFunc, transferred *atomic.Int64, throttled *throttleClock) (stop func()) {
+	policy := d.stall
+	minBytes := int64(float64(policy.MinSpeed) * policy.Window.Seconds())
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(policy.Window)
+		defer ticker.Stop()
+
+		var last int64
+		var active, lastThrottled time.Duration
+		lastTick := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				now, waited := time.Now(), throttled.load()
+				active += now.Sub(lastTick) - (waited - lastThrottled)
+				lastTick, lastThrottled = now, waited
+				if active < policy.Window {
+					continue
+				}
+				current := transferred.Load()
+				if current-last < minBytes {
+					cancel(ErrStalled)
+					return
+				}
+				last, active = current, 0
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// recordStall 记录一次停滞（总体、任务、IP 三个维度）
+func (d *Downloader) recordStall(task DownloadTask) {
+	byTask, byIP := d.statsFor(task)
+	d.stalls.Add(1)
+	byTask.stalls.Add(1)
+	byIP.stalls.Add(1)
+}
+
+// Stalls 返回停滞中止的总次数
+func (d *Downloader) Stalls() int64 {
+	return d.stalls.Load()
+}
+
+// StallsByIP 返回每个 IP 的停滞次数
+func (d *Downloader) StallsByIP() map[string]int64 {
+	d.statsMu.Lock()
+	defer d.statsMu.Unlock()
+
+	result := make(map[string]int64, len(d.ipStats))
+	for ip, s := range d.ipStats {
+		if n := s.stalls.Load(); n > 0 {
+			result[ip] = n
+		}
+	}
+	return result
+}
+
+// StallsByTask 返回每个任务的停滞次数（键为 "IP,URL"）
+func (d *Downloader) StallsByTask() map[string]int64 {
+	d.statsMu.Lock()
+	defer d.statsMu.Unlock()
+
+	result := make(map[string]int64, len(d.taskStats))
+	for key, s := range d.taskStats {
+		if n := s.stalls.Load(); n > 0 {
+			result[key] = n
+		}
+	}
+	return result
+}
@@ -0,0 +1,141 @@
+package downloader
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/dora-exku/netflood/pkg/stats"
+)
+
+// taskStats 单个任务或单个 IP 维度的统计
+type taskStats struct {
+	timing    stats.TimingRecorder // 请求耗时分解
+	bytes     atomic.Int64         // 下载字节数
+	stalls    atomic.Int64         // 停滞中止次数
+	completed atomic.Int64         // 成功次数
+	failed    atomic.Int64         // 失败次数
+	active    atomic.Int64         // 正在进行的下载数
+}
+
+// taskKey 返回任务的唯一标识
+func taskKey(task DownloadTask) string {
+	return task.IP + "," + task.URL
+}
+
+// statsFor 获取任务和 IP 对应的统计对象（不存在时创建）
+func (d *Downloader) statsFor(task DownloadTask) (byTask, byIP *taskStats) {
+	d.statsMu.Lock()
+	defer d.statsMu.Unlock()
+
+	if d.taskStats == nil {
+		d.taskStats = make(map[string]*taskStats)
+		d.ipStats = make(map[string]*taskStats)
+	}
+
+	key := taskKey(task)
+	byTask = d.taskStats[key]
+	if byTask == nil {
+		byTask = &taskStats{}
+		d.taskStats[key] = byTask
+	}
+
+	byIP = d.ipStats[task.IP]
+	if byIP == nil {
+		byIP = &taskStats{}
+		d.ipStats[task.IP] = byIP
+	}
+	return byTask, byIP
+}
+
+// recordTiming 记录一次请求的耗时（总体、任务、IP 三个维度）
+func (d *Downloader) recordTiming(task DownloadTask, timing stats.RequestTiming) {
+	byTask, byIP := d.statsFor(task)
+	d.timing.Record(timing)
+	byTask.timing.Record(timing)
+	byIP.timing.Record(timing)
+}
+
+// recordBytes 记录一次传输的字节数（任务、IP 两个维度，总量由分片计数器统计）
+func (d *Downloader) recordBytes(task DownloadTask, n int64) {
+	if n <= 0 {
+		return
+	}
+	byTask, byIP := d.statsFor(task)
+	byTask.bytes.Add(n)
+	byIP.bytes.Add(n)
+}
+
+// trackActive 记录任务开始（delta 为 1）或结束（delta 为 -1）
+func (d *Downloader) trackActive(task DownloadTask, delta int64) {
+	byTask, _ := d.statsFor(task)
+	byTask.active.Add(delta)
+	d.active.Add(delta)
+}
+
+// recordResult 记录一次下载的结果（总体、任务、IP 三个维度）
+func (d *Downloader) recordResult(task DownloadTask, err error) {
+	byTask, byIP := d.statsFor(task)
+	if err != nil {
+		d.failed.Add(1)
+		byTask.failed.Add(1)
+		byIP.failed.Add(1)
+		return
+	}
+	d.completed.Add(1)
+	byTask.completed.Add(1)
+	byIP.completed.Add(1)
+}
+
+// Completed 返回成功完成的下载次数
+func (d *Downloader) Completed() int64 {
+	return d.completed.Load()
+}
+
+// Failed 返回失败的下载次数
+func (d *Downloader) Failed() int64 {
+	return d.failed.Load()
+}
+
+// BytesDownloaded 返回已下载的总字节数
+func (d *Downloader) BytesDownloaded() int64 {
+	return d.bytesDownloaded.Load()
+}
+
+// LatencySummary 返回总体耗时分位数
+func (d *Downloader) LatencySummary() stats.LatencySummary {
+	return d.timing.Summary()
+}
+
+// LatencyByIP 返回每个 IP 的耗时分位数
+func (d *Downloader) LatencyByIP() map[string]stats.LatencySummary {
+	d.statsMu.Lock()
+	defer d.statsMu.Unlock()
+
+	result := make(map[string]stats.LatencySummary, len(d.ipStats))
+	for ip, s := range d.ipStats {
+		result[ip] = s.timing.Summary()
+	}
+	return result
+}
+
+// LatencyByTask 返回每个任务的耗时分位数（键为 "IP,URL"）
+func (d *Downloader) LatencyByTask() map[string]stats.LatencySummary {
+	d.statsMu.Lock()
+	defer d.statsMu.Unlock()
+
+	result := make(map[string]stats.LatencySummary, len(d.taskStats))
+	for key, s := range d.taskStats {
+		result[key] = s.timing.Summary()
+	}
+	return result
+}
+
+// sortedKeys 返回排序后的键，保证输出顺序稳定
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,231 @@
+package downloader
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/stats"
+	"github.com/dora-exku/netflood/pkg/tcpproto"
+	"github.com/dora-exku/netflood/pkg/units"
+)
+
+// DefaultTCPBytes tcp:// 任务未设置 bytes 和 duration 时每次传输的字节数
+const DefaultTCPBytes = 1 << 30
+
+// tcpReplyTimeout 上传结束后等待服务器确认的超时
+const tcpReplyTimeout = 30 * time.Second
+
+// tcpPayloadBlock 上传的数据块：伪随机数据，避免链路上的压缩影响结果
+var tcpPayloadBlock = func() []byte {
+	p := make([]byte, DefaultBufferSize)
+	rand.NewChaCha8([32]byte{'t', 'c', 'p'}).Read(p)
+	return p
+}()
+
+// tcpTarget 解析后的 tcp:// 任务
+type tcpTarget struct {
+	addr     string        // 连接地址：任务的 IP 加上 URL 中的端口
+	dir      string        // tcpproto.Download 或 tcpproto.Upload
+	bytes    int64         // 每次传输的字节数，0 表示只按时长结束
+	duration time.Duration // 每次传输的时长，0 表示只按字节数结束
+}
+
+// isTCPTask 任务是否为 tcp:// 原始 TCP 传输
+func isTCPTask(task DownloadTask) bool {
+	return strings.HasPrefix(task.URL, "tcp://")
+}
+
+// parseTCPTask 解析 tcp://host:port[?dir=upload][&bytes=1GB][&duration=30s]：
+// 连接任务的 IP（为空时连接 URL 中的主机），dir 默认为 download；
+// bytes 和 duration 先到者结束本次传输，都不设置时传输 DefaultTCPBytes
+func parseTCPTask(task DownloadTask) (tcpTarget, error) {
+	u, err := url.Parse(task.URL)
+	if err != nil {
+		return tcpTarget{}, fmt.Errorf("解析任务地址失败: %w", err)
+	}
+	if u.Port() == "" {
+		return tcpTarget{}, fmt.Errorf("tcp 任务缺少端口: %s", task.URL)
+	}
+	query := u.Query()
+	t := tcpTarget{
+		addr: net.JoinHostPort(cmp.Or(task.IP, u.Hostname()), u.Port()),
+		dir:  cmp.Or(query.Get("dir"), tcpproto.Download),
+	}
+	if t.dir != tcpproto.Download && t.dir != tcpproto.Upload {
+		return tcpTarget{}, fmt.Errorf("tcp 任务的 dir 应为 download 或 upload: %s", t.dir)
+	}
+	if v := query.Get("duration"); v != "" {
+		if t.duration, err = time.ParseDuration(v); err != nil || t.duration <= 0 {
+			return tcpTarget{}, fmt.Errorf("tcp 任务的 duration 无效: %s", v)
+		}
+	}
+	if v := query.Get("bytes"); v != "" {
+		if t.bytes, err = units.ParseBytes(v); err != nil || t.bytes <= 0 {
+			return tcpTarget{}, fmt.Errorf("tcp 任务的 bytes 无效: %s", v)
+		}
+	} else if t.duration == 0 {
+		t.bytes = DefaultTCPBytes
+	}
+	return t, nil
+}
+
+// transferTCP 与 netflood serve -tcp 进行一次原始 TCP 传输，返回本次传输的字节数；
+// 上传的字节数与下载一样计入统计
+func (d *Downloader) transferTCP(task DownloadTask, shard *counterShard) (int64, error) {
+	target, err := parseTCPTask(task)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	ctx, cancel := context.WithCancelCause(d.abortCtx)
+	defer cancel(nil)
+	stopPause := context.AfterFunc(d.pauseContext(), func() { cancel(ErrPaused) })
+	defer stopPause()
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", target.addr)
+	if err != nil {
+		return 0, d.transferError(ctx, task, "连接失败", err)
+	}
+	defer conn.Close()
+	// 取消（暂停、停滞、运行边界）时关闭连接，中断正在进行的读写
+	stopClose := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stopClose()
+	timing := stats.RequestTiming{Connect: time.Since(start)}
+
+	// 握手：发送请求行，等待服务器确认
+	conn.SetDeadline(time.Now().Add(tcpReplyTimeout))
+	r := bufio.NewReader(conn)
+	if _, err := io.WriteString(conn, tcpproto.Request(target.dir, target.bytes)); err != nil {
+		return 0, d.transferError(ctx, task, "发送请求失败", err)
+	}
+	reply, err := r.ReadString('\n')
+	if err != nil {
+		return 0, d.transferError(ctx, task, "读取握手失败", err)
+	}
+	if reply = strings.TrimSpace(reply); reply != "OK" {
+		return 0, fmt.Errorf("服务器拒绝: %s", strings.TrimPrefix(reply, "ERR "))
+	}
+	conn.SetDeadline(time.Time{})
+	timing.TTFB = time.Since(start)
+	transferStart := time.Now()
+
+	writer, stop := d.transferWriter(ctx, cancel, task, shard)
+	defer stop()
+	var n int64
+	if target.dir == tcpproto.Download {
+		n, err = d.receiveTCP(conn, r, writer, target, transferStart)
+	} else {
+		n, err = d.sendTCP(conn, r, writer, target, transferStart)
+	}
+	d.recordBytes(task, n)
+	if err != nil {
+		return n, d.transferError(ctx, task, "传输失败", err)
+	}
+
+	timing.Transfer = time.Since(transferStart)
+	d.recordTiming(task, timing)
+	return n, nil
+}
+
+// receiveTCP 下载方向：读到服务器关闭连接或到达时长
+func (d *Downloader) receiveTCP(conn net.Conn, r io.Reader, writer countingWriter, target tcpTarget, start time.Time) (int64, error) {
+	if target.duration > 0 {
+		conn.SetReadDeadline(start.Add(target.duration))
+	}
+	n, err := d.buffers.drain(writer, r)
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded) && target.duration > 0:
+		return n, nil
+	case err != nil:
+		return n, err
+	case target.bytes > 0 && n < target.bytes:
+		return n, fmt.Errorf("连接提前关闭，收到 %d / %d 字节: %w", n, target.bytes, io.ErrUnexpectedEOF)
+	}
+	return n, nil
+}
+
+// sendTCP 上传方向：发送到字节数或时长后关闭写方向，等待服务器确认收到的字节数
+func (d *Downloader) sendTCP(conn net.Conn, r *bufio.Reader, writer countingWriter, target tcpTarget, start time.Time) (int64, error) {
+	payload := &tcpPayload{remaining: target.bytes}
+	if target.bytes == 0 {
+		payload.remaining = -1
+	}
+	if target.duration > 0 {
+		payload.until = start.Add(target.duration)
+	}
+	// 写入连接成功后再计数，计数时按限速器等待
+	buf := d.buffers.Get()
+	defer d.buffers.Put(buf)
+	n, err := io.CopyBuffer(sentWriter{conn: conn, counter: writer}, payload, *buf)
+	if err != nil {
+		return n, err
+	}
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		if err := cw.CloseWrite(); err != nil {
+			return n, err
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(tcpReplyTimeout))
+	reply, err := r.ReadString('\n')
+	if err != nil {
+		return n, fmt.Errorf("读取服务器确认失败: %w", err)
+	}
+	received, err := strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(reply), "OK "), 10, 64)
+	if err != nil {
+		return n, fmt.Errorf("服务器确认无效: %q", strings.TrimSpace(reply))
+	}
+	if received != n {
+		return n, fmt.Errorf("服务器只收到 %d / %d 字节", received, n)
+	}
+	return n, nil
+}
+
+// tcpPayload 上传的数据源：发送 remaining 字节（小于 0 表示不限）或到 until 为止
+type tcpPayload struct {
+	remaining int64
+	until     time.Time
+}
+
+// Read 实现 io.Reader
+func (p *tcpPayload) Read(b []byte) (int, error) {
+	if p.remaining == 0 || !p.until.IsZero() && !time.Now().Before(p.until) {
+		return 0, io.EOF
+	}
+	n := copy(b, tcpPayloadBlock)
+	if p.remaining > 0 {
+		n = int(min(int64(n), p.remaining))
+		p.remaining -= int64(n)
+	}
+	return n, nil
+}
+
+// sentWriter 写入连接后把实际写入的字节数计入 counter
+// 不实现 io.ReaderFrom，使 io.CopyBuffer 使用池化缓冲区
+type sentWriter struct {
+	conn    io.Writer
+	counter countingWriter
+}
+
+// Write 实现 io.Writer
+func (w sentWriter) Write(p []byte) (int, error) {
+	n, err := w.conn.Write(p)
+	if n > 0 {
+		if _, cerr := w.counter.Write(p[:n]); err == nil {
+			err = cerr
+		}
+	}
+	return n, err
+}
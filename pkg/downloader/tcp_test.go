@@ -0,0 +1,172 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/server"
+	"github.com/dora-exku/netflood/pkg/tcpproto"
+)
+
+// newTCPServer 启动 netflood serve -tcp 使用的 TCP 测试服务器，返回监听地址
+func newTCPServer(tb testing.TB, opts server.Options) (string, *server.Server) {
+	tb.Helper()
+	s := server.New(opts)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.ServeTCP(ctx, ln)
+	}()
+	tb.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return ln.Addr().String(), s
+}
+
+func TestParseTCPTask(t *testing.T) {
+	tests := []struct {
+		task    DownloadTask
+		want    tcpTarget
+		wantErr bool
+	}{
+		{DownloadTask{IP: "10.0.0.1", URL: "tcp://peer.example.com:5201"}, tcpTarget{addr: "10.0.0.1:5201", dir: tcpproto.Download, bytes: DefaultTCPBytes}, false},
+		{DownloadTask{URL: "tcp://peer.example.com:5201?dir=upload&bytes=100MB"}, tcpTarget{addr: "peer.example.com:5201", dir: tcpproto.Upload, bytes: 100 << 20}, false},
+		{DownloadTask{IP: "::1", URL: "tcp://peer:5201?duration=30s"}, tcpTarget{addr: "[::1]:5201", dir: tcpproto.Download, duration: 30 * time.Second}, false},
+		{DownloadTask{URL: "tcp://peer:5201?duration=10s&bytes=1GB"}, tcpTarget{addr: "peer:5201", dir: tcpproto.Download, bytes: 1 << 30, duration: 10 * time.Second}, false},
+		{DownloadTask{URL: "tcp://peer"}, tcpTarget{}, true},
+		{DownloadTask{URL: "tcp://peer:5201?dir=sideways"}, tcpTarget{}, true},
+		{DownloadTask{URL: "tcp://peer:5201?bytes=0"}, tcpTarget{}, true},
+		{DownloadTask{URL: "tcp://peer:5201?duration=-1s"}, tcpTarget{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTCPTask(tt.task)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseTCPTask(%v) = %+v, %v, want %+v, error %v", tt.task, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDownloadTask_TCP(t *testing.T) {
+	addr, srv := newTCPServer(t, server.Options{})
+	_, port, _ := net.SplitHostPort(addr)
+	base := "tcp://peer.invalid:" + port
+
+	tests := []struct {
+		name, query string
+		want        int64 // 传输的字节数，小于 0 时只检查大于 0
+	}{
+		{"download", "?bytes=1MB", 1 << 20},
+		{"upload", "?dir=upload&bytes=1MB", 1 << 20},
+		{"download duration", "?duration=200ms", -1},
+		{"upload duration", "?dir=upload&duration=200ms", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := New(WithWorkers(1))
+			d.SetMaxRate(10 << 20)
+			start := time.Now()
+			n, err := d.downloadTask(DownloadTask{IP: "127.0.0.1", URL: base + tt.query}, d.bytesDownloaded.shard(0))
+			if err != nil {
+				t.Fatalf("downloadTask() error = %v", err)
+			}
+			if tt.want >= 0 && n != tt.want || n <= 0 {
+				t.Errorf("downloadTask() = %d bytes, want %d", n, tt.want)
+			}
+			if tt.want < 0 && time.Since(start) > 2*time.Second {
+				t.Errorf("duration transfer took %v", time.Since(start))
+			}
+			if got := d.bytesDownloaded.Load(); got != n {
+				t.Errorf("bytesDownloaded = %d, want %d", got, n)
+			}
+		})
+	}
+
+	if st := srv.Stats(); st.Bytes < 1<<20 || st.Received < 1<<20 {
+		t.Errorf("server Stats() = %+v", st)
+	}
+
+	// 设置了 MaxSize 的服务器拒绝超过上限的下载，只按时长结束的下载发送到上限为止
+	limited, _ := newTCPServer(t, server.Options{MaxSize: 1 << 20})
+	d := New(WithWorkers(1))
+	if _, err := d.downloadTask(DownloadTask{URL: "tcp://" + limited + "?bytes=2MB"}, d.bytesDownloaded.shard(0)); err == nil || !strings.Contains(err.Error(), "服务器拒绝") {
+		t.Errorf("downloadTask(bytes=2MB) over MaxSize error = %v, want rejection", err)
+	}
+	if n, err := d.downloadTask(DownloadTask{URL: "tcp://" + limited + "?duration=10s"}, d.bytesDownloaded.shard(0)); err != nil || n != 1<<20 {
+		t.Errorf("downloadTask(duration=10s) = %d, %v, want MaxSize bytes", n, err)
+	}
+}
+
+func TestDownloadTask_TCPConnectionClosed(t *testing.T) {
+	// 服务器在发送完之前关闭连接
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Read(make([]byte, 64))
+		io.WriteString(conn, "OK\n")
+		conn.Write(make([]byte, 1000))
+	}()
+
+	d := New(WithWorkers(1))
+	n, err := d.downloadTask(DownloadTask{URL: "tcp://" + ln.Addr().String() + "?bytes=1MB"}, d.bytesDownloaded.shard(0))
+	if !errors.Is(err, io.ErrUnexpectedEOF) || n != 1000 {
+		t.Errorf("downloadTask() = %d, %v, want 1000 bytes and io.ErrUnexpectedEOF", n, err)
+	}
+}
+
+func TestStart_TCPTasks(t *testing.T) {
+	addr, srv := newTCPServer(t, server.Options{})
+
+	d := New(WithWorkers(2))
+	d.tasks = []DownloadTask{
+		{IP: "127.0.0.1", URL: "tcp://" + addr + "?bytes=256KB"},
+		{IP: "127.0.0.1", URL: "tcp://" + addr + "?dir=upload&bytes=256KB"},
+	}
+	runWithLimits(t, d, RunLimits{Iterations: 2})
+
+	if d.Completed() != 4 || d.Failed() != 0 || d.bytesDownloaded.Load() != 4*256<<10 {
+		t.Errorf("Completed/Failed/bytes = %d/%d/%d, want 4/0/%d", d.Completed(), d.Failed(), d.bytesDownloaded.Load(), 4*256<<10)
+	}
+	if st := srv.Stats(); st.Requests != 4 || st.Bytes != 2*256<<10 || st.Received != 2*256<<10 {
+		t.Errorf("server Stats() = %+v", st)
+	}
+}
+
+// shortWriter 只接受前 limit 字节，之后返回错误
+type shortWriter struct{ limit int }
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	n := min(len(p), w.limit)
+	w.limit -= n
+	if n < len(p) {
+		return n, io.ErrClosedPipe
+	}
+	return n, nil
+}
+
+func TestSentWriter_CountsWrittenBytes(t *testing.T) {
+	var counter shardedCounter
+	w := sentWriter{conn: &shortWriter{limit: 1500}, counter: countingWriter{shard: counter.shard(0)}}
+	n, err := io.Copy(w, io.LimitReader(&tcpPayload{remaining: -1}, 4096))
+	if !errors.Is(err, io.ErrClosedPipe) || n != 1500 || counter.Load() != 1500 {
+		t.Errorf("Copy() = %d, %v, counted %d, want 1500 bytes counted and io.ErrClosedPipe", n, err, counter.Load())
+	}
+}
@@ -0,0 +1,342 @@
+package history
+
+import (
+	"cmp"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 历史记录格式
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// rotatedSuffixLayout 轮转文件的时间后缀
+const rotatedSuffixLayout = "20060102-150405"
+
+// rotateRetryInterval 按大小轮转失败后，间隔多久再重试
+const rotateRetryInterval = time.Minute
+
+// Record 一条速度记录（速度单位 MB/s）
+type Record struct {
+	Time       time.Time `json:"time"`
+	Speed      float64   `json:"speed"`     // 本周期速度
+	AvgSpeed   float64   `json:"avg_speed"` // 平均速度
+	TotalBytes int64     `json:"total_bytes"`
+	Completed  int64     `json:"completed"`
+	Failed     int64     `json:"failed"`
+}
+
+// csvHeader CSV 表头
+var csvHeader = []string{"time", "speed", "avg_speed", "total_bytes", "completed", "failed"}
+
+// Options 历史记录配置
+type Options struct {
+	Path     string // 文件路径
+	Format   string // csv 或 jsonl，默认 csv
+	MaxSize  int64  // 单个文件的最大字节数，超过后轮转，0 表示不按大小轮转
+	Daily    bool   // 跨天时轮转
+	MaxFiles int    // 保留的轮转文件数量，0 表示全部保留
+	Compress bool   // 轮转后使用 gzip 压缩旧文件
+
+	Logger *slog.Logger // 记录轮转失败，默认丢弃
+}
+
+// Writer 追加写入的速度历史记录，支持按大小或日期轮转
+type Writer struct {
+	opts   Options
+	logger *slog.Logger
+	mu     sync.Mutex
+	file   *os.File // 为 nil 且未关闭时，下次写入重新打开
+	closed bool
+	size   int64
+	day    string // 当前文件对应的日期（按日期轮转时使用）
+	csv    *csv.Writer
+
+	retryAt time.Time                           // 按大小轮转失败后，在此之前不再重试
+	rename  func(oldpath, newpath string) error // 轮转使用的重命名，测试中替换
+}
+
+// NewWriter 创建历史记录写入器（文件已存在时继续追加）
+func NewWriter(opts Options) (*Writer, error) {
+	if opts.Path == "" {
+		return nil, fmt.Errorf("历史记录文件路径不能为空")
+	}
+	switch opts.Format {
+	case "":
+		opts.Format = FormatCSV
+	case FormatCSV, FormatJSONL:
+	default:
+		return nil, fmt.Errorf("不支持的历史记录格式: %s (应为 csv 或 jsonl)", opts.Format)
+	}
+
+	w := &Writer{opts: opts, logger: cmp.Or(opts.Logger, slog.New(slog.DiscardHandler)), rename: os.Rename}
+	if err := w.open(time.Now()); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open 打开（或创建）当前文件
+func (w *Writer) open(now time.Time) error {
+	file, err := os.OpenFile(w.opts.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("打开历史记录文件失败: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("读取历史记录文件信息失败: %w", err)
+	}
+
+	w.file = file
+	w.size = info.Size()
+	w.day = now.Format("2006-01-02")
+	if info.Size() > 0 {
+		// 续写已有文件时，以文件最后修改的日期为准
+		w.day = info.ModTime().Format("2006-01-02")
+	}
+	w.csv = csv.NewWriter(countingWriter{w})
+
+	// 新文件写入 CSV 表头
+	if w.opts.Format == FormatCSV && w.size == 0 {
+		w.csv.Write(csvHeader)
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return fmt.Errorf("写入历史记录失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// countingWriter 统计写入当前文件的字节数
+type countingWriter struct {
+	w *Writer
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.file.Write(p)
+	c.w.size += int64(n)
+	return n, err
+}
+
+// Write 追加一条记录，必要时先轮转文件
+func (w *Writer) Write(r Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return fmt.Errorf("历史记录已关闭")
+	}
+	if w.file == nil {
+		// 上次轮转后重新打开失败，再试一次
+		if err := w.open(r.Time); err != nil {
+			return err
+		}
+	}
+
+	if w.shouldRotate(r.Time) {
+		if err := w.rotate(r.Time); err != nil {
+			return err
+		}
+	}
+
+	switch w.opts.Format {
+	case FormatJSONL:
+		data, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("序列化历史记录失败: %w", err)
+		}
+		if _, err := (countingWriter{w}).Write(append(data, '\n')); err != nil {
+			return fmt.Errorf("写入历史记录失败: %w", err)
+		}
+	default:
+		w.csv.Write([]string{
+			r.Time.Format(time.RFC3339),
+			strconv.FormatFloat(r.Speed, 'f', 2, 64),
+			strconv.FormatFloat(r.AvgSpeed, 'f', 2, 64),
+			strconv.FormatInt(r.TotalBytes, 10),
+			strconv.FormatInt(r.Completed, 10),
+			strconv.FormatInt(r.Failed, 10),
+		})
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return fmt.Errorf("写入历史记录失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// shouldRotate 判断写入下一条记录前是否需要轮转
+func (w *Writer) shouldRotate(now time.Time) bool {
+	if w.opts.Daily && now.Format("2006-01-02") != w.day {
+		return true
+	}
+	return w.opts.MaxSize > 0 && w.size >= w.opts.MaxSize && !now.Before(w.retryAt)
+}
+
+// rotate 关闭当前文件，重命名为带时间后缀的文件，然后打开新文件；
+// 重命名失败时继续写入原文件，压缩和清理旧文件失败只记录日志，都不会中断历史记录
+func (w *Writer) rotate(now time.Time) error {
+	if err := w.file.Close(); err != nil {
+		w.logger.Warn("关闭历史记录文件失败", "path", w.opts.Path, "error", err)
+	}
+	w.file = nil
+
+	rotated := w.rotatedName(now)
+	renameErr := w.rename(w.opts.Path, rotated)
+	if renameErr != nil {
+		w.logger.Warn("轮转历史记录文件失败", "path", w.opts.Path, "error", renameErr)
+	} else {
+		if w.opts.Compress {
+			if err := compressFile(rotated); err != nil {
+				w.logger.Warn("压缩历史记录文件失败", "path", rotated, "error", err)
+			}
+		}
+		if err := w.prune(); err != nil {
+			w.logger.Warn("清理旧的历史记录文件失败", "path", w.opts.Path, "error", err)
+		}
+	}
+	if err := w.open(now); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		// 不在每次写入都重试：按日期轮转到下一天再轮转，按大小轮转等待一段时间后重试
+		w.day = now.Format("2006-01-02")
+		w.retryAt = now.Add(rotateRetryInterval)
+	}
+	return nil
+}
+
+// rotatedName 返回不与已有文件冲突的轮转文件名
+func (w *Writer) rotatedName(now time.Time) string {
+	base := w.opts.Path + "." + now.Format(rotatedSuffixLayout)
+	name := base
+	for i := 1; fileExists(name) || fileExists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s.%d", base, i)
+	}
+	return name
+}
+
+// prune 删除超出保留数量的旧文件
+func (w *Writer) prune() error {
+	if w.opts.MaxFiles <= 0 {
+		return nil
+	}
+
+	files, err := Rotated(w.opts.Path)
+	if err != nil {
+		return err
+	}
+	for len(files) > w.opts.MaxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			return fmt.Errorf("删除旧的历史记录文件失败: %w", err)
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// Close 关闭写入器
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// Rotated 返回 path 对应的所有轮转文件，按时间从旧到新排序
+func Rotated(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("查找轮转文件失败: %w", err)
+	}
+
+	var files []string
+	for _, m := range matches {
+		suffix := strings.TrimPrefix(m, path+".")
+		if len(suffix) >= len(rotatedSuffixLayout) {
+			if _, err := time.Parse(rotatedSuffixLayout, suffix[:len(rotatedSuffixLayout)]); err == nil {
+				files = append(files, m)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// compressFile 把文件压缩为 .gz 并删除原文件
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("打开待压缩文件失败: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path + ".gz")
+	if err != nil {
+		return fmt.Errorf("创建压缩文件失败: %w", err)
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("压缩文件失败: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		return fmt.Errorf("压缩文件失败: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("压缩文件失败: %w", err)
+	}
+	return os.Remove(path)
+}
+
+// fileExists 判断文件是否存在
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// WriteFileAtomic 先写临时文件再重命名，读取方不会看到空文件或写了一半的内容
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // 重命名成功后为空操作
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("写入临时文件失败: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("写入临时文件失败: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("设置文件权限失败: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("替换文件失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) error = %v", path, err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestWriter_CSVAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "speed.csv")
+	now := time.Now()
+
+	w, err := NewWriter(Options{Path: path})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := w.Write(Record{Time: now, Speed: 1.5, TotalBytes: int64(i)}); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	w.Close()
+
+	// 重新打开后继续追加，不重复写表头
+	w, err = NewWriter(Options{Path: path})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	w.Write(Record{Time: now, Speed: 2})
+	w.Close()
+
+	lines := readLines(t, path)
+	if len(lines) != 5 {
+		t.Fatalf("got %d lines, want 5 (header + 4 records): %q", len(lines), lines)
+	}
+	if lines[0] != strings.Join(csvHeader, ",") {
+		t.Errorf("header = %q", lines[0])
+	}
+	if !strings.Contains(lines[1], ",1.50,") {
+		t.Errorf("record = %q, want speed 1.50", lines[1])
+	}
+}
+
+func TestWriter_JSONL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "speed.jsonl")
+	w, err := NewWriter(Options{Path: path, Format: FormatJSONL})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	w.Write(Record{Time: time.Now(), Speed: 3.25, TotalBytes: 1024})
+	w.Close()
+
+	var r Record
+	if err := json.Unmarshal([]byte(readLines(t, path)[0]), &r); err != nil {
+		t.Fatalf("invalid JSON line: %v", err)
+	}
+	if r.Speed != 3.25 || r.TotalBytes != 1024 {
+		t.Errorf("record = %+v", r)
+	}
+}
+
+func TestNewWriter_InvalidFormat(t *testing.T) {
+	if _, err := NewWriter(Options{Path: filepath.Join(t.TempDir(), "x"), Format: "xml"}); err == nil {
+		t.Error("NewWriter() with invalid format: expected error, got nil")
+	}
+}
+
+func TestWriter_RotateBySizeKeepsFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "speed.jsonl")
+	w, err := NewWriter(Options{Path: path, Format: FormatJSONL, MaxSize: 1, MaxFiles: 2})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	start := time.Date(2025, 10, 25, 12, 0, 0, 0, time.Local)
+	for i := 0; i < 5; i++ {
+		if err := w.Write(Record{Time: start.Add(time.Duration(i) * time.Second), TotalBytes: int64(i)}); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	w.Close()
+
+	rotated, err := Rotated(path)
+	if err != nil {
+		t.Fatalf("Rotated() error = %v", err)
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("got %d rotated files, want 2: %v", len(rotated), rotated)
+	}
+	// 当前文件只保留最新一条记录
+	if lines := readLines(t, path); len(lines) != 1 || !strings.Contains(lines[0], `"total_bytes":4`) {
+		t.Errorf("current file = %q, want only the latest record", lines)
+	}
+}
+
+func TestWriter_RotateDailyCompress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "speed.csv")
+	w, err := NewWriter(Options{Path: path, Daily: true, Compress: true})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	today := time.Now()
+	w.Write(Record{Time: today, Speed: 1})
+	w.Write(Record{Time: today.AddDate(0, 0, 1), Speed: 2})
+	w.Close()
+
+	rotated, _ := Rotated(path)
+	if len(rotated) != 1 || !strings.HasSuffix(rotated[0], ".gz") {
+		t.Fatalf("rotated = %v, want one .gz file", rotated)
+	}
+
+	f, err := os.Open(rotated[0])
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error = %v", err)
+	}
+	data, _ := io.ReadAll(gz)
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	var n int
+	for scanner.Scan() {
+		n++
+	}
+	if n != 2 {
+		t.Errorf("compressed file has %d lines, want 2 (header + 1 record)", n)
+	}
+
+	// 新文件重新写入表头
+	if lines := readLines(t, path); len(lines) != 2 || lines[0] != strings.Join(csvHeader, ",") {
+		t.Errorf("current file = %q, want header + 1 record", lines)
+	}
+}
+
+func TestWriter_RotateFailureKeepsWriting(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "speed.jsonl")
+	var logs bytes.Buffer
+	w, err := NewWriter(Options{Path: path, Format: FormatJSONL, MaxSize: 1, Logger: slog.New(slog.NewTextHandler(&logs, nil))})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	defer w.Close()
+	start := time.Date(2025, 10, 25, 12, 0, 0, 0, time.Local)
+	if err := w.Write(Record{Time: start, TotalBytes: 1}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	// 重命名持续失败时继续写入原文件，重试间隔内只尝试一次
+	w.rename = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrPermission}
+	}
+	for i := 2; i <= 5; i++ {
+		if err := w.Write(Record{Time: start.Add(time.Duration(i) * time.Second), TotalBytes: int64(i)}); err != nil {
+			t.Fatalf("Write() after failed rotation error = %v", err)
+		}
+	}
+	if n := strings.Count(logs.String(), "轮转历史记录文件失败"); n != 1 {
+		t.Errorf("logged %d rotation failures, want 1:\n%s", n, logs.String())
+	}
+	if lines := readLines(t, path); len(lines) != 5 {
+		t.Errorf("current file has %d records, want 5", len(lines))
+	}
+
+	// 重试间隔过后恢复轮转
+	w.rename = os.Rename
+	if err := w.Write(Record{Time: start.Add(rotateRetryInterval + 2*time.Second), TotalBytes: 6}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if lines := readLines(t, path); len(lines) != 1 || !strings.Contains(lines[0], `"total_bytes":6`) {
+		t.Errorf("current file = %q, want the latest record", lines)
+	}
+	if rotated, _ := Rotated(path); len(rotated) != 1 {
+		t.Errorf("rotated = %v, want one file after rotation resumed", rotated)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "speed")
+
+	for _, content := range []string{"first\n", "second\n"} {
+		if err := WriteFileAtomic(path, []byte(content)); err != nil {
+			t.Fatalf("WriteFileAtomic() error = %v", err)
+		}
+		data, _ := os.ReadFile(path)
+		if string(data) != content {
+			t.Errorf("content = %q, want %q", data, content)
+		}
+	}
+
+	// 不留下临时文件
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want 1", len(entries))
+	}
+}
@@ -0,0 +1,112 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// LevelSummary 周期汇总日志级别，介于 INFO 和 WARN 之间
+// 安静模式下只输出该级别及以上的日志
+const LevelSummary = slog.Level(2)
+
+// 日志格式
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Options 日志配置
+type Options struct {
+	Level  string    // debug、info、warn、error，默认 info
+	Format string    // text 或 json，默认 text
+	Quiet  bool      // 安静模式：只输出周期汇总和警告、错误
+	File   string    // 日志文件路径（追加写入），为空时输出到 Output
+	Output io.Writer // 未设置日志文件时的输出，默认标准输出
+}
+
+// ParseLevel 解析日志级别
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "summary":
+		return LevelSummary, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("无效的日志级别: %s (应为 debug、info、summary、warn 或 error)", s)
+	}
+}
+
+// New 按配置创建日志记录器，返回的 io.Closer 用于关闭日志文件
+func New(opts Options) (*slog.Logger, io.Closer, error) {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.Quiet && level < LevelSummary {
+		level = LevelSummary
+	}
+
+	var out io.Writer = os.Stdout
+	if opts.Output != nil {
+		out = opts.Output
+	}
+	var closer io.Closer = nopCloser{}
+	if opts.File != "" {
+		file, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("打开日志文件失败: %w", err)
+		}
+		out = file
+		closer = file
+	}
+
+	handler, err := NewHandler(out, opts.Format, level)
+	if err != nil {
+		closer.Close()
+		return nil, nil, err
+	}
+	return slog.New(handler), closer, nil
+}
+
+// NewHandler 创建指定格式和级别的日志处理器
+func NewHandler(w io.Writer, format string, level slog.Leveler) (slog.Handler, error) {
+	handlerOpts := &slog.HandlerOptions{Level: level, ReplaceAttr: replaceLevel}
+	switch strings.ToLower(format) {
+	case "", FormatText:
+		return slog.NewTextHandler(w, handlerOpts), nil
+	case FormatJSON:
+		return slog.NewJSONHandler(w, handlerOpts), nil
+	default:
+		return nil, fmt.Errorf("无效的日志格式: %s (应为 text 或 json)", format)
+	}
+}
+
+// replaceLevel 把汇总级别显示为 SUMMARY
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if level, ok := a.Value.Any().(slog.Level); ok && level == LevelSummary {
+			a.Value = slog.StringValue("SUMMARY")
+		}
+	}
+	return a
+}
+
+// Summary 以汇总级别记录日志
+func Summary(logger *slog.Logger, msg string, args ...any) {
+	logger.Log(context.Background(), LevelSummary, msg, args...)
+}
+
+// nopCloser 不需要关闭的输出
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    slog.Level
+		wantErr bool
+	}{
+		{"", slog.LevelInfo, false},
+		{"debug", slog.LevelDebug, false},
+		{"INFO", slog.LevelInfo, false},
+		{"summary", LevelSummary, false},
+		{"warn", slog.LevelWarn, false},
+		{"error", slog.LevelError, false},
+		{"verbose", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLevel(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseLevel(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestNewHandler_SummaryLevelName(t *testing.T) {
+	var buf bytes.Buffer
+	handler, err := NewHandler(&buf, FormatJSON, slog.LevelInfo)
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	Summary(slog.New(handler), "周期汇总", "total_mb", 12.5)
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("invalid JSON log: %v", err)
+	}
+	if entry["level"] != "SUMMARY" {
+		t.Errorf("level = %v, want SUMMARY", entry["level"])
+	}
+	if entry["total_mb"] != 12.5 {
+		t.Errorf("total_mb = %v, want 12.5", entry["total_mb"])
+	}
+}
+
+func TestNew_QuietToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netflood.log")
+	logger, closer, err := New(Options{Quiet: true, File: path})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	logger.Info("每秒速度")
+	logger.Debug("下载完成")
+	Summary(logger, "周期汇总")
+	logger.Warn("下载失败")
+	closer.Close()
+
+	data, _ := os.ReadFile(path)
+	out := string(data)
+	if strings.Contains(out, "每秒速度") || strings.Contains(out, "下载完成") {
+		t.Errorf("quiet mode wrote info/debug logs: %s", out)
+	}
+	if !strings.Contains(out, "周期汇总") || !strings.Contains(out, "下载失败") {
+		t.Errorf("quiet mode dropped summary/warn logs: %s", out)
+	}
+}
+
+func TestNew_InvalidFormat(t *testing.T) {
+	if _, _, err := New(Options{Format: "xml"}); err == nil {
+		t.Error("New() with invalid format: expected error, got nil")
+	}
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// minBurst 最小突发量，避免低速率时每次读取都要等待
+const minBurst = 64 * 1024
+
+// Limiter 令牌桶限速器（单位：字节/秒），可在运行中调整速率，多个协程共用
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒令牌数，<= 0 表示不限速
+	burst  float64 // 桶容量
+	tokens float64
+	last   time.Time
+}
+
+// New 创建限速器，bytesPerSec <= 0 表示不限速
+func New(bytesPerSec int64) *Limiter {
+	l := &Limiter{last: time.Now()}
+	l.SetRate(bytesPerSec)
+	l.tokens = l.burst
+	return l
+}
+
+// SetRate 调整速率，bytesPerSec <= 0 表示不限速
+func (l *Limiter) SetRate(bytesPerSec int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(time.Now())
+	l.rate = float64(bytesPerSec)
+	// 桶容量为 100ms 的流量
+	l.burst = max(l.rate/10, minBurst)
+	l.tokens = min(l.tokens, l.burst)
+}
+
+// Rate 返回当前速率（字节/秒），0 表示不限速
+func (l *Limiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int64(max(l.rate, 0))
+}
+
+// WaitN 等待直到可以传输 n 个字节，ctx 取消时返回错误
+// n 可以大于桶容量，超出部分按速率折算等待时间
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	l.refill(now)
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// refill 按经过的时间补充令牌（调用方需持有 l.mu）
+func (l *Limiter) refill(now time.Time) {
+	if l.rate > 0 {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.burst)
+	}
+	l.last = now
+}
@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiter_Unlimited(t *testing.T) {
+	l := New(0)
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		if err := l.WaitN(context.Background(), 1<<20); err != nil {
+			t.Fatalf("WaitN() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("unlimited WaitN took %v", elapsed)
+	}
+}
+
+func TestLimiter_Rate(t *testing.T) {
+	const rate = 10 << 20 // 10 MB/s，桶容量 1 MB
+	l := New(rate)
+
+	start := time.Now()
+	for i := 0; i < 48; i++ {
+		if err := l.WaitN(context.Background(), 64<<10); err != nil {
+			t.Fatalf("WaitN() error = %v", err)
+		}
+	}
+	// 3 MB 减去 1 MB 初始令牌，至少需要约 200ms
+	elapsed := time.Since(start)
+	if elapsed < 180*time.Millisecond || elapsed > time.Second {
+		t.Errorf("3MB at 10MB/s took %v, want ~200ms", elapsed)
+	}
+}
+
+func TestLimiter_SetRate(t *testing.T) {
+	l := New(1024)
+	if got := l.Rate(); got != 1024 {
+		t.Errorf("Rate() = %d, want 1024", got)
+	}
+	l.SetRate(0)
+	if got := l.Rate(); got != 0 {
+		t.Errorf("Rate() = %d, want 0", got)
+	}
+	if err := l.WaitN(context.Background(), 1<<30); err != nil {
+		t.Errorf("WaitN() after SetRate(0) error = %v", err)
+	}
+}
+
+func TestLimiter_WaitCanceled(t *testing.T) {
+	l := New(1024)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := l.WaitN(ctx, 1<<20); err == nil {
+		t.Error("WaitN() with canceled context: expected error, got nil")
+	}
+}
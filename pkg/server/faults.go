@@ -0,0 +1,338 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/dora-exku/netflood/pkg/timerange"
+	"github.com/dora-exku/netflood/pkg/units"
+)
+
+// 故障类型
+const (
+	FaultLatency  = "latency"  // 延迟整个响应（发送状态行之前）
+	FaultTTFB     = "ttfb"     // 立即发送响应头，延迟第一个字节的响应体
+	FaultReset    = "reset"    // 发送部分响应体后以 RST 重置连接（HTTP/2 重置流）
+	FaultTruncate = "truncate" // 按完整长度设置 Content-Length，只发送部分响应体后关闭连接
+	FaultTrickle  = "trickle"  // 以极低的速度发送响应体
+	FaultStatus   = "status"   // 返回 429/503 等错误状态码和 Retry-After
+	FaultTLS      = "tls"      // TLS 握手失败（只能通过配置设置）
+)
+
+// faultTypes 所有故障类型，按处理顺序排列
+var faultTypes = [...]string{FaultLatency, FaultStatus, FaultTTFB, FaultTrickle, FaultReset, FaultTruncate, FaultTLS}
+
+// 故障参数的默认值
+const (
+	DefaultFaultDelay      = time.Second
+	DefaultFaultAfter      = "50%"
+	DefaultFaultRate       = "1KB"
+	DefaultFaultStatus     = http.StatusServiceUnavailable
+	DefaultFaultRetryAfter = time.Second
+)
+
+// errTLSFault 注入的 TLS 握手失败
+var errTLSFault = errors.New("注入的 TLS 握手失败")
+
+// Fault 一条故障注入规则：在 schedule（time、every/for）内的每个请求以 probability 的概率注入
+type Fault struct {
+	Type        string        `yaml:"type"`        // 故障类型，见 Fault* 常量
+	Probability *float64      `yaml:"probability"` // 注入概率（大于 0，不大于 1），不设置时为 1
+	Delay       time.Duration `yaml:"delay"`       // latency、ttfb 的延迟，默认 1s
+	After       string        `yaml:"after"`       // reset、truncate 发送多少响应体后中断，例如 1MB 或 50%，默认 50%
+	Rate        string        `yaml:"rate"`        // trickle 的速度，例如 1KB 或 8kbps，默认 1KB/s
+	Status      int           `yaml:"status"`      // status 返回的状态码，默认 503
+	RetryAfter  time.Duration `yaml:"retry_after"` // status 的 Retry-After，默认 1s，小于 0 时不设置
+	Time        string        `yaml:"time"`        // 生效时间段（格式与 -time 相同），为空时全天生效
+	Every       time.Duration `yaml:"every"`       // 与 for 一起使用：从服务器启动起每个 every 周期的前 for 时长内生效
+	For         time.Duration `yaml:"for"`
+
+	probability float64
+	rate        int64
+	after       offset
+	times       *timerange.TimeRangeManager
+}
+
+// offset 响应体中的位置：固定字节数或响应体长度的比例
+type offset struct {
+	bytes   int64
+	percent float64 // 大于 0 时按比例计算
+}
+
+// of 返回长度为 total 的响应体中的位置，total 未知（小于 0）时按比例计算的位置为 0
+func (o offset) of(total int64) int64 {
+	if o.percent > 0 {
+		return int64(float64(max(total, 0)) * o.percent / 100)
+	}
+	return o.bytes
+}
+
+// parseOffset 解析 1MB、4096 或 50% 形式的位置
+func parseOffset(s string) (offset, error) {
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		percent, err := strconv.ParseFloat(p, 64)
+		if err != nil || percent <= 0 || percent >= 100 {
+			return offset{}, fmt.Errorf("比例应在 0%% 到 100%% 之间: %q", s)
+		}
+		return offset{percent: percent}, nil
+	}
+	n, err := units.ParseBytes(s)
+	if err != nil {
+		return offset{}, err
+	}
+	return offset{bytes: n}, nil
+}
+
+// FaultConfig 故障注入配置文件
+type FaultConfig struct {
+	Faults []Fault `yaml:"faults"`
+}
+
+// LoadFaults 读取并检查故障注入配置文件（YAML）
+func LoadFaults(path string) ([]Fault, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取故障注入配置失败: %w", err)
+	}
+	var cfg FaultConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("解析故障注入配置失败: %w", err)
+	}
+	for i := range cfg.Faults {
+		if err := cfg.Faults[i].normalize(); err != nil {
+			return nil, fmt.Errorf("第 %d 条故障规则: %w", i+1, err)
+		}
+	}
+	return cfg.Faults, nil
+}
+
+// normalize 检查规则、解析参数并填写默认值
+func (f *Fault) normalize() error {
+	if !slices.Contains(faultTypes[:], f.Type) {
+		return fmt.Errorf("未知的故障类型: %q", f.Type)
+	}
+	f.probability = 1
+	if f.Probability != nil {
+		f.probability = *f.Probability
+	}
+	if !(f.probability > 0 && f.probability <= 1) {
+		return fmt.Errorf("probability 应大于 0 且不大于 1（不注入时删除该规则）: %v", f.probability)
+	}
+	if f.Delay < 0 {
+		return errors.New("delay 不能为负数")
+	}
+	f.Delay = cmp.Or(f.Delay, DefaultFaultDelay)
+	var err error
+	if f.after, err = parseOffset(cmp.Or(f.After, DefaultFaultAfter)); err != nil {
+		return fmt.Errorf("after 无效: %w", err)
+	}
+	if f.rate, err = units.ParseRate(cmp.Or(f.Rate, DefaultFaultRate)); err != nil || f.rate <= 0 {
+		return fmt.Errorf("rate 无效: %q", f.Rate)
+	}
+	f.Status = cmp.Or(f.Status, DefaultFaultStatus)
+	if f.Status < 400 || f.Status > 599 {
+		return fmt.Errorf("status 应为 4xx 或 5xx: %d", f.Status)
+	}
+	f.RetryAfter = cmp.Or(f.RetryAfter, DefaultFaultRetryAfter)
+	if f.Every < 0 || f.For < 0 || (f.Every > 0) != (f.For > 0) || f.For > f.Every {
+		return errors.New("every 和 for 需要同时设置，且 for 不能大于 every")
+	}
+	if f.times, err = timerange.NewTimeRangeManager(f.Time); err != nil {
+		return err
+	}
+	return nil
+}
+
+// activeAt 规则在 now 时是否生效，start 为服务器启动时间
+func (f *Fault) activeAt(now, start time.Time) bool {
+	if f.times.IsEnabled() {
+		if _, ok := f.times.RangeAt(now); !ok {
+			return false
+		}
+	}
+	if f.Every > 0 {
+		return now.Sub(start)%f.Every < f.For
+	}
+	return true
+}
+
+// parseQueryFaults 解析请求参数中的故障：参数名为故障类型，值为该故障的参数，
+// 可以用 @ 加上概率，例如 latency=200ms、reset=1MB@0.3、status=429、trickle=1KB、truncate=50%；
+// status 的 Retry-After 由 retry_after 参数设置
+func parseQueryFaults(query url.Values) ([]Fault, error) {
+	var faults []Fault
+	for _, typ := range faultTypes {
+		v := query.Get(typ)
+		if v == "" || typ == FaultTLS {
+			continue
+		}
+		f := Fault{Type: typ}
+		if value, p, ok := strings.Cut(v, "@"); ok {
+			probability, err := strconv.ParseFloat(p, 64)
+			if err != nil || !(probability > 0 && probability <= 1) {
+				return nil, fmt.Errorf("%s 参数的概率无效: %q", typ, p)
+			}
+			f.Probability = &probability
+			v = value
+		}
+		switch typ {
+		case FaultLatency, FaultTTFB:
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s 参数无效: %q", typ, v)
+			}
+			f.Delay = d
+		case FaultReset, FaultTruncate:
+			f.After = v
+		case FaultTrickle:
+			f.Rate = v
+		case FaultStatus:
+			status, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("status 参数无效: %q", v)
+			}
+			f.Status = status
+		}
+		if v := query.Get("retry_after"); v != "" && typ == FaultStatus {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("retry_after 参数无效: %q", v)
+			}
+			f.RetryAfter = d
+		}
+		if err := f.normalize(); err != nil {
+			return nil, fmt.Errorf("%s 参数: %w", typ, err)
+		}
+		faults = append(faults, f)
+	}
+	return faults, nil
+}
+
+// SetFaults 检查并替换配置的故障注入规则（可在运行中调用），nil 表示不注入
+func (s *Server) SetFaults(faults []Fault) error {
+	faults = slices.Clone(faults)
+	for i := range faults {
+		if err := faults[i].normalize(); err != nil {
+			return fmt.Errorf("第 %d 条故障规则: %w", i+1, err)
+		}
+	}
+	s.faults.Store(&faults)
+	return nil
+}
+
+// Faults 返回配置的故障注入规则
+func (s *Server) Faults() []Fault {
+	if p := s.faults.Load(); p != nil {
+		return slices.Clone(*p)
+	}
+	return nil
+}
+
+// roll 按规则的时间表和概率决定是否注入，注入时计数
+func (s *Server) roll(f *Fault) bool {
+	if !f.activeAt(s.now(), s.start) || rand.Float64() >= f.probability {
+		return false
+	}
+	s.faultCounts[slices.Index(faultTypes[:], f.Type)].Add(1)
+	return true
+}
+
+// faultPlan 一个请求要注入的故障
+type faultPlan struct {
+	latency time.Duration
+	ttfb    time.Duration
+	status  *Fault
+	trickle int64
+	cut     *Fault // reset 或 truncate
+}
+
+// planFaults 从配置和请求参数的规则中选出本次请求注入的故障；同类故障只取第一条
+func (s *Server) planFaults(query url.Values) (faultPlan, error) {
+	var plan faultPlan
+	faults, err := parseQueryFaults(query)
+	if err != nil {
+		return plan, err
+	}
+	if p := s.faults.Load(); p != nil {
+		faults = append(slices.Clone(*p), faults...)
+	}
+	for i := range faults {
+		f := &faults[i]
+		switch {
+		case f.Type == FaultTLS:
+		case f.Type == FaultLatency && plan.latency == 0 && s.roll(f):
+			plan.latency = f.Delay
+		case f.Type == FaultTTFB && plan.ttfb == 0 && s.roll(f):
+			plan.ttfb = f.Delay
+		case f.Type == FaultStatus && plan.status == nil && s.roll(f):
+			plan.status = f
+		case f.Type == FaultTrickle && plan.trickle == 0 && s.roll(f):
+			plan.trickle = f.rate
+		case (f.Type == FaultReset || f.Type == FaultTruncate) && plan.cut == nil && s.roll(f):
+			plan.cut = f
+		}
+	}
+	return plan, nil
+}
+
+// TLSConfig 返回使用 cert 的 TLS 配置，按 tls 故障规则让握手失败
+func (s *Server) TLSConfig(cert tls.Certificate) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			if p := s.faults.Load(); p != nil {
+				for i := range *p {
+					if f := &(*p)[i]; f.Type == FaultTLS && s.roll(f) {
+						return nil, errTLSFault
+					}
+				}
+			}
+			return nil, nil
+		},
+	}
+}
+
+// sleep 等待 d，ctx 取消时提前返回 false
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// resetConn 以 RST 关闭 HTTP/1.x 连接；HTTP/2 无法接管连接，重置当前流
+func resetConn(w http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	raw := conn
+	if tc, ok := conn.(*tls.Conn); ok {
+		raw = tc.NetConn()
+	}
+	if tc, ok := raw.(*net.TCPConn); ok {
+		tc.SetLinger(0)
+	}
+	raw.Close()
+}
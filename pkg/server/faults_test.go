@@ -0,0 +1,174 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLoadFaults(t *testing.T) {
+	faults, err := LoadFaults("../../examples/serve-faults/faults.yml")
+	if err != nil {
+		t.Fatalf("LoadFaults(example) error = %v", err)
+	}
+	if len(faults) != 8 || faults[2].Status != 503 || faults[6].rate != 1000 || faults[3].RetryAfter != DefaultFaultRetryAfter {
+		t.Errorf("LoadFaults(example) = %+v", faults)
+	}
+
+	tests := []struct {
+		name, yaml, want string
+	}{
+		{"unknown type", "faults: [{type: explode}]", "explode"},
+		{"unknown field", "faults: [{type: reset, foo: 1}]", "foo"},
+		{"bad probability", "faults: [{type: reset, probability: 2}]", "probability"},
+		{"zero probability", "faults: [{type: reset, probability: 0}]", "probability"},
+		{"bad after", "faults: [{type: reset, after: 150%}]", "after"},
+		{"bad rate", "faults: [{type: trickle, rate: slow}]", "rate"},
+		{"bad status", "faults: [{type: status, status: 200}]", "status"},
+		{"every without for", "faults: [{type: status, every: 1m}]", "every"},
+		{"for longer than every", "faults: [{type: status, every: 1m, for: 2m}]", "every"},
+		{"bad time", "faults: [{type: latency, time: '9-18'}]", "时间"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "faults.yml")
+			os.WriteFile(path, []byte(tt.yaml), 0o644)
+			_, err := LoadFaults(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadFaults() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFault_ActiveAt(t *testing.T) {
+	start := time.Date(2025, 10, 26, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		fault Fault
+		at    time.Duration // 距 start 的时长
+		want  bool
+	}{
+		{Fault{Type: FaultStatus}, 0, true},
+		{Fault{Type: FaultStatus, Every: 10 * time.Minute, For: time.Minute}, 30 * time.Second, true},
+		{Fault{Type: FaultStatus, Every: 10 * time.Minute, For: time.Minute}, 5 * time.Minute, false},
+		{Fault{Type: FaultStatus, Every: 10 * time.Minute, For: time.Minute}, 20*time.Minute + 59*time.Second, true},
+		{Fault{Type: FaultStatus, Time: "12:00-13:00"}, 30 * time.Minute, true},
+		{Fault{Type: FaultStatus, Time: "12:00-13:00"}, 2 * time.Hour, false},
+		{Fault{Type: FaultStatus, Time: "12:00-13:00", Every: time.Hour, For: time.Minute}, 30 * time.Minute, false},
+	}
+	for _, tt := range tests {
+		if err := tt.fault.normalize(); err != nil {
+			t.Fatalf("normalize(%+v) error = %v", tt.fault, err)
+		}
+		if got := tt.fault.activeAt(start.Add(tt.at), start); got != tt.want {
+			t.Errorf("%+v activeAt(+%v) = %v, want %v", tt.fault, tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestServer_Faults(t *testing.T) {
+	ts, s := newTestServer(t, Options{})
+
+	tests := []struct {
+		name, query string
+		status      int
+		header      string // 期望的 Retry-After
+		body        int    // 期望收到的字节数
+		err         error  // 期望的读取错误，nil 表示完整读取
+		minElapsed  time.Duration
+	}{
+		{"none", "", 200, "", 1 << 20, nil, 0},
+		{"never", "status=503@0.0001&reset=1KB@0.0001", 200, "", 1 << 20, nil, 0},
+		{"latency", "latency=200ms", 200, "", 1 << 20, nil, 200 * time.Millisecond},
+		{"ttfb", "ttfb=200ms", 200, "", 1 << 20, nil, 200 * time.Millisecond},
+		{"status", "status=429&retry_after=1500ms", 429, "2", -1, nil, 0},
+		{"status default", "status=503", 503, "1", -1, nil, 0},
+		{"trickle", "trickle=512KB", 200, "", 1 << 20, nil, 800 * time.Millisecond},
+		{"truncate", "truncate=256KB", 200, "", 256 << 10, io.ErrUnexpectedEOF, 0},
+		{"truncate chunked", "truncate=50%25&chunked=1", 200, "", 512 << 10, io.ErrUnexpectedEOF, 0},
+		{"reset", "reset=25%25", 200, "", -1, syscall.ECONNRESET, 0},
+		{"reset chunked", "reset=100KB&chunked=1", 200, "", -1, syscall.ECONNRESET, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			resp, err := http.Get(ts.URL + "/bytes/1MB?" + tt.query)
+			if err != nil {
+				t.Fatalf("GET error = %v", err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.status || resp.Header.Get("Retry-After") != tt.header {
+				t.Errorf("status = %d, Retry-After = %q, want %d, %q", resp.StatusCode, resp.Header.Get("Retry-After"), tt.status, tt.header)
+			}
+			if tt.err == nil && err != nil || tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("read error = %v, want %v", err, tt.err)
+			}
+			if tt.body >= 0 && len(body) != tt.body {
+				t.Errorf("read %d bytes, want %d", len(body), tt.body)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minElapsed {
+				t.Errorf("took %v, want >= %v", elapsed, tt.minElapsed)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/bytes/1KB?reset=1KB@2", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid probability: status = %d, want 400", rec.Code)
+	}
+	faults := s.Stats().Faults
+	if faults[FaultStatus] != 2 || faults[FaultReset] != 2 || faults[FaultTruncate] != 2 || faults[FaultLatency] != 1 {
+		t.Errorf("Stats().Faults = %v", faults)
+	}
+}
+
+func TestServer_ConfiguredFaults(t *testing.T) {
+	certPEM, keyPEM, err := SelfSigned("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := tls.X509KeyPair(certPEM, keyPEM)
+	s := New(Options{})
+	if err := s.SetFaults([]Fault{{Type: FaultTLS, Every: time.Hour, For: time.Minute}, {Type: "explode"}}); err == nil {
+		t.Error("SetFaults() with unknown type: expected error, got nil")
+	}
+	if err := s.SetFaults([]Fault{{Type: FaultTLS, Every: time.Hour, For: time.Minute}, {Type: FaultStatus, Status: 429}}); err != nil {
+		t.Fatalf("SetFaults() error = %v", err)
+	}
+	ts := httptest.NewUnstartedServer(s)
+	ts.TLS = s.TLSConfig(cert)
+	ts.StartTLS()
+	defer ts.Close()
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+
+	// 时间表内握手失败，配置的 status 规则作用于所有请求
+	if _, err := client.Get(ts.URL + "/bytes/1KB"); err == nil {
+		t.Error("GET during tls fault: expected handshake error, got nil")
+	}
+	s.now = func() time.Time { return s.start.Add(2 * time.Minute) }
+	resp, err := client.Get(ts.URL + "/bytes/1KB")
+	if err != nil {
+		t.Fatalf("GET after tls fault error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 429 {
+		t.Errorf("status = %d, want 429", resp.StatusCode)
+	}
+	if faults := s.Stats().Faults; faults[FaultTLS] != 1 || faults[FaultStatus] != 1 {
+		t.Errorf("Stats().Faults = %v", faults)
+	}
+}
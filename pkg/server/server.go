@@ -0,0 +1,385 @@
+// Package server 提供合成数据的 HTTP 测试服务器：按请求的大小即时生成响应体，
+// 不需要真实的 CDN 或大文件即可端到端测试下载器和链路
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/ratelimit"
+	"github.com/dora-exku/netflood/pkg/units"
+)
+
+// 默认值
+const (
+	DefaultLogInterval = 10 * time.Second // 吞吐量日志的间隔
+	chunkSize          = 32 * 1024        // 每次写入的字节数
+	patternSize        = 64 * 1024        // 响应体重复使用的数据块大小
+)
+
+// pattern 响应体的内容：固定种子生成的伪随机数据块循环重复，同一偏移量的内容总是相同，
+// 可以校验 Range 请求的结果，也不会被压缩
+var pattern = func() []byte {
+	p := make([]byte, patternSize)
+	r := rand.NewChaCha8([32]byte{'n', 'e', 't', 'f', 'l', 'o', 'o', 'd'})
+	r.Read(p)
+	return p
+}()
+
+// Options 测试服务器配置
+type Options struct {
+	Rate        int64         // 每个连接的速度上限（字节/秒），0 表示不限速；可以用 rate 参数按请求覆盖
+	Chunked     bool          // 默认使用分块传输（不设置 Content-Length、不支持 Range）；可以用 chunked 参数按请求覆盖
+	MaxSize     int64         // 单个对象的最大字节数，0 表示不限
+	LogInterval time.Duration // 吞吐量日志的间隔，默认 DefaultLogInterval，小于 0 时不输出
+	Logger      *slog.Logger  // 默认丢弃
+}
+
+// Stats 服务器运行以来的统计
+type Stats struct {
+	Requests    int64            `json:"requests"`         // 收到的请求数
+	Bytes       int64            `json:"bytes"`            // 发送的响应体字节数
+	Received    int64            `json:"received"`         // TCP 上传测试收到的字节数
+	Connections int64            `json:"connections"`      // 当前的连接数
+	Faults      map[string]int64 `json:"faults,omitempty"` // 按类型统计注入的故障次数
+}
+
+// Server 合成数据测试服务器
+//
+//	GET /bytes/{size}   返回 size 字节的数据，例如 /bytes/10GB、/bytes/4096
+//
+// 查询参数：chunked=1 使用分块传输（chunked=0 强制固定长度），rate=10MB 设置本次请求的速度上限，
+// latency=200ms、reset=1MB@0.3 等注入故障（见 Fault）。
+// 固定长度的响应支持 Range（包括多个范围）、If-Range 和 HEAD。
+// 通过 HTTPServer 创建的 http.Server 按连接限速并统计连接数
+type Server struct {
+	opts   Options
+	logger *slog.Logger
+	mux    *http.ServeMux
+	start  time.Time
+	now    func() time.Time // 故障时间表使用的时钟，测试中替换
+	faults atomic.Pointer[[]Fault]
+
+	tcpIdle time.Duration // TCP 连接握手后的空闲超时，测试中替换
+
+	requests    atomic.Int64
+	bytes       atomic.Int64
+	received    atomic.Int64
+	connections atomic.Int64
+	faultCounts [len(faultTypes)]atomic.Int64
+}
+
+// New 创建测试服务器，故障注入规则通过 SetFaults 设置
+func New(opts Options) *Server {
+	s := &Server{
+		opts:   opts,
+		logger: cmp.Or(opts.Logger, slog.New(slog.DiscardHandler)),
+		mux:    http.NewServeMux(),
+		start:  time.Now(),
+		now:    time.Now,
+
+		tcpIdle: tcpIdleTimeout,
+	}
+	s.opts.LogInterval = cmp.Or(opts.LogInterval, DefaultLogInterval)
+	s.mux.HandleFunc("GET /bytes/{size}", s.handleBytes)
+	s.mux.HandleFunc("GET /{$}", s.handleIndex)
+	return s
+}
+
+// ServeHTTP 实现 http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+	s.mux.ServeHTTP(w, r)
+}
+
+// Stats 返回运行以来的统计
+func (s *Server) Stats() Stats {
+	st := Stats{Requests: s.requests.Load(), Bytes: s.bytes.Load(), Received: s.received.Load(), Connections: s.connections.Load()}
+	for i, typ := range faultTypes {
+		if n := s.faultCounts[i].Load(); n > 0 {
+			if st.Faults == nil {
+				st.Faults = make(map[string]int64)
+			}
+			st.Faults[typ] = n
+		}
+	}
+	return st
+}
+
+// connLimiterKey 连接上下文中保存连接限速器的键
+type connLimiterKey struct{}
+
+// HTTPServer 创建监听 addr 的 http.Server：每个连接使用独立的限速器（HTTP/2 的多个流共用），并统计连接数
+func (s *Server) HTTPServer(addr string) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			if s.opts.Rate > 0 {
+				ctx = context.WithValue(ctx, connLimiterKey{}, ratelimit.New(s.opts.Rate))
+			}
+			return ctx
+		},
+		ConnState: func(c net.Conn, state http.ConnState) {
+			switch state {
+			case http.StateNew:
+				s.connections.Add(1)
+			case http.StateHijacked, http.StateClosed:
+				s.connections.Add(-1)
+			}
+		},
+	}
+}
+
+// Run 按 LogInterval 输出服务器吞吐量（发送和 TCP 上传收到的字节数之和），直到 ctx 取消；没有流量和连接时不输出
+func (s *Server) Run(ctx context.Context) {
+	if s.opts.LogInterval < 0 {
+		return
+	}
+	ticker := time.NewTicker(s.opts.LogInterval)
+	defer ticker.Stop()
+	last, lastTime := s.bytes.Load()+s.received.Load(), time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			stats := s.Stats()
+			total := stats.Bytes + stats.Received
+			delta := total - last
+			if delta > 0 || stats.Connections > 0 {
+				args := []any{
+					"speed", fmt.Sprintf("%.2f MB/s", float64(delta)/1024/1024/now.Sub(lastTime).Seconds()),
+					"bytes", units.FormatBytes(delta),
+					"total", units.FormatBytes(total),
+					"connections", stats.Connections,
+					"requests", stats.Requests,
+				}
+				if stats.Received > 0 {
+					args = append(args, "received", units.FormatBytes(stats.Received))
+				}
+				if len(stats.Faults) > 0 {
+					args = append(args, "faults", stats.Faults)
+				}
+				s.logger.Info("服务器吞吐", args...)
+			}
+			last, lastTime = total, now
+		}
+	}
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "netflood serve")
+	fmt.Fprintln(w, "GET /bytes/{size}[?chunked=1][&rate=10MB]  返回 size 字节的合成数据，例如 /bytes/10GB")
+	fmt.Fprintln(w, "故障注入参数（值后可加 @概率）: latency=200ms ttfb=1s reset=1MB truncate=50% trickle=1KB status=503 retry_after=5s")
+}
+
+// handleBytes 返回 size 字节的合成数据
+func (s *Server) handleBytes(w http.ResponseWriter, r *http.Request) {
+	size, err := units.ParseBytes(r.PathValue("size"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.opts.MaxSize > 0 && size > s.opts.MaxSize {
+		http.Error(w, fmt.Sprintf("大小超过上限 %s", units.FormatBytes(s.opts.MaxSize)), http.StatusRequestEntityTooLarge)
+		return
+	}
+	query := r.URL.Query()
+	chunked := s.opts.Chunked
+	if v := query.Get("chunked"); v != "" {
+		if chunked, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "chunked 参数无效: "+v, http.StatusBadRequest)
+			return
+		}
+	}
+	limiter, _ := r.Context().Value(connLimiterKey{}).(*ratelimit.Limiter)
+	if v := query.Get("rate"); v != "" {
+		rate, err := units.ParseRate(v)
+		if err != nil {
+			http.Error(w, "rate 参数无效: "+v, http.StatusBadRequest)
+			return
+		}
+		limiter = ratelimit.New(rate)
+	}
+	plan, err := s.planFaults(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if plan.latency > 0 && !sleep(r.Context(), plan.latency) {
+		return
+	}
+	if f := plan.status; f != nil {
+		if f.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(f.RetryAfter.Seconds()))))
+		}
+		http.Error(w, http.StatusText(f.Status), f.Status)
+		return
+	}
+	if plan.trickle > 0 {
+		limiter = ratelimit.New(plan.trickle)
+	}
+	body := &shapedWriter{
+		w:         w,
+		paced:     pacedWriter{w: w, ctx: r.Context(), limiter: limiter, counter: &s.bytes},
+		ttfb:      plan.ttfb,
+		remaining: -1,
+	}
+	if f := plan.cut; f != nil {
+		// 截断需要按完整长度声明 Content-Length
+		chunked = chunked && f.Type != FaultTruncate
+		body.cut, body.size = &f.after, size
+		defer func() {
+			if body.cutOff && f.Type == FaultReset {
+				resetConn(w)
+			}
+		}()
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if chunked {
+		// 不设置 Content-Length 并先发送响应头时 net/http 使用分块传输（HTTP/1.1），
+		// 否则较小的响应体会在结束时自动补上 Content-Length
+		w.Header().Set("Accept-Ranges", "none")
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		http.NewResponseController(w).Flush()
+		io.Copy(body, io.NewSectionReader(content{}, 0, size))
+		return
+	}
+	// 内容只由大小决定，ETag 使 If-Range 可用
+	w.Header().Set("ETag", `"`+strconv.FormatInt(size, 10)+`"`)
+	http.ServeContent(body, r, "", time.Time{}, io.NewSectionReader(content{}, 0, size))
+}
+
+// content 无限长的合成数据，偏移量 off 处的内容为 pattern[off % patternSize]
+type content struct{}
+
+// ReadAt 实现 io.ReaderAt
+func (content) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		start := int((off + int64(n)) % patternSize)
+		n += copy(p[n:], pattern[start:])
+	}
+	return n, nil
+}
+
+// Pattern 返回合成数据在 [off, off+n) 的内容，用于校验下载结果
+func Pattern(off int64, n int) []byte {
+	p := make([]byte, n)
+	content{}.ReadAt(p, off)
+	return p
+}
+
+// errCut 注入 reset、truncate 故障时停止写入响应体
+var errCut = errors.New("注入故障，停止发送响应体")
+
+// pacedWriter 分块等待限速器后写入并统计字节数
+type pacedWriter struct {
+	w       io.Writer
+	ctx     context.Context
+	limiter *ratelimit.Limiter // 为 nil 时不限速
+	counter *atomic.Int64
+}
+
+// Write 实现 io.Writer
+func (pw *pacedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), chunkSize)]
+		if pw.limiter != nil {
+			if err := pw.limiter.WaitN(pw.ctx, len(chunk)); err != nil {
+				return written, err
+			}
+		}
+		n, err := pw.w.Write(chunk)
+		written += n
+		pw.counter.Add(int64(n))
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// shapedWriter 按限速器写入响应体，按故障延迟首字节或中途停止；只实现 Write，
+// 使 io.Copy 不绕过限速使用 http.ResponseWriter 的 ReadFrom
+type shapedWriter struct {
+	w     http.ResponseWriter
+	paced pacedWriter
+
+	ttfb      time.Duration // 首次写入前先发送响应头并等待的时长
+	cut       *offset       // 发送到该位置后停止，nil 表示不停止
+	size      int64         // 没有 Content-Length 时按比例计算 cut 使用的长度
+	remaining int64         // 停止前还能写入的字节数，小于 0 表示不限
+	cutOff    bool          // 是否已停止
+	started   bool
+}
+
+// start 首次写入前发送响应头、等待首字节延迟并计算停止位置
+func (sw *shapedWriter) start() error {
+	sw.started = true
+	if sw.cut != nil {
+		total := sw.size
+		if n, err := strconv.ParseInt(sw.w.Header().Get("Content-Length"), 10, 64); err == nil {
+			total = n
+		}
+		sw.remaining = sw.cut.of(total)
+	}
+	if sw.ttfb > 0 {
+		http.NewResponseController(sw.w).Flush()
+		if !sleep(sw.paced.ctx, sw.ttfb) {
+			return sw.paced.ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Header 实现 http.ResponseWriter（http.ServeContent 需要）
+func (sw *shapedWriter) Header() http.Header {
+	return sw.w.Header()
+}
+
+// WriteHeader 实现 http.ResponseWriter
+func (sw *shapedWriter) WriteHeader(status int) {
+	sw.w.WriteHeader(status)
+}
+
+// Write 写入响应体，到达停止位置时返回 errCut
+func (sw *shapedWriter) Write(p []byte) (int, error) {
+	if !sw.started {
+		if err := sw.start(); err != nil {
+			return 0, err
+		}
+	}
+	if sw.remaining < 0 {
+		return sw.paced.Write(p)
+	}
+	n, err := sw.paced.Write(p[:min(int64(len(p)), sw.remaining)])
+	sw.remaining -= int64(n)
+	if err == nil && n < len(p) {
+		sw.cutOff = true
+		http.NewResponseController(sw.w).Flush()
+		err = errCut
+	}
+	return n, err
+}
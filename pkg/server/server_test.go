@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestServer 启动使用 HTTPServer 配置（连接限速和统计）的测试服务器
+func newTestServer(t *testing.T, opts Options) (*httptest.Server, *Server) {
+	t.Helper()
+	s := New(opts)
+	ts := httptest.NewUnstartedServer(s)
+	ts.Config = s.HTTPServer("")
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts, s
+}
+
+func get(t *testing.T, url string, header map[string]string) (*http.Response, []byte) {
+	t.Helper()
+	req, _ := http.NewRequest("GET", url, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("GET %s read error = %v", url, err)
+	}
+	return resp, body
+}
+
+func TestServer_Bytes(t *testing.T) {
+	ts, s := newTestServer(t, Options{MaxSize: 1 << 30})
+
+	tests := []struct {
+		name, path  string
+		header      map[string]string
+		status      int
+		off, length int64
+		chunked     bool
+	}{
+		{"fixed", "/bytes/100KB", nil, http.StatusOK, 0, 100 << 10, false},
+		{"plain number", "/bytes/4096", nil, http.StatusOK, 0, 4096, false},
+		{"range", "/bytes/1MB", map[string]string{"Range": "bytes=70000-70099"}, http.StatusPartialContent, 70000, 100, false},
+		{"suffix range", "/bytes/1MB", map[string]string{"Range": "bytes=-10"}, http.StatusPartialContent, 1<<20 - 10, 10, false},
+		{"if-range match", "/bytes/1MB", map[string]string{"Range": "bytes=0-9", "If-Range": `"1048576"`}, http.StatusPartialContent, 0, 10, false},
+		{"if-range mismatch", "/bytes/1KB", map[string]string{"Range": "bytes=0-9", "If-Range": `"1"`}, http.StatusOK, 0, 1024, false},
+		{"chunked", "/bytes/200KB?chunked=1", nil, http.StatusOK, 0, 200 << 10, true},
+		{"chunked ignores range", "/bytes/1KB?chunked=true", map[string]string{"Range": "bytes=0-9"}, http.StatusOK, 0, 1024, true},
+		{"bad size", "/bytes/lots", nil, http.StatusBadRequest, 0, 0, false},
+		{"overflowing size", "/bytes/1e30", nil, http.StatusBadRequest, 0, 0, false},
+		{"NaN size", "/bytes/NaN", nil, http.StatusBadRequest, 0, 0, false},
+		{"too large", "/bytes/2GB", nil, http.StatusRequestEntityTooLarge, 0, 0, false},
+		{"bad chunked", "/bytes/1KB?chunked=maybe", nil, http.StatusBadRequest, 0, 0, false},
+		{"bad rate", "/bytes/1KB?rate=fast", nil, http.StatusBadRequest, 0, 0, false},
+		{"bit rate", "/bytes/1KB?rate=800Mbps", nil, http.StatusOK, 0, 1024, false},
+		{"unknown path", "/missing", nil, http.StatusNotFound, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := get(t, ts.URL+tt.path, tt.header)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.status, body)
+			}
+			if tt.status >= 300 {
+				return
+			}
+			if !bytes.Equal(body, Pattern(tt.off, int(tt.length))) {
+				t.Errorf("body (%d bytes) does not match pattern at %d+%d", len(body), tt.off, tt.length)
+			}
+			if chunked := resp.ContentLength == -1; chunked != tt.chunked {
+				t.Errorf("ContentLength = %d, chunked want %v", resp.ContentLength, tt.chunked)
+			}
+		})
+	}
+
+	resp, err := http.Head(ts.URL + "/bytes/1GB")
+	if err != nil || resp.StatusCode != http.StatusOK || resp.ContentLength != 1<<30 {
+		t.Fatalf("HEAD /bytes/1GB = %v, %v", resp, err)
+	}
+	if resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.Errorf("HEAD Accept-Ranges = %q, want bytes", resp.Header.Get("Accept-Ranges"))
+	}
+
+	if st := s.Stats(); st.Requests != int64(len(tests))+1 || st.Bytes == 0 {
+		t.Errorf("Stats() = %+v", st)
+	}
+}
+
+func TestServer_Rate(t *testing.T) {
+	ts, s := newTestServer(t, Options{Rate: 1 << 20})
+
+	// 首个 64KB 为突发量，其余按 1 MB/s 发送
+	start := time.Now()
+	_, body := get(t, ts.URL+"/bytes/320KB", nil)
+	if elapsed := time.Since(start); len(body) != 320<<10 || elapsed < 200*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("GET at 1MB/s: %d bytes in %v, want ~250ms", len(body), elapsed)
+	}
+
+	// rate 参数覆盖连接限速
+	start = time.Now()
+	_, body = get(t, ts.URL+"/bytes/320KB?rate=100MB", nil)
+	if elapsed := time.Since(start); len(body) != 320<<10 || elapsed > 150*time.Millisecond {
+		t.Errorf("GET with rate=100MB: %d bytes in %v", len(body), elapsed)
+	}
+	if st := s.Stats(); st.Bytes != 640<<10 || st.Connections != 1 {
+		t.Errorf("Stats() = %+v, want 640KB over one keep-alive connection", st)
+	}
+}
+
+func TestSelfSigned(t *testing.T) {
+	certPEM, keyPEM, err := SelfSigned("localhost", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("SelfSigned() error = %v", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("X509KeyPair() error = %v", err)
+	}
+	s := New(Options{})
+	ts := httptest.NewUnstartedServer(s)
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	ts.StartTLS()
+	defer ts.Close()
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := client.Get(ts.URL + "/bytes/1KB")
+	if err != nil {
+		t.Fatalf("GET over TLS error = %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); len(body) != 1024 {
+		t.Errorf("GET over TLS body = %d bytes, want 1024", len(body))
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/ratelimit"
+	"github.com/dora-exku/netflood/pkg/tcpproto"
+	"github.com/dora-exku/netflood/pkg/units"
+)
+
+// tcpHandshakeTimeout 等待客户端请求行的超时
+const tcpHandshakeTimeout = 10 * time.Second
+
+// tcpIdleTimeout 握手后单次读写的超时：对端停止收发（例如进程挂起）时关闭连接，释放协程和连接数
+const tcpIdleTimeout = 30 * time.Second
+
+// ServeTCP 在 ln 上接受 TCP 测试连接（协议见 pkg/tcpproto），直到 ctx 取消或 ln 关闭；
+// 每个连接按 Options.Rate 限速，字节数和连接数计入 Stats
+func (s *Server) ServeTCP(ctx context.Context, ln net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("接受 TCP 连接失败: %w", err)
+		}
+		go func() {
+			s.connections.Add(1)
+			defer s.connections.Add(-1)
+			defer conn.Close()
+			connCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			context.AfterFunc(connCtx, func() { conn.Close() })
+			if err := s.handleTCP(connCtx, conn); err != nil {
+				s.logger.Debug("TCP 连接结束", "remote", conn.RemoteAddr().String(), "error", err)
+			}
+		}()
+	}
+}
+
+// handleTCP 处理一个 TCP 测试连接
+func (s *Server) handleTCP(ctx context.Context, c net.Conn) error {
+	conn := &idleConn{Conn: c}
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(tcpHandshakeTimeout))
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("读取请求失败: %w", err)
+	}
+	// 之后每次读写前重新设置截止时间，限速等待的时间不计入
+	conn.timeout = s.tcpIdle
+	s.requests.Add(1)
+
+	dir, size, err := s.parseTCPRequest(line)
+	if err != nil {
+		fmt.Fprintf(conn, "ERR %v\n", err)
+		return err
+	}
+	if _, err := io.WriteString(conn, "OK\n"); err != nil {
+		return err
+	}
+
+	var limiter *ratelimit.Limiter
+	if s.opts.Rate > 0 {
+		limiter = ratelimit.New(s.opts.Rate)
+	}
+	if dir == tcpproto.Download {
+		var src io.Reader = io.NewSectionReader(content{}, 0, size)
+		if size == 0 {
+			src = &infiniteReader{}
+		}
+		_, err := io.Copy(&pacedWriter{w: conn, ctx: ctx, limiter: limiter, counter: &s.bytes}, src)
+		return err
+	}
+
+	// 上传：读到客户端关闭写方向后回复收到的字节数
+	buf := make([]byte, chunkSize)
+	var received int64
+	for {
+		n, err := r.Read(buf)
+		received += int64(n)
+		s.received.Add(int64(n))
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if limiter != nil {
+			if err := limiter.WaitN(ctx, n); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = fmt.Fprintf(conn, "OK %d\n", received)
+	return err
+}
+
+// parseTCPRequest 解析请求行并检查大小上限：超过上限的下载被拒绝，
+// 不限字节数的下载（客户端按时长结束）最多发送到上限
+func (s *Server) parseTCPRequest(line string) (dir string, size int64, err error) {
+	if dir, size, err = tcpproto.ParseRequest(line); err != nil {
+		return "", 0, err
+	}
+	if dir == tcpproto.Download && s.opts.MaxSize > 0 {
+		if size > s.opts.MaxSize {
+			return "", 0, fmt.Errorf("大小超过上限 %s", units.FormatBytes(s.opts.MaxSize))
+		}
+		size = cmp.Or(size, s.opts.MaxSize)
+	}
+	return dir, size, nil
+}
+
+// idleConn 每次读写前把对应方向的截止时间设为 timeout 之后，timeout 为 0 时不设置
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+// Read 实现 io.Reader
+func (c *idleConn) Read(p []byte) (int, error) {
+	if c.timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	}
+	return c.Conn.Read(p)
+}
+
+// Write 实现 io.Writer
+func (c *idleConn) Write(p []byte) (int, error) {
+	if c.timeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	}
+	return c.Conn.Write(p)
+}
+
+// infiniteReader 无限长的合成数据
+type infiniteReader struct{ off int64 }
+
+// Read 实现 io.Reader
+func (r *infiniteReader) Read(p []byte) (int, error) {
+	n, _ := content{}.ReadAt(p, r.off)
+	r.off += int64(n)
+	return n, nil
+}
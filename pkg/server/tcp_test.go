@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTCP 启动 TCP 测试服务器，返回监听地址
+func startTCP(t *testing.T, s *Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.ServeTCP(ctx, ln) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("ServeTCP() error = %v", err)
+		}
+	})
+	return ln.Addr().String()
+}
+
+// dialTCP 连接并发送请求行，返回连接和服务器的回复
+func dialTCP(t *testing.T, addr, request string) (net.Conn, *bufio.Reader, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprint(conn, request)
+	r := bufio.NewReader(conn)
+	reply, _ := r.ReadString('\n')
+	return conn, r, reply
+}
+
+func TestServeTCP(t *testing.T) {
+	s := New(Options{MaxSize: 1 << 30})
+	addr := startTCP(t, s)
+
+	// 下载：收到指定字节数的合成数据后连接关闭
+	_, r, reply := dialTCP(t, addr, "NETFLOOD/1 download 100000\n")
+	body, err := io.ReadAll(r)
+	if reply != "OK\n" || err != nil || !bytes.Equal(body, Pattern(0, 100000)) {
+		t.Errorf("download: reply = %q, err = %v, %d bytes", reply, err, len(body))
+	}
+
+	// 上传：关闭写方向后服务器回复收到的字节数
+	conn, r, reply := dialTCP(t, addr, "NETFLOOD/1 upload 0\n")
+	conn.Write(make([]byte, 300000))
+	conn.(*net.TCPConn).CloseWrite()
+	if confirm, _ := r.ReadString('\n'); reply != "OK\n" || confirm != "OK 300000\n" {
+		t.Errorf("upload: reply = %q, confirm = %q", reply, confirm)
+	}
+
+	tests := []struct{ request, want string }{
+		{"GET / HTTP/1.1\n", "无效的请求"},
+		{"NETFLOOD/1 sideways 10\n", "未知的方向"},
+		{"NETFLOOD/1 download -1\n", "无效的字节数"},
+		{"NETFLOOD/1 download 2147483648\n", "上限"},
+	}
+	for _, tt := range tests {
+		if _, _, reply := dialTCP(t, addr, tt.request); !strings.HasPrefix(reply, "ERR ") || !strings.Contains(reply, tt.want) {
+			t.Errorf("request %q: reply = %q, want ERR containing %q", tt.request, reply, tt.want)
+		}
+	}
+
+	if st := s.Stats(); st.Bytes != 100000 || st.Received != 300000 || st.Requests != 6 {
+		t.Errorf("Stats() = %+v", st)
+	}
+}
+
+func TestServeTCP_MaxSizeCapsUnbounded(t *testing.T) {
+	addr := startTCP(t, New(Options{MaxSize: 200000}))
+
+	// 不限字节数的下载发送到上限后关闭连接
+	_, r, reply := dialTCP(t, addr, "NETFLOOD/1 download 0\n")
+	n, err := io.Copy(io.Discard, r)
+	if reply != "OK\n" || err != nil || n != 200000 {
+		t.Errorf("unbounded download: reply = %q, err = %v, %d bytes, want 200000", reply, err, n)
+	}
+}
+
+func TestServeTCP_Rate(t *testing.T) {
+	addr := startTCP(t, New(Options{Rate: 1 << 20}))
+
+	// 不限字节数时一直发送，按连接限速：首个 64KB 为突发量
+	conn, r, reply := dialTCP(t, addr, "NETFLOOD/1 download 0\n")
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	n, _ := io.Copy(io.Discard, r)
+	if reply != "OK\n" || n < 400<<10 || n > 700<<10 {
+		t.Errorf("download at 1MB/s for 500ms: reply = %q, %d bytes", reply, n)
+	}
+}
+
+func TestServeTCP_IdleTimeout(t *testing.T) {
+	tests := []struct{ name, request string }{
+		{"download to a peer that stops reading", "NETFLOOD/1 download 0\n"},
+		{"upload from a peer that stops sending", "NETFLOOD/1 upload 0\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(Options{})
+			s.tcpIdle = 100 * time.Millisecond
+			addr := startTCP(t, s)
+
+			// 握手后对端不再收发也不关闭连接，空闲超时后服务器关闭连接
+			if _, _, reply := dialTCP(t, addr, tt.request); reply != "OK\n" {
+				t.Fatalf("reply = %q, want OK", reply)
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for s.Stats().Connections > 0 {
+				if time.Now().After(deadline) {
+					t.Fatalf("Stats().Connections = %d after the peer went silent, want 0", s.Stats().Connections)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
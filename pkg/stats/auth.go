@@ -0,0 +1,267 @@
+package stats
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 签名相关的请求头
+const (
+	HeaderTimestamp = "X-Netflood-Timestamp" // Unix 秒
+	HeaderNonce     = "X-Netflood-Nonce"     // 随机数，每个请求不同
+	HeaderSignature = "X-Netflood-Signature" // sha256=<十六进制 HMAC-SHA256>
+)
+
+// DefaultMaxSkew 校验签名时允许的时间偏差，超出时视为过期
+const DefaultMaxSkew = 5 * time.Minute
+
+// 签名校验错误
+var (
+	ErrInvalidSignature = errors.New("签名无效")
+	ErrExpired          = errors.New("签名已过期")
+	ErrReplay           = errors.New("重复的请求")
+)
+
+// AuthOptions HTTP 输出目标的认证配置
+// 密钥类字段可直接填写，也可通过 *_file（读取文件内容）或 *_env（读取环境变量）提供，优先级为直接填写 > 文件 > 环境变量
+type AuthOptions struct {
+	Token          string            `yaml:"token"`            // Bearer token
+	TokenFile      string            `yaml:"token_file"`       // 从文件读取 Bearer token
+	TokenEnv       string            `yaml:"token_env"`        // 从环境变量读取 Bearer token
+	Headers        map[string]string `yaml:"headers"`          // 自定义请求头，值中的 ${VAR} 替换为环境变量
+	HMACSecret     string            `yaml:"hmac_secret"`      // HMAC-SHA256 签名密钥
+	HMACSecretFile string            `yaml:"hmac_secret_file"` // 从文件读取签名密钥
+	HMACSecretEnv  string            `yaml:"hmac_secret_env"`  // 从环境变量读取签名密钥
+	TLS            TLSOptions        `yaml:"tls"`              // HTTPS 与客户端证书（mTLS）
+}
+
+// TLSOptions HTTPS 连接配置
+type TLSOptions struct {
+	CAFile             string `yaml:"ca_file"`              // 校验服务器证书的 CA，默认使用系统 CA
+	CertFile           string `yaml:"cert_file"`            // 客户端证书（mTLS）
+	KeyFile            string `yaml:"key_file"`             // 客户端私钥（mTLS）
+	ServerName         string `yaml:"server_name"`          // 校验服务器证书时使用的名称，默认取自 URL
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // 不校验服务器证书，仅用于测试
+}
+
+// IsZero 返回是否未配置任何认证
+func (o AuthOptions) IsZero() bool {
+	return o.Token == "" && o.TokenFile == "" && o.TokenEnv == "" && len(o.Headers) == 0 &&
+		o.HMACSecret == "" && o.HMACSecretFile == "" && o.HMACSecretEnv == "" && o.TLS == TLSOptions{}
+}
+
+// Auth 为 HTTP 请求添加认证信息和签名
+type Auth struct {
+	token   string
+	headers map[string]string
+	secret  []byte
+	tls     *tls.Config
+	now     func() time.Time
+}
+
+// NewAuth 按配置读取密钥、加载证书
+func NewAuth(opts AuthOptions) (*Auth, error) {
+	a := &Auth{headers: make(map[string]string), now: time.Now}
+
+	token, err := readSecret("token", opts.Token, opts.TokenFile, opts.TokenEnv)
+	if err != nil {
+		return nil, err
+	}
+	a.token = token
+
+	secret, err := readSecret("hmac_secret", opts.HMACSecret, opts.HMACSecretFile, opts.HMACSecretEnv)
+	if err != nil {
+		return nil, err
+	}
+	a.secret = []byte(secret)
+
+	for k, v := range opts.Headers {
+		a.headers[k] = os.ExpandEnv(v)
+	}
+
+	if opts.TLS != (TLSOptions{}) {
+		if a.tls, err = loadTLS(opts.TLS); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// readSecret 按直接填写、文件、环境变量的顺序读取密钥，文件内容去除首尾空白
+// 指定了文件或环境变量但读取结果为空时返回错误，避免静默地以未认证方式发送
+func readSecret(field, value, file, env string) (string, error) {
+	switch {
+	case value != "":
+		return value, nil
+	case file != "":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("读取 %s 文件失败: %w", field, err)
+		}
+		if s := strings.TrimSpace(string(data)); s != "" {
+			return s, nil
+		}
+		return "", fmt.Errorf("%s 文件为空: %s", field, file)
+	case env != "":
+		if s := os.Getenv(env); s != "" {
+			return s, nil
+		}
+		return "", fmt.Errorf("环境变量 %s 未设置（%s）", env, field)
+	}
+	return "", nil
+}
+
+// loadTLS 加载 CA 和客户端证书
+func loadTLS(opts TLSOptions) (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: opts.ServerName, InsecureSkipVerify: opts.InsecureSkipVerify}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 CA 证书失败: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA 证书中没有有效的 PEM 证书: %s", opts.CAFile)
+		}
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		if opts.CertFile == "" || opts.KeyFile == "" {
+			return nil, errors.New("客户端证书需要同时设置 cert_file 和 key_file")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("加载客户端证书失败: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// Client 返回发送请求使用的 HTTP 客户端，配置了 TLS 时使用对应的证书；a 为 nil 时返回默认客户端
+func (a *Auth) Client() *http.Client {
+	if a == nil || a.tls == nil {
+		return &http.Client{}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = a.tls
+	return &http.Client{Transport: transport}
+}
+
+// Apply 为请求添加请求头、Bearer token 和签名，body 为请求体；a 为 nil 时不做任何操作
+func (a *Auth) Apply(req *http.Request, body []byte) error {
+	if a == nil {
+		return nil
+	}
+	for k, v := range a.headers {
+		req.Header.Set(k, v)
+	}
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	if len(a.secret) > 0 {
+		return signHeader(req.Header, a.secret, a.now(), body)
+	}
+	return nil
+}
+
+// signHeader 生成随机数，把时间戳、随机数和签名写入 h
+func signHeader(h http.Header, secret []byte, now time.Time, body []byte) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("生成随机数失败: %w", err)
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	h.Set(HeaderTimestamp, timestamp)
+	h.Set(HeaderNonce, hex.EncodeToString(nonce))
+	h.Set(HeaderSignature, Sign(secret, timestamp, hex.EncodeToString(nonce), body))
+	return nil
+}
+
+// Sign 计算请求签名：HMAC-SHA256(secret, timestamp + "\n" + nonce + "\n" + body)，返回 sha256=<十六进制>
+func Sign(secret []byte, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "\n" + nonce + "\n"))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verifier 校验请求签名，拒绝过期和重放的请求（接收端使用）
+type Verifier struct {
+	secret  []byte
+	public  ed25519.PublicKey // 不为 nil 时校验收集器的指令签名（见 NewDirectiveVerifier）
+	maxSkew time.Duration
+	now     func() time.Time
+
+	mu     sync.Mutex
+	nonces map[string]time.Time // 有效期内已使用的随机数及其过期时间
+}
+
+// NewVerifier 创建签名校验器，maxSkew 为允许的时间偏差，小于等于 0 时使用 DefaultMaxSkew
+func NewVerifier(secret []byte, maxSkew time.Duration) *Verifier {
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	return &Verifier{secret: secret, maxSkew: maxSkew, now: time.Now, nonces: make(map[string]time.Time)}
+}
+
+// SignHeader 使用校验器的 HMAC 密钥为响应签名，格式与请求签名相同；指令校验器只有公钥，不能签名
+func (v *Verifier) SignHeader(h http.Header, body []byte) error {
+	if v.public != nil {
+		return errors.New("指令签名校验器不能签名")
+	}
+	return signHeader(h, v.secret, v.now(), body)
+}
+
+// Verify 校验请求头中的签名和请求体；时间戳超出允许偏差或随机数已使用过时返回错误
+func (v *Verifier) Verify(header http.Header, body []byte) error {
+	timestamp, nonce, signature := header.Get(HeaderTimestamp), header.Get(HeaderNonce), header.Get(HeaderSignature)
+	if v.public != nil {
+		signature = header.Get(HeaderDirectiveSignature)
+	}
+	if timestamp == "" || nonce == "" || signature == "" {
+		return fmt.Errorf("%w: 缺少签名请求头", ErrInvalidSignature)
+	}
+	if v.public != nil {
+		if !verifyDirectiveSignature(v.public, header, timestamp, nonce, body) {
+			return ErrInvalidSignature
+		}
+	} else if !hmac.Equal([]byte(signature), []byte(Sign(v.secret, timestamp, nonce, body))) {
+		return ErrInvalidSignature
+	}
+
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: 时间戳格式错误", ErrInvalidSignature)
+	}
+	now := v.now()
+	if skew := now.Sub(time.Unix(sec, 0)).Abs(); skew > v.maxSkew {
+		return fmt.Errorf("%w: 时间偏差 %s", ErrExpired, skew.Round(time.Second))
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for n, expires := range v.nonces {
+		if now.After(expires) {
+			delete(v.nonces, n)
+		}
+	}
+	if _, used := v.nonces[nonce]; used {
+		return ErrReplay
+	}
+	// 时间戳超出偏差后请求会被拒绝，随机数只需保留到那时
+	v.nonces[nonce] = time.Unix(sec, 0).Add(v.maxSkew)
+	return nil
+}
@@ -0,0 +1,223 @@
+package stats
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHTTPSink_Auth(t *testing.T) {
+	verifier := NewVerifier([]byte("s3cret"), 0)
+	var header http.Header
+	var verifyErr error
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		header = r.Header.Clone()
+		verifyErr = verifier.Verify(r.Header, body)
+	}))
+	defer server.Close()
+
+	t.Setenv("NF_TEST_TOKEN", "tok-env")
+	t.Setenv("NF_TEST_REGION", "cn-east")
+	sink, err := NewSink(SinkConfig{Type: SinkHTTP, URL: server.URL, Auth: AuthOptions{
+		TokenEnv:   "NF_TEST_TOKEN",
+		Headers:    map[string]string{"X-Region": "${NF_TEST_REGION}"},
+		HMACSecret: "s3cret",
+	}})
+	if err != nil {
+		t.Fatalf("NewSink() error = %v", err)
+	}
+	defer sink.Close()
+
+	if err := sink.Send(context.Background(), sampleData()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if got := header.Get("Authorization"); got != "Bearer tok-env" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if got := header.Get("X-Region"); got != "cn-east" {
+		t.Errorf("X-Region = %q", got)
+	}
+	if verifyErr != nil {
+		t.Errorf("Verify() error = %v", verifyErr)
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	secret := []byte("s3cret")
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"seq":1}`)
+
+	signed := func(ts time.Time, nonce string) http.Header {
+		timestamp := strconv.FormatInt(ts.Unix(), 10)
+		h := http.Header{}
+		h.Set(HeaderTimestamp, timestamp)
+		h.Set(HeaderNonce, nonce)
+		h.Set(HeaderSignature, Sign(secret, timestamp, nonce, body))
+		return h
+	}
+
+	v := NewVerifier(secret, time.Minute)
+	v.now = func() time.Time { return now }
+
+	tests := []struct {
+		name   string
+		header http.Header
+		body   []byte
+		want   error
+	}{
+		{"valid", signed(now, "n1"), body, nil},
+		{"replay", signed(now, "n1"), body, ErrReplay},
+		{"tampered body", signed(now, "n2"), []byte(`{"seq":2}`), ErrInvalidSignature},
+		{"wrong secret", func() http.Header {
+			h := signed(now, "n3")
+			h.Set(HeaderSignature, Sign([]byte("other"), strconv.FormatInt(now.Unix(), 10), "n3", body))
+			return h
+		}(), body, ErrInvalidSignature},
+		{"missing headers", http.Header{}, body, ErrInvalidSignature},
+		{"expired", signed(now.Add(-2*time.Minute), "n4"), body, ErrExpired},
+		{"future", signed(now.Add(2*time.Minute), "n5"), body, ErrExpired},
+		{"within skew", signed(now.Add(-30*time.Second), "n6"), body, nil},
+	}
+	for _, tt := range tests {
+		if err := v.Verify(tt.header, tt.body); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Verify() error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// 随机数过期后清理，不再占用内存
+	v.now = func() time.Time { return now.Add(time.Hour) }
+	v.Verify(http.Header{}, body)
+	if err := v.Verify(signed(now.Add(time.Hour), "n7"), body); err != nil {
+		t.Errorf("Verify() after an hour error = %v", err)
+	}
+	if len(v.nonces) != 1 {
+		t.Errorf("nonces = %d, want expired ones removed", len(v.nonces))
+	}
+}
+
+func TestReadSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(path, []byte("from-file\n"), 0o600)
+	empty := filepath.Join(t.TempDir(), "empty")
+	os.WriteFile(empty, []byte("\n"), 0o600)
+	t.Setenv("NF_TEST_SECRET", "from-env")
+
+	tests := []struct {
+		value, file, env string
+		want             string
+		wantErr          bool
+	}{
+		{"", "", "", "", false},
+		{"direct", path, "NF_TEST_SECRET", "direct", false},
+		{"", path, "NF_TEST_SECRET", "from-file", false},
+		{"", "", "NF_TEST_SECRET", "from-env", false},
+		{"", filepath.Join(t.TempDir(), "missing"), "", "", true},
+		{"", empty, "", "", true},
+		{"", "", "NF_TEST_UNSET", "", true},
+	}
+	for _, tt := range tests {
+		got, err := readSecret("token", tt.value, tt.file, tt.env)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("readSecret(%q, %q, %q) = %q, %v; want %q, err %v", tt.value, tt.file, tt.env, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNewSink_AuthErrors(t *testing.T) {
+	tests := []SinkConfig{
+		{Type: SinkStatsD, Addr: "127.0.0.1:8125", Auth: AuthOptions{Token: "x"}},
+		{Type: SinkHTTP, URL: "http://x", Auth: AuthOptions{TokenEnv: "NF_TEST_UNSET"}},
+		{Type: SinkHTTP, URL: "http://x", Auth: AuthOptions{TLS: TLSOptions{CertFile: "cert.pem"}}},
+		{Type: SinkHTTP, URL: "http://x", Auth: AuthOptions{TLS: TLSOptions{CAFile: "missing.pem"}}},
+	}
+	for _, cfg := range tests {
+		if _, err := NewSink(cfg); err == nil {
+			t.Errorf("NewSink(%+v): expected error, got nil", cfg)
+		}
+	}
+}
+
+// writeCert 生成自签名证书，写入 dir 并返回证书、私钥文件路径和证书池
+func writeCert(t *testing.T, dir, name string) (string, string, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return certPath, keyPath, pool
+}
+
+func TestHTTPSink_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, clientCAs := writeCert(t, dir, "node")
+
+	var clientName string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientName = r.TLS.PeerCertificates[0].Subject.CommonName
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := filepath.Join(dir, "server-ca.crt")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600)
+
+	sink, err := NewSink(SinkConfig{Type: SinkHTTP, URL: server.URL, Auth: AuthOptions{
+		TLS: TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile},
+	}})
+	if err != nil {
+		t.Fatalf("NewSink() error = %v", err)
+	}
+	defer sink.Close()
+	if err := sink.Send(context.Background(), sampleData()); err != nil {
+		t.Fatalf("Send() with client certificate error = %v", err)
+	}
+	if clientName != "node" {
+		t.Errorf("client certificate CN = %q, want node", clientName)
+	}
+
+	// 不带客户端证书时握手失败
+	noCert, _ := NewSink(SinkConfig{Type: SinkHTTP, URL: server.URL, Auth: AuthOptions{TLS: TLSOptions{CAFile: caFile}}})
+	defer noCert.Close()
+	if err := noCert.Send(context.Background(), sampleData()); err == nil {
+		t.Error("Send() without client certificate: expected error, got nil")
+	}
+}
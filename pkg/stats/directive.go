@@ -0,0 +1,198 @@
+package stats
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 收集器指令的动作
+const (
+	ActionPause       = "pause"        // 暂停下载，中断正在进行的传输
+	ActionResume      = "resume"       // 恢复下载
+	ActionSetRate     = "set_rate"     // 设置总下载速度上限，Value 为每秒字节数，0 表示不限速
+	ActionSetWorkers  = "set_workers"  // 设置工作协程数，Value 为协程数
+	ActionReloadTasks = "reload_tasks" // 重新加载任务列表
+	ActionShutdown    = "shutdown"     // 结束运行（与收到退出信号相同，等待当前任务完成）
+)
+
+// 指令的执行结果
+const (
+	AckApplied  = "applied"  // 已执行
+	AckFailed   = "failed"   // 执行失败
+	AckRejected = "rejected" // 未执行：已过期、不是发给本节点的、未知动作或节点未设置处理函数
+)
+
+// Directive 收集器在上报响应中下发的指令
+// 只有收集器指令签名校验通过的响应中的指令才会执行（见 HTTPSink.AcceptDirectives）
+type Directive struct {
+	ID      string    `json:"id"`                // 指令 ID，节点在之后的上报中确认
+	Node    string    `json:"node"`              // 目标节点标识（node_id，未设置时为名称），与本节点不符时拒绝执行
+	Action  string    `json:"action"`            // pause、resume、set_rate、set_workers、reload_tasks、shutdown
+	Value   int64     `json:"value,omitempty"`   // set_rate 的每秒字节数，set_workers 的协程数
+	Expires time.Time `json:"expires,omitempty"` // 过期时间，之后收到的指令拒绝执行
+}
+
+// Validate 检查动作和参数
+func (d Directive) Validate() error {
+	switch d.Action {
+	case ActionPause, ActionResume, ActionReloadTasks, ActionShutdown:
+	case ActionSetRate:
+		if d.Value < 0 {
+			return fmt.Errorf("%s 的速度不能为负数", d.Action)
+		}
+	case ActionSetWorkers:
+		if d.Value < 1 {
+			return fmt.Errorf("%s 的协程数必须大于 0", d.Action)
+		}
+	default:
+		return fmt.Errorf("未知的指令动作: %q", d.Action)
+	}
+	return nil
+}
+
+// Ack 节点对指令的确认，附加在之后的上报中
+type Ack struct {
+	ID     string    `json:"id"`
+	Action string    `json:"action"`
+	Status string    `json:"status"` // applied、failed 或 rejected
+	Error  string    `json:"error,omitempty"`
+	Time   time.Time `json:"time"` // 处理时间
+}
+
+// ReportResponse HTTP 统计上报的响应体，Directives 为下发给节点的指令
+type ReportResponse struct {
+	Status     string      `json:"status"`
+	Accepted   int         `json:"accepted"`
+	Directives []Directive `json:"directives,omitempty"`
+}
+
+// DirectiveHandler 执行收集器指令，返回错误时确认为 failed
+type DirectiveHandler func(ctx context.Context, d Directive) error
+
+// DirectiveSink 能从上报响应中接收指令的输出目标
+// 每次发送后调用 Directives 取出收到的指令；err 不为 nil 时表示响应中的指令被丢弃的原因（例如签名无效）
+type DirectiveSink interface {
+	Sink
+	Directives() ([]Directive, error)
+}
+
+// ErrUnsignedDirectives 响应中带有指令但没有有效签名
+var ErrUnsignedDirectives = errors.New("响应中的指令没有有效签名，已忽略")
+
+// maxHandledDirectives 记住的已处理指令数量，收集器重复下发时不会重复执行
+const maxHandledDirectives = 256
+
+// SetDirectiveHandler 设置收集器指令的处理函数，未设置时收到的指令均确认为 rejected
+func (r *Reporter) SetDirectiveHandler(handler DirectiveHandler) {
+	r.directiveMu.Lock()
+	defer r.directiveMu.Unlock()
+	r.handler = handler
+}
+
+// pendingDirective 等待执行的指令及收到它的输出目标
+type pendingDirective struct {
+	entry *sinkEntry
+	d     Directive
+}
+
+// receive 取出输出目标收到的指令加入待执行队列；在发送路径中调用，
+// 指令由 applyDirectives 在发送之外执行，处理函数（如重新加载任务）不会阻塞上报
+func (r *Reporter) receive(entry *sinkEntry) {
+	ds, ok := entry.sink.(DirectiveSink)
+	if !ok {
+		return
+	}
+	directives, err := ds.Directives()
+	if err != nil {
+		r.logger.Warn("忽略收集器指令", "sink", entry.name, "error", err)
+	}
+	if len(directives) == 0 {
+		return
+	}
+	r.pendingMu.Lock()
+	for _, d := range directives {
+		r.pending = append(r.pending, pendingDirective{entry, d})
+	}
+	r.pendingMu.Unlock()
+	select {
+	case r.wake <- struct{}{}:
+	default: // 已有待处理的信号
+	}
+}
+
+// runDirectives Run 期间执行收到的指令，直到 ctx 结束；结束时未执行的指令被丢弃
+func (r *Reporter) runDirectives(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.wake:
+			r.applyDirectives(ctx)
+		}
+	}
+}
+
+// applyDirectives 逐条执行待执行的指令，确认附加到收到指令的输出目标的下一次上报
+func (r *Reporter) applyDirectives(ctx context.Context) {
+	for {
+		r.pendingMu.Lock()
+		pending := r.pending
+		r.pending = nil
+		r.pendingMu.Unlock()
+		if len(pending) == 0 {
+			return
+		}
+		for _, p := range pending {
+			ack := r.handle(ctx, p.d)
+			p.entry.mu.Lock()
+			p.entry.acks = append(p.entry.acks, ack)
+			p.entry.mu.Unlock()
+		}
+	}
+}
+
+// handle 执行一条指令并返回确认；已处理过的指令不重复执行，返回原确认
+func (r *Reporter) handle(ctx context.Context, d Directive) Ack {
+	r.directiveMu.Lock()
+	defer r.directiveMu.Unlock()
+
+	if ack, ok := r.handled[d.ID]; ok && d.ID != "" {
+		return ack
+	}
+
+	now := time.Now()
+	ack := Ack{ID: d.ID, Action: d.Action, Status: AckRejected, Time: now}
+	node := cmp.Or(r.identity.NodeID, r.identity.Name)
+	switch err := d.Validate(); {
+	case d.ID == "":
+		ack.Error = "缺少指令 ID"
+	case d.Node != node:
+		ack.Error = fmt.Sprintf("指令的目标节点 %q 不是本节点", d.Node)
+	case !d.Expires.IsZero() && now.After(d.Expires):
+		ack.Error = "指令已过期"
+	case err != nil:
+		ack.Error = err.Error()
+	case r.handler == nil:
+		ack.Error = "节点不支持指令"
+	default:
+		if err := r.handler(ctx, d); err != nil {
+			ack.Status, ack.Error = AckFailed, err.Error()
+		} else {
+			ack.Status = AckApplied
+		}
+	}
+	r.logger.Info("处理收集器指令", "id", d.ID, "action", d.Action, "value", d.Value, "status", ack.Status, "error", ack.Error)
+
+	if d.ID != "" {
+		r.handled[d.ID] = ack
+		r.handledIDs = append(r.handledIDs, d.ID)
+		if len(r.handledIDs) > maxHandledDirectives {
+			delete(r.handled, r.handledIDs[0])
+			r.handledIDs = r.handledIDs[1:]
+		}
+	}
+	return ack
+}
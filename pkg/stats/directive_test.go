@@ -0,0 +1,255 @@
+package stats
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testSigner 创建测试用的指令签名器
+func testSigner(t *testing.T) *DirectiveSigner {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewDirectiveSigner(key)
+}
+
+// directiveServer 返回带指令的上报响应，sign 不为 nil 时用它签名
+func directiveServer(t *testing.T, sign func(http.Header, []byte) error, directives ...Directive) (*httptest.Server, *[]StatsData) {
+	t.Helper()
+	var received []StatsData
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data StatsData
+		json.NewDecoder(r.Body).Decode(&data)
+		received = append(received, data)
+		body, _ := json.Marshal(ReportResponse{Status: "ok", Accepted: 1, Directives: directives})
+		if sign != nil {
+			sign(w.Header(), body)
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &received
+}
+
+func TestHTTPSink_Directives(t *testing.T) {
+	d := Directive{ID: "d1", Node: "n1", Action: ActionPause}
+
+	signer := testSigner(t)
+	server, _ := directiveServer(t, signer.SignHeader, d)
+	sink, err := NewSink(SinkConfig{Type: SinkHTTP, URL: server.URL, Auth: AuthOptions{HMACSecret: "s3cret"}, Directives: true, DirectiveKey: signer.PublicKey()})
+	if err != nil {
+		t.Fatalf("NewSink() error = %v", err)
+	}
+	if err := sink.Send(context.Background(), sampleData()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	got, err := sink.(DirectiveSink).Directives()
+	if err != nil || len(got) != 1 || got[0] != d {
+		t.Errorf("Directives() = %+v, %v", got, err)
+	}
+	if got, _ := sink.(DirectiveSink).Directives(); len(got) != 0 {
+		t.Errorf("Directives() again = %+v, want none", got)
+	}
+
+	// 未签名、密钥不同或只用上报的 HMAC 密钥签名（其他节点也持有）的响应中的指令被丢弃
+	unsigned, _ := directiveServer(t, nil, d)
+	wrongKey, _ := directiveServer(t, testSigner(t).SignHeader, d)
+	forged, _ := directiveServer(t, NewVerifier([]byte("s3cret"), 0).SignHeader, d)
+	for _, server := range []*httptest.Server{unsigned, wrongKey, forged} {
+		sink, _ := NewSink(SinkConfig{Type: SinkHTTP, URL: server.URL, Auth: AuthOptions{HMACSecret: "s3cret"}, Directives: true, DirectiveKey: signer.PublicKey()})
+		if err := sink.Send(context.Background(), sampleData()); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		if got, err := sink.(DirectiveSink).Directives(); len(got) != 0 || !errors.Is(err, ErrUnsignedDirectives) {
+			t.Errorf("Directives() from unsigned response = %+v, %v", got, err)
+		}
+	}
+
+	// 未开启 directives 时忽略响应中的指令
+	plain := NewHTTPSink(server.URL)
+	plain.Send(context.Background(), sampleData())
+	if got, err := plain.Directives(); len(got) != 0 || err != nil {
+		t.Errorf("Directives() without AcceptDirectives = %+v, %v", got, err)
+	}
+}
+
+func TestNewSink_DirectivesErrors(t *testing.T) {
+	tests := []SinkConfig{
+		{Type: SinkHTTP, URL: "http://x", Directives: true},
+		{Type: SinkHTTP, URL: "http://x", Auth: AuthOptions{HMACSecret: "x"}, Directives: true},
+		{Type: SinkHTTP, URL: "http://x", Directives: true, DirectiveKey: "bm90IGEga2V5"},
+		{Type: SinkInfluxHTTP, URL: "http://x", Directives: true, DirectiveKey: testSigner(t).PublicKey()},
+	}
+	for _, cfg := range tests {
+		if _, err := NewSink(cfg); err == nil {
+			t.Errorf("NewSink(%+v): expected error, got nil", cfg)
+		}
+	}
+}
+
+func TestReporter_Directives(t *testing.T) {
+	expired := time.Now().Add(-time.Minute)
+	signer := testSigner(t)
+	server, received := directiveServer(t, signer.SignHeader,
+		Directive{ID: "d1", Node: "n1", Action: ActionSetRate, Value: 1 << 20},
+		Directive{ID: "d2", Node: "n1", Action: ActionShutdown},
+		Directive{ID: "d3", Node: "n2", Action: ActionPause},
+		Directive{ID: "d4", Node: "n1", Action: ActionPause, Expires: expired},
+		Directive{ID: "d5", Node: "n1", Action: "reboot"},
+	)
+	sink, err := NewSink(SinkConfig{Type: SinkHTTP, URL: server.URL, Directives: true, DirectiveKey: signer.PublicKey()})
+	if err != nil {
+		t.Fatalf("NewSink() error = %v", err)
+	}
+
+	var calls []string
+	reporter := NewReporter(fixedSnapshot)
+	reporter.SetLogger(discardLogger())
+	reporter.SetIdentity(Identity{NodeID: "n1"})
+	reporter.AddSink("collector", sink, SinkOptions{})
+	reporter.SetDirectiveHandler(func(ctx context.Context, d Directive) error {
+		calls = append(calls, d.ID)
+		if d.Action == ActionShutdown {
+			return errors.New("busy")
+		}
+		return nil
+	})
+
+	// 第一次上报收到指令，第二次上报附带确认；收集器重复下发的指令不重复执行
+	for range 2 {
+		if err := reporter.Report(context.Background()); err != nil {
+			t.Fatalf("Report() error = %v", err)
+		}
+	}
+	if strings.Join(calls, ",") != "d1,d2" {
+		t.Errorf("handler calls = %v, want d1,d2", calls)
+	}
+	if len(*received) != 2 || len((*received)[0].Acks) != 0 {
+		t.Fatalf("received %+v", *received)
+	}
+	var statuses []string
+	for _, ack := range (*received)[1].Acks {
+		statuses = append(statuses, ack.ID+"="+ack.Status)
+	}
+	if want := "d1=applied,d2=failed,d3=rejected,d4=rejected,d5=rejected"; strings.Join(statuses, ",") != want {
+		t.Errorf("acks = %v, want %s", statuses, want)
+	}
+}
+
+func TestReporter_SlowDirectiveDoesNotBlockReports(t *testing.T) {
+	var reports atomic.Int64
+	signer := testSigner(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reports.Add(1)
+		body, _ := json.Marshal(ReportResponse{Status: "ok", Accepted: 1, Directives: []Directive{{ID: "d1", Node: "n1", Action: ActionReloadTasks}}})
+		signer.SignHeader(w.Header(), body)
+		w.Write(body)
+	}))
+	defer server.Close()
+	sink, err := NewSink(SinkConfig{Type: SinkHTTP, URL: server.URL, Directives: true, DirectiveKey: signer.PublicKey()})
+	if err != nil {
+		t.Fatalf("NewSink() error = %v", err)
+	}
+
+	reporter := NewReporter(fixedSnapshot)
+	reporter.SetLogger(discardLogger())
+	reporter.SetIdentity(Identity{NodeID: "n1"})
+	reporter.AddSink("collector", sink, SinkOptions{Interval: 10 * time.Millisecond})
+	started, release := make(chan struct{}), make(chan struct{})
+	reporter.SetDirectiveHandler(func(ctx context.Context, d Directive) error {
+		close(started)
+		<-release // 例如任务接口很慢
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reporter.Run(ctx)
+	}()
+
+	// 处理函数阻塞期间上报照常进行
+	<-started
+	before := reports.Load()
+	deadline := time.Now().Add(5 * time.Second)
+	for reports.Load() < before+3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if reports.Load() < before+3 {
+		t.Errorf("reports while directive handler blocked = %d, want at least 3", reports.Load()-before)
+	}
+	close(release)
+	cancel()
+	<-done
+}
+
+func TestLoadDirectiveSigner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "directive.key")
+	signer, created, err := LoadDirectiveSigner(path)
+	if err != nil || !created {
+		t.Fatalf("LoadDirectiveSigner() = %v, created %v", err, created)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("key file = %v, %v, want mode 0600", info, err)
+	}
+	again, created, err := LoadDirectiveSigner(path)
+	if err != nil || created || again.PublicKey() != signer.PublicKey() {
+		t.Errorf("LoadDirectiveSigner() again = %v, created %v, same key %v", err, created, again != nil && again.PublicKey() == signer.PublicKey())
+	}
+
+	// 签名可以用公钥校验，篡改响应体或重放时失败
+	v, err := NewDirectiveVerifier(signer.PublicKey(), 0)
+	if err != nil {
+		t.Fatalf("NewDirectiveVerifier() error = %v", err)
+	}
+	header := http.Header{}
+	signer.SignHeader(header, []byte("body"))
+	if err := v.Verify(header, []byte("tampered")); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify(tampered) = %v, want ErrInvalidSignature", err)
+	}
+	if err := v.Verify(header, []byte("body")); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+	if err := v.Verify(header, []byte("body")); !errors.Is(err, ErrReplay) {
+		t.Errorf("Verify() replay = %v, want ErrReplay", err)
+	}
+	if err := v.SignHeader(http.Header{}, nil); err == nil {
+		t.Error("SignHeader() with public key verifier: expected error, got nil")
+	}
+
+	os.WriteFile(path, []byte("not a key"), 0o600)
+	if _, _, err := LoadDirectiveSigner(path); err == nil {
+		t.Error("LoadDirectiveSigner(invalid): expected error, got nil")
+	}
+}
+
+func TestReporter_DirectivesWithoutHandler(t *testing.T) {
+	reporter := NewReporter(fixedSnapshot)
+	reporter.SetLogger(discardLogger())
+	reporter.SetIdentity(Identity{Name: "edge-1"})
+	if ack := reporter.handle(context.Background(), Directive{ID: "d1", Node: "edge-1", Action: ActionPause}); ack.Status != AckRejected {
+		t.Errorf("handle() without handler = %+v, want rejected", ack)
+	}
+
+	// 超出上限后最早处理的指令被忘记
+	for i := range maxHandledDirectives {
+		reporter.handle(context.Background(), Directive{ID: fmt.Sprintf("id-%d", i), Node: "edge-1", Action: ActionPause})
+	}
+	if _, ok := reporter.handled["d1"]; ok || len(reporter.handled) != maxHandledDirectives {
+		t.Errorf("handled = %d entries, d1 remembered = %v", len(reporter.handled), ok)
+	}
+}
@@ -0,0 +1,115 @@
+package stats
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HeaderDirectiveSignature 带指令的上报响应的签名：ed25519=<base64 签名>，
+// 签名内容与请求签名相同（时间戳、随机数和响应体），时间戳和随机数使用相同的请求头
+const HeaderDirectiveSignature = "X-Netflood-Directive-Signature"
+
+// DirectiveSigner 收集器使用独立的 Ed25519 私钥为带指令的响应签名；
+// 节点只持有公钥，持有上报签名密钥的节点也无法伪造指令
+type DirectiveSigner struct {
+	key ed25519.PrivateKey
+	now func() time.Time
+}
+
+// NewDirectiveSigner 创建指令签名器
+func NewDirectiveSigner(key ed25519.PrivateKey) *DirectiveSigner {
+	return &DirectiveSigner{key: key, now: time.Now}
+}
+
+// LoadDirectiveSigner 读取 PEM（PKCS#8）格式的 Ed25519 私钥；文件不存在时生成新密钥并写入（权限 0600），
+// created 表示是否新生成
+func LoadDirectiveSigner(path string) (s *DirectiveSigner, created bool, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, false, fmt.Errorf("生成指令签名密钥失败: %w", err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, false, fmt.Errorf("编码指令签名密钥失败: %w", err)
+		}
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+			return nil, false, fmt.Errorf("保存指令签名密钥失败: %w", err)
+		}
+		return NewDirectiveSigner(key), true, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("读取指令签名密钥失败: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, false, fmt.Errorf("指令签名密钥不是 PEM 格式: %s", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, false, fmt.Errorf("解析指令签名密钥失败: %w", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, false, fmt.Errorf("指令签名密钥不是 Ed25519 密钥: %T", parsed)
+	}
+	return NewDirectiveSigner(key), false, nil
+}
+
+// PublicKey 返回 base64 编码的公钥，填写在节点 http 输出目标的 directive_key 中
+func (s *DirectiveSigner) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey))
+}
+
+// SignHeader 为响应签名，把时间戳、随机数和签名写入 h
+func (s *DirectiveSigner) SignHeader(h http.Header, body []byte) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("生成随机数失败: %w", err)
+	}
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+	h.Set(HeaderTimestamp, timestamp)
+	h.Set(HeaderNonce, hex.EncodeToString(nonce))
+	sig := ed25519.Sign(s.key, signedMessage(timestamp, hex.EncodeToString(nonce), body))
+	h.Set(HeaderDirectiveSignature, "ed25519="+base64.StdEncoding.EncodeToString(sig))
+	return nil
+}
+
+// NewDirectiveVerifier 创建校验收集器指令签名的校验器，publicKey 为 base64 编码的 Ed25519 公钥，
+// maxSkew 小于等于 0 时使用 DefaultMaxSkew
+func NewDirectiveVerifier(publicKey string, maxSkew time.Duration) (*Verifier, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("指令签名公钥应为 base64 编码的 Ed25519 公钥")
+	}
+	v := NewVerifier(nil, maxSkew)
+	v.public = ed25519.PublicKey(key)
+	return v, nil
+}
+
+// verifyDirectiveSignature 校验 Ed25519 签名
+func verifyDirectiveSignature(key ed25519.PublicKey, header http.Header, timestamp, nonce string, body []byte) bool {
+	sig, ok := strings.CutPrefix(header.Get(HeaderDirectiveSignature), "ed25519=")
+	if !ok {
+		return false
+	}
+	raw, err := base64.StdEncoding.DecodeString(sig)
+	return err == nil && ed25519.Verify(key, signedMessage(timestamp, nonce, body), raw)
+}
+
+// signedMessage 签名的内容：timestamp + "\n" + nonce + "\n" + body
+func signedMessage(timestamp, nonce string, body []byte) []byte {
+	return append([]byte(timestamp+"\n"+nonce+"\n"), body...)
+}
@@ -0,0 +1,120 @@
+package stats
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// 直方图桶参数：从 1µs 开始，每个 2 倍区间再细分为 8 个桶（相对误差约 9%），
+// 共 30 个 2 倍区间，最大约 18 分钟，超出部分计入最后一个桶
+const (
+	histogramSubBuckets = 8
+	histogramOctaves    = 30
+	histogramBuckets    = histogramSubBuckets*histogramOctaves + 1
+	histogramMin        = time.Microsecond
+)
+
+// Histogram 耗时直方图（指数分桶，并发安全）
+type Histogram struct {
+	mu     sync.Mutex
+	counts [histogramBuckets]int64
+	count  int64
+	sum    time.Duration
+	max    time.Duration
+}
+
+// bucketIndex 计算耗时所在的桶
+func bucketIndex(d time.Duration) int {
+	if d <= histogramMin {
+		return 0
+	}
+	idx := int(math.Ceil(math.Log2(float64(d)/float64(histogramMin)) * histogramSubBuckets))
+	if idx >= histogramBuckets {
+		return histogramBuckets - 1
+	}
+	return idx
+}
+
+// bucketUpperBound 返回桶的上界
+func bucketUpperBound(idx int) time.Duration {
+	return time.Duration(float64(histogramMin) * math.Exp2(float64(idx)/histogramSubBuckets))
+}
+
+// Record 记录一次耗时
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	idx := bucketIndex(d)
+
+	h.mu.Lock()
+	h.counts[idx]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+	h.mu.Unlock()
+}
+
+// Count 返回记录次数
+func (h *Histogram) Count() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// Quantile 返回分位数（q 取值 0-1），没有数据时返回 0
+func (h *Histogram) Quantile(q float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.quantileLocked(q)
+}
+
+func (h *Histogram) quantileLocked(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(q * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			// 最后一个桶没有上界；其余桶的上界可能超过实际最大值，取较小者
+			if i == histogramBuckets-1 {
+				return h.max
+			}
+			return min(bucketUpperBound(i), h.max)
+		}
+	}
+	return h.max
+}
+
+// Percentiles 返回 p50/p90/p99（单位：毫秒）
+func (h *Histogram) Percentiles() Percentiles {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return Percentiles{
+		P50: durationMillis(h.quantileLocked(0.50)),
+		P90: durationMillis(h.quantileLocked(0.90)),
+		P99: durationMillis(h.quantileLocked(0.99)),
+	}
+}
+
+// Percentiles 分位数（单位：毫秒）
+type Percentiles struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+// durationMillis 把耗时转换为毫秒（保留小数）
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,71 @@
+package stats
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHistogram_Empty(t *testing.T) {
+	var h Histogram
+	if got := h.Quantile(0.5); got != 0 {
+		t.Errorf("Quantile() on empty histogram = %v, want 0", got)
+	}
+	if got := h.Percentiles(); got != (Percentiles{}) {
+		t.Errorf("Percentiles() on empty histogram = %+v, want zero", got)
+	}
+}
+
+func TestHistogram_Quantile(t *testing.T) {
+	var h Histogram
+	for i := 1; i <= 100; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	if h.Count() != 100 {
+		t.Fatalf("Count() = %d, want 100", h.Count())
+	}
+
+	tests := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0.50, 50 * time.Millisecond},
+		{0.90, 90 * time.Millisecond},
+		{0.99, 99 * time.Millisecond},
+		{1.00, 100 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		got := h.Quantile(tt.q)
+		// 指数分桶的相对误差约 9%
+		if diff := math.Abs(float64(got-tt.want)) / float64(tt.want); diff > 0.1 {
+			t.Errorf("Quantile(%v) = %v, want about %v", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestHistogram_Overflow(t *testing.T) {
+	var h Histogram
+	h.Record(48 * time.Hour)
+	if got := h.Quantile(0.5); got != 48*time.Hour {
+		t.Errorf("Quantile() = %v, want %v", got, 48*time.Hour)
+	}
+}
+
+func TestTimingRecorder_SkipsMissingPhases(t *testing.T) {
+	var r TimingRecorder
+	r.Record(RequestTiming{Connect: 10 * time.Millisecond, TTFB: 20 * time.Millisecond})
+	r.Record(RequestTiming{TTFB: 30 * time.Millisecond, Reused: true})
+
+	s := r.Summary()
+	if s.Requests != 2 || s.Reused != 1 {
+		t.Errorf("Requests/Reused = %d/%d, want 2/1", s.Requests, s.Reused)
+	}
+	if r.connect.Count() != 1 {
+		t.Errorf("connect count = %d, want 1 (reused connection must not be recorded)", r.connect.Count())
+	}
+	if s.TLS != (Percentiles{}) {
+		t.Errorf("TLS = %+v, want zero for plain HTTP", s.TLS)
+	}
+}
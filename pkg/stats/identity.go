@@ -0,0 +1,118 @@
+package stats
+
+import (
+	"cmp"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dora-exku/netflood/pkg/history"
+)
+
+// Identity 节点身份，附加在每次上报的数据中
+type Identity struct {
+	Name    string            // 节点名称，默认为主机名
+	NodeID  string            // 持久化的节点 ID，重启和改名后不变
+	RunID   string            // 本次运行的 ID，每次启动不同
+	Version string            // 程序版本
+	Labels  map[string]string // 自定义标签，例如 region、isp、rack
+}
+
+// NewUUID 生成随机的 UUID（版本 4）
+func NewUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// DefaultNodeIDPath 返回默认的节点 ID 文件路径（用户配置目录下的 netflood/node-id），无法确定配置目录时返回空
+func DefaultNodeIDPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "netflood", "node-id")
+}
+
+// LoadNodeID 从文件读取节点 ID，文件不存在时生成新的 UUID 并保存
+// 文件内容可以手动修改为任意不含空白的字符串
+func LoadNodeID(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		id := strings.TrimSpace(string(data))
+		if id == "" || strings.ContainsAny(id, " \t\r\n") {
+			return "", fmt.Errorf("节点 ID 文件内容无效: %s", path)
+		}
+		return id, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("读取节点 ID 失败: %w", err)
+	}
+
+	id := NewUUID()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("创建节点 ID 目录失败: %w", err)
+	}
+	if err := history.WriteFileAtomic(path, []byte(id+"\n")); err != nil {
+		return "", fmt.Errorf("保存节点 ID 失败: %w", err)
+	}
+	return id, nil
+}
+
+// ParseLabel 解析 key=value 格式的标签
+func ParseLabel(s string) (string, string, error) {
+	key, value, ok := strings.Cut(s, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("标签格式应为 key=value: %q", s)
+	}
+	return key, strings.TrimSpace(value), nil
+}
+
+// 请求任务接口时附加节点身份的请求头，收集器据此为节点分配任务
+const (
+	HeaderNodeID   = "X-Netflood-Node-Id"
+	HeaderNodeName = "X-Netflood-Node-Name"
+	HeaderLabels   = "X-Netflood-Labels" // URL 查询参数格式，例如 region=eu&isp=ct
+)
+
+// SetHeader 把节点身份写入请求头，未设置的名称使用主机名
+func (id Identity) SetHeader(h http.Header) {
+	if id.NodeID != "" {
+		h.Set(HeaderNodeID, id.NodeID)
+	}
+	if name := cmp.Or(id.Name, hostname()); name != "" {
+		h.Set(HeaderNodeName, name)
+	}
+	if len(id.Labels) > 0 {
+		values := url.Values{}
+		for k, v := range id.Labels {
+			values.Set(k, v)
+		}
+		h.Set(HeaderLabels, values.Encode())
+	}
+}
+
+// IdentityFromHeader 从请求头读取 SetHeader 写入的节点身份，格式不正确的标签被忽略
+func IdentityFromHeader(h http.Header) Identity {
+	id := Identity{NodeID: h.Get(HeaderNodeID), Name: h.Get(HeaderNodeName)}
+	if values, err := url.ParseQuery(h.Get(HeaderLabels)); err == nil && len(values) > 0 {
+		id.Labels = make(map[string]string, len(values))
+		for k := range values {
+			id.Labels[k] = values.Get(k)
+		}
+	}
+	return id
+}
+
+// hostname 返回主机名，获取失败时返回空
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
@@ -0,0 +1,98 @@
+package stats
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestLoadNodeID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netflood", "node-id")
+
+	first, err := LoadNodeID(path)
+	if err != nil {
+		t.Fatalf("LoadNodeID() error = %v", err)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(first) {
+		t.Errorf("LoadNodeID() = %q, want a UUID v4", first)
+	}
+	second, err := LoadNodeID(path)
+	if err != nil || second != first {
+		t.Errorf("LoadNodeID() second call = %q, %v; want persisted %q", second, err, first)
+	}
+
+	os.WriteFile(path, []byte("  custom-id\n"), 0o644)
+	if id, err := LoadNodeID(path); err != nil || id != "custom-id" {
+		t.Errorf("LoadNodeID() = %q, %v; want custom-id", id, err)
+	}
+	os.WriteFile(path, []byte("\n"), 0o644)
+	if _, err := LoadNodeID(path); err == nil {
+		t.Error("LoadNodeID() with empty file: expected error, got nil")
+	}
+}
+
+func TestParseLabel(t *testing.T) {
+	tests := []struct {
+		in         string
+		key, value string
+		wantErr    bool
+	}{
+		{"region=cn-east", "region", "cn-east", false},
+		{" rack = r1 ", "rack", "r1", false},
+		{"empty=", "empty", "", false},
+		{"a=b=c", "a", "b=c", false},
+		{"novalue", "", "", true},
+		{"=x", "", "", true},
+	}
+	for _, tt := range tests {
+		key, value, err := ParseLabel(tt.in)
+		if key != tt.key || value != tt.value || (err != nil) != tt.wantErr {
+			t.Errorf("ParseLabel(%q) = %q, %q, %v", tt.in, key, value, err)
+		}
+	}
+}
+
+func TestReporter_Identity(t *testing.T) {
+	sink := &stubSink{}
+	reporter := NewReporter(fixedSnapshot)
+	runID := reporter.Identity().RunID
+	if runID == "" {
+		t.Fatal("NewReporter() did not generate a run ID")
+	}
+
+	reporter.SetIdentity(Identity{NodeID: "node-1", Version: "v1", Labels: map[string]string{"region": "eu"}})
+	reporter.AddSink("s", sink, SinkOptions{})
+	reporter.Report(context.Background())
+
+	got := sink.received()[0]
+	if got.Name != reporter.GetHostname() || got.NodeID != "node-1" || got.RunID != runID || got.Version != "v1" || got.Labels["region"] != "eu" {
+		t.Errorf("received %+v, want hostname, node ID, kept run ID, version and labels", got)
+	}
+
+	reporter.SetIdentity(Identity{Name: "edge-1"})
+	reporter.Report(context.Background())
+	if got := sink.received()[1]; got.Name != "edge-1" {
+		t.Errorf("Name = %q, want edge-1", got.Name)
+	}
+}
+
+func TestIdentity_Header(t *testing.T) {
+	h := http.Header{}
+	Identity{NodeID: "node-1", Name: "edge-1", Labels: map[string]string{"region": "eu west", "isp": "a&b"}}.SetHeader(h)
+	got := IdentityFromHeader(h)
+	if got.NodeID != "node-1" || got.Name != "edge-1" || got.Labels["region"] != "eu west" || got.Labels["isp"] != "a&b" || len(got.Labels) != 2 {
+		t.Errorf("IdentityFromHeader() = %+v", got)
+	}
+
+	h = http.Header{}
+	Identity{}.SetHeader(h)
+	if name, _ := os.Hostname(); h.Get(HeaderNodeName) != name || h.Get(HeaderNodeID) != "" || h.Get(HeaderLabels) != "" {
+		t.Errorf("SetHeader() of empty identity = %v, want hostname only", h)
+	}
+	if got := IdentityFromHeader(http.Header{}); got.Labels != nil || got.NodeID != "" {
+		t.Errorf("IdentityFromHeader() of empty header = %+v", got)
+	}
+}
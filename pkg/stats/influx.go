@@ -0,0 +1,147 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultMeasurement 默认的 InfluxDB measurement
+const DefaultMeasurement = "netflood"
+
+// LineProtocol 把统计数据编码为一行 InfluxDB 行协议（纳秒时间戳，不含换行）
+// 节点名称、节点 ID 和版本作为 host、node_id、version 标签，自定义标签和 extraTags 为附加标签（同名时不覆盖前三者）
+// 运行 ID 每次启动都不同，不作为标签
+func LineProtocol(measurement string, data StatsData, extraTags map[string]string) string {
+	if measurement == "" {
+		measurement = DefaultMeasurement
+	}
+
+	tags := map[string]string{}
+	for k, v := range data.Labels {
+		tags[k] = v
+	}
+	for k, v := range extraTags {
+		tags[k] = v
+	}
+	tags["host"] = data.Name
+	tags["node_id"] = data.NodeID
+	tags["version"] = data.Version
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		if tags[k] != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(lineEscaper.Replace(measurement))
+	for _, k := range keys {
+		b.WriteString("," + tagEscaper.Replace(k) + "=" + tagEscaper.Replace(tags[k]))
+	}
+	for i, m := range metrics(data) {
+		if i == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(tagEscaper.Replace(m.name) + "=")
+		if m.integer {
+			b.WriteString(strconv.FormatInt(int64(m.value), 10) + "i")
+		} else {
+			b.WriteString(strconv.FormatFloat(m.value, 'f', -1, 64))
+		}
+	}
+	b.WriteString(" " + strconv.FormatInt(data.Timestamp.UnixNano(), 10))
+	return b.String()
+}
+
+var (
+	lineEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper  = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
+
+// InfluxHTTPSink 通过 HTTP 写入 InfluxDB 行协议
+// url 为完整的写入地址，例如 http://influx:8086/write?db=netflood 或 /api/v2/write?org=...&bucket=...
+type InfluxHTTPSink struct {
+	url         string
+	measurement string
+	client      *http.Client
+	auth        *Auth
+}
+
+// NewInfluxHTTPSink 创建 InfluxDB HTTP 输出目标，measurement 为空时使用 DefaultMeasurement
+func NewInfluxHTTPSink(url, measurement string) *InfluxHTTPSink {
+	return &InfluxHTTPSink{url: url, measurement: measurement, client: &http.Client{}}
+}
+
+// Send 实现 Sink
+func (s *InfluxHTTPSink) Send(ctx context.Context, data StatsData) error {
+	return s.SendBatch(ctx, []StatsData{data})
+}
+
+// SendBatch 实现 BatchSink，每条数据一行
+func (s *InfluxHTTPSink) SendBatch(ctx context.Context, batch []StatsData) error {
+	body := lines(s.measurement, batch)
+	_, _, err := postBody(ctx, s.client, s.auth, s.url, "text/plain; charset=utf-8", []byte(body))
+	return err
+}
+
+// SetAuth 设置认证、签名和 TLS 配置，nil 表示不认证
+func (s *InfluxHTTPSink) SetAuth(auth *Auth) {
+	s.auth = auth
+	s.client = auth.Client()
+}
+
+// lines 把多条数据编码为行协议，每行以换行结尾
+func lines(measurement string, batch []StatsData) string {
+	var b strings.Builder
+	for _, data := range batch {
+		b.WriteString(LineProtocol(measurement, data, nil) + "\n")
+	}
+	return b.String()
+}
+
+// Close 实现 Sink
+func (s *InfluxHTTPSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// InfluxUDPSink 通过 UDP 发送 InfluxDB 行协议
+type InfluxUDPSink struct {
+	conn        net.Conn
+	measurement string
+}
+
+// NewInfluxUDPSink 创建 InfluxDB UDP 输出目标，measurement 为空时使用 DefaultMeasurement
+func NewInfluxUDPSink(addr, measurement string) (*InfluxUDPSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("连接 InfluxDB UDP 地址失败: %w", err)
+	}
+	return &InfluxUDPSink{conn: conn, measurement: measurement}, nil
+}
+
+// Send 实现 Sink
+func (s *InfluxUDPSink) Send(ctx context.Context, data StatsData) error {
+	return s.SendBatch(ctx, []StatsData{data})
+}
+
+// SendBatch 实现 BatchSink，整批放在一个数据包中
+func (s *InfluxUDPSink) SendBatch(ctx context.Context, batch []StatsData) error {
+	if _, err := s.conn.Write([]byte(lines(s.measurement, batch))); err != nil {
+		return fmt.Errorf("发送 UDP 数据失败: %w", err)
+	}
+	return nil
+}
+
+// Close 实现 Sink
+func (s *InfluxUDPSink) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,111 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dora-exku/netflood/pkg/history"
+)
+
+// DefaultPrometheusPrefix 默认的 Prometheus 指标名前缀
+const DefaultPrometheusPrefix = "netflood"
+
+// PrometheusText 把统计数据编码为 Prometheus 文本格式（node_exporter textfile collector 使用的格式）
+// 每个指标带 host、node_id 和自定义标签；版本和运行 ID 只出现在 <prefix>_info 指标中，避免标签基数增长
+func PrometheusText(prefix string, data StatsData) string {
+	if prefix == "" {
+		prefix = DefaultPrometheusPrefix
+	}
+	prefix = promName(prefix)
+
+	labels := map[string]string{}
+	for k, v := range data.Labels {
+		labels[promName(k)] = v
+	}
+	labels["host"] = data.Name
+	labels["node_id"] = data.NodeID
+	common := promLabels(labels)
+
+	labels["version"] = data.Version
+	labels["run_id"] = data.RunID
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# TYPE %s_info gauge\n%s_info%s 1\n", prefix, prefix, promLabels(labels))
+	for _, m := range metrics(data) {
+		name := prefix + "_" + m.name
+		fmt.Fprintf(&b, "# TYPE %s gauge\n%s%s %s\n", name, name, common, strconv.FormatFloat(m.value, 'f', -1, 64))
+	}
+	return b.String()
+}
+
+// promLabels 编码标签集合，按名称排序，忽略空值
+func promLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k, v := range labels {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + `="` + promEscaper.Replace(labels[k]) + `"`
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promName 把任意字符串转换为合法的指标或标签名：非字母数字替换为下划线，不能以数字开头
+func promName(s string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, s)
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// PrometheusSink 把最新的统计数据写入 Prometheus 文本文件（原子替换）
+// 配合 node_exporter 的 --collector.textfile.directory 使用，文件名需要以 .prom 结尾
+type PrometheusSink struct {
+	path   string
+	prefix string
+}
+
+// NewPrometheusSink 创建 Prometheus 文本文件输出目标，prefix 为空时使用 DefaultPrometheusPrefix
+func NewPrometheusSink(path, prefix string) *PrometheusSink {
+	return &PrometheusSink{path: path, prefix: prefix}
+}
+
+// Send 实现 Sink
+func (s *PrometheusSink) Send(ctx context.Context, data StatsData) error {
+	if err := history.WriteFileAtomic(s.path, []byte(PrometheusText(s.prefix, data))); err != nil {
+		return fmt.Errorf("写入 Prometheus 文件失败: %w", err)
+	}
+	return nil
+}
+
+// SendBatch 实现 BatchSink，文件只保留最新的一条
+func (s *PrometheusSink) SendBatch(ctx context.Context, batch []StatsData) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	return s.Send(ctx, batch[len(batch)-1])
+}
+
+// Close 实现 Sink
+func (s *PrometheusSink) Close() error {
+	return nil
+}
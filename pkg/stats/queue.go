@@ -0,0 +1,239 @@
+package stats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/history"
+	"github.com/dora-exku/netflood/pkg/units"
+)
+
+// QueueOptions 发送失败时的缓存与重试配置
+type QueueOptions struct {
+	Memory     int           `yaml:"memory"`      // 内存队列容量（条），默认 1000
+	Dir        string        `yaml:"dir"`         // 内存队列满或退出时溢出到磁盘的目录，为空时丢弃最旧的数据
+	MaxDisk    string        `yaml:"max_disk"`    // 磁盘队列大小上限（如 10MB），超过后丢弃最旧的数据，默认 10MB
+	Batch      int           `yaml:"batch"`       // 每次发送的最大条数，默认 50
+	MaxBackoff time.Duration `yaml:"max_backoff"` // 最大重试间隔，默认 5m（初始间隔为上报间隔，失败后翻倍）
+}
+
+// 队列默认值
+const (
+	DefaultQueueMemory = 1000
+	DefaultQueueBatch  = 50
+	DefaultMaxDisk     = 10 * units.MB
+	DefaultMaxBackoff  = 5 * time.Minute
+)
+
+// queue 待发送的统计数据，按采集顺序排列
+// 内存队列满时整体追加到磁盘文件，磁盘上的数据总是早于内存中的数据，发送时先发磁盘上的
+type queue struct {
+	mu        sync.Mutex
+	memory    []StatsData
+	capacity  int
+	path      string // 磁盘队列文件，为空时不溢出到磁盘
+	maxDisk   int64
+	diskCount int   // 磁盘队列中的条数
+	dropped   int64 // 因超出上限丢弃的条数
+}
+
+// newQueue 创建队列，设置了磁盘目录时加载上次退出时保存的数据
+func newQueue(name string, opts QueueOptions) (*queue, error) {
+	q := &queue{capacity: opts.Memory, maxDisk: DefaultMaxDisk}
+	if q.capacity <= 0 {
+		q.capacity = DefaultQueueMemory
+	}
+	if opts.MaxDisk != "" {
+		n, err := units.ParseBytes(opts.MaxDisk)
+		if err != nil {
+			return nil, fmt.Errorf("解析 max_disk 失败: %w", err)
+		}
+		q.maxDisk = n
+	}
+	if opts.Dir == "" {
+		return q, nil
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("创建队列目录失败: %w", err)
+	}
+	q.path = filepath.Join(opts.Dir, queueFileName(name))
+	items, err := q.readDisk()
+	if err != nil {
+		return nil, err
+	}
+	q.diskCount = len(items)
+	return q, nil
+}
+
+// queueFileName 把输出目标名称转换为文件名
+func queueFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, name) + ".queue.jsonl"
+}
+
+// Len 返回队列中的条数（内存和磁盘）
+func (q *queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.memory) + q.diskCount
+}
+
+// Dropped 返回因超出上限丢弃的条数
+func (q *queue) Dropped() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// Push 追加一条数据，内存队列已满时溢出到磁盘（未设置磁盘目录时丢弃最旧的一条）
+// 同一次运行中序号不大于队尾的数据视为重复，不会加入队列
+func (q *queue) Push(data StatsData) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if n := len(q.memory); n > 0 {
+		last := q.memory[n-1]
+		if last.Start.Equal(data.Start) && data.Seq <= last.Seq {
+			return nil
+		}
+	}
+
+	if len(q.memory) >= q.capacity {
+		if q.path == "" {
+			q.memory = q.memory[1:]
+			q.dropped++
+		} else if err := q.spill(); err != nil {
+			return err
+		}
+	}
+	q.memory = append(q.memory, data)
+	return nil
+}
+
+// Peek 返回队首最多 n 条数据；磁盘上有数据时只从磁盘读取
+func (q *queue) Peek(n int) ([]StatsData, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.diskCount > 0 {
+		items, err := q.readDisk()
+		if err != nil {
+			return nil, err
+		}
+		if len(items) > 0 {
+			return items[:min(n, len(items))], nil
+		}
+		q.diskCount = 0 // 文件已被删除或全部损坏
+	}
+	return append([]StatsData(nil), q.memory[:min(n, len(q.memory))]...), nil
+}
+
+// Remove 移除队首 n 条数据（已发送成功的 Peek 结果）
+func (q *queue) Remove(n int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.diskCount > 0 {
+		items, err := q.readDisk()
+		if err != nil {
+			return err
+		}
+		return q.writeDisk(items[min(n, len(items)):])
+	}
+	q.memory = q.memory[min(n, len(q.memory)):]
+	return nil
+}
+
+// Persist 把内存中的数据保存到磁盘，下次启动时继续发送；未设置磁盘目录时不做任何操作
+func (q *queue) Persist() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.path == "" || len(q.memory) == 0 {
+		return nil
+	}
+	return q.spill()
+}
+
+// spill 把内存中的数据追加到磁盘文件，超出大小上限时丢弃最旧的数据
+func (q *queue) spill() error {
+	items, err := q.readDisk()
+	if err != nil {
+		return err
+	}
+	if err := q.writeDisk(append(items, q.memory...)); err != nil {
+		return err
+	}
+	q.memory = nil
+	return nil
+}
+
+// readDisk 读取磁盘队列中的所有数据，文件不存在时返回空
+func (q *queue) readDisk() ([]StatsData, error) {
+	file, err := os.Open(q.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取磁盘队列失败: %w", err)
+	}
+	defer file.Close()
+
+	var items []StatsData
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var data StatsData
+		if err := json.Unmarshal(scanner.Bytes(), &data); err != nil {
+			continue // 跳过损坏的行（例如写入时断电）
+		}
+		items = append(items, data)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取磁盘队列失败: %w", err)
+	}
+	return items, nil
+}
+
+// writeDisk 用 items 替换磁盘队列，超出大小上限时从最旧的开始丢弃
+func (q *queue) writeDisk(items []StatsData) error {
+	lines := make([][]byte, len(items))
+	var size int64
+	for i, data := range items {
+		line, err := json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("序列化数据失败: %w", err)
+		}
+		lines[i] = append(line, '\n')
+		size += int64(len(lines[i]))
+	}
+	for size > q.maxDisk && len(lines) > 0 {
+		size -= int64(len(lines[0]))
+		lines = lines[1:]
+		q.dropped++
+	}
+
+	q.diskCount = len(lines)
+	if len(lines) == 0 {
+		if err := os.Remove(q.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("删除磁盘队列失败: %w", err)
+		}
+		return nil
+	}
+	if err := history.WriteFileAtomic(q.path, bytes.Join(lines, nil)); err != nil {
+		return fmt.Errorf("写入磁盘队列失败: %w", err)
+	}
+	return nil
+}
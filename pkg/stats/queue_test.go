@@ -0,0 +1,102 @@
+package stats
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// seqs 返回数据的序号列表
+func seqs(items []StatsData) string {
+	var result []uint64
+	for _, data := range items {
+		result = append(result, data.Seq)
+	}
+	return fmt.Sprint(result)
+}
+
+func pushSeqs(t *testing.T, q *queue, start time.Time, from, to uint64) {
+	t.Helper()
+	for seq := from; seq <= to; seq++ {
+		if err := q.Push(StatsData{Start: start, Seq: seq}); err != nil {
+			t.Fatalf("Push(%d) error = %v", seq, err)
+		}
+	}
+}
+
+func TestQueue_MemoryDropsOldest(t *testing.T) {
+	q, _ := newQueue("mem", QueueOptions{Memory: 3})
+	start := time.Now()
+	pushSeqs(t, q, start, 1, 5)
+	pushSeqs(t, q, start, 4, 5) // 重复的序号被忽略
+
+	items, _ := q.Peek(10)
+	if seqs(items) != "[3 4 5]" || q.Dropped() != 2 {
+		t.Errorf("Peek() = %s, Dropped() = %d, want [3 4 5] and 2", seqs(items), q.Dropped())
+	}
+}
+
+func TestQueue_SpillsToDisk(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now()
+
+	q, err := newQueue("http://a", QueueOptions{Memory: 2, Dir: dir})
+	if err != nil {
+		t.Fatalf("newQueue() error = %v", err)
+	}
+	pushSeqs(t, q, start, 1, 5)
+	if q.Len() != 5 {
+		t.Fatalf("Len() = %d, want 5", q.Len())
+	}
+
+	// 磁盘上的数据先发送
+	items, _ := q.Peek(10)
+	if seqs(items) != "[1 2 3 4]" {
+		t.Errorf("Peek() = %s, want disk items [1 2 3 4]", seqs(items))
+	}
+	q.Remove(3)
+	items, _ = q.Peek(10)
+	if seqs(items) != "[4]" {
+		t.Errorf("Peek() after Remove(3) = %s, want [4]", seqs(items))
+	}
+
+	// 退出时保存内存中的数据，下次启动时加载
+	if err := q.Persist(); err != nil {
+		t.Fatalf("Persist() error = %v", err)
+	}
+	reopened, err := newQueue("http://a", QueueOptions{Memory: 2, Dir: dir})
+	if err != nil {
+		t.Fatalf("newQueue() error = %v", err)
+	}
+	items, _ = reopened.Peek(10)
+	if seqs(items) != "[4 5]" || reopened.Len() != 2 {
+		t.Errorf("reopened Peek() = %s, Len() = %d, want [4 5] and 2", seqs(items), reopened.Len())
+	}
+
+	reopened.Remove(2)
+	if _, err := os.Stat(filepath.Join(dir, "http___a.queue.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("queue file still exists after all items were sent: %v", err)
+	}
+}
+
+func TestQueue_MaxDisk(t *testing.T) {
+	q, err := newQueue("bounded", QueueOptions{Memory: 1, Dir: t.TempDir(), MaxDisk: "400B"})
+	if err != nil {
+		t.Fatalf("newQueue() error = %v", err)
+	}
+	pushSeqs(t, q, time.Now(), 1, 20)
+
+	items, _ := q.Peek(100)
+	if len(items) == 0 || len(items) >= 19 || items[len(items)-1].Seq != 19 {
+		t.Errorf("disk items = %s, want newest items up to 19 within 400 bytes", seqs(items))
+	}
+	if q.Dropped() == 0 {
+		t.Error("Dropped() = 0, want oldest items dropped")
+	}
+
+	if _, err := newQueue("bad", QueueOptions{MaxDisk: "lots"}); err == nil {
+		t.Error("newQueue() with invalid max_disk: expected error, got nil")
+	}
+}
@@ -1,116 +1,531 @@
 package stats
 
 import (
-	"bytes"
+	"cmp"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
+	"log/slog"
+	"math/rand/v2"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
-// StatsData 统计数据结构
+// StatsData 统计数据结构（速度单位均为 MB/s）
 type StatsData struct {
-	Name  string  `json:"name"`  // 主机名称
-	Speed float64 `json:"speed"` // 平均下载速度（MB/s）
-	Total float64 `json:"total"` // 总下载量（MB）
-	Time  string  `json:"time"`  // 时间范围
+	Name      string    `json:"name"`      // 节点名称，默认为主机名
+	Seq       uint64    `json:"seq"`       // 上报序号，每个输出目标从 1 开始单调递增
+	Timestamp time.Time `json:"timestamp"` // 采集时间
+	Start     time.Time `json:"start"`     // 运行开始时间
+
+	NodeID  string            `json:"node_id,omitempty"` // 持久化的节点 ID
+	RunID   string            `json:"run_id"`            // 本次运行的 ID
+	Version string            `json:"version,omitempty"` // 程序版本
+	Labels  map[string]string `json:"labels,omitempty"`  // 自定义标签
+
+	Speed      float64 `json:"speed"`       // 运行期间的平均速度（包括时间段外的等待时间）
+	Total      float64 `json:"total"`       // 总下载量（MB）
+	TotalBytes int64   `json:"total_bytes"` // 总下载量（字节）
+	Completed  int64   `json:"completed"`   // 运行以来成功完成的下载次数
+	Failed     int64   `json:"failed"`      // 运行以来失败的下载次数（包括停滞中止）
+
+	Interval Interval      `json:"interval"`          // 距上一次上报（首次上报从运行开始算起）
+	Session  *SessionStats `json:"session,omitempty"` // 当前下载会话，时间段外等待时为空
+	Window   *Window       `json:"window,omitempty"`  // 当前下载时间段，未启用时间段或不在时间段内时为空
+
+	Latency     *LatencySummary           `json:"latency,omitempty"`       // 总体请求耗时分位数
+	LatencyByIP map[string]LatencySummary `json:"latency_by_ip,omitempty"` // 每个 IP 的请求耗时分位数
+
+	Stalls     int64            `json:"stalls"`                 // 停滞中止次数
+	StallsByIP map[string]int64 `json:"stalls_by_ip,omitempty"` // 每个 IP 的停滞中止次数
+
+	QueueDepth int `json:"queue_depth"` // 采集时该输出目标积压待重试的条数
+
+	Paused  bool  `json:"paused,omitempty"`   // 被收集器指令暂停
+	Workers int   `json:"workers,omitempty"`  // 当前工作协程数
+	MaxRate int64 `json:"max_rate,omitempty"` // 当前总下载速度上限（字节/秒），0 表示不限速
+	Acks    []Ack `json:"acks,omitempty"`     // 对该输出目标上次响应中指令的确认
+
+	LocalMaxRate int64 `json:"local_max_rate,omitempty"` // 节点本地配置的速度上限（-max-rate），收集器的上限不会超过它
+}
+
+// Interval 两次上报之间的增量
+type Interval struct {
+	Start   time.Time `json:"start"`   // 区间开始时间（上一次上报的采集时间）
+	Seconds float64   `json:"seconds"` // 区间时长
+	Bytes   int64     `json:"bytes"`   // 区间内下载的字节数
+	Speed   float64   `json:"speed"`   // 区间平均速度
+
+	Completed int64 `json:"completed"` // 区间内成功完成的下载次数
+	Failed    int64 `json:"failed"`    // 区间内失败的下载次数
+}
+
+// SessionStats 当前下载会话（一次进入时间段或一次全天候运行）的统计
+type SessionStats struct {
+	Start time.Time `json:"start"`
+	Bytes int64     `json:"bytes"`
+	Speed float64   `json:"speed"` // 会话开始以来的平均速度
+}
+
+// Window 下载时间段
+type Window struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// bytesToMB 字节转换为 MB
+func bytesToMB(n int64) float64 {
+	return float64(n) / 1024 / 1024
+}
+
+// SnapshotFunc 采集一次统计数据
+type SnapshotFunc func() StatsData
+
+// ReportStatus 统计上报状态
+type ReportStatus struct {
+	LastAttempt time.Time // 最近一次上报时间
+	LastSuccess time.Time // 最近一次成功上报时间
+	LastError   string    // 最近一次上报的错误，成功时为空
+	Successes   int64     // 成功次数
+	Failures    int64     // 失败次数
+	Queued      int       // 积压待重试的条数
+	Dropped     int64     // 积压超出上限被丢弃的条数
+}
+
+// DefaultInterval 默认上报间隔
+const DefaultInterval = 10 * time.Second
+
+// DefaultTimeout 默认单次发送超时
+const DefaultTimeout = 10 * time.Second
+
+// DefaultFlushTimeout 默认的最终上报超时（Run 结束时）
+const DefaultFlushTimeout = 5 * time.Second
+
+// SinkOptions 输出目标的上报参数
+type SinkOptions struct {
+	Interval time.Duration // 上报间隔，默认 DefaultInterval
+	Jitter   time.Duration // 随机抖动，每次等待 Interval 加上 [0, Jitter) 内的随机时长，避免大量节点同时上报
+	Timeout  time.Duration // 单次发送超时，默认 DefaultTimeout
+	Queue    QueueOptions  // 发送失败时的缓存与重试
+}
+
+// sinkEntry 一个输出目标及其上报状态
+type sinkEntry struct {
+	name       string
+	sink       Sink
+	interval   time.Duration
+	jitter     time.Duration
+	timeout    time.Duration
+	trigger    chan struct{} // 立即上报的信号
+	batch      int
+	maxBackoff time.Duration
+	queue      *queue
+
+	sendMu  sync.Mutex // 保证同一输出目标按顺序发送
+	backoff time.Duration
+	retryAt time.Time // 退避期间只入队不发送
+
+	mu        sync.Mutex
+	status    ReportStatus
+	seq       uint64    // 最近一次上报的序号
+	lastTime  time.Time // 最近一次上报的采集时间
+	lastBytes int64     // 最近一次上报时的总字节数
+	lastDone  int64     // 最近一次上报时的成功次数
+	lastFail  int64     // 最近一次上报时的失败次数
+	acks      []Ack     // 待附加到下一次上报的指令确认
 }
 
 // Reporter 统计数据上报器
+// 每个输出目标在独立的协程中按各自的间隔发送，一个目标失败或阻塞不影响其他目标
 type Reporter struct {
-	apiURL   string
-	hostname string
-	client   *http.Client
+	hostname     string
+	identity     Identity
+	snapshot     SnapshotFunc
+	logger       *slog.Logger
+	flushTimeout time.Duration
+	sinks        []*sinkEntry
+
+	directiveMu sync.Mutex // 保证指令按顺序逐条执行
+	handler     DirectiveHandler
+	handled     map[string]Ack // 已处理的指令，重复下发时直接返回原确认
+	handledIDs  []string       // 按处理顺序排列的指令 ID，超出 maxHandledDirectives 时淘汰最早的
+
+	pendingMu sync.Mutex
+	pending   []pendingDirective // 已收到、等待执行的指令
+	wake      chan struct{}      // 有新指令的信号
 }
 
-// NewReporter 创建统计上报器
-func NewReporter(apiURL string) (*Reporter, error) {
+// NewReporter 创建统计上报器，每次上报时调用 snapshot 采集数据
+func NewReporter(snapshot SnapshotFunc) *Reporter {
 	hostname, err := os.Hostname()
 	if err != nil {
 		hostname = "unknown"
 	}
 
 	return &Reporter{
-		apiURL:   apiURL,
-		hostname: hostname,
-		client: &http.Client{
-			Timeout: 10 * time.Second,
-		},
-	}, nil
+		hostname:     hostname,
+		identity:     Identity{Name: hostname, RunID: NewUUID()},
+		snapshot:     snapshot,
+		logger:       slog.Default(),
+		flushTimeout: DefaultFlushTimeout,
+		handled:      make(map[string]Ack),
+		wake:         make(chan struct{}, 1),
+	}
 }
 
-// Report 上报统计数据
-func (r *Reporter) Report(avgSpeed, totalMB float64, timeRange string) error {
-	data := StatsData{
-		Name:  r.hostname,
-		Speed: avgSpeed,
-		Total: totalMB,
-		Time:  timeRange,
-	}
+// SetIdentity 设置节点身份，Name 和 RunID 为空时保留默认值（主机名和启动时生成的 ID）
+func (r *Reporter) SetIdentity(id Identity) {
+	id.Name = cmp.Or(id.Name, r.hostname)
+	id.RunID = cmp.Or(id.RunID, r.identity.RunID)
+	r.identity = id
+}
+
+// Identity 返回节点身份
+func (r *Reporter) Identity() Identity {
+	return r.identity
+}
+
+// SetLogger 设置日志记录器
+func (r *Reporter) SetLogger(logger *slog.Logger) {
+	r.logger = logger
+}
+
+// SetFlushTimeout 设置 Run 结束时最终上报的超时，0 使用 DefaultFlushTimeout，小于 0 时不做最终上报
+func (r *Reporter) SetFlushTimeout(timeout time.Duration) {
+	r.flushTimeout = cmp.Or(timeout, DefaultFlushTimeout)
+}
 
-	jsonData, err := json.Marshal(data)
+// AddSink 添加输出目标，未设置的参数使用默认值
+// 设置了磁盘队列目录时加载上次退出时未发送的数据
+// 名称用于区分状态和磁盘队列文件，不能与已添加的输出目标重复
+func (r *Reporter) AddSink(name string, sink Sink, opts SinkOptions) error {
+	for _, e := range r.sinks {
+		if e.name == name {
+			return fmt.Errorf("统计输出名称重复: %s（请为同类型的输出目标设置不同的 name）", name)
+		}
+	}
+	q, err := newQueue(name, opts.Queue)
 	if err != nil {
-		return fmt.Errorf("序列化数据失败: %w", err)
+		return fmt.Errorf("创建 %s 的发送队列失败: %w", name, err)
+	}
+	entry := &sinkEntry{
+		name:       name,
+		sink:       sink,
+		interval:   cmp.Or(opts.Interval, DefaultInterval),
+		jitter:     max(opts.Jitter, 0),
+		timeout:    cmp.Or(opts.Timeout, DefaultTimeout),
+		trigger:    make(chan struct{}, 1),
+		batch:      cmp.Or(opts.Queue.Batch, DefaultQueueBatch),
+		maxBackoff: cmp.Or(opts.Queue.MaxBackoff, DefaultMaxBackoff),
+		queue:      q,
 	}
+	r.sinks = append(r.sinks, entry)
+	return nil
+}
 
-	req, err := http.NewRequest("POST", r.apiURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("创建请求失败: %w", err)
+// Len 返回输出目标数量
+func (r *Reporter) Len() int {
+	return len(r.sinks)
+}
+
+// GetHostname 获取主机名
+func (r *Reporter) GetHostname() string {
+	return r.hostname
+}
+
+// Status 返回所有输出目标汇总后的上报状态
+// 次数累加，时间取最近一次；任一目标最近一次失败时 LastError 列出失败的目标
+func (r *Reporter) Status() ReportStatus {
+	var total ReportStatus
+	var errs []string
+	for _, entry := range r.sinks {
+		status := entry.Status()
+		total.Successes += status.Successes
+		total.Failures += status.Failures
+		total.Queued += status.Queued
+		total.Dropped += status.Dropped
+		if status.LastAttempt.After(total.LastAttempt) {
+			total.LastAttempt = status.LastAttempt
+		}
+		if status.LastSuccess.After(total.LastSuccess) {
+			total.LastSuccess = status.LastSuccess
+		}
+		if status.LastError != "" {
+			errs = append(errs, entry.name+": "+status.LastError)
+		}
 	}
+	total.LastError = strings.Join(errs, "; ")
+	return total
+}
 
-	req.Header.Set("Content-Type", "application/json")
+// Statuses 返回每个输出目标的上报状态
+func (r *Reporter) Statuses() map[string]ReportStatus {
+	result := make(map[string]ReportStatus, len(r.sinks))
+	for _, entry := range r.sinks {
+		result[entry.name] = entry.Status()
+	}
+	return result
+}
 
-	resp, err := r.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("发送请求失败: %w", err)
+// Names 返回输出目标名称（按名称排序）
+func (r *Reporter) Names() []string {
+	names := make([]string, len(r.sinks))
+	for i, entry := range r.sinks {
+		names[i] = entry.name
 	}
-	defer resp.Body.Close()
+	sort.Strings(names)
+	return names
+}
+
+// Status 返回该输出目标的上报状态
+func (e *sinkEntry) Status() ReportStatus {
+	e.mu.Lock()
+	status := e.status
+	e.mu.Unlock()
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("服务器返回错误状态码: %d", resp.StatusCode)
+	status.Queued = e.queue.Len()
+	status.Dropped = e.queue.Dropped()
+	return status
+}
+
+// next 为该输出目标填写序号和区间增量
+func (e *sinkEntry) next(data StatsData) StatsData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	start, startBytes, startDone, startFail := e.lastTime, e.lastBytes, e.lastDone, e.lastFail
+	if start.IsZero() {
+		start, startBytes, startDone, startFail = data.Start, 0, 0, 0
 	}
+	e.seq++
+	e.lastTime, e.lastBytes, e.lastDone, e.lastFail = data.Timestamp, data.TotalBytes, data.Completed, data.Failed
 
-	return nil
+	data.Seq = e.seq
+	data.QueueDepth = e.queue.Len()
+	data.Acks, e.acks = e.acks, nil
+	data.Interval = Interval{
+		Start:     start,
+		Bytes:     data.TotalBytes - startBytes,
+		Completed: data.Completed - startDone,
+		Failed:    data.Failed - startFail,
+	}
+	if !start.IsZero() {
+		data.Interval.Seconds = data.Timestamp.Sub(start).Seconds()
+	}
+	if data.Interval.Seconds > 0 {
+		data.Interval.Speed = bytesToMB(data.Interval.Bytes) / data.Interval.Seconds
+	}
+	return data
+}
+
+// Report 立即采集一次数据并发送到所有输出目标（忽略退避，积压的数据一并发送），返回所有失败原因
+func (r *Reporter) Report(ctx context.Context) error {
+	data := r.collect()
+	var errs []error
+	for _, entry := range r.sinks {
+		if _, err := r.deliver(ctx, entry, data, true); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", entry.name, err))
+		}
+	}
+	r.applyDirectives(ctx)
+	return errors.Join(errs...)
 }
 
-// StartReporting 启动定期上报
-func (r *Reporter) StartReporting(ctx context.Context, getBytesDownloaded func() int64, getStartTime func() time.Time, getTimeRange func() string) {
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
+// Trigger 让所有输出目标立即上报一次（例如下载会话开始、结束时），不等待发送完成
+// 输出目标处于失败后的退避期时只加入队列
+func (r *Reporter) Trigger() {
+	for _, entry := range r.sinks {
+		select {
+		case entry.trigger <- struct{}{}:
+		default: // 已有待处理的信号
+		}
+	}
+}
+
+// Run 按各自的间隔向所有输出目标上报，直到 ctx 结束
+// ctx 结束后在 flushTimeout 内做最后一次上报（包括积压的数据），然后把未发送的数据保存到磁盘队列（如果设置），并关闭所有输出目标
+func (r *Reporter) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.runDirectives(ctx)
+	}()
+	for _, entry := range r.sinks {
+		if n := entry.queue.Len(); n > 0 {
+			r.logger.Info("继续发送上次未发送的统计数据", "sink", entry.name, "count", n)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.loop(ctx, entry)
+		}()
+	}
+	wg.Wait()
+
+	for _, entry := range r.sinks {
+		if err := entry.queue.Persist(); err != nil {
+			r.logger.Warn("保存未发送的统计数据失败", "sink", entry.name, "error", err)
+		} else if n := entry.queue.Len(); n > 0 {
+			r.logger.Warn("仍有未发送的统计数据", "sink", entry.name, "count", n, "saved", entry.queue.path != "")
+		}
+		if err := entry.sink.Close(); err != nil {
+			r.logger.Warn("关闭统计输出失败", "sink", entry.name, "error", err)
+		}
+	}
+}
+
+// loop 单个输出目标的上报循环，每次上报（包括 Trigger 触发的）后重新计时
+func (r *Reporter) loop(ctx context.Context, entry *sinkEntry) {
+	timer := time.NewTimer(entry.wait())
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
+			r.flush(entry)
 			return
-		case <-ticker.C:
-			// 获取统计数据
-			totalBytes := getBytesDownloaded()
-			startTime := getStartTime()
-			timeRange := getTimeRange()
+		case <-timer.C:
+		case <-entry.trigger:
+			timer.Stop()
+		}
 
-			// 计算统计
-			elapsed := time.Since(startTime).Seconds()
-			if elapsed < 1 {
-				elapsed = 1
-			}
+		data := r.collect()
+		sent, err := r.deliver(ctx, entry, data, false)
+		r.logResult(entry, data, sent, err)
+		timer.Reset(entry.wait())
+	}
+}
 
-			totalMB := float64(totalBytes) / 1024 / 1024
-			avgSpeedMBps := totalMB / elapsed
+// flush 运行结束时的最后一次上报，忽略退避，超时由 flushTimeout 限制
+func (r *Reporter) flush(entry *sinkEntry) {
+	if r.flushTimeout < 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.flushTimeout)
+	defer cancel()
 
-			// 上报数据
-			if err := r.Report(avgSpeedMBps, totalMB, timeRange); err != nil {
-				fmt.Printf("[统计上报] 失败: %v\n", err)
-			} else {
-				fmt.Printf("[统计上报] 成功: 主机=%s, 平均速度=%.2f MB/s, 总下载=%.2f MB, 时间段=%s\n",
-					r.hostname, avgSpeedMBps, totalMB, timeRange)
-			}
+	data := r.collect()
+	sent, err := r.deliver(ctx, entry, data, true)
+	r.logResult(entry, data, sent, err)
+}
+
+// logResult 记录一次上报的结果
+func (r *Reporter) logResult(entry *sinkEntry, data StatsData, sent int, err error) {
+	switch {
+	case errors.Is(err, errBackoff):
+		r.logger.Debug("统计上报等待重试", "sink", entry.name, "queued", entry.queue.Len(), "retry_at", entry.retryAt.Format("15:04:05"))
+	case err != nil:
+		r.logger.Warn("统计上报失败", "sink", entry.name, "queued", entry.queue.Len(), "error", err)
+	default:
+		r.logger.Info("统计上报成功", "sink", entry.name, "sent", sent, "host", data.Name,
+			"avg_speed_mbs", data.Speed, "total_mb", data.Total)
+	}
+}
+
+// wait 返回距下一次上报的等待时间：上报间隔加上随机抖动
+func (e *sinkEntry) wait() time.Duration {
+	if e.jitter <= 0 {
+		return e.interval
+	}
+	return e.interval + rand.N(e.jitter)
+}
+
+// collect 采集一次统计数据，附加节点身份
+func (r *Reporter) collect() StatsData {
+	data := r.snapshot()
+	id := r.identity
+	data.Name = cmp.Or(data.Name, id.Name)
+	data.NodeID = cmp.Or(data.NodeID, id.NodeID)
+	data.RunID = cmp.Or(data.RunID, id.RunID)
+	data.Version = cmp.Or(data.Version, id.Version)
+	if data.Labels == nil {
+		data.Labels = id.Labels
+	}
+	if data.Timestamp.IsZero() {
+		data.Timestamp = time.Now()
+	}
+	return data
+}
+
+// errBackoff 处于失败后的退避期，本次只入队
+var errBackoff = errors.New("等待重试")
+
+// deliver 为输出目标填写序号后加入队列，然后按批次依次发送队列中的数据
+// 不处于退避期或 force 为 true 时才发送；返回本次发送成功的条数
+func (r *Reporter) deliver(ctx context.Context, entry *sinkEntry, data StatsData, force bool) (int, error) {
+	entry.sendMu.Lock()
+	defer entry.sendMu.Unlock()
+
+	if err := entry.queue.Push(entry.next(data)); err != nil {
+		r.logger.Warn("统计数据加入队列失败", "sink", entry.name, "error", err)
+	}
+	if !force && time.Now().Before(entry.retryAt) {
+		return 0, errBackoff
+	}
+
+	total := 0
+	for {
+		batch, err := entry.queue.Peek(entry.batch)
+		if err != nil || len(batch) == 0 {
+			return total, err
 		}
+
+		sent, err := r.send(ctx, entry, batch)
+		r.receive(entry)
+		if rmErr := entry.queue.Remove(sent); rmErr != nil {
+			return total, rmErr
+		}
+		total += sent
+		if err != nil {
+			// 失败后退避：初始为上报间隔，之后每次翻倍，不超过 maxBackoff
+			entry.backoff = min(max(entry.backoff*2, entry.interval), entry.maxBackoff)
+			entry.retryAt = time.Now().Add(entry.backoff)
+			return total, err
+		}
+		entry.backoff, entry.retryAt = 0, time.Time{}
 	}
 }
 
-// GetHostname 获取主机名
-func (r *Reporter) GetHostname() string {
-	return r.hostname
+// send 向一个输出目标发送一批数据并记录状态，返回发送成功的条数
+// 输出目标支持 BatchSink 时整批发送，否则逐条发送，遇到失败即停止
+// 输出目标发生 panic 时视为发送失败，不影响其他目标
+func (r *Reporter) send(ctx context.Context, entry *sinkEntry, batch []StatsData) (sent int, err error) {
+	ctx, cancel := context.WithTimeout(ctx, entry.timeout)
+	defer cancel()
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("输出目标异常: %v", p)
+		}
+
+		entry.mu.Lock()
+		defer entry.mu.Unlock()
+		entry.status.LastAttempt = time.Now()
+		if err != nil {
+			entry.status.LastError = err.Error()
+			entry.status.Failures++
+		} else {
+			entry.status.LastSuccess = entry.status.LastAttempt
+			entry.status.LastError = ""
+			entry.status.Successes++
+		}
+	}()
+
+	if bs, ok := entry.sink.(BatchSink); ok && len(batch) > 1 {
+		if err := bs.SendBatch(ctx, batch); err != nil {
+			return 0, err
+		}
+		return len(batch), nil
+	}
+	for _, data := range batch {
+		if err := entry.sink.Send(ctx, data); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
 }
@@ -1,107 +1,339 @@
 package stats
 
 import (
-	"encoding/json"
-	"net/http"
-	"net/http/httptest"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 )
 
-func TestNewReporter(t *testing.T) {
-	reporter, err := NewReporter("http://example.com/stats")
-	if err != nil {
-		t.Fatalf("NewReporter() error = %v", err)
+// stubSink 记录收到的数据，可指定返回的错误或阻塞
+type stubSink struct {
+	mu     sync.Mutex
+	data   []StatsData
+	err    error
+	block  bool
+	panic  bool
+	closed bool
+}
+
+func (s *stubSink) Send(ctx context.Context, data StatsData) error {
+	if s.panic {
+		panic("boom")
 	}
+	if s.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = append(s.data, data)
+	return s.err
+}
+
+func (s *stubSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *stubSink) received() []StatsData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]StatsData(nil), s.data...)
+}
 
-	if reporter.apiURL != "http://example.com/stats" {
-		t.Errorf("NewReporter() apiURL = %v, want %v", reporter.apiURL, "http://example.com/stats")
+func fixedSnapshot() StatsData {
+	return StatsData{Speed: 15.5, Total: 1024}
+}
+
+func TestNewReporter(t *testing.T) {
+	reporter := NewReporter(fixedSnapshot)
+	if reporter.GetHostname() == "" {
+		t.Error("GetHostname() returned empty string")
+	}
+	if reporter.Len() != 0 {
+		t.Errorf("Len() = %d, want 0", reporter.Len())
 	}
+}
 
-	if reporter.hostname == "" {
-		t.Errorf("NewReporter() hostname is empty")
+func TestReporter_AddSink_DuplicateName(t *testing.T) {
+	reporter := NewReporter(fixedSnapshot)
+	dir := t.TempDir()
+	opts := SinkOptions{Queue: QueueOptions{Dir: dir}}
+	if err := reporter.AddSink(SinkHTTP, &stubSink{}, opts); err != nil {
+		t.Fatalf("AddSink() error = %v", err)
+	}
+	if err := reporter.AddSink(SinkHTTP, &stubSink{}, opts); err == nil {
+		t.Error("AddSink() with duplicate name: want error")
+	}
+	if err := reporter.AddSink("backup", &stubSink{}, opts); err != nil {
+		t.Errorf("AddSink() with distinct name error = %v", err)
+	}
+	if reporter.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", reporter.Len())
 	}
 }
 
 func TestReporter_Report(t *testing.T) {
-	// 创建测试服务器
-	var receivedData StatsData
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// 检查请求方法
-		if r.Method != "POST" {
-			t.Errorf("Expected POST request, got %s", r.Method)
-		}
+	ok, failing, panicking := &stubSink{}, &stubSink{err: errors.New("down")}, &stubSink{panic: true}
 
-		// 检查Content-Type
-		if r.Header.Get("Content-Type") != "application/json" {
-			t.Errorf("Expected Content-Type: application/json, got %s", r.Header.Get("Content-Type"))
-		}
+	reporter := NewReporter(fixedSnapshot)
+	reporter.AddSink("ok", ok, SinkOptions{})
+	reporter.AddSink("failing", failing, SinkOptions{})
+	reporter.AddSink("panicking", panicking, SinkOptions{})
 
-		// 解析请求体
-		if err := json.NewDecoder(r.Body).Decode(&receivedData); err != nil {
-			t.Errorf("Failed to decode request body: %v", err)
+	if err := reporter.Report(context.Background()); err == nil {
+		t.Error("Report() error = nil, want failures from two sinks")
+	}
+
+	got := ok.received()
+	if len(got) != 1 || got[0].Speed != 15.5 || got[0].Name != reporter.GetHostname() || got[0].Timestamp.IsZero() {
+		t.Errorf("ok sink received %+v, want one snapshot with hostname and timestamp", got)
+	}
+
+	statuses := reporter.Statuses()
+	if s := statuses["ok"]; s.Successes != 1 || s.LastError != "" || s.LastSuccess.IsZero() {
+		t.Errorf("ok status = %+v", s)
+	}
+	for _, name := range []string{"failing", "panicking"} {
+		if s := statuses[name]; s.Failures != 1 || s.LastError == "" || !s.LastSuccess.IsZero() {
+			t.Errorf("%s status = %+v, want one failure", name, s)
 		}
+	}
+
+	total := reporter.Status()
+	if total.Successes != 1 || total.Failures != 2 || total.LastError == "" {
+		t.Errorf("Status() = %+v, want 1 success, 2 failures and an error", total)
+	}
+}
+
+func TestReporter_Run_IsolatesSinks(t *testing.T) {
+	fast, blocked := &stubSink{}, &stubSink{block: true}
+
+	reporter := NewReporter(fixedSnapshot)
+	reporter.SetLogger(discardLogger())
+	reporter.AddSink("fast", fast, SinkOptions{Interval: 20 * time.Millisecond})
+	reporter.AddSink("blocked", blocked, SinkOptions{Interval: 20 * time.Millisecond, Timeout: 50 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	reporter.Run(ctx)
+
+	if n := len(fast.received()); n < 5 {
+		t.Errorf("fast sink received %d reports, want >= 5 despite blocked sink", n)
+	}
+	if s := reporter.Statuses()["blocked"]; s.Failures == 0 || s.Successes != 0 {
+		t.Errorf("blocked status = %+v, want timeouts", s)
+	}
+	if !fast.closed || !blocked.closed {
+		t.Error("Run() did not close sinks")
+	}
+}
+
+func TestReporter_IntervalAndSeq(t *testing.T) {
+	start := time.Date(2025, 10, 26, 12, 0, 0, 0, time.UTC)
+	samples := []StatsData{
+		{Timestamp: start.Add(10 * time.Second), TotalBytes: 100 << 20, Completed: 1},
+		{Timestamp: start.Add(20 * time.Second), TotalBytes: 300 << 20, Completed: 3, Failed: 1},
+		{Timestamp: start.Add(40 * time.Second), TotalBytes: 300 << 20, Completed: 3, Failed: 4},
+	}
+	i := 0
+	snapshot := func() StatsData {
+		data := samples[i]
+		data.Start = start
+		i++
+		return data
+	}
 
-		w.WriteHeader(http.StatusOK)
-	}))
-	defer server.Close()
+	a, b := &stubSink{}, &stubSink{}
+	reporter := NewReporter(snapshot)
+	reporter.AddSink("a", a, SinkOptions{})
+	reporter.Report(context.Background())
+	reporter.Report(context.Background())
+	reporter.AddSink("b", b, SinkOptions{}) // 新加入的输出目标从运行开始算起
+	reporter.Report(context.Background())
 
-	// 创建Reporter
-	reporter, err := NewReporter(server.URL)
-	if err != nil {
-		t.Fatalf("NewReporter() error = %v", err)
+	tests := []struct {
+		got     StatsData
+		seq     uint64
+		seconds float64
+		bytes   int64
+		speed   float64
+		ivStart time.Time
+		done    int64
+		failed  int64
+	}{
+		{a.received()[0], 1, 10, 100 << 20, 10, start, 1, 0},
+		{a.received()[1], 2, 10, 200 << 20, 20, start.Add(10 * time.Second), 2, 1},
+		{a.received()[2], 3, 20, 0, 0, start.Add(20 * time.Second), 0, 3},
+		{b.received()[0], 1, 40, 300 << 20, 7.5, start, 3, 4},
 	}
+	for i, tt := range tests {
+		iv := tt.got.Interval
+		if tt.got.Seq != tt.seq || iv.Seconds != tt.seconds || iv.Bytes != tt.bytes || iv.Speed != tt.speed || !iv.Start.Equal(tt.ivStart) ||
+			iv.Completed != tt.done || iv.Failed != tt.failed {
+			t.Errorf("report %d: seq=%d interval=%+v, want seq=%d seconds=%v bytes=%d speed=%v start=%v completed=%d failed=%d",
+				i, tt.got.Seq, iv, tt.seq, tt.seconds, tt.bytes, tt.speed, tt.ivStart, tt.done, tt.failed)
+		}
+	}
+}
+
+// flakySink 前 failures 次发送失败，记录每批收到的序号
+type flakySink struct {
+	stubSink
+	failures int
+	batches  [][]uint64
+}
 
-	// 测试上报
-	err = reporter.Report(15.5, 1024.0, "12:00-13:00")
-	if err != nil {
-		t.Errorf("Report() error = %v", err)
+func (s *flakySink) SendBatch(ctx context.Context, batch []StatsData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("collector down")
+	}
+	var seqs []uint64
+	for _, data := range batch {
+		seqs = append(seqs, data.Seq)
+		s.data = append(s.data, data)
 	}
+	s.batches = append(s.batches, seqs)
+	return nil
+}
+
+func (s *flakySink) Send(ctx context.Context, data StatsData) error {
+	return s.SendBatch(ctx, []StatsData{data})
+}
 
-	// 验证接收到的数据
-	if receivedData.Speed != 15.5 {
-		t.Errorf("Expected speed 15.5, got %f", receivedData.Speed)
+func TestReporter_RetriesInOrder(t *testing.T) {
+	sink := &flakySink{failures: 3}
+	reporter := NewReporter(fixedSnapshot)
+	if err := reporter.AddSink("flaky", sink, SinkOptions{Queue: QueueOptions{Batch: 2}}); err != nil {
+		t.Fatalf("AddSink() error = %v", err)
 	}
 
-	if receivedData.Total != 1024.0 {
-		t.Errorf("Expected total 1024.0, got %f", receivedData.Total)
+	for i := 0; i < 3; i++ {
+		if err := reporter.Report(context.Background()); err == nil {
+			t.Fatalf("Report() %d: expected error while collector is down", i)
+		}
+	}
+	if got := reporter.Status().Queued; got != 3 {
+		t.Errorf("Status().Queued = %d, want 3", got)
 	}
 
-	if receivedData.Time != "12:00-13:00" {
-		t.Errorf("Expected time '12:00-13:00', got %s", receivedData.Time)
+	// 恢复后：积压的 3 条加上新的 1 条按顺序、每批最多 2 条发送
+	if err := reporter.Report(context.Background()); err != nil {
+		t.Fatalf("Report() after recovery error = %v", err)
+	}
+	if fmt.Sprint(sink.batches) != "[[1 2] [3 4]]" {
+		t.Errorf("batches = %v, want [[1 2] [3 4]]", sink.batches)
+	}
+	if got := sink.received()[3].QueueDepth; got != 3 {
+		t.Errorf("QueueDepth of seq 4 = %d, want 3", got)
 	}
+	if status := reporter.Status(); status.Queued != 0 || status.Failures != 3 || status.Successes != 2 {
+		t.Errorf("Status() = %+v, want empty queue, 3 failures, 2 successes", status)
+	}
+}
+
+func TestReporter_Backoff(t *testing.T) {
+	sink := &flakySink{failures: 100}
+	reporter := NewReporter(fixedSnapshot)
+	reporter.SetLogger(discardLogger())
+	reporter.AddSink("down", sink, SinkOptions{Interval: 10 * time.Millisecond, Queue: QueueOptions{MaxBackoff: 80 * time.Millisecond}})
 
-	if receivedData.Name != reporter.hostname {
-		t.Errorf("Expected name '%s', got %s", reporter.hostname, receivedData.Name)
+	ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+	defer cancel()
+	reporter.Run(ctx)
+
+	// 约 40 次上报，退避 10、20、40、80、80... 毫秒，实际发送不超过 10 次
+	status := reporter.Status()
+	if status.Failures == 0 || status.Failures > 10 {
+		t.Errorf("Failures = %d, want a few attempts with backoff", status.Failures)
+	}
+	if status.Queued < 20 {
+		t.Errorf("Queued = %d, want reports kept while collector is down", status.Queued)
 	}
 }
 
-func TestReporter_Report_ServerError(t *testing.T) {
-	// 创建返回错误的测试服务器
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusInternalServerError)
-	}))
-	defer server.Close()
+func TestReporter_TriggerAndFlush(t *testing.T) {
+	sink := &stubSink{}
+	reporter := NewReporter(fixedSnapshot)
+	reporter.SetLogger(discardLogger())
+	reporter.AddSink("s", sink, SinkOptions{Interval: time.Hour})
 
-	reporter, err := NewReporter(server.URL)
-	if err != nil {
-		t.Fatalf("NewReporter() error = %v", err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		reporter.Run(ctx)
+		close(done)
+	}()
+
+	reporter.Trigger()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(sink.received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := len(sink.received()); n != 1 {
+		t.Fatalf("after Trigger() sink received %d reports, want 1", n)
 	}
 
-	// 测试上报（应该返回错误）
-	err = reporter.Report(15.5, 1024.0, "12:00-13:00")
-	if err == nil {
-		t.Error("Expected error for server error response, got nil")
+	cancel()
+	<-done
+	if got := sink.received(); len(got) != 2 || got[1].Seq != 2 {
+		t.Errorf("after Run() returned sink received %+v, want a final report with seq 2", got)
 	}
 }
 
-func TestReporter_GetHostname(t *testing.T) {
-	reporter, err := NewReporter("http://example.com/stats")
-	if err != nil {
-		t.Fatalf("NewReporter() error = %v", err)
+func TestReporter_FlushTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		sink    *stubSink
+		reports int
+	}{
+		{"disabled", -1, &stubSink{}, 0},
+		{"blocked sink bounded by timeout", 50 * time.Millisecond, &stubSink{block: true}, 0},
 	}
+	for _, tt := range tests {
+		reporter := NewReporter(fixedSnapshot)
+		reporter.SetLogger(discardLogger())
+		reporter.SetFlushTimeout(tt.timeout)
+		reporter.AddSink("s", tt.sink, SinkOptions{Interval: time.Hour})
 
-	hostname := reporter.GetHostname()
-	if hostname == "" {
-		t.Error("GetHostname() returned empty string")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		start := time.Now()
+		reporter.Run(ctx)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: Run() took %v after cancel", tt.name, elapsed)
+		}
+		if n := len(tt.sink.received()); n != tt.reports {
+			t.Errorf("%s: sink received %d reports, want %d", tt.name, n, tt.reports)
+		}
+	}
+}
+
+func TestSinkEntry_Wait(t *testing.T) {
+	entry := &sinkEntry{interval: time.Second}
+	if got := entry.wait(); got != time.Second {
+		t.Errorf("wait() without jitter = %v, want 1s", got)
+	}
+
+	entry.jitter = 500 * time.Millisecond
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		got := entry.wait()
+		if got < time.Second || got >= 1500*time.Millisecond {
+			t.Fatalf("wait() = %v, want within [1s, 1.5s)", got)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 10 {
+		t.Errorf("wait() returned only %d distinct values, want random jitter", len(seen))
 	}
 }
@@ -0,0 +1,336 @@
+package stats
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Sink 统计数据输出目标
+// Send 只会在同一个协程中调用，实现不需要并发安全
+type Sink interface {
+	Send(ctx context.Context, data StatsData) error
+	Close() error
+}
+
+// BatchSink 支持一次发送多条数据的输出目标，重试积压数据时整批发送
+type BatchSink interface {
+	Sink
+	SendBatch(ctx context.Context, batch []StatsData) error
+}
+
+// 输出目标类型
+const (
+	SinkHTTP       = "http"        // HTTP POST JSON（-stats-api 使用的格式）
+	SinkInfluxHTTP = "influx-http" // InfluxDB 行协议，HTTP 写入
+	SinkInfluxUDP  = "influx-udp"  // InfluxDB 行协议，UDP 写入
+	SinkStatsD     = "statsd"      // StatsD gauge，UDP 发送
+	SinkSyslog     = "syslog"      // 本机 syslog
+	SinkFile       = "file"        // 本地 JSON Lines 文件
+	SinkPrometheus = "prometheus"  // Prometheus 文本文件（node_exporter textfile collector）
+)
+
+// SinkConfig 输出目标配置（对应配置文件 stats 列表中的一项）
+type SinkConfig struct {
+	Name        string        `yaml:"name"`        // 名称，用于日志和状态显示，默认为类型
+	Type        string        `yaml:"type"`        // 类型：http、influx-http、influx-udp、statsd、syslog、file、prometheus
+	URL         string        `yaml:"url"`         // http、influx-http 的地址
+	Addr        string        `yaml:"addr"`        // influx-udp、statsd 的地址（host:port）
+	Path        string        `yaml:"path"`        // file、prometheus 的文件路径
+	Measurement string        `yaml:"measurement"` // InfluxDB measurement，默认 netflood
+	Prefix      string        `yaml:"prefix"`      // 指标前缀，StatsD 默认 netflood.<主机名>，Prometheus 默认 netflood
+	Tag         string        `yaml:"tag"`         // syslog 标签，默认 netflood
+	Interval    time.Duration `yaml:"interval"`    // 上报间隔，默认 10s
+	Jitter      time.Duration `yaml:"jitter"`      // 随机抖动，每次等待间隔加上 [0, jitter) 内的随机时长
+	Timeout     time.Duration `yaml:"timeout"`     // 单次发送超时，默认 10s
+	Queue       QueueOptions  `yaml:"queue"`       // 发送失败时的缓存与重试
+	Auth        AuthOptions   `yaml:"auth"`        // http、influx-http 的认证、签名和 TLS
+	Directives  bool          `yaml:"directives"`  // 执行收集器在响应中下发的指令，仅 http 类型，需要 directive_key
+
+	DirectiveKey string `yaml:"directive_key"` // 收集器的指令签名公钥（base64，netflood-collector 启动时输出）
+}
+
+// Options 返回上报参数
+func (c SinkConfig) Options() SinkOptions {
+	return SinkOptions{Interval: c.Interval, Jitter: c.Jitter, Timeout: c.Timeout, Queue: c.Queue}
+}
+
+// DisplayName 返回输出目标名称，未设置时使用类型
+func (c SinkConfig) DisplayName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Type
+}
+
+// NewSink 按配置创建输出目标
+func NewSink(cfg SinkConfig) (Sink, error) {
+	required := func(field, value string) error {
+		if value == "" {
+			return fmt.Errorf("%s 类型的统计输出缺少 %s", cfg.Type, field)
+		}
+		return nil
+	}
+
+	if cfg.Directives && cfg.Type != SinkHTTP {
+		return nil, fmt.Errorf("%s 类型的统计输出不支持 directives", cfg.Type)
+	}
+
+	var auth *Auth
+	if !cfg.Auth.IsZero() {
+		if cfg.Type != SinkHTTP && cfg.Type != SinkInfluxHTTP {
+			return nil, fmt.Errorf("%s 类型的统计输出不支持 auth", cfg.Type)
+		}
+		var err error
+		if auth, err = NewAuth(cfg.Auth); err != nil {
+			return nil, fmt.Errorf("%s 统计输出认证配置错误: %w", cfg.DisplayName(), err)
+		}
+	}
+
+	switch cfg.Type {
+	case SinkHTTP:
+		if err := required("url", cfg.URL); err != nil {
+			return nil, err
+		}
+		sink := NewHTTPSink(cfg.URL)
+		sink.SetAuth(auth)
+		if cfg.Directives {
+			if err := sink.AcceptDirectives(cfg.DirectiveKey); err != nil {
+				return nil, fmt.Errorf("%s 统计输出: %w", cfg.DisplayName(), err)
+			}
+		}
+		return sink, nil
+	case SinkInfluxHTTP:
+		if err := required("url", cfg.URL); err != nil {
+			return nil, err
+		}
+		sink := NewInfluxHTTPSink(cfg.URL, cfg.Measurement)
+		sink.SetAuth(auth)
+		return sink, nil
+	case SinkInfluxUDP:
+		if err := required("addr", cfg.Addr); err != nil {
+			return nil, err
+		}
+		return NewInfluxUDPSink(cfg.Addr, cfg.Measurement)
+	case SinkStatsD:
+		if err := required("addr", cfg.Addr); err != nil {
+			return nil, err
+		}
+		return NewStatsDSink(cfg.Addr, cfg.Prefix)
+	case SinkSyslog:
+		return NewSyslogSink(cfg.Tag)
+	case SinkFile:
+		if err := required("path", cfg.Path); err != nil {
+			return nil, err
+		}
+		return NewFileSink(cfg.Path)
+	case SinkPrometheus:
+		if err := required("path", cfg.Path); err != nil {
+			return nil, err
+		}
+		return NewPrometheusSink(cfg.Path, cfg.Prefix), nil
+	default:
+		return nil, fmt.Errorf("未知的统计输出类型: %q", cfg.Type)
+	}
+}
+
+// HTTPSink 以 JSON 格式 POST 到指定地址
+type HTTPSink struct {
+	url    string
+	client *http.Client
+	auth   *Auth
+
+	verifier     *Verifier   // 校验响应签名，不为 nil 时接收响应中的指令
+	directives   []Directive // 收到但尚未取出的指令
+	directiveErr error       // 响应中的指令被丢弃的原因
+}
+
+// NewHTTPSink 创建 HTTP JSON 输出目标
+func NewHTTPSink(url string) *HTTPSink {
+	return &HTTPSink{url: url, client: &http.Client{}}
+}
+
+// Send 实现 Sink
+func (s *HTTPSink) Send(ctx context.Context, data StatsData) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("序列化数据失败: %w", err)
+	}
+	return s.post(ctx, jsonData)
+}
+
+// SendBatch 实现 BatchSink，以 JSON 数组发送
+func (s *HTTPSink) SendBatch(ctx context.Context, batch []StatsData) error {
+	jsonData, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("序列化数据失败: %w", err)
+	}
+	return s.post(ctx, jsonData)
+}
+
+// post 发送请求，接收指令时解析响应中的指令
+func (s *HTTPSink) post(ctx context.Context, body []byte) error {
+	header, resp, err := postBody(ctx, s.client, s.auth, s.url, "application/json", body)
+	if err != nil || s.verifier == nil {
+		return err
+	}
+	s.receive(header, resp)
+	return nil
+}
+
+// receive 解析响应体中的指令，签名无效时丢弃全部指令
+func (s *HTTPSink) receive(header http.Header, body []byte) {
+	var resp ReportResponse
+	if json.Unmarshal(body, &resp) != nil || len(resp.Directives) == 0 {
+		return
+	}
+	if err := s.verifier.Verify(header, body); err != nil {
+		s.directiveErr = errors.Join(s.directiveErr, fmt.Errorf("%w: %w", ErrUnsignedDirectives, err))
+		return
+	}
+	s.directives = append(s.directives, resp.Directives...)
+}
+
+// AcceptDirectives 接收收集器在上报响应中下发的指令，响应必须使用 publicKey 对应的收集器私钥签名
+// （见 DirectiveSigner）；与上报的 HMAC 密钥分开，其他节点无法伪造指令
+func (s *HTTPSink) AcceptDirectives(publicKey string) error {
+	if publicKey == "" {
+		return errors.New("接收指令需要配置 directive_key")
+	}
+	v, err := NewDirectiveVerifier(publicKey, 0)
+	if err != nil {
+		return err
+	}
+	s.verifier = v
+	return nil
+}
+
+// Directives 实现 DirectiveSink，取出上次调用之后收到的指令
+func (s *HTTPSink) Directives() ([]Directive, error) {
+	directives, err := s.directives, s.directiveErr
+	s.directives, s.directiveErr = nil, nil
+	return directives, err
+}
+
+// SetAuth 设置认证、签名和 TLS 配置，nil 表示不认证
+func (s *HTTPSink) SetAuth(auth *Auth) {
+	s.auth = auth
+	s.client = auth.Client()
+}
+
+// Close 实现 Sink
+func (s *HTTPSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// maxResponseSize 读取的响应体大小上限
+const maxResponseSize = 1 << 20
+
+// postBody 发送 POST 请求，auth 不为 nil 时添加认证信息和签名，非 2xx 状态码视为失败
+// 返回响应头和响应体（最多 maxResponseSize 字节）
+func postBody(ctx context.Context, client *http.Client, auth *Auth, url, contentType string, body []byte) (http.Header, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if err := auth.Apply(req, body); err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("发送请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("服务器返回错误状态码: %d", resp.StatusCode)
+	}
+	// 服务器已接收数据，读取响应体失败不视为发送失败，避免重复发送
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	return resp.Header, data, nil
+}
+
+// FileSink 以 JSON Lines 格式追加写入本地文件
+type FileSink struct {
+	file *os.File
+}
+
+// NewFileSink 打开（或创建）文件用于追加写入
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("打开统计文件失败: %w", err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+// Send 实现 Sink
+func (s *FileSink) Send(ctx context.Context, data StatsData) error {
+	return s.SendBatch(ctx, []StatsData{data})
+}
+
+// SendBatch 实现 BatchSink，每条数据一行，一次写入
+func (s *FileSink) SendBatch(ctx context.Context, batch []StatsData) error {
+	var buf bytes.Buffer
+	for _, data := range batch {
+		line, err := json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("序列化数据失败: %w", err)
+		}
+		buf.Write(append(line, '\n'))
+	}
+	if _, err := s.file.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("写入统计文件失败: %w", err)
+	}
+	return nil
+}
+
+// Close 实现 Sink
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// metric 一个数值指标
+type metric struct {
+	name    string
+	value   float64
+	integer bool // 整数指标（InfluxDB 字段以 i 结尾）
+}
+
+// metrics 把统计数据展开为数值指标，供行协议和 StatsD 使用
+func metrics(data StatsData) []metric {
+	result := []metric{
+		{name: "seq", value: float64(data.Seq), integer: true},
+		{name: "speed", value: data.Speed},
+		{name: "total", value: data.Total},
+		{name: "interval_bytes", value: float64(data.Interval.Bytes), integer: true},
+		{name: "interval_speed", value: data.Interval.Speed},
+	}
+	if data.Session != nil {
+		result = append(result, metric{name: "session_speed", value: data.Session.Speed})
+	}
+	result = append(result,
+		metric{name: "completed", value: float64(data.Completed), integer: true},
+		metric{name: "failed", value: float64(data.Failed), integer: true},
+		metric{name: "stalls", value: float64(data.Stalls), integer: true},
+		metric{name: "queue_depth", value: float64(data.QueueDepth), integer: true},
+	)
+	if l := data.Latency; l != nil {
+		result = append(result,
+			metric{name: "requests", value: float64(l.Requests), integer: true},
+			metric{name: "ttfb_p50", value: l.TTFB.P50},
+			metric{name: "ttfb_p90", value: l.TTFB.P90},
+			metric{name: "ttfb_p99", value: l.TTFB.P99},
+		)
+	}
+	return result
+}
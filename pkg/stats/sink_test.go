@@ -0,0 +1,276 @@
+package stats
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.DiscardHandler)
+}
+
+func sampleData() StatsData {
+	return StatsData{
+		Name:      "host 1",
+		Seq:       7,
+		Speed:     15.5,
+		Total:     1024,
+		Timestamp: time.Unix(1700000000, 5),
+		Interval:  Interval{Seconds: 10, Bytes: 200 << 20, Speed: 20},
+		Session:   &SessionStats{Speed: 18.5},
+		Stalls:    2,
+		Latency:   &LatencySummary{Requests: 4, TTFB: Percentiles{P50: 12, P90: 30, P99: 45}},
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	var received StatsData
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("Expected POST request, got %s", r.Method)
+		}
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected Content-Type: application/json, got %s", r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Failed to decode request body: %v", err)
+		}
+		if r.URL.Path == "/error" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	sink := NewHTTPSink(server.URL)
+	defer sink.Close()
+	if err := sink.Send(context.Background(), sampleData()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if received.Speed != 15.5 || received.Total != 1024 || received.Seq != 7 || received.Interval.Speed != 20 || received.Name != "host 1" {
+		t.Errorf("received %+v", received)
+	}
+	if received.Latency == nil || received.Latency.TTFB.P90 != 30 {
+		t.Errorf("Expected latency ttfb p90 30, got %+v", received.Latency)
+	}
+
+	if err := NewHTTPSink(server.URL+"/error").Send(context.Background(), sampleData()); err == nil {
+		t.Error("Send() with server error: expected error, got nil")
+	}
+}
+
+func TestHTTPSink_SendBatch(t *testing.T) {
+	var received []StatsData
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Failed to decode request body as array: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	a, b := sampleData(), sampleData()
+	b.Seq = 8
+	if err := NewHTTPSink(server.URL).SendBatch(context.Background(), []StatsData{a, b}); err != nil {
+		t.Fatalf("SendBatch() error = %v", err)
+	}
+	if len(received) != 2 || received[0].Seq != 7 || received[1].Seq != 8 {
+		t.Errorf("received %+v, want seq 7 and 8", received)
+	}
+}
+
+func TestLineProtocol(t *testing.T) {
+	got := LineProtocol("", sampleData(), map[string]string{"region": "cn,east", "empty": ""})
+	want := `netflood,host=host\ 1,region=cn\,east seq=7i,speed=15.5,total=1024,interval_bytes=209715200i,interval_speed=20,session_speed=18.5,completed=0i,failed=0i,stalls=2i,queue_depth=0i,requests=4i,ttfb_p50=12,ttfb_p90=30,ttfb_p99=45 1700000000000000005`
+	if got != want {
+		t.Errorf("LineProtocol() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLineProtocol_Identity(t *testing.T) {
+	data := StatsData{Name: "edge-1", NodeID: "abc", Version: "v2.2.0", RunID: "run-1",
+		Labels: map[string]string{"region": "cn-east", "isp": "ct", "host": "spoofed"}, Timestamp: time.Unix(1, 0)}
+	got := LineProtocol("", data, nil)
+	want := `netflood,host=edge-1,isp=ct,node_id=abc,region=cn-east,version=v2.2.0 seq=0i,`
+	if !strings.HasPrefix(got, want) {
+		t.Errorf("LineProtocol() =\n%s\nwant prefix\n%s", got, want)
+	}
+}
+
+func TestPrometheusText(t *testing.T) {
+	data := StatsData{Name: "edge-1", NodeID: "abc", Version: "v2.2.0", RunID: "run-1", Speed: 15.5, Total: 1024,
+		Labels: map[string]string{"region": "cn-east", "rack-id": `r"1`}}
+	got := PrometheusText("", data)
+	for _, want := range []string{
+		"# TYPE netflood_info gauge\n" +
+			`netflood_info{host="edge-1",node_id="abc",rack_id="r\"1",region="cn-east",run_id="run-1",version="v2.2.0"} 1` + "\n",
+		"# TYPE netflood_speed gauge\n" + `netflood_speed{host="edge-1",node_id="abc",rack_id="r\"1",region="cn-east"} 15.5` + "\n",
+		`netflood_total{host="edge-1",node_id="abc",rack_id="r\"1",region="cn-east"} 1024` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("PrometheusText() =\n%s\nmissing\n%s", got, want)
+		}
+	}
+}
+
+func TestPrometheusSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netflood.prom")
+	sink, err := NewSink(SinkConfig{Type: SinkPrometheus, Path: path, Prefix: "nf"})
+	if err != nil {
+		t.Fatalf("NewSink(prometheus) error = %v", err)
+	}
+	defer sink.Close()
+
+	a, b := sampleData(), sampleData()
+	b.Seq = 8
+	if err := sink.(BatchSink).SendBatch(context.Background(), []StatsData{a, b}); err != nil {
+		t.Fatalf("SendBatch() error = %v", err)
+	}
+	content, _ := os.ReadFile(path)
+	if !strings.Contains(string(content), `nf_seq{host="host 1"} 8`) || strings.Contains(string(content), "} 7\n") {
+		t.Errorf("file =\n%s\nwant only the latest report", content)
+	}
+}
+
+func TestStatsDPacket(t *testing.T) {
+	data := StatsData{Speed: 15.5, Total: 1024, Interval: Interval{Speed: 20}, Stalls: 2}
+	got := StatsDPacket("nf", data)
+	want := "nf.seq:0|g\nnf.speed:15.5|g\nnf.total:1024|g\nnf.interval_bytes:0|g\nnf.interval_speed:20|g\nnf.completed:0|g\nnf.failed:0|g\nnf.stalls:2|g\nnf.queue_depth:0|g"
+	if got != want {
+		t.Errorf("StatsDPacket() = %q, want %q", got, want)
+	}
+}
+
+// listenUDP 在本机监听 UDP，返回地址和接收到的数据包
+func listenUDP(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	packets := make(chan string, 10)
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			packets <- string(buf[:n])
+		}
+	}()
+	return conn.LocalAddr().String(), packets
+}
+
+func TestUDPSinks(t *testing.T) {
+	addr, packets := listenUDP(t)
+
+	influx, err := NewSink(SinkConfig{Type: SinkInfluxUDP, Addr: addr, Measurement: "bw"})
+	if err != nil {
+		t.Fatalf("NewSink(influx-udp) error = %v", err)
+	}
+	statsd, err := NewSink(SinkConfig{Type: SinkStatsD, Addr: addr, Prefix: "nf."})
+	if err != nil {
+		t.Fatalf("NewSink(statsd) error = %v", err)
+	}
+
+	for _, tt := range []struct {
+		sink   Sink
+		prefix string
+	}{
+		{influx, "bw,host=host\\ 1 seq=7i,speed=15.5"},
+		{statsd, "nf.seq:7|g\nnf.speed:15.5|g\n"},
+	} {
+		if err := tt.sink.Send(context.Background(), sampleData()); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		select {
+		case packet := <-packets:
+			if !strings.HasPrefix(packet, tt.prefix) {
+				t.Errorf("packet = %q, want prefix %q", packet, tt.prefix)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("no UDP packet received")
+		}
+		tt.sink.Close()
+	}
+}
+
+func TestInfluxHTTPSink(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	if err := NewInfluxHTTPSink(server.URL+"/write?db=netflood", "").Send(context.Background(), sampleData()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if !strings.HasPrefix(body, "netflood,host=") || !strings.HasSuffix(body, "\n") {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.jsonl")
+	for i := 0; i < 2; i++ {
+		sink, err := NewSink(SinkConfig{Type: SinkFile, Path: path})
+		if err != nil {
+			t.Fatalf("NewSink(file) error = %v", err)
+		}
+		if err := sink.Send(context.Background(), sampleData()); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		sink.Close()
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer file.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var data StatsData
+		if err := json.Unmarshal(scanner.Bytes(), &data); err != nil || data.Name != "host 1" {
+			t.Errorf("line %d = %s, %v", lines, scanner.Text(), err)
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("file has %d lines, want 2 (appended)", lines)
+	}
+}
+
+func TestNewSink_Errors(t *testing.T) {
+	tests := []SinkConfig{
+		{Type: "kafka"},
+		{Type: SinkHTTP},
+		{Type: SinkInfluxHTTP},
+		{Type: SinkInfluxUDP},
+		{Type: SinkStatsD},
+		{Type: SinkFile},
+		{Type: SinkPrometheus},
+	}
+
+	for _, cfg := range tests {
+		if _, err := NewSink(cfg); err == nil {
+			t.Errorf("NewSink(%+v): expected error, got nil", cfg)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// StatsDSink 通过 UDP 以 StatsD gauge 发送指标，所有指标合并在一个数据包中（以换行分隔）
+type StatsDSink struct {
+	conn   net.Conn
+	prefix string
+}
+
+// NewStatsDSink 创建 StatsD 输出目标，prefix 为空时使用 "netflood.<主机名>"
+func NewStatsDSink(addr, prefix string) (*StatsDSink, error) {
+	if prefix == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "unknown"
+		}
+		prefix = "netflood." + statsdName(hostname)
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("连接 StatsD 地址失败: %w", err)
+	}
+	return &StatsDSink{conn: conn, prefix: strings.TrimSuffix(prefix, ".")}, nil
+}
+
+// Send 实现 Sink
+func (s *StatsDSink) Send(ctx context.Context, data StatsData) error {
+	if _, err := s.conn.Write([]byte(StatsDPacket(s.prefix, data))); err != nil {
+		return fmt.Errorf("发送 UDP 数据失败: %w", err)
+	}
+	return nil
+}
+
+// Close 实现 Sink
+func (s *StatsDSink) Close() error {
+	return s.conn.Close()
+}
+
+// StatsDPacket 把统计数据编码为 StatsD gauge，例如 "netflood.host1.speed:12.5|g"
+func StatsDPacket(prefix string, data StatsData) string {
+	var lines []string
+	for _, m := range metrics(data) {
+		lines = append(lines, prefix+"."+m.name+":"+strconv.FormatFloat(m.value, 'f', -1, 64)+"|g")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// statsdName 把主机名中的点、冒号等字符替换为下划线，避免破坏指标层级
+var statsdName = strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", " ", "_").Replace
@@ -0,0 +1,44 @@
+//go:build !windows && !plan9
+
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+)
+
+// SyslogSink 以 JSON 格式写入本机 syslog（LOG_INFO | LOG_DAEMON）
+type SyslogSink struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogSink 连接本机 syslog，tag 为空时使用 "netflood"
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	if tag == "" {
+		tag = "netflood"
+	}
+	writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, fmt.Errorf("连接 syslog 失败: %w", err)
+	}
+	return &SyslogSink{writer: writer}, nil
+}
+
+// Send 实现 Sink
+func (s *SyslogSink) Send(ctx context.Context, data StatsData) error {
+	line, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("序列化数据失败: %w", err)
+	}
+	if err := s.writer.Info(string(line)); err != nil {
+		return fmt.Errorf("写入 syslog 失败: %w", err)
+	}
+	return nil
+}
+
+// Close 实现 Sink
+func (s *SyslogSink) Close() error {
+	return s.writer.Close()
+}
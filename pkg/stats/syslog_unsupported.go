@@ -0,0 +1,26 @@
+//go:build windows || plan9
+
+package stats
+
+import (
+	"context"
+	"fmt"
+)
+
+// SyslogSink 当前平台不支持 syslog
+type SyslogSink struct{}
+
+// NewSyslogSink 当前平台不支持 syslog，总是返回错误
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	return nil, fmt.Errorf("当前平台不支持 syslog")
+}
+
+// Send 实现 Sink
+func (s *SyslogSink) Send(ctx context.Context, data StatsData) error {
+	return fmt.Errorf("当前平台不支持 syslog")
+}
+
+// Close 实现 Sink
+func (s *SyslogSink) Close() error {
+	return nil
+}
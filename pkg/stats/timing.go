@@ -0,0 +1,75 @@
+package stats
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// RequestTiming 单次请求的耗时分解
+type RequestTiming struct {
+	DNS      time.Duration // DNS 解析耗时（指定 IP 时通常为 0）
+	Connect  time.Duration // TCP 建连耗时（复用连接时为 0）
+	TLS      time.Duration // TLS 握手耗时（复用连接或 HTTP 时为 0）
+	TTFB     time.Duration // 从发出请求到收到首字节的耗时
+	Transfer time.Duration // 从首字节到响应体读完的耗时
+	Reused   bool          // 是否复用了已有连接
+}
+
+// TimingRecorder 请求耗时直方图集合
+type TimingRecorder struct {
+	requests atomic.Int64
+	reused   atomic.Int64
+	dns      Histogram
+	connect  Histogram
+	tls      Histogram
+	ttfb     Histogram
+	transfer Histogram
+}
+
+// Record 记录一次请求的耗时分解
+// 只有实际发生的阶段才计入对应直方图（例如复用连接不计建连耗时）
+func (r *TimingRecorder) Record(t RequestTiming) {
+	r.requests.Add(1)
+	if t.Reused {
+		r.reused.Add(1)
+	}
+	if t.DNS > 0 {
+		r.dns.Record(t.DNS)
+	}
+	if t.Connect > 0 {
+		r.connect.Record(t.Connect)
+	}
+	if t.TLS > 0 {
+		r.tls.Record(t.TLS)
+	}
+	if t.TTFB > 0 {
+		r.ttfb.Record(t.TTFB)
+	}
+	if t.Transfer > 0 {
+		r.transfer.Record(t.Transfer)
+	}
+}
+
+// Summary 返回耗时分位数汇总
+func (r *TimingRecorder) Summary() LatencySummary {
+	return LatencySummary{
+		Requests: r.requests.Load(),
+		Reused:   r.reused.Load(),
+		DNS:      r.dns.Percentiles(),
+		Connect:  r.connect.Percentiles(),
+		TLS:      r.tls.Percentiles(),
+		TTFB:     r.ttfb.Percentiles(),
+		Transfer: r.transfer.Percentiles(),
+	}
+}
+
+// LatencySummary 请求耗时分位数汇总（单位：毫秒）
+type LatencySummary struct {
+	Requests int64       `json:"requests"` // 请求数
+	Reused   int64       `json:"reused"`   // 复用连接的请求数
+	DNS      Percentiles `json:"dns"`      // DNS 解析
+	Connect  Percentiles `json:"connect"`  // TCP 建连
+	TLS      Percentiles `json:"tls"`      // TLS 握手
+	TTFB     Percentiles `json:"ttfb"`     // 首字节时间
+	Transfer Percentiles `json:"transfer"` // 传输耗时
+}
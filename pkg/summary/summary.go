@@ -0,0 +1,104 @@
+package summary
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/downloader"
+	"github.com/dora-exku/netflood/pkg/stats"
+	"github.com/dora-exku/netflood/pkg/units"
+)
+
+// Summary 运行结束后的汇总（统计快照 + 退出结果）
+type Summary struct {
+	downloader.Snapshot
+	ExitCode int    `json:"exit_code"` // 进程退出码
+	Result   string `json:"result"`    // 退出码的含义
+}
+
+// WriteJSON 把汇总写入 JSON 文件
+func WriteJSON(path string, s Summary) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化汇总失败: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("写入汇总文件失败: %w", err)
+	}
+	return nil
+}
+
+// WriteMarkdown 把汇总写入 Markdown 文件
+func WriteMarkdown(path string, s Summary) error {
+	if err := os.WriteFile(path, []byte(Markdown(s)), 0o644); err != nil {
+		return fmt.Errorf("写入汇总文件失败: %w", err)
+	}
+	return nil
+}
+
+// Markdown 返回汇总的 Markdown 文本
+func Markdown(s Summary) string {
+	var b strings.Builder
+	timeFormat := "2006-01-02 15:04:05"
+
+	b.WriteString("# NetFlood 运行汇总\n\n")
+	fmt.Fprintf(&b, "| 项目 | 值 |\n|------|----|\n")
+	fmt.Fprintf(&b, "| 结果 | %s（退出码 %d） |\n", s.Result, s.ExitCode)
+	fmt.Fprintf(&b, "| 开始时间 | %s |\n", s.StartTime.Format(timeFormat))
+	fmt.Fprintf(&b, "| 结束时间 | %s |\n", s.Time.Format(timeFormat))
+	fmt.Fprintf(&b, "| 运行时长 | %v |\n", time.Duration(s.Duration*float64(time.Second)).Round(time.Second))
+	if s.StopReason != "" {
+		fmt.Fprintf(&b, "| 结束原因 | %s |\n", s.StopReason)
+	}
+	fmt.Fprintf(&b, "| 总下载量 | %s |\n", units.FormatBytes(s.TotalBytes))
+	fmt.Fprintf(&b, "| 平均速度 | %.2f MB/s |\n", s.AvgSpeed)
+	fmt.Fprintf(&b, "| 峰值速度 | %.2f MB/s |\n", s.PeakSpeed)
+	fmt.Fprintf(&b, "| 成功 / 失败 / 停滞 | %d / %d / %d |\n", s.Completed, s.Failed, s.Stalls)
+	fmt.Fprintf(&b, "| 下载时间段 | %s |\n", s.TimeRange)
+	fmt.Fprintf(&b, "| 进入时间段次数 | %d |\n", s.WindowsEntered)
+	fmt.Fprintf(&b, "| 下载会话数 | %d |\n", len(s.Sessions))
+
+	if len(s.Tasks) > 0 {
+		b.WriteString("\n## 任务\n\n")
+		b.WriteString("| IP | URL | 下载量 | 成功 | 失败 | 停滞 | 首字节 p50/p90/p99 (ms) |\n")
+		b.WriteString("|----|-----|--------|------|------|------|--------------------------|\n")
+		for _, t := range s.Tasks {
+			fmt.Fprintf(&b, "| %s | %s | %s | %d | %d | %d | %s |\n",
+				t.IP, t.URL, units.FormatBytes(t.Bytes), t.Completed, t.Failed, t.Stalls, formatPercentiles(t.Latency.TTFB))
+		}
+	}
+
+	if len(s.Sessions) > 0 {
+		b.WriteString("\n## 下载会话\n\n")
+		b.WriteString("| 开始 | 结束 | 时间段 | 下载量 |\n")
+		b.WriteString("|------|------|--------|--------|\n")
+		for _, session := range s.Sessions {
+			window := session.Window
+			if window == "" {
+				window = "全天候"
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s | %s |\n",
+				session.Start.Format(timeFormat), session.End.Format(timeFormat), window, units.FormatBytes(session.Bytes))
+		}
+	}
+
+	if s.Latency.Requests > 0 {
+		b.WriteString("\n## 请求耗时（p50 / p90 / p99，毫秒）\n\n")
+		b.WriteString("| 阶段 | 耗时 |\n|------|------|\n")
+		fmt.Fprintf(&b, "| 建连 | %s |\n", formatPercentiles(s.Latency.Connect))
+		fmt.Fprintf(&b, "| TLS 握手 | %s |\n", formatPercentiles(s.Latency.TLS))
+		fmt.Fprintf(&b, "| 首字节 | %s |\n", formatPercentiles(s.Latency.TTFB))
+		fmt.Fprintf(&b, "| 传输 | %s |\n", formatPercentiles(s.Latency.Transfer))
+		fmt.Fprintf(&b, "\n共 %d 个请求，其中 %d 个复用连接。\n", s.Latency.Requests, s.Latency.Reused)
+	}
+
+	return b.String()
+}
+
+// formatPercentiles 格式化分位数
+func formatPercentiles(p stats.Percentiles) string {
+	return fmt.Sprintf("%.1f / %.1f / %.1f", p.P50, p.P90, p.P99)
+}
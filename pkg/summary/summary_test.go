@@ -0,0 +1,74 @@
+package summary
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/downloader"
+)
+
+func testSummary() Summary {
+	start := time.Date(2025, 10, 25, 12, 0, 0, 0, time.Local)
+	return Summary{
+		Snapshot: downloader.Snapshot{
+			StartTime:  start,
+			Time:       start.Add(10 * time.Minute),
+			Duration:   600,
+			TotalBytes: 3 << 30,
+			AvgSpeed:   5.12,
+			PeakSpeed:  9.5,
+			Completed:  42,
+			Failed:     3,
+			StopReason: downloader.StopDuration,
+			TimeRange:  "12:00-13:00",
+			Sessions: []downloader.SessionSnapshot{
+				{Start: start, End: start.Add(10 * time.Minute), Window: "12:00-13:00", Bytes: 3 << 30},
+			},
+			WindowsEntered: 1,
+			Tasks: []downloader.TaskSnapshot{
+				{IP: "1.2.3.4", URL: "https://example.com/a.apk", Bytes: 3 << 30, Completed: 42, Failed: 3},
+			},
+		},
+		ExitCode: 0,
+		Result:   "成功",
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.json")
+	if err := WriteJSON(path, testSummary()); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	for _, key := range []string{"total_bytes", "avg_speed", "peak_speed", "tasks", "sessions", "windows_entered", "exit_code"} {
+		if _, ok := got[key]; !ok {
+			t.Errorf("JSON summary missing key %q", key)
+		}
+	}
+	if got["stop_reason"] != downloader.StopDuration {
+		t.Errorf("stop_reason = %v, want %q", got["stop_reason"], downloader.StopDuration)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	md := Markdown(testSummary())
+
+	for _, want := range []string{"# NetFlood 运行汇总", "3.00 GB", "9.50 MB/s", "1.2.3.4", "12:00-13:00", "成功（退出码 0）"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown() missing %q", want)
+		}
+	}
+}
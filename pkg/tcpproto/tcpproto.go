@@ -0,0 +1,42 @@
+// Package tcpproto 定义 netflood 原始 TCP 测试协议，供 serve -tcp 和 tcp:// 任务共用
+//
+// 客户端连接后发送一行 "NETFLOOD/1 <方向> <字节数>\n"，服务器回复 "OK\n" 或 "ERR <原因>\n"：
+//
+//   - download：服务器发送指定字节数的数据后关闭连接；字节数为 0 时一直发送到客户端关闭连接
+//     （服务器设置了大小上限时发送到上限为止）
+//   - upload：客户端发送数据后关闭写方向，服务器读完后回复 "OK <收到的字节数>\n"
+package tcpproto
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 协议版本和传输方向
+const (
+	Protocol = "NETFLOOD/1"
+	Download = "download"
+	Upload   = "upload"
+)
+
+// Request 返回请求行（包括换行符）
+func Request(dir string, size int64) string {
+	return fmt.Sprintf("%s %s %d\n", Protocol, dir, size)
+}
+
+// ParseRequest 解析请求行 "NETFLOOD/1 <方向> <字节数>"
+func ParseRequest(line string) (dir string, size int64, err error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 || fields[0] != Protocol {
+		return "", 0, fmt.Errorf("无效的请求: %q", strings.TrimSpace(line))
+	}
+	dir = fields[1]
+	if dir != Download && dir != Upload {
+		return "", 0, fmt.Errorf("未知的方向: %s", dir)
+	}
+	if size, err = strconv.ParseInt(fields[2], 10, 64); err != nil || size < 0 {
+		return "", 0, fmt.Errorf("无效的字节数: %s", fields[2])
+	}
+	return dir, size, nil
+}
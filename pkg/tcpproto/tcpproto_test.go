@@ -0,0 +1,27 @@
+package tcpproto
+
+import "testing"
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		line    string
+		dir     string
+		size    int64
+		wantErr bool
+	}{
+		{Request(Download, 100000), Download, 100000, false},
+		{Request(Upload, 0), Upload, 0, false},
+		{"NETFLOOD/1 download 10", Download, 10, false},
+		{"NETFLOOD/2 download 10\n", "", 0, true},
+		{"NETFLOOD/1 sideways 10\n", "", 0, true},
+		{"NETFLOOD/1 download -1\n", "", 0, true},
+		{"NETFLOOD/1 download lots\n", "", 0, true},
+		{"GET / HTTP/1.1\n", "", 0, true},
+	}
+	for _, tt := range tests {
+		dir, size, err := ParseRequest(tt.line)
+		if (err != nil) != tt.wantErr || dir != tt.dir || size != tt.size {
+			t.Errorf("ParseRequest(%q) = %q, %d, %v, want %q, %d (error %v)", tt.line, dir, size, err, tt.dir, tt.size, tt.wantErr)
+		}
+	}
+}
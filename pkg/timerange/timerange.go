@@ -120,28 +120,68 @@ func (tm *TimeRangeManager) IsInRange() bool {
 		return true // 未启用时间控制，始终返回true
 	}
 
-	now := time.Now()
-	currentMinutes := now.Hour()*60 + now.Minute()
+	_, ok := tm.CurrentRange()
+	return ok
+}
+
+// CurrentRange 返回当前所在的时间段，不在任何时间段内（或未启用）时 ok 为 false
+func (tm *TimeRangeManager) CurrentRange() (TimeRange, bool) {
+	return tm.RangeAt(time.Now())
+}
+
+// RangeAt 返回 t 所在的时间段，不在任何时间段内（或未启用）时 ok 为 false
+func (tm *TimeRangeManager) RangeAt(t time.Time) (TimeRange, bool) {
+	if !tm.enabled {
+		return TimeRange{}, false
+	}
+
+	currentMinutes := t.Hour()*60 + t.Minute()
 
 	for _, r := range tm.ranges {
-		startMinutes := r.StartHour*60 + r.StartMinute
-		endMinutes := r.EndHour*60 + r.EndMinute
-
-		// 处理跨天的情况（例如 23:00-01:00）
-		if endMinutes < startMinutes {
-			// 跨天情况：如果当前时间在开始时间之后或结束时间之前
-			if currentMinutes >= startMinutes || currentMinutes < endMinutes {
-				return true
-			}
-		} else {
-			// 正常情况：当前时间在开始和结束之间
-			if currentMinutes >= startMinutes && currentMinutes < endMinutes {
-				return true
-			}
+		if r.contains(currentMinutes) {
+			return r, true
 		}
 	}
 
-	return false
+	return TimeRange{}, false
+}
+
+// contains 检查一天中的第几分钟是否在时间段内
+func (r TimeRange) contains(currentMinutes int) bool {
+	startMinutes := r.StartHour*60 + r.StartMinute
+	endMinutes := r.EndHour*60 + r.EndMinute
+
+	// 处理跨天的情况（例如 23:00-01:00）
+	if endMinutes < startMinutes {
+		// 跨天情况：如果当前时间在开始时间之后或结束时间之前
+		return currentMinutes >= startMinutes || currentMinutes < endMinutes
+	}
+
+	// 正常情况：当前时间在开始和结束之间
+	return currentMinutes >= startMinutes && currentMinutes < endMinutes
+}
+
+// String 返回时间段的字符串表示，例如 "12:00-13:00"
+func (r TimeRange) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", r.StartHour, r.StartMinute, r.EndHour, r.EndMinute)
+}
+
+// EndAfter 返回 now 之后最近一次的结束时间（跨天时间段的结束时间在第二天）
+func (r TimeRange) EndAfter(now time.Time) time.Time {
+	end := time.Date(now.Year(), now.Month(), now.Day(), r.EndHour, r.EndMinute, 0, 0, now.Location())
+	if !end.After(now) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end
+}
+
+// StartBefore 返回 now 及之前最近一次的开始时间（跨天时间段在凌晨时开始时间在前一天）
+func (r TimeRange) StartBefore(now time.Time) time.Time {
+	start := time.Date(now.Year(), now.Month(), now.Day(), r.StartHour, r.StartMinute, 0, 0, now.Location())
+	if start.After(now) {
+		start = start.AddDate(0, 0, -1)
+	}
+	return start
 }
 
 // WaitUntilNextRange 等待到下一个时间段开始
@@ -202,8 +242,7 @@ func (tm *TimeRangeManager) String() string {
 
 	var parts []string
 	for _, r := range tm.ranges {
-		parts = append(parts, fmt.Sprintf("%02d:%02d-%02d:%02d",
-			r.StartHour, r.StartMinute, r.EndHour, r.EndMinute))
+		parts = append(parts, r.String())
 	}
 	return strings.Join(parts, ", ")
 }
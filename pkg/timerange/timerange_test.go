@@ -128,6 +128,88 @@ func TestTimeRangeManager_IsInRange(t *testing.T) {
 	}
 }
 
+func TestTimeRangeManager_CurrentRange(t *testing.T) {
+	now := time.Now()
+	inside := now.Add(-30*time.Minute).Format("15:04") + "-" + now.Add(30*time.Minute).Format("15:04")
+	outside := now.Add(2*time.Hour).Format("15:04") + "-" + now.Add(3*time.Hour).Format("15:04")
+
+	trm, err := NewTimeRangeManager(outside + "," + inside)
+	if err != nil {
+		t.Fatalf("NewTimeRangeManager() error = %v", err)
+	}
+
+	r, ok := trm.CurrentRange()
+	if !ok {
+		t.Fatal("CurrentRange() ok = false, want true")
+	}
+	if r.String() != inside {
+		t.Errorf("CurrentRange() = %s, want %s", r, inside)
+	}
+
+	disabled, _ := NewTimeRangeManager("")
+	if _, ok := disabled.CurrentRange(); ok {
+		t.Error("CurrentRange() ok = true for disabled manager, want false")
+	}
+}
+
+func TestTimeRangeManager_RangeAt(t *testing.T) {
+	trm, err := NewTimeRangeManager("09:00-18:00,23:00-01:00")
+	if err != nil {
+		t.Fatalf("NewTimeRangeManager() error = %v", err)
+	}
+	tests := []struct {
+		hour, minute int
+		want         string
+	}{
+		{9, 0, "09:00-18:00"},
+		{17, 59, "09:00-18:00"},
+		{18, 0, ""},
+		{0, 30, "23:00-01:00"},
+	}
+	for _, tt := range tests {
+		r, ok := trm.RangeAt(time.Date(2025, 10, 26, tt.hour, tt.minute, 0, 0, time.UTC))
+		if got := map[bool]string{true: r.String()}[ok]; got != tt.want {
+			t.Errorf("RangeAt(%02d:%02d) = %q, want %q", tt.hour, tt.minute, got, tt.want)
+		}
+	}
+}
+
+func TestTimeRange_EndAfter(t *testing.T) {
+	now := time.Date(2025, 10, 26, 23, 30, 0, 0, time.Local)
+	tests := []struct {
+		r    TimeRange
+		want time.Time
+	}{
+		{TimeRange{StartHour: 23, EndHour: 23, EndMinute: 45}, time.Date(2025, 10, 26, 23, 45, 0, 0, time.Local)},
+		{TimeRange{StartHour: 23, EndHour: 1}, time.Date(2025, 10, 27, 1, 0, 0, 0, time.Local)},
+		{TimeRange{StartHour: 22, EndHour: 23, EndMinute: 30}, time.Date(2025, 10, 27, 23, 30, 0, 0, time.Local)},
+	}
+
+	for _, tt := range tests {
+		if got := tt.r.EndAfter(now); !got.Equal(tt.want) {
+			t.Errorf("%s.EndAfter() = %v, want %v", tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestTimeRange_StartBefore(t *testing.T) {
+	now := time.Date(2025, 10, 27, 0, 30, 0, 0, time.Local)
+	tests := []struct {
+		r    TimeRange
+		want time.Time
+	}{
+		{TimeRange{StartHour: 0, StartMinute: 15, EndHour: 1}, time.Date(2025, 10, 27, 0, 15, 0, 0, time.Local)},
+		{TimeRange{StartHour: 23, EndHour: 1}, time.Date(2025, 10, 26, 23, 0, 0, 0, time.Local)},
+		{TimeRange{StartHour: 0, StartMinute: 30, EndHour: 1}, now},
+	}
+
+	for _, tt := range tests {
+		if got := tt.r.StartBefore(now); !got.Equal(tt.want) {
+			t.Errorf("%s.StartBefore() = %v, want %v", tt.r, got, tt.want)
+		}
+	}
+}
+
 func TestTimeRangeManager_IsInRange_Disabled(t *testing.T) {
 	trm, err := NewTimeRangeManager("")
 	if err != nil {
@@ -0,0 +1,68 @@
+package tui
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+)
+
+// LogBuffer 保留最近日志行的输出，仪表盘运行期间日志写入这里，避免打乱画面
+type LogBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	max   int
+	out   io.Writer // Detach 后日志直接写入 out
+}
+
+// NewLogBuffer 创建最多保留 max 行的日志缓冲
+func NewLogBuffer(max int) *LogBuffer {
+	return &LogBuffer{max: max}
+}
+
+// Write 实现 io.Writer，按行保存日志
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.out != nil {
+		return b.out.Write(p)
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		b.lines = append(b.lines, line)
+	}
+	if len(b.lines) > b.max {
+		b.lines = append([]string(nil), b.lines[len(b.lines)-b.max:]...)
+	}
+	return len(p), nil
+}
+
+// Lines 返回最近的 n 行日志
+func (b *LogBuffer) Lines(n int) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if n > len(b.lines) {
+		n = len(b.lines)
+	}
+	return append([]string(nil), b.lines[len(b.lines)-n:]...)
+}
+
+// Detach 把保留的日志写入 w，之后的日志直接写入 w（仪表盘退出后调用）
+func (b *LogBuffer) Detach(w io.Writer) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.out = w
+	if len(b.lines) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, line := range b.lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	b.lines = nil
+	_, err := w.Write(buf.Bytes())
+	return err
+}
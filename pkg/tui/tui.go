@@ -0,0 +1,335 @@
+package tui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/downloader"
+	"github.com/dora-exku/netflood/pkg/timerange"
+	"github.com/dora-exku/netflood/pkg/units"
+)
+
+// ANSI 控制序列
+const (
+	enterAltScreen = "\x1b[?1049h\x1b[?25l" // 切换到备用屏幕并隐藏光标
+	leaveAltScreen = "\x1b[?25h\x1b[?1049l" // 显示光标并回到主屏幕
+	cursorHome     = "\x1b[H"
+	clearLine      = "\x1b[K"
+	clearBelow     = "\x1b[J"
+)
+
+// 默认值
+const (
+	DefaultInterval = time.Second
+	sparklineWidth  = 60 // 走势图保留的采样数
+	logLines        = 5  // 面板底部显示的日志行数
+	urlWidth        = 48 // 任务表中 URL 的最大显示宽度
+)
+
+// sparkBlocks 走势图使用的字符（从低到高）
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// Options 仪表盘配置
+type Options struct {
+	Limits    downloader.RunLimits        // 运行边界，用于显示剩余额度
+	TimeRange *timerange.TimeRangeManager // 可选，用于显示时间段状态和倒计时
+	Logs      *LogBuffer                  // 可选，在面板底部显示最近的日志
+	Interval  time.Duration               // 刷新间隔，默认 1 秒
+}
+
+// Dashboard 原地刷新的终端仪表盘（只使用 ANSI 控制序列，无第三方依赖）
+type Dashboard struct {
+	out  io.Writer
+	dl   *downloader.Downloader
+	opts Options
+
+	speeds    []float64        // 最近的速度采样，用于走势图
+	lastBytes map[string]int64 // 上一帧每个任务的下载量，用于计算任务速率
+	lastTime  time.Time
+}
+
+// New 创建仪表盘
+func New(out io.Writer, dl *downloader.Downloader, opts Options) *Dashboard {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	return &Dashboard{
+		out:       out,
+		dl:        dl,
+		opts:      opts,
+		lastBytes: make(map[string]int64),
+	}
+}
+
+// IsTerminal 判断文件是否为终端（非终端时应使用滚动日志输出）
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Start 切换到备用屏幕并开始定期刷新，返回的函数用于停止刷新并恢复终端（可重复调用）
+func (d *Dashboard) Start() (stop func()) {
+	io.WriteString(d.out, enterAltScreen)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(d.opts.Interval)
+		defer ticker.Stop()
+
+		for {
+			io.WriteString(d.out, d.Frame(time.Now()))
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+			io.WriteString(d.out, leaveAltScreen)
+		})
+	}
+}
+
+// Frame 采集当前数据并返回一帧画面（包含回到左上角和清屏的控制序列）
+func (d *Dashboard) Frame(now time.Time) string {
+	snap := d.dl.Stats()
+	rates := d.sample(snap, now)
+
+	var lines []string
+	add := func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	elapsed := time.Duration(snap.Duration * float64(time.Second)).Round(time.Second)
+	add("NetFlood 实时面板    %s    已运行 %s", now.Format("2006-01-02 15:04:05"), elapsed)
+	add("")
+	add("速度      当前 %8.2f MB/s    平均 %8.2f MB/s    峰值 %8.2f MB/s", snap.CurrentSpeed, snap.AvgSpeed, snap.PeakSpeed)
+	add("走势      %s", Sparkline(d.speeds))
+	add("总量      %s    成功 %d    失败 %d    停滞 %d", units.FormatBytes(snap.TotalBytes), snap.Completed, snap.Failed, snap.Stalls)
+	add("协程      活跃 %d / %d", snap.ActiveWorkers, snap.Workers)
+	add("时间段    %s", windowStatus(d.opts.TimeRange, now))
+	add("运行边界  %s", budget(d.opts.Limits, snap))
+	add("统计上报  %s", d.reportStatus())
+	add("")
+
+	// 任务表
+	header := []string{"IP", "URL", "下载量", "速率", "成功", "错误", "状态"}
+	rows := [][]string{header}
+	for _, t := range snap.Tasks {
+		rows = append(rows, []string{
+			t.IP,
+			truncate(t.URL, urlWidth),
+			units.FormatBytes(t.Bytes),
+			fmt.Sprintf("%.2f MB/s", rates[t.IP+","+t.URL]),
+			fmt.Sprint(t.Completed),
+			fmt.Sprint(t.Failed),
+			taskState(t, snap, d.opts.TimeRange),
+		})
+	}
+	lines = append(lines, table(rows)...)
+
+	if d.opts.Logs != nil {
+		add("")
+		add("最近日志")
+		lines = append(lines, d.opts.Logs.Lines(logLines)...)
+	}
+
+	var b strings.Builder
+	b.WriteString(cursorHome)
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteString(clearLine)
+		b.WriteByte('\n')
+	}
+	b.WriteString(clearBelow)
+	return b.String()
+}
+
+// sample 记录速度采样，返回每个任务自上一帧以来的速率（MB/s）
+func (d *Dashboard) sample(snap downloader.Snapshot, now time.Time) map[string]float64 {
+	d.speeds = append(d.speeds, snap.CurrentSpeed)
+	if len(d.speeds) > sparklineWidth {
+		d.speeds = d.speeds[len(d.speeds)-sparklineWidth:]
+	}
+
+	rates := make(map[string]float64, len(snap.Tasks))
+	elapsed := now.Sub(d.lastTime).Seconds()
+	for _, t := range snap.Tasks {
+		key := t.IP + "," + t.URL
+		if last, ok := d.lastBytes[key]; ok && elapsed > 0 {
+			rates[key] = float64(t.Bytes-last) / 1024 / 1024 / elapsed
+		}
+		d.lastBytes[key] = t.Bytes
+	}
+	d.lastTime = now
+	return rates
+}
+
+// reportStatus 返回统计上报状态描述
+func (d *Dashboard) reportStatus() string {
+	status, ok := d.dl.ReportStatus()
+	if !ok {
+		return "未启用"
+	}
+	counts := fmt.Sprintf("（成功 %d / 失败 %d", status.Successes, status.Failures)
+	if status.Queued > 0 {
+		counts += fmt.Sprintf(" / 积压 %d", status.Queued)
+	}
+	if status.Dropped > 0 {
+		counts += fmt.Sprintf(" / 丢弃 %d", status.Dropped)
+	}
+	counts += "）"
+	switch {
+	case status.LastAttempt.IsZero():
+		return "等待首次上报"
+	case status.LastError != "":
+		return fmt.Sprintf("✗ %s 失败: %s%s", status.LastAttempt.Format("15:04:05"), status.LastError, counts)
+	default:
+		return fmt.Sprintf("✓ %s 成功%s", status.LastSuccess.Format("15:04:05"), counts)
+	}
+}
+
+// Sparkline 把速度序列绘制为走势图（按序列中的最大值缩放）
+func Sparkline(values []float64) string {
+	var max float64
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+
+	var b strings.Builder
+	for _, v := range values {
+		idx := 0
+		if max > 0 {
+			idx = int(v / max * float64(len(sparkBlocks)-1))
+		}
+		b.WriteRune(sparkBlocks[idx])
+	}
+	return b.String()
+}
+
+// windowStatus 返回时间段状态及倒计时
+func windowStatus(trm *timerange.TimeRangeManager, now time.Time) string {
+	if trm == nil || !trm.IsEnabled() {
+		return "全天候运行"
+	}
+	if r, ok := trm.CurrentRange(); ok {
+		left := r.EndAfter(now).Sub(now).Round(time.Second)
+		return fmt.Sprintf("● 下载中 %s，%s 后结束", r, left)
+	}
+	next := trm.GetNextRangeStart().Truncate(time.Minute)
+	return fmt.Sprintf("○ 等待中，%s 后进入下一时间段（%s）", next.Sub(now).Round(time.Second), trm)
+}
+
+// budget 返回运行边界的剩余额度
+func budget(limits downloader.RunLimits, snap downloader.Snapshot) string {
+	if !limits.IsBounded() {
+		return "不限（循环下载）"
+	}
+
+	var parts []string
+	if limits.Duration > 0 {
+		left := limits.Duration - time.Duration(snap.Duration*float64(time.Second))
+		parts = append(parts, fmt.Sprintf("时长剩余 %s", max(left, 0).Round(time.Second)))
+	}
+	if limits.Bytes > 0 {
+		left := max(limits.Bytes-snap.TotalBytes, 0)
+		parts = append(parts, fmt.Sprintf("流量剩余 %s / %s", units.FormatBytes(left), units.FormatBytes(limits.Bytes)))
+	}
+	if limits.Iterations > 0 {
+		parts = append(parts, fmt.Sprintf("第 %d / %d 轮", min(snap.Passes+1, limits.Iterations), limits.Iterations))
+	}
+	return strings.Join(parts, "    ")
+}
+
+// taskState 返回任务状态
+func taskState(t downloader.TaskSnapshot, snap downloader.Snapshot, trm *timerange.TimeRangeManager) string {
+	switch {
+	case t.Active > 0:
+		return fmt.Sprintf("下载中 ×%d", t.Active)
+	case snap.StopReason != "":
+		return "已结束"
+	case trm != nil && !trm.IsInRange():
+		return "等待时间段"
+	default:
+		return "空闲"
+	}
+}
+
+// table 按显示宽度对齐表格各列
+func table(rows [][]string) []string {
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], displayWidth(cell))
+		}
+	}
+
+	lines := make([]string, 0, len(rows))
+	for _, row := range rows {
+		var b strings.Builder
+		for i, cell := range row {
+			b.WriteString(cell)
+			if i < len(row)-1 {
+				b.WriteString(strings.Repeat(" ", widths[i]-displayWidth(cell)+2))
+			}
+		}
+		lines = append(lines, b.String())
+	}
+	return lines
+}
+
+// truncate 把字符串截断到指定显示宽度
+func truncate(s string, width int) string {
+	if displayWidth(s) <= width {
+		return s
+	}
+	var b strings.Builder
+	w := 0
+	for _, r := range s {
+		if w+runeWidth(r) > width-3 {
+			break
+		}
+		b.WriteRune(r)
+		w += runeWidth(r)
+	}
+	return b.String() + "..."
+}
+
+// displayWidth 返回字符串在终端中的显示宽度（中日韩字符占两列）
+func displayWidth(s string) int {
+	w := 0
+	for _, r := range s {
+		w += runeWidth(r)
+	}
+	return w
+}
+
+// runeWidth 返回字符的显示宽度
+func runeWidth(r rune) int {
+	if (r >= 0x1100 && r <= 0x115F) || (r >= 0x2E80 && r <= 0xA4CF) ||
+		(r >= 0xAC00 && r <= 0xD7A3) || (r >= 0xF900 && r <= 0xFAFF) || (r >= 0xFF00 && r <= 0xFF60) {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,102 @@
+package tui
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dora-exku/netflood/pkg/downloader"
+	"github.com/dora-exku/netflood/pkg/units"
+)
+
+func TestSparkline(t *testing.T) {
+	tests := []struct {
+		values []float64
+		want   string
+	}{
+		{nil, ""},
+		{[]float64{0, 0}, "▁▁"},
+		{[]float64{0, 5, 10}, "▁▄█"},
+	}
+
+	for _, tt := range tests {
+		if got := Sparkline(tt.values); got != tt.want {
+			t.Errorf("Sparkline(%v) = %q, want %q", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestBudget(t *testing.T) {
+	snap := downloader.Snapshot{Duration: 90, TotalBytes: 30 * units.GB, Passes: 1}
+	tests := []struct {
+		limits downloader.RunLimits
+		want   string
+	}{
+		{downloader.RunLimits{}, "不限（循环下载）"},
+		{downloader.RunLimits{Duration: 10 * time.Minute}, "时长剩余 8m30s"},
+		{downloader.RunLimits{Duration: time.Minute}, "时长剩余 0s"},
+		{downloader.RunLimits{Bytes: 50 * units.GB}, "流量剩余 20.00 GB / 50.00 GB"},
+		{downloader.RunLimits{Iterations: 3}, "第 2 / 3 轮"},
+	}
+
+	for _, tt := range tests {
+		if got := budget(tt.limits, snap); got != tt.want {
+			t.Errorf("budget(%+v) = %q, want %q", tt.limits, got, tt.want)
+		}
+	}
+}
+
+func TestTable_AlignsWideCharacters(t *testing.T) {
+	lines := table([][]string{
+		{"IP", "状态", "x"},
+		{"1.1.1.1", "空闲", "y"},
+	})
+	if strings.Index(lines[0], "x") != strings.Index(lines[1], "y") {
+		t.Errorf("columns not aligned:\n%s\n%s", lines[0], lines[1])
+	}
+	if displayWidth(lines[0]) != displayWidth(lines[1]) {
+		t.Errorf("display widths differ: %d vs %d", displayWidth(lines[0]), displayWidth(lines[1]))
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("https://example.com/file.bin", 12); got != "https://e..." {
+		t.Errorf("truncate() = %q", got)
+	}
+	if got := truncate("short", 12); got != "short" {
+		t.Errorf("truncate() = %q", got)
+	}
+}
+
+func TestLogBuffer(t *testing.T) {
+	buf := NewLogBuffer(3)
+	for _, line := range []string{"a\n", "b\n", "c\nd\n"} {
+		buf.Write([]byte(line))
+	}
+	if got := strings.Join(buf.Lines(10), ","); got != "b,c,d" {
+		t.Errorf("Lines() = %q, want b,c,d", got)
+	}
+
+	var out bytes.Buffer
+	if err := buf.Detach(&out); err != nil {
+		t.Fatalf("Detach() error = %v", err)
+	}
+	buf.Write([]byte("e\n"))
+	if out.String() != "b\nc\nd\ne\n" {
+		t.Errorf("output after Detach = %q", out.String())
+	}
+}
+
+func TestDashboard_Frame(t *testing.T) {
+	logs := NewLogBuffer(10)
+	logs.Write([]byte("level=WARN msg=下载失败\n"))
+	d := New(&bytes.Buffer{}, downloader.New(downloader.WithWorkers(4)), Options{Logs: logs})
+
+	frame := d.Frame(time.Now())
+	for _, want := range []string{cursorHome, "活跃 0 / 4", "全天候运行", "不限（循环下载）", "统计上报  未启用", "下载失败", clearBelow} {
+		if !strings.Contains(frame, want) {
+			t.Errorf("frame missing %q:\n%s", want, frame)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package units
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 字节单位（二进制，与程序中 MB = 1024*1024 的约定一致）
+const (
+	KB int64 = 1 << (10 * (iota + 1))
+	MB
+	GB
+	TB
+)
+
+// ParseBytes 解析字节数，例如 "100KB"、"1.5GB"、"50g"、"4096"
+// 不区分大小写，支持 B/K/KB/KiB/M/MB/MiB/G/GB/GiB/T/TB/TiB 后缀，无后缀视为字节
+func ParseBytes(s string) (int64, error) {
+	str := strings.TrimSpace(s)
+	if str == "" {
+		return 0, fmt.Errorf("字节数不能为空")
+	}
+
+	upper := strings.ToUpper(str)
+	unit := int64(1)
+	for _, suffix := range []struct {
+		text string
+		size int64
+	}{
+		{"TIB", TB}, {"GIB", GB}, {"MIB", MB}, {"KIB", KB},
+		{"TB", TB}, {"GB", GB}, {"MB", MB}, {"KB", KB},
+		{"T", TB}, {"G", GB}, {"M", MB}, {"K", KB}, {"B", 1},
+	} {
+		if strings.HasSuffix(upper, suffix.text) {
+			unit = suffix.size
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, suffix.text))
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(upper, 64)
+	n, ok := toInt64(value * float64(unit))
+	if err != nil || !ok {
+		return 0, fmt.Errorf("无效的字节数: %s", s)
+	}
+	return n, nil
+}
+
+// toInt64 把非负的有限值转换为 int64，NaN、Inf、负数和超出 int64 范围时返回 false
+func toInt64(value float64) (int64, bool) {
+	if !(value >= 0 && value < math.MaxInt64) {
+		return 0, false
+	}
+	return int64(value), true
+}
+
+// ParseRate 解析速度，返回每秒字节数
+// 以 bps 结尾时按比特（十进制）解析，例如 "20Gbps"、"500Mbps"、"1.5kbps"；
+// 否则按字节数解析（可以带 /s 后缀），例如 "2.5GB/s"、"50MB"
+func ParseRate(s string) (int64, error) {
+	str := strings.TrimSpace(s)
+	if rest, ok := strings.CutSuffix(str, "bps"); ok {
+		unit := 1.0
+		for _, prefix := range []struct {
+			text string
+			size float64
+		}{{"T", 1e12}, {"G", 1e9}, {"M", 1e6}, {"k", 1e3}, {"K", 1e3}} {
+			if r, ok := strings.CutSuffix(rest, prefix.text); ok {
+				rest, unit = r, prefix.size
+				break
+			}
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(rest), 64)
+		n, ok := toInt64(value * unit / 8)
+		if err != nil || !ok {
+			return 0, fmt.Errorf("无效的速度: %s", s)
+		}
+		return n, nil
+	}
+	n, err := ParseBytes(strings.TrimSuffix(str, "/s"))
+	if err != nil {
+		return 0, fmt.Errorf("无效的速度: %s", s)
+	}
+	return n, nil
+}
+
+// FormatBytes 把字节数格式化为易读的字符串，例如 "1.50 GB"
+func FormatBytes(n int64) string {
+	switch {
+	case n >= TB:
+		return fmt.Sprintf("%.2f TB", float64(n)/float64(TB))
+	case n >= GB:
+		return fmt.Sprintf("%.2f GB", float64(n)/float64(GB))
+	case n >= MB:
+		return fmt.Sprintf("%.2f MB", float64(n)/float64(MB))
+	case n >= KB:
+		return fmt.Sprintf("%.2f KB", float64(n)/float64(KB))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
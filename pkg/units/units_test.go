@@ -0,0 +1,87 @@
+package units
+
+import "testing"
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{"4096", 4096, false},
+		{"100KB", 100 * KB, false},
+		{"100kb", 100 * KB, false},
+		{"1.5GB", 3 * GB / 2, false},
+		{"50G", 50 * GB, false},
+		{"2 MiB", 2 * MB, false},
+		{"1TB", TB, false},
+		{"10B", 10, false},
+		{"", 0, true},
+		{"abc", 0, true},
+		{"-1MB", 0, true},
+		{"NaN", 0, true},
+		{"Inf", 0, true},
+		{"-Inf", 0, true},
+		{"1e30", 0, true},
+		{"8589934592GB", 0, true},
+		{"8EB", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseBytes(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseBytes(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseBytes(%q) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{"20Gbps", 2_500_000_000, false},
+		{"500 Mbps", 62_500_000, false},
+		{"8kbps", 1000, false},
+		{"800bps", 100, false},
+		{"2.5GB/s", 5 * GB / 2, false},
+		{"50MB", 50 * MB, false},
+		{"fastbps", 0, true},
+		{"-1Gbps", 0, true},
+		{"NaNbps", 0, true},
+		{"InfGbps", 0, true},
+		{"1e30Tbps", 0, true},
+		{"NaN/s", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRate(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseRate(%q) = %d, %v, want %d (error %v)", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		input int64
+		want  string
+	}{
+		{512, "512 B"},
+		{2 * KB, "2.00 KB"},
+		{3 * GB / 2, "1.50 GB"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatBytes(tt.input); got != tt.want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}